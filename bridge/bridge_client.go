@@ -18,6 +18,7 @@ import (
 	"os"
 	"strconv"
 	"sync"
+	"sync/atomic"
 )
 
 // BridgeClient encapsulates all subscriptions and io to and from brokers.
@@ -26,7 +27,7 @@ type BridgeClient struct {
 	TCPc             *stomp.Conn     // STOMP TCP Connection
 	ConnectedChan    chan bool
 	disconnectedChan chan bool
-	connected        bool
+	connected        int32
 	inboundChan      chan *frame.Frame
 	stompConnected   bool
 	Subscriptions    map[string]*BridgeClientSub
@@ -49,7 +50,6 @@ func newBridgeWsClient(enableLogging bool) *BridgeClient {
 		WSc:              nil,
 		TCPc:             nil,
 		stompConnected:   false,
-		connected:        false,
 		logger:           l,
 		lock:             sync.Mutex{},
 		sendLock:         sync.Mutex{},
@@ -118,6 +118,7 @@ func (ws *BridgeClient) Connect(url *url.URL, config *BrokerConnectorConfig) err
 // Disconnect from broker endpoint
 func (ws *BridgeClient) Disconnect() error {
 	if ws.WSc != nil {
+		atomic.StoreInt32(&ws.connected, 0)
 		defer ws.WSc.Close()
 		ws.disconnectedChan <- true
 	} else {
@@ -126,6 +127,11 @@ func (ws *BridgeClient) Disconnect() error {
 	return nil
 }
 
+// IsConnected returns true if the STOMP session is established and the socket can be read.
+func (ws *BridgeClient) IsConnected() bool {
+	return atomic.LoadInt32(&ws.connected) == 1
+}
+
 // Subscribe to destination
 func (ws *BridgeClient) Subscribe(destination string) *BridgeClientSub {
 	ws.lock.Lock()
@@ -200,6 +206,7 @@ func (ws *BridgeClient) listenSocket() {
 		f, _ := sr.Read()
 
 		if err != nil {
+			atomic.StoreInt32(&ws.connected, 0)
 			break // socket can't be read anymore, exit.
 		}
 		if f != nil {
@@ -220,13 +227,14 @@ func (ws *BridgeClient) handleIncomingSTOMPFrames() {
 					ws.logger.Printf("STOMP Client connected")
 				}
 				ws.stompConnected = true
-				ws.connected = true
+				atomic.StoreInt32(&ws.connected, 1)
 				ws.ConnectedChan <- true
 
 			case frame.MESSAGE:
 				for _, sub := range ws.Subscriptions {
 					if sub.Destination == f.Header.Get(frame.Destination) {
-						c := &model.MessageConfig{Payload: f.Body, Destination: sub.Destination}
+						c := &model.MessageConfig{Payload: f.Body, Destination: sub.Destination,
+							Headers: appendTraceContextHeaders(nil, f.Header)}
 						sub.lock.RLock()
 						if sub.subscribed {
 							ws.sendResponseSafe(sub.C, model.GenerateResponse(c))
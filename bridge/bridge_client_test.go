@@ -47,3 +47,31 @@ func TestBridgeClient_handleCommands(t *testing.T) {
 	m := <-s.E
 	assert.Error(t, m.Error)
 }
+
+func TestBridgeClient_handleMessageTraceContext(t *testing.T) {
+	d := "rainbow-land"
+	bc := new(BridgeClient)
+	i := make(chan *frame.Frame, 1)
+	c := make(chan *model.Message, 1)
+
+	bc.inboundChan = i
+	bc.Subscriptions = make(map[string]*BridgeClientSub)
+	s := &BridgeClientSub{C: c, Destination: d, subscribed: true}
+	bc.Subscriptions[d] = s
+
+	go bc.handleIncomingSTOMPFrames()
+
+	bc.inboundChan <- frame.New(frame.MESSAGE, frame.Destination, d,
+		"traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"tracestate", "vendor=value",
+		"other", "ignored")
+	m := <-s.C
+	assert.Equal(t, []model.MessageHeader{
+		{Label: "traceparent", Value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+		{Label: "tracestate", Value: "vendor=value"},
+	}, m.Headers)
+
+	bc.inboundChan <- frame.New(frame.MESSAGE, frame.Destination, d)
+	m = <-s.C
+	assert.Empty(t, m.Headers)
+}
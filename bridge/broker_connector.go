@@ -8,11 +8,14 @@ import (
 	"fmt"
 	"github.com/go-stomp/stomp/v3"
 	"github.com/google/uuid"
+	"net"
 	"net/url"
 	"sync"
+	"sync/atomic"
 )
 
-// BrokerConnector is used to connect to a message broker over TCP or WebSocket.
+// BrokerConnector is used to connect to a message broker over TCP, UNIX socket, WebSocket or
+// a user supplied net.Conn such as an in-memory pipe.
 type BrokerConnector interface {
 	Connect(config *BrokerConnectorConfig, enableLogging bool) (Connection, error)
 }
@@ -32,7 +35,7 @@ func checkConfig(config *BrokerConnectorConfig) error {
 	if config == nil {
 		return fmt.Errorf("config is nil")
 	}
-	if config.ServerAddr == "" {
+	if config.ServerAddr == "" && (config.DialConn == nil || config.UseWS) {
 		return fmt.Errorf("config invalid, config missing server address")
 	}
 	if config.Username == "" {
@@ -92,14 +95,32 @@ func (bc *brokerConnector) connectTCP(config *BrokerConnectorConfig, err error)
 		}
 	}
 
-	conn, err := stomp.Dial("tcp", config.ServerAddr, options...)
+	var netConn net.Conn
+	if config.DialConn != nil {
+		netConn, err = config.DialConn()
+	} else {
+		network := config.Network
+		if network == "" {
+			network = "tcp"
+		}
+		netConn, err = net.Dial(network, config.ServerAddr)
+	}
 	if err != nil {
 		return nil, err
 	}
+
+	// monitor the raw connection to detect when the broker goes away
+	monitoredConn := &monitoredNetConn{Conn: netConn}
+	conn, err := stomp.Connect(monitoredConn, options...)
+	if err != nil {
+		netConn.Close()
+		return nil, err
+	}
 	id := uuid.New()
 	bcConn := &connection{
 		id:             &id,
 		conn:           conn,
+		netConn:        monitoredConn,
 		subscriptions:  make(map[string]Subscription),
 		useWs:          false,
 		connLock:       sync.Mutex{},
@@ -134,3 +155,30 @@ func (bc *brokerConnector) connectWs(config *BrokerConnectorConfig, enableLoggin
 	bc.connected = true
 	return bcConn, nil
 }
+
+// monitoredNetConn records failed reads and writes of the raw connection
+// to the broker, which means the connection is lost.
+type monitoredNetConn struct {
+	net.Conn
+	failed int32
+}
+
+func (c *monitoredNetConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if err != nil {
+		atomic.StoreInt32(&c.failed, 1)
+	}
+	return n, err
+}
+
+func (c *monitoredNetConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if err != nil {
+		atomic.StoreInt32(&c.failed, 1)
+	}
+	return n, err
+}
+
+func (c *monitoredNetConn) isFailed() bool {
+	return atomic.LoadInt32(&c.failed) == 1
+}
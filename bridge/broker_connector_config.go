@@ -5,6 +5,7 @@ package bridge
 
 import (
 	"crypto/tls"
+	"net"
 	"net/http"
 	"time"
 )
@@ -29,6 +30,10 @@ type BrokerConnectorConfig struct {
 	HeartBeatIn     time.Duration     // inbound heartbeat interval (from server to client)
 	STOMPHeader     map[string]string // additional STOMP headers for handshake
 	HttpHeader      http.Header       // additional HTTP headers for WebSocket Upgrade
+	Network         string            // network to dial when UseWS is false, "tcp" (default) or "unix"
+	// optional function opening the raw connection to the broker instead of dialing ServerAddr,
+	// e.g. the Dial method of an in-memory stompserver.PipeConnectionListener. Not used with UseWS.
+	DialConn func() (net.Conn, error)
 }
 
 // LoadX509KeyPairFromFiles loads from paths to x509 cert and its matching key files and initializes
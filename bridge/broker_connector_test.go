@@ -172,6 +172,15 @@ func TestBrokerConnector_ConnectBroker(t *testing.T) {
 					"access-token": "token",
 				},
 			}},
+		{
+			"Connect via custom net.Conn",
+			&BrokerConnectorConfig{
+				Username: "guest",
+				Password: "guest",
+				DialConn: func() (net.Conn, error) {
+					return net.Dial("tcp", testBrokerAddress)
+				},
+			}},
 	}
 
 	for _, tc := range tt {
@@ -187,6 +196,7 @@ func TestBrokerConnector_ConnectBroker(t *testing.T) {
 
 			assert.NotNil(t, c)
 			assert.Nil(t, err)
+			assert.True(t, c.IsConnected())
 			if tc.config.UseWS {
 				assert.NotNil(t, c.(*connection).wsConn)
 			}
@@ -197,6 +207,7 @@ func TestBrokerConnector_ConnectBroker(t *testing.T) {
 			// disconnect
 			err = c.Disconnect()
 			assert.Nil(t, err)
+			assert.False(t, c.IsConnected())
 			if tc.config.UseWS {
 				assert.Nil(t, c.(*connection).wsConn)
 			}
@@ -208,6 +219,28 @@ func TestBrokerConnector_ConnectBroker(t *testing.T) {
 	}
 }
 
+func TestBrokerConnector_ConnectionLost(t *testing.T) {
+	var netConn net.Conn
+	bc := NewBrokerConnector()
+	c, err := bc.Connect(&BrokerConnectorConfig{
+		Username: "guest",
+		Password: "guest",
+		DialConn: func() (net.Conn, error) {
+			var dialErr error
+			netConn, dialErr = net.Dial("tcp", testBrokerAddress)
+			return netConn, dialErr
+		},
+	}, false)
+	assert.Nil(t, err)
+	assert.True(t, c.IsConnected())
+
+	// the socket fails underneath the STOMP connection
+	netConn.Close()
+	assert.Eventually(t, func() bool {
+		return !c.IsConnected()
+	}, time.Second, 5*time.Millisecond)
+}
+
 func TestBrokerConnector_ConnectBrokerFail(t *testing.T) {
 	tt := []struct {
 		test   string
@@ -225,6 +258,17 @@ func TestBrokerConnector_ConnectBrokerFail(t *testing.T) {
 			"Connect via TCP fails with bad address",
 			&BrokerConnectorConfig{
 				Username: "guest", Password: "guest", ServerAddr: "somewhere"}},
+		{
+			"Connect via UNIX socket fails with bad address",
+			&BrokerConnectorConfig{
+				Username: "guest", Password: "guest", ServerAddr: "/nowhere/fabric.sock", Network: "unix"}},
+		{
+			"Connect via custom net.Conn fails with dial error",
+			&BrokerConnectorConfig{
+				Username: "guest", Password: "guest",
+				DialConn: func() (net.Conn, error) {
+					return nil, fmt.Errorf("dial-error")
+				}}},
 	}
 
 	for _, tc := range tt {
@@ -9,6 +9,7 @@ import (
 	"github.com/go-stomp/stomp/v3/frame"
 	"github.com/google/uuid"
 	"github.com/vmware/transport-go/model"
+	"github.com/vmware/transport-go/tracing"
 	"log"
 	"sync"
 )
@@ -21,6 +22,7 @@ type Connection interface {
 	SendJSONMessage(destination string, payload []byte, opts ...func(*frame.Frame) error) error
 	SendMessage(destination, contentType string, payload []byte, opts ...func(*frame.Frame) error) error
 	SendMessageWithReplyDestination(destination, replyDestination, contentType string, payload []byte, opts ...func(*frame.Frame) error) error
+	IsConnected() bool
 }
 
 // Connection represents a Connection to a message broker.
@@ -28,6 +30,7 @@ type connection struct {
 	id             *uuid.UUID
 	useWs          bool
 	conn           *stomp.Conn
+	netConn        *monitoredNetConn
 	wsConn         *BridgeClient
 	disconnectChan chan bool
 	subscriptions  map[string]Subscription
@@ -91,7 +94,7 @@ func (c *connection) Disconnect() (err error) {
 		return fmt.Errorf("cannot disconnect, not connected")
 	}
 	if c.useWs {
-		if c.wsConn != nil && c.wsConn.connected {
+		if c.wsConn != nil && c.wsConn.IsConnected() {
 			defer c.cleanUpConnection()
 			err = c.wsConn.Disconnect()
 		}
@@ -104,6 +107,20 @@ func (c *connection) Disconnect() (err error) {
 	return err
 }
 
+// IsConnected returns false once the connection is disconnected or the underlying
+// socket to the broker fails.
+func (c *connection) IsConnected() bool {
+	if c == nil {
+		return false
+	}
+	c.connLock.Lock()
+	defer c.connLock.Unlock()
+	if c.useWs {
+		return c.wsConn != nil && c.wsConn.IsConnected()
+	}
+	return c.conn != nil && (c.netConn == nil || !c.netConn.isFailed())
+}
+
 func (c *connection) cleanUpConnection() {
 	if c.conn != nil {
 		c.conn = nil
@@ -184,6 +201,7 @@ func (c *connection) listenTCPFrames(src chan *stomp.Message, dst chan *model.Me
 			if replyTo, ok := f.Header.Contains("reply-to"); ok { // used by rabbitmq for temp queues
 				cf.Headers = []model.MessageHeader{{Label: "reply-to", Value: replyTo}}
 			}
+			cf.Headers = appendTraceContextHeaders(cf.Headers, f.Header)
 
 			m := model.GenerateResponse(cf)
 			dst <- m
@@ -191,6 +209,20 @@ func (c *connection) listenTCPFrames(src chan *stomp.Message, dst chan *model.Me
 	}
 }
 
+// appendTraceContextHeaders transfers the W3C trace context headers of a received frame to the message headers,
+// so that the trace of a message sent through the broker is continued on the bus.
+func appendTraceContextHeaders(headers []model.MessageHeader, frameHeader *frame.Header) []model.MessageHeader {
+	if frameHeader == nil {
+		return headers
+	}
+	for _, key := range []string{tracing.TraceParentHeader, tracing.TraceStateHeader} {
+		if value, ok := frameHeader.Contains(key); ok {
+			headers = append(headers, model.MessageHeader{Label: key, Value: value})
+		}
+	}
+	return headers
+}
+
 // SendJSONMessage sends a []byte payload carrying JSON data to a destination.
 func (c *connection) SendJSONMessage(destination string, payload []byte, opts ...func(*frame.Frame) error) error {
 	return c.SendMessage(destination, "application/json", payload, opts...)
@@ -4,11 +4,16 @@
 package bus
 
 import (
+	"context"
 	"github.com/google/uuid"
 	"github.com/vmware/transport-go/bridge"
 	"github.com/vmware/transport-go/model"
+	"github.com/vmware/transport-go/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 // Channel represents the stream and the subscribed event handlers waiting for ticks on the stream
@@ -23,6 +28,7 @@ type Channel struct {
 	brokerSubs                []*connectionSub
 	brokerConns               []bridge.Connection
 	brokerMappedEvent         chan bool
+	monitor                   *transportMonitor
 }
 
 // Create a new Channel with the supplied Channel name. Returns a pointer to that Channel.
@@ -69,6 +75,11 @@ func (channel *Channel) IsPrivate() bool {
 
 // Send a new message on this Channel, to all event handlers.
 func (channel *Channel) Send(message *model.Message) {
+	sent := time.Now()
+	if channel.hasMonitorListeners(ChannelMessageSentEvt) {
+		channel.sendMonitorEvent(ChannelMessageSentEvt, message)
+	}
+
 	channel.channelLock.Lock()
 	defer channel.channelLock.Unlock()
 	if eventHandlers := channel.eventHandlers; len(eventHandlers) > 0 {
@@ -83,8 +94,43 @@ func (channel *Channel) Send(message *model.Message) {
 				continue
 			}
 			channel.wg.Add(1)
-			go channel.sendMessageToHandler(eventHandler, message)
+			if eventHandler.ordered {
+				channel.queueMessageForHandler(eventHandler, message, sent)
+			} else {
+				go channel.sendMessageToHandler(eventHandler, message, sent)
+			}
+		}
+	}
+}
+
+// queueMessageForHandler queues the message for an ordered handler and starts delivering the
+// queued messages if the handler is idle. Must be called with the channelLock held, so that the
+// messages are queued in the order in which they were sent.
+func (channel *Channel) queueMessageForHandler(handler *channelEventHandler, message *model.Message, sent time.Time) {
+	handler.queueLock.Lock()
+	defer handler.queueLock.Unlock()
+	handler.queue = append(handler.queue, &pendingMessage{message: message, sent: sent})
+	if !handler.delivering {
+		handler.delivering = true
+		go channel.deliverQueuedMessages(handler)
+	}
+}
+
+// deliverQueuedMessages sends the queued messages to the ordered handler one by one until the queue is empty.
+func (channel *Channel) deliverQueuedMessages(handler *channelEventHandler) {
+	for {
+		handler.queueLock.Lock()
+		if len(handler.queue) == 0 {
+			handler.delivering = false
+			handler.queueLock.Unlock()
+			return
 		}
+		next := handler.queue[0]
+		handler.queue[0] = nil
+		handler.queue = handler.queue[1:]
+		handler.queueLock.Unlock()
+
+		channel.sendMessageToHandler(handler, next.message, next.sent)
 	}
 }
 
@@ -93,13 +139,36 @@ func (channel *Channel) ContainsHandlers() bool {
 	return len(channel.eventHandlers) > 0
 }
 
+// Returns the number of handlers subscribed to the Channel
+func (channel *Channel) CountHandlers() int {
+	channel.channelLock.Lock()
+	defer channel.channelLock.Unlock()
+	return len(channel.eventHandlers)
+}
+
 // Send message to handler function
-func (channel *Channel) sendMessageToHandler(handler *channelEventHandler, message *model.Message) {
+func (channel *Channel) sendMessageToHandler(handler *channelEventHandler, message *model.Message, sent time.Time) {
 	handler.callBackFunction(message)
 	atomic.AddInt64(&handler.runCount, 1)
+	if channel.hasMonitorListeners(ChannelMessageDeliveredEvt) {
+		channel.sendMonitorEvent(ChannelMessageDeliveredEvt,
+			&MessageDeliveryMonitorData{Message: message, Latency: time.Since(sent)})
+	}
 	channel.wg.Done()
 }
 
+// hasMonitorListeners returns whether the bus which created the Channel, if any, has listeners for the event type
+func (channel *Channel) hasMonitorListeners(evtType MonitorEventType) bool {
+	return channel.monitor != nil && channel.monitor.hasListeners(evtType)
+}
+
+// sendMonitorEvent notifies the monitor of the bus which created the Channel, if any
+func (channel *Channel) sendMonitorEvent(evtType MonitorEventType, data interface{}) {
+	if channel.monitor != nil {
+		channel.monitor.sendEvent(NewMonitorEvent(evtType, channel.Name, data))
+	}
+}
+
 // Subscribe a new handler function.
 func (channel *Channel) subscribeHandler(handler *channelEventHandler) {
 	channel.channelLock.Lock()
@@ -140,13 +209,33 @@ func (channel *Channel) listenToBrokerSubscription(sub bridge.Subscription) {
 	for {
 		msg, m := <-sub.GetMsgChannel()
 		if m {
-			channel.Send(msg)
+			channel.sendBrokerMessage(sub, msg)
 		} else {
 			break
 		}
 	}
 }
 
+// sendBrokerMessage sends a message received from the broker on the channel. the trace of messages carrying
+// a trace context is continued with a span of receiving the message from the broker.
+func (channel *Channel) sendBrokerMessage(sub bridge.Subscription, msg *model.Message) {
+	ctx := tracing.ContextFromHeaders(context.Background(), msg.Headers)
+	if !tracing.HasTraceContext(ctx) {
+		channel.Send(msg)
+		return
+	}
+	ctx, span := tracing.Tracer().Start(ctx, sub.GetDestination()+" receive",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String(tracing.ChannelAttribute, channel.Name),
+			attribute.String(tracing.DestinationAttribute, sub.GetDestination())))
+	defer span.End()
+	carrier := tracing.MessageHeaderCarrier(msg.Headers)
+	tracing.Inject(ctx, &carrier)
+	msg.Headers = carrier
+	channel.Send(msg)
+}
+
 func (channel *Channel) isBrokerSubscribed(sub bridge.Subscription) bool {
 	channel.channelLock.Lock()
 	defer channel.channelLock.Unlock()
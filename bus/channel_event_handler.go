@@ -5,6 +5,9 @@ package bus
 
 import (
 	"github.com/google/uuid"
+	"github.com/vmware/transport-go/model"
+	"sync"
+	"time"
 )
 
 type channelEventHandler struct {
@@ -12,4 +15,14 @@ type channelEventHandler struct {
 	runOnce          bool
 	runCount         int64
 	uuid             *uuid.UUID
+	// ordered handlers get the messages one at a time, in the order they were sent to the channel
+	ordered    bool
+	queueLock  sync.Mutex
+	queue      []*pendingMessage
+	delivering bool
+}
+
+type pendingMessage struct {
+	message *model.Message
+	sent    time.Time
 }
@@ -49,7 +49,9 @@ func (manager *busChannelManager) CreateChannel(channelName string) *Channel {
 		return channel
 	}
 
-	manager.Channels[channelName] = NewChannel(channelName)
+	channel = NewChannel(channelName)
+	channel.monitor = manager.bus.monitor
+	manager.Channels[channelName] = channel
 	go manager.bus.SendMonitorEvent(ChannelCreatedEvt, channelName, nil)
 	return manager.Channels[channelName]
 }
@@ -77,7 +79,15 @@ func (manager *busChannelManager) GetChannel(channelName string) (*Channel, erro
 
 // Get all channels currently open. Returns a map of Channel names and pointers to those Channel objects.
 func (manager *busChannelManager) GetAllChannels() map[string]*Channel {
-	return manager.Channels
+	manager.lock.RLock()
+	defer manager.lock.RUnlock()
+
+	// return a copy, the channels may be created and destroyed while the caller iterates over them
+	channels := make(map[string]*Channel, len(manager.Channels))
+	for name, channel := range manager.Channels {
+		channels[name] = channel
+	}
+	return channels
 }
 
 // Check Channel exists, returns true if so.
@@ -91,12 +101,20 @@ func (manager *busChannelManager) CheckChannelExists(channelName string) bool {
 // Subscribe new handler lambda for Channel, bool flag runOnce determines if this is a single Fire handler.
 // Returns UUID pointer, or error if there is no Channel by that name.
 func (manager *busChannelManager) SubscribeChannelHandler(channelName string, fn MessageHandlerFunction, runOnce bool) (*uuid.UUID, error) {
+	return manager.subscribeChannelHandler(channelName, fn, runOnce, false)
+}
+
+// subscribeChannelHandler subscribes the handler function to the channel. ordered handlers get the messages
+// one at a time, in the order in which they were sent to the channel.
+func (manager *busChannelManager) subscribeChannelHandler(
+	channelName string, fn MessageHandlerFunction, runOnce bool, ordered bool) (*uuid.UUID, error) {
+
 	channel, err := manager.GetChannel(channelName)
 	if err != nil {
 		return nil, err
 	}
 	id := uuid.New()
-	channel.subscribeHandler(&channelEventHandler{callBackFunction: fn, runOnce: runOnce, uuid: &id})
+	channel.subscribeHandler(&channelEventHandler{callBackFunction: fn, runOnce: runOnce, uuid: &id, ordered: ordered})
 	manager.bus.SendMonitorEvent(ChannelSubscriberJoinedEvt, channelName, nil)
 	return &id, nil
 }
@@ -10,7 +10,13 @@ import (
 	"github.com/stretchr/testify/mock"
 	"github.com/vmware/transport-go/bridge"
 	"github.com/vmware/transport-go/model"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"sync"
 	"testing"
+	"time"
 )
 
 var testChannelName string = "testing"
@@ -39,11 +45,13 @@ func TestChannel_HandlerCheck(t *testing.T) {
 	channel := NewChannel(testChannelName)
 	id := uuid.New()
 	assert.False(t, channel.ContainsHandlers())
+	assert.Equal(t, 0, channel.CountHandlers())
 
 	handler := func(*model.Message) {}
 	channel.subscribeHandler(&channelEventHandler{callBackFunction: handler, runOnce: false, uuid: &id})
 
 	assert.True(t, channel.ContainsHandlers())
+	assert.Equal(t, 1, channel.CountHandlers())
 }
 
 func TestChannel_SendMessage(t *testing.T) {
@@ -66,6 +74,36 @@ func TestChannel_SendMessage(t *testing.T) {
 	channel.wg.Wait()
 }
 
+func TestChannel_SendMessageMonitorEvents(t *testing.T) {
+	bus := newTestEventBus()
+	channel := bus.GetChannelManager().CreateChannel(testChannelName)
+
+	var lock sync.Mutex
+	var monitorEvents []*MonitorEvent
+	bus.AddMonitorEventListener(func(monitorEvt *MonitorEvent) {
+		lock.Lock()
+		monitorEvents = append(monitorEvents, monitorEvt)
+		lock.Unlock()
+	}, ChannelMessageSentEvt, ChannelMessageDeliveredEvt)
+
+	id := uuid.New()
+	channel.subscribeHandler(&channelEventHandler{callBackFunction: func(message *model.Message) {
+		time.Sleep(10 * time.Millisecond)
+	}, uuid: &id})
+
+	message := &model.Message{Id: &id, Payload: "pickled eggs", Channel: testChannelName, Direction: model.RequestDir}
+	channel.Send(message)
+	channel.wg.Wait()
+
+	assert.Len(t, monitorEvents, 2)
+	assert.Equal(t, NewMonitorEvent(ChannelMessageSentEvt, testChannelName, message), monitorEvents[0])
+	assert.Equal(t, ChannelMessageDeliveredEvt, monitorEvents[1].EventType)
+	assert.Equal(t, testChannelName, monitorEvents[1].EntityName)
+	deliveryData := monitorEvents[1].Data.(*MessageDeliveryMonitorData)
+	assert.Equal(t, message, deliveryData.Message)
+	assert.GreaterOrEqual(t, deliveryData.Latency, 10*time.Millisecond)
+}
+
 func TestChannel_SendMessageRunOnceHasRun(t *testing.T) {
 	id := uuid.New()
 	channel := NewChannel(testChannelName)
@@ -242,9 +280,50 @@ func TestChannel_CheckIfBrokerSubscribed(t *testing.T) {
 	assert.False(t, ch.isBrokerSubscribed(s))
 }
 
+func TestChannel_BrokerMessageTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(trace.NewNoopTracerProvider())
+
+	channel := NewChannel(testChannelName)
+	id := uuid.New()
+	messages := make(chan *model.Message, 2)
+	channel.subscribeHandler(&channelEventHandler{callBackFunction: func(message *model.Message) {
+		messages <- message
+	}, uuid: &id})
+
+	sub := &MockBridgeSubscription{Id: &id, Destination: "/topic/testing", Channel: make(chan *model.Message)}
+	channel.addBrokerSubscription(&MockBridgeConnection{Id: &id}, sub)
+	defer close(sub.Channel)
+
+	// messages with trace context continue the trace with a span of receiving the message
+	sub.Channel <- model.GenerateResponse(&model.MessageConfig{Payload: "traced", Headers: []model.MessageHeader{
+		{Label: "reply-to", Value: "/temp-queue/1"},
+		{Label: "traceparent", Value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+	}})
+	message := <-messages
+	assert.Eventually(t, func() bool { return len(recorder.Ended()) == 1 }, time.Second, time.Millisecond)
+	span := recorder.Ended()[0]
+	assert.Equal(t, "/topic/testing receive", span.Name())
+	assert.Equal(t, trace.SpanKindConsumer, span.SpanKind())
+	assert.Equal(t, "00f067aa0ba902b7", span.Parent().SpanID().String())
+	assert.Equal(t, []model.MessageHeader{
+		{Label: "reply-to", Value: "/temp-queue/1"},
+		{Label: "traceparent", Value: "00-4bf92f3577b34da6a3ce929d0e0e4736-" + span.SpanContext().SpanID().String() + "-01"},
+	}, message.Headers)
+
+	// other messages are not traced
+	sub.Channel <- model.GenerateResponse(&model.MessageConfig{Payload: "untraced"})
+	message = <-messages
+	assert.Equal(t, "untraced", message.Payload)
+	assert.Empty(t, message.Headers)
+	assert.Len(t, recorder.Started(), 1)
+}
+
 type MockBridgeConnection struct {
 	mock.Mock
-	Id *uuid.UUID
+	Id           *uuid.UUID
+	disconnected bool
 }
 
 func (c *MockBridgeConnection) GetId() *uuid.UUID {
@@ -265,6 +344,10 @@ func (c *MockBridgeConnection) Disconnect() (err error) {
 	return nil
 }
 
+func (c *MockBridgeConnection) IsConnected() bool {
+	return !c.disconnected
+}
+
 func (c *MockBridgeConnection) SendJSONMessage(destination string, payload []byte, opts ...func(frame *frame.Frame) error) error {
 	args := c.MethodCalled("SendJSONMessage", destination, payload)
 	return args.Error(0)
@@ -4,11 +4,16 @@
 package bus
 
 import (
+	"context"
 	"fmt"
+	"github.com/go-stomp/stomp/v3/frame"
 	"github.com/google/uuid"
 	"github.com/vmware/transport-go/bridge"
 	"github.com/vmware/transport-go/model"
 	"github.com/vmware/transport-go/stompserver"
+	"github.com/vmware/transport-go/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"sync"
 	"sync/atomic"
 )
@@ -38,8 +43,13 @@ type EventBus interface {
 	RequestStream(channelName string, payload interface{}) (MessageHandler, error)
 	RequestStreamForDestination(channelName string, payload interface{}, destId *uuid.UUID) (MessageHandler, error)
 	ConnectBroker(config *bridge.BrokerConnectorConfig) (conn bridge.Connection, err error)
+	GetBrokerConnections() []bridge.Connection
 	StartFabricEndpoint(connectionListener stompserver.RawConnectionListener, config EndpointConfig) error
 	StopFabricEndpoint() error
+	DrainFabricEndpoint(ctx context.Context) error
+	IsFabricEndpointRunning() bool
+	SendToClient(connId string, channel string, payload interface{}, opts ...func(*frame.Frame) error) error
+	SendToUser(principal string, channel string, payload interface{}, opts ...func(*frame.Frame) error) error
 	GetStoreManager() StoreManager
 	CreateSyncTransaction() BusTransaction
 	CreateAsyncTransaction() BusTransaction
@@ -82,8 +92,11 @@ type transportEventBus struct {
 	storeManager      StoreManager
 	Id                uuid.UUID
 	brokerConnections map[*uuid.UUID]bridge.Connection
+	brokerAddresses   map[*uuid.UUID]string
+	brokerConnLock    sync.RWMutex
 	bc                bridge.BrokerConnector
 	fabEndpoint       FabricEndpoint
+	fabEndpointLock   sync.RWMutex
 	initStoreSync     sync.Once
 	storeSyncService  *storeSyncService
 	monitor           *transportMonitor
@@ -96,6 +109,14 @@ type transportMonitor struct {
 	listenersByType       map[MonitorEventType]map[MonitorEventListenerId]MonitorEventHandler
 	listenersForAllEvents map[MonitorEventListenerId]MonitorEventHandler
 	subId                 MonitorEventListenerId
+	// the event types with listeners, read without the lock so that the events of the hot paths, e.g.
+	// sending messages on channels, are neither created nor sent while nobody listens to them
+	listenedTypes atomic.Value // *listenedEventTypes
+}
+
+type listenedEventTypes struct {
+	all   bool
+	types map[MonitorEventType]bool
 }
 
 func newMonitor() *transportMonitor {
@@ -122,6 +143,7 @@ func (m *transportMonitor) addListener(listener MonitorEventHandler, eventTypes
 			listeners[m.subId] = listener
 		}
 	}
+	m.updateListenedTypes()
 
 	return m.subId
 }
@@ -134,6 +156,27 @@ func (m *transportMonitor) removeListener(listenerId MonitorEventListenerId) {
 	for _, listeners := range m.listenersByType {
 		delete(listeners, listenerId)
 	}
+	m.updateListenedTypes()
+}
+
+// updateListenedTypes records the event types with listeners. Must be called with the lock held.
+func (m *transportMonitor) updateListenedTypes() {
+	listened := &listenedEventTypes{
+		all:   len(m.listenersForAllEvents) > 0,
+		types: make(map[MonitorEventType]bool),
+	}
+	for eventType, listeners := range m.listenersByType {
+		if len(listeners) > 0 {
+			listened.types[eventType] = true
+		}
+	}
+	m.listenedTypes.Store(listened)
+}
+
+// hasListeners returns whether any listener receives the events of the given type
+func (m *transportMonitor) hasListeners(eventType MonitorEventType) bool {
+	listened, _ := m.listenedTypes.Load().(*listenedEventTypes)
+	return listened != nil && (listened.all || listened.types[eventType])
 }
 
 func (m *transportMonitor) sendEvent(event *MonitorEvent) {
@@ -158,6 +201,7 @@ func (bus *transportEventBus) init() {
 	bus.storeManager = newStoreManager(bus)
 	bus.ChannelManager = NewBusChannelManager(bus)
 	bus.brokerConnections = make(map[*uuid.UUID]bridge.Connection)
+	bus.brokerAddresses = make(map[*uuid.UUID]string)
 	bus.bc = bridge.NewBrokerConnector()
 	bus.monitor = newMonitor()
 	if enableLogging {
@@ -208,8 +252,12 @@ func (bus *transportEventBus) SendRequestMessage(channelName string, payload int
 		return err
 	}
 	config := buildConfig(channelName, payload, destId)
+	span := startDispatchSpan(config)
 	message := model.GenerateRequest(config)
 	sendMessageToChannel(channelObject, message)
+	if span != nil {
+		span.End()
+	}
 	return nil
 }
 
@@ -448,18 +496,37 @@ func (bus *transportEventBus) RequestStreamForDestination(
 func (bus *transportEventBus) ConnectBroker(config *bridge.BrokerConnectorConfig) (conn bridge.Connection, err error) {
 	conn, err = bus.bc.Connect(config, enableLogging)
 	if conn != nil {
+		bus.brokerConnLock.Lock()
+		defer bus.brokerConnLock.Unlock()
+		// a new connection to the same broker replaces the ones which were lost or disconnected
+		for id, addr := range bus.brokerAddresses {
+			if addr == config.ServerAddr && !bus.brokerConnections[id].IsConnected() {
+				delete(bus.brokerConnections, id)
+				delete(bus.brokerAddresses, id)
+			}
+		}
 		bus.brokerConnections[conn.GetId()] = conn
+		bus.brokerAddresses[conn.GetId()] = config.ServerAddr
 	}
 	return
 }
 
+// GetBrokerConnections returns all connections made with ConnectBroker, except the ones which were
+// replaced by a later connection to the same broker.
+func (bus *transportEventBus) GetBrokerConnections() []bridge.Connection {
+	bus.brokerConnLock.RLock()
+	defer bus.brokerConnLock.RUnlock()
+	connections := make([]bridge.Connection, 0, len(bus.brokerConnections))
+	for _, conn := range bus.brokerConnections {
+		connections = append(connections, conn)
+	}
+	return connections
+}
+
 // Start a new Fabric Endpoint
 func (bus *transportEventBus) StartFabricEndpoint(
 	connectionListener stompserver.RawConnectionListener, config EndpointConfig) error {
 
-	if bus.fabEndpoint != nil {
-		return fmt.Errorf("unable to start: fabric endpoint is already running")
-	}
 	if configErr := config.validate(); configErr != nil {
 		return configErr
 	}
@@ -470,21 +537,89 @@ func (bus *transportEventBus) StartFabricEndpoint(
 		bus.storeSyncService = newStoreSyncService(bus)
 	})
 
-	bus.fabEndpoint = newFabricEndpoint(bus, connectionListener, config)
-	bus.fabEndpoint.Start()
+	bus.fabEndpointLock.Lock()
+	if bus.fabEndpoint != nil {
+		bus.fabEndpointLock.Unlock()
+		return fmt.Errorf("unable to start: fabric endpoint is already running")
+	}
+	fe := newFabricEndpoint(bus, connectionListener, config)
+	bus.fabEndpoint = fe
+	bus.fabEndpointLock.Unlock()
+
+	// the endpoint runs until it is stopped, so it is started without holding the lock
+	fe.Start()
 	return nil
 }
 
 func (bus *transportEventBus) StopFabricEndpoint() error {
-	fe := bus.fabEndpoint
+	fe := bus.takeFabricEndpoint()
 	if fe == nil {
 		return fmt.Errorf("unable to stop: fabric endpoint is not running")
 	}
-	bus.fabEndpoint = nil
 	fe.Stop()
 	return nil
 }
 
+// DrainFabricEndpoint gracefully stops the running Fabric Endpoint. The endpoint stops accepting
+// new connections, notifies the connected clients and waits for the in-flight messages to be
+// delivered before closing all connections. If the context is done before the endpoint becomes idle
+// the remaining connections are closed immediately and the context error is returned.
+func (bus *transportEventBus) DrainFabricEndpoint(ctx context.Context) error {
+	fe := bus.takeFabricEndpoint()
+	if fe == nil {
+		return fmt.Errorf("unable to drain: fabric endpoint is not running")
+	}
+	return fe.Drain(ctx)
+}
+
+// takeFabricEndpoint detaches the running Fabric Endpoint from the bus, so that it is stopped only once.
+func (bus *transportEventBus) takeFabricEndpoint() FabricEndpoint {
+	bus.fabEndpointLock.Lock()
+	defer bus.fabEndpointLock.Unlock()
+	fe := bus.fabEndpoint
+	bus.fabEndpoint = nil
+	return fe
+}
+
+func (bus *transportEventBus) getFabricEndpoint() FabricEndpoint {
+	bus.fabEndpointLock.RLock()
+	defer bus.fabEndpointLock.RUnlock()
+	return bus.fabEndpoint
+}
+
+// IsFabricEndpointRunning returns true if the Fabric Endpoint was started and not stopped since.
+func (bus *transportEventBus) IsFabricEndpointRunning() bool {
+	return bus.getFabricEndpoint() != nil
+}
+
+// SendToClient sends an unsolicited message to a single client connected to the Fabric Endpoint.
+// The message is delivered on the private user queue destination of the channel, the client
+// has to be subscribed to that destination to receive it. The options are applied to the MESSAGE frame,
+// e.g. tracing.InjectFrame to propagate the trace context of the caller to the client.
+func (bus *transportEventBus) SendToClient(connId string, channel string, payload interface{},
+	opts ...func(*frame.Frame) error) error {
+
+	fe := bus.getFabricEndpoint()
+	if fe == nil {
+		return fmt.Errorf("unable to send message to client: fabric endpoint is not running")
+	}
+	return fe.SendToClient(connId, channel, payload, opts...)
+}
+
+// SendToUser sends an unsolicited message to all Fabric Endpoint connections authenticated as the given
+// principal. The message is delivered on the private user queue destination of the channel. Connections
+// with only the unverified STOMP login of the principal don't receive the message. The options are applied
+// to the MESSAGE frames, as for SendToClient.
+func (bus *transportEventBus) SendToUser(principal string, channel string, payload interface{},
+	opts ...func(*frame.Frame) error) error {
+
+	fe := bus.getFabricEndpoint()
+	if fe == nil {
+		return fmt.Errorf("unable to send message to user: fabric endpoint is not running")
+	}
+	return fe.SendToUser(principal, channel, payload, opts...)
+}
+
 func (bus *transportEventBus) CreateAsyncTransaction() BusTransaction {
 	return newBusTransaction(bus, asyncTransaction)
 }
@@ -496,7 +631,9 @@ func (bus *transportEventBus) CreateSyncTransaction() BusTransaction {
 func (bus *transportEventBus) SendMonitorEvent(
 	evtType MonitorEventType, entityName string, payload interface{}) {
 
-	bus.monitor.sendEvent(NewMonitorEvent(evtType, entityName, payload))
+	if bus.monitor.hasListeners(evtType) {
+		bus.monitor.sendEvent(NewMonitorEvent(evtType, entityName, payload))
+	}
 }
 
 func (bus *transportEventBus) wrapMessageHandler(
@@ -595,6 +732,30 @@ func buildConfig(channelName string, payload interface{}, destinationId *uuid.UU
 	return config
 }
 
+// startDispatchSpan starts the span of dispatching a request which is part of a trace, i.e. a model.Request
+// carrying a trace context in its metadata, and adds the trace context of the span to the message headers
+// so that the handlers of the request continue the trace. returns nil for other messages.
+func startDispatchSpan(config *model.MessageConfig) trace.Span {
+	var metadata *model.RequestMetadata
+	switch request := config.Payload.(type) {
+	case model.Request:
+		metadata = request.Metadata
+	case *model.Request:
+		metadata = request.Metadata
+	}
+	ctx := tracing.ContextFromMetadata(context.Background(), metadata)
+	if !tracing.HasTraceContext(ctx) {
+		return nil
+	}
+	ctx, span := tracing.Tracer().Start(ctx, config.Channel+" send",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(attribute.String(tracing.ChannelAttribute, config.Channel)))
+	carrier := tracing.MessageHeaderCarrier(config.Headers)
+	tracing.Inject(ctx, &carrier)
+	config.Headers = carrier
+	return span
+}
+
 func buildError(channelName string, err error, destinationId *uuid.UUID) *model.MessageConfig {
 	config := new(model.MessageConfig)
 	id := uuid.New()
@@ -4,6 +4,7 @@
 package bus
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/google/uuid"
@@ -265,6 +266,28 @@ func TestEventBus_ListenRequestStream(t *testing.T) {
 	destroyTestChannel()
 }
 
+func TestEventBus_ListenRequestStreamOrdered(t *testing.T) {
+	createTestChannel()
+	handler, _ := evtBusTest.ListenRequestStream(evtbusTestChannelName)
+	var received []int
+	handler.(OrderedMessageHandler).HandleOrdered(
+		func(msg *model.Message) {
+			received = append(received, msg.Payload.(int))
+		},
+		func(err error) {})
+
+	for i := 0; i < 1000; i++ {
+		evtBusTest.SendRequestMessage(evtbusTestChannelName, i, nil)
+	}
+	evtbusTestManager.WaitForChannel(evtbusTestChannelName)
+	assert.Len(t, received, 1000)
+	for i, value := range received {
+		assert.Equal(t, i, value)
+	}
+	handler.Close()
+	destroyTestChannel()
+}
+
 func TestEventBus_ListenRequestStreamForDestination(t *testing.T) {
 	createTestChannel()
 	id := uuid.New()
@@ -644,6 +667,34 @@ func TestChannelManager_TestConnectBroker(t *testing.T) {
 	assert.Equal(t, c, mockCon)
 	assert.Equal(t, len(evtBusTest.brokerConnections), 1)
 	assert.Equal(t, evtBusTest.brokerConnections[mockCon.Id], mockCon)
+	assert.Equal(t, []bridge.Connection{mockCon}, evtBusTest.GetBrokerConnections())
+}
+
+func TestChannelManager_TestConnectBrokerReplacesLostConnections(t *testing.T) {
+	evtBusTest := newTestEventBus().(*transportEventBus)
+	evtBusTest.bc = new(MockBrokerConnector)
+
+	cf := &bridge.BrokerConnectorConfig{ServerAddr: "broker-url"}
+	lostId := uuid.New()
+	lostCon := &MockBridgeConnection{Id: &lostId, disconnected: true}
+	evtBusTest.bc.(*MockBrokerConnector).On("Connect", cf).Return(lostCon, nil).Once()
+	evtBusTest.ConnectBroker(cf)
+
+	otherCf := &bridge.BrokerConnectorConfig{ServerAddr: "other-broker-url"}
+	otherId := uuid.New()
+	otherCon := &MockBridgeConnection{Id: &otherId, disconnected: true}
+	evtBusTest.bc.(*MockBrokerConnector).On("Connect", otherCf).Return(otherCon, nil).Once()
+	evtBusTest.ConnectBroker(otherCf)
+
+	// reconnecting to the broker forgets the lost connection, but not the ones to other brokers
+	id := uuid.New()
+	mockCon := &MockBridgeConnection{Id: &id}
+	evtBusTest.bc.(*MockBrokerConnector).On("Connect", cf).Return(mockCon, nil).Once()
+	evtBusTest.ConnectBroker(cf)
+
+	assert.Len(t, evtBusTest.GetBrokerConnections(), 2)
+	assert.Equal(t, mockCon, evtBusTest.brokerConnections[mockCon.Id])
+	assert.Equal(t, otherCon, evtBusTest.brokerConnections[otherCon.Id])
 }
 
 func TestEventBus_TestCreateSyncTransaction(t *testing.T) {
@@ -700,17 +751,98 @@ func TestBifrostEventBus_StartFabricEndpoint(t *testing.T) {
 
 	err = bus.StartFabricEndpoint(connListener, EndpointConfig{TopicPrefix: "/topic"})
 	assert.EqualError(t, err, "unable to start: fabric endpoint is already running")
+	assert.True(t, bus.IsFabricEndpointRunning())
 
 	connListener.wg.Add(1)
 	bus.StopFabricEndpoint()
 	connListener.wg.Wait()
 
 	assert.Nil(t, bus.fabEndpoint)
+	assert.False(t, bus.IsFabricEndpointRunning())
 	assert.True(t, connListener.stopped)
 
 	assert.EqualError(t, bus.StopFabricEndpoint(), "unable to stop: fabric endpoint is not running")
 }
 
+func TestBifrostEventBus_DrainFabricEndpoint(t *testing.T) {
+	bus := newTestEventBus().(*transportEventBus)
+
+	connListener := &MockRawConnListener{
+		connections: make(chan stompserver.RawConnection),
+	}
+
+	assert.EqualError(t, bus.DrainFabricEndpoint(context.Background()),
+		"unable to drain: fabric endpoint is not running")
+
+	connListener.wg.Add(1)
+	go bus.StartFabricEndpoint(connListener, EndpointConfig{TopicPrefix: "/topic"})
+	connListener.wg.Wait()
+
+	connListener.wg.Add(1)
+	assert.Nil(t, bus.DrainFabricEndpoint(context.Background()))
+
+	assert.Nil(t, bus.fabEndpoint)
+	assert.True(t, connListener.stopped)
+}
+
+func TestBifrostEventBus_SendToClientAndUser(t *testing.T) {
+	bus := newTestEventBus().(*transportEventBus)
+
+	assert.EqualError(t, bus.SendToClient("con1", "test-channel", "test"),
+		"unable to send message to client: fabric endpoint is not running")
+	assert.EqualError(t, bus.SendToUser("user1", "test-channel", "test"),
+		"unable to send message to user: fabric endpoint is not running")
+
+	fe, mockServer := newTestFabricEndpoint(bus, EndpointConfig{UserQueuePrefix: "/user/queue"})
+	bus.fabEndpoint = fe
+	fe.Start()
+
+	assert.Nil(t, bus.SendToClient("con1", "test-channel", "test"))
+	assert.Len(t, mockServer.sentMessages, 1)
+	assert.Equal(t, "/user/queue/test-channel", mockServer.sentMessages[0].Destination)
+	assert.EqualError(t, bus.SendToUser("user1", "test-channel", "test"),
+		"unable to send message to user: no connections found for principal 'user1'")
+
+	fe.Stop()
+	bus.fabEndpoint = nil
+}
+
+func TestBifrostEventBus_StartFabricEndpointWithPipeListener(t *testing.T) {
+	bus := newTestEventBus().(*transportEventBus)
+	bus.GetChannelManager().CreateChannel("pipe-channel")
+
+	connListener := stompserver.NewPipeConnectionListener()
+	go bus.StartFabricEndpoint(connListener, EndpointConfig{TopicPrefix: "/topic", AppRequestPrefix: "/pub"})
+
+	conn, err := bridge.NewBrokerConnector().Connect(&bridge.BrokerConnectorConfig{
+		Username: "guest",
+		Password: "guest",
+		DialConn: connListener.Dial,
+	}, false)
+	assert.Nil(t, err)
+	assert.NotNil(t, conn)
+
+	// echo every request back as a response
+	reqHandler, _ := bus.ListenRequestStream("pipe-channel")
+	reqHandler.Handle(func(message *model.Message) {
+		req := message.Payload.(*model.Request)
+		bus.SendResponseMessage("pipe-channel", "echo-"+req.Request, nil)
+	}, func(e error) {})
+
+	sub, err := conn.Subscribe("/topic/pipe-channel")
+	assert.Nil(t, err)
+
+	// frames are processed in order, so the subscription is in place by the time
+	// the request reaches the channel
+	conn.SendJSONMessage("/pub/pipe-channel", []byte(`{"request": "ping"}`))
+
+	msg := <-sub.GetMsgChannel()
+	assert.Equal(t, "echo-ping", string(msg.Payload.([]byte)))
+
+	conn.Disconnect()
+	assert.Nil(t, bus.StopFabricEndpoint())
+}
+
 func TestBifrostEventBus_AddMonitorEventListener(t *testing.T) {
 
 	bus := newTestEventBus()
@@ -767,3 +899,21 @@ func TestBifrostEventBus_AddMonitorEventListener(t *testing.T) {
 	assert.Equal(t, listener2Count, 2)
 	assert.Equal(t, listener3Count, 5)
 }
+
+func TestBifrostEventBus_MonitorHasListeners(t *testing.T) {
+	bus := newTestEventBus()
+	monitor := bus.(*transportEventBus).monitor
+	assert.False(t, monitor.hasListeners(ChannelMessageSentEvt))
+
+	listener1 := bus.AddMonitorEventListener(func(event *MonitorEvent) {}, ChannelMessageSentEvt)
+	assert.True(t, monitor.hasListeners(ChannelMessageSentEvt))
+	assert.False(t, monitor.hasListeners(ChannelMessageDeliveredEvt))
+
+	listener2 := bus.AddMonitorEventListener(func(event *MonitorEvent) {})
+	assert.True(t, monitor.hasListeners(ChannelMessageDeliveredEvt))
+
+	bus.RemoveMonitorEventListener(listener2)
+	assert.False(t, monitor.hasListeners(ChannelMessageDeliveredEvt))
+	bus.RemoveMonitorEventListener(listener1)
+	assert.False(t, monitor.hasListeners(ChannelMessageSentEvt))
+}
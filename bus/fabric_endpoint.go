@@ -4,18 +4,33 @@
 package bus
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/google/uuid"
 	"github.com/vmware/transport-go/log"
 	"github.com/vmware/transport-go/model"
 	"github.com/vmware/transport-go/stompserver"
+	"github.com/vmware/transport-go/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"strings"
 	"sync"
+	"time"
 )
 
 const (
 	STOMP_SESSION_NOTIFY_CHANNEL = TRANSPORT_INTERNAL_CHANNEL_PREFIX + "stomp-session-notify"
+
+	// maximum number of private request ids remembered per connection
+	maxPrivateRequestsPerConnection = 256
+
+	// maximum number of unanswered requests tracked per connection
+	maxPendingRequestsPerConnection = 256
+
+	// time after which a bridged request without a response no longer delays draining the endpoint
+	pendingRequestTimeout = 30 * time.Second
 )
 
 type EndpointConfig struct {
@@ -34,6 +49,10 @@ type EndpointConfig struct {
 	// This behavior will mimic the Spring SimpleMessageBroker implementation.
 	AppRequestQueuePrefix string
 	Heartbeat             int64
+	// Multiplier applied to the client heart-beat interval before a silent connection
+	// is considered dead, e.g. 1.5 tolerates heart-beats arriving up to 50% late.
+	// Defaults to 1 (no tolerance).
+	HeartbeatGraceMultiplier float64
 }
 
 func (ec *EndpointConfig) validate() error {
@@ -51,6 +70,17 @@ func (ec *EndpointConfig) validate() error {
 type FabricEndpoint interface {
 	Start()
 	Stop()
+	// Drain stops accepting new client connections, notifies the connected clients that the
+	// endpoint is shutting down and stops the endpoint once all in-flight messages are processed
+	// or the context is done.
+	Drain(ctx context.Context) error
+	// SendToClient sends the payload to a single client connection on the private user queue
+	// destination of the channel (e.g. "/user/queue/<channel>"). The options are applied to the
+	// MESSAGE frame, e.g. tracing.InjectFrame to propagate the trace context of the caller.
+	SendToClient(connId string, channel string, payload interface{}, opts ...func(*frame.Frame) error) error
+	// SendToUser sends the payload to all connections authenticated as the given principal on
+	// the private user queue destination of the channel, applying the options to the MESSAGE frames.
+	SendToUser(principal string, channel string, payload interface{}, opts ...func(*frame.Frame) error) error
 }
 
 type channelMapping struct {
@@ -70,6 +100,27 @@ type fabricEndpoint struct {
 	config       EndpointConfig
 	chanLock     sync.RWMutex
 	chanMappings map[string]*channelMapping
+	presence     *presenceService
+	// private requests bridged from the clients, keyed by the request id and the connection
+	// id, used to route responses to the requesting client even if they are missing BrokerDestination
+	privateReqLock   sync.Mutex
+	privateRequests  map[uuid.UUID]map[string]*model.BrokerDestinationConfig
+	privateReqByConn map[string][]uuid.UUID
+	// metadata of the connected clients, copied to the requests they send
+	connLock     sync.RWMutex
+	connMetadata map[string]*model.RequestMetadata
+	// requests bridged from the clients that have not been answered yet, consulted
+	// when draining the endpoint
+	pendingLock     sync.Mutex
+	pendingRequests map[uuid.UUID]*pendingRequest
+	pendingByConn   map[string][]uuid.UUID
+}
+
+type pendingRequest struct {
+	connId   string
+	received time.Time
+	// context of the span which bridged the request, propagated to the clients with its response
+	spanContext trace.SpanContext
 }
 
 func addPrefixIfNotEmpty(s string, prefix string) string {
@@ -87,14 +138,19 @@ func newFabricEndpoint(bus EventBus,
 	config.AppRequestQueuePrefix = addPrefixIfNotEmpty(config.AppRequestQueuePrefix, "/")
 	config.UserQueuePrefix = addPrefixIfNotEmpty(config.UserQueuePrefix, "/")
 
-	stompConf := stompserver.NewStompConfig(config.Heartbeat,
+	stompConf := stompserver.NewStompConfigWithHeartBeatGrace(config.Heartbeat, config.HeartbeatGraceMultiplier,
 		[]string{config.AppRequestPrefix, config.AppRequestQueuePrefix})
 
 	fabricEndpoint := &fabricEndpoint{
-		server:       stompserver.NewStompServer(conListener, stompConf),
-		config:       config,
-		bus:          bus,
-		chanMappings: make(map[string]*channelMapping),
+		server:           stompserver.NewStompServer(conListener, stompConf),
+		config:           config,
+		bus:              bus,
+		chanMappings:     make(map[string]*channelMapping),
+		privateRequests:  make(map[uuid.UUID]map[string]*model.BrokerDestinationConfig),
+		privateReqByConn: make(map[string][]uuid.UUID),
+		connMetadata:     make(map[string]*model.RequestMetadata),
+		pendingRequests:  make(map[uuid.UUID]*pendingRequest),
+		pendingByConn:    make(map[string][]uuid.UUID),
 	}
 
 	fabricEndpoint.initHandlers()
@@ -102,18 +158,56 @@ func newFabricEndpoint(bus EventBus,
 }
 
 func (fe *fabricEndpoint) Start() {
+	if fe.bus != nil {
+		fe.presence = newPresenceService(fe.bus)
+	}
+
+	fe.server.SetConnectionEventCallback(stompserver.ConnectionEstablished, func(connEvent *stompserver.ConnEvent) {
+		metadata := fe.connectionEstablished(connEvent)
+		if fe.presence != nil {
+			// the presence of connections which were not authenticated is tracked under their STOMP login
+			principal := metadata.Principal
+			if principal == nil && connEvent.Principal != "" {
+				principal = &model.Principal{Name: connEvent.Principal, AuthMethod: model.AuthMethodSTOMPLogin}
+			}
+			fe.presence.connectionEstablished(connEvent.ConnId, principal)
+		}
+	})
 	fe.server.SetConnectionEventCallback(stompserver.ConnectionStarting, func(connEvent *stompserver.ConnEvent) {
+		if fe.bus != nil {
+			fe.bus.SendMonitorEvent(FabricEndpointConnectionOpenedEvt, connEvent.ConnId, nil)
+		}
 		busInstance.SendResponseMessage(STOMP_SESSION_NOTIFY_CHANNEL, &StompSessionEvent{
 			Id:        connEvent.ConnId,
 			EventType: stompserver.ConnectionStarting,
 		}, nil)
 	})
 	fe.server.SetConnectionEventCallback(stompserver.ConnectionClosed, func(connEvent *stompserver.ConnEvent) {
+		if fe.presence != nil {
+			fe.presence.connectionClosed(connEvent.ConnId)
+		}
+		fe.forgetPrivateRequests(connEvent.ConnId)
+		fe.forgetPendingRequests(connEvent.ConnId)
+		fe.connLock.Lock()
+		delete(fe.connMetadata, connEvent.ConnId)
+		fe.connLock.Unlock()
+		if fe.bus != nil {
+			fe.bus.SendMonitorEvent(FabricEndpointConnectionClosedEvt, connEvent.ConnId, nil)
+		}
 		busInstance.SendResponseMessage(STOMP_SESSION_NOTIFY_CHANNEL, &StompSessionEvent{
 			Id:        connEvent.ConnId,
 			EventType: stompserver.ConnectionClosed,
 		}, nil)
 	})
+	fe.server.SetConnectionEventCallback(stompserver.ConnectionTimedOut, func(connEvent *stompserver.ConnEvent) {
+		if fe.presence != nil {
+			fe.presence.connectionTimedOut(connEvent.ConnId)
+		}
+		busInstance.SendResponseMessage(STOMP_SESSION_NOTIFY_CHANNEL, &StompSessionEvent{
+			Id:        connEvent.ConnId,
+			EventType: stompserver.ConnectionTimedOut,
+		}, nil)
+	})
 	fe.server.SetConnectionEventCallback(stompserver.UnsubscribeFromTopic, func(connEvent *stompserver.ConnEvent) {
 		busInstance.SendResponseMessage(STOMP_SESSION_NOTIFY_CHANNEL, &StompSessionEvent{
 			Id:        connEvent.ConnId,
@@ -125,12 +219,49 @@ func (fe *fabricEndpoint) Start() {
 
 func (fe *fabricEndpoint) Stop() {
 	fe.server.Stop()
+	fe.closePresence()
+}
+
+func (fe *fabricEndpoint) Drain(ctx context.Context) error {
+	err := fe.server.Drain(ctx)
+	fe.closePresence()
+	return err
+}
+
+func (fe *fabricEndpoint) closePresence() {
+	if fe.presence != nil {
+		fe.presence.close()
+	}
 }
 
 func (fe *fabricEndpoint) initHandlers() {
-	fe.server.OnApplicationRequest(fe.bridgeMessage)
+	if frameHandler, ok := fe.server.(stompserver.ApplicationRequestFrameHandler); ok {
+		frameHandler.OnApplicationRequestFrame(fe.bridgeMessage)
+	} else {
+		// without the SEND frames the trace context of the requests is lost, and every request starts a new trace
+		fe.server.OnApplicationRequest(func(destination string, message []byte, connectionId string) {
+			f := frame.New(frame.SEND, frame.Destination, destination)
+			f.Body = message
+			fe.bridgeMessage(destination, f, connectionId)
+		})
+	}
 	fe.server.OnSubscribeEvent(fe.addSubscription)
 	fe.server.OnUnsubscribeEvent(fe.removeSubscription)
+	fe.server.OnFrame(fe.frameTransferred)
+	fe.server.OnIdleCheck(fe.allRequestsAnswered)
+}
+
+// frameTransferred sends a monitor event for every STOMP frame received from or sent to a client.
+// the command of the frame is used as the entity name of the event.
+func (fe *fabricEndpoint) frameTransferred(connectionId string, f *frame.Frame, direction stompserver.FrameDirection) {
+	if fe.bus == nil {
+		return
+	}
+	if direction == stompserver.FrameReceived {
+		fe.bus.SendMonitorEvent(FabricEndpointFrameReceivedEvt, f.Command, connectionId)
+	} else {
+		fe.bus.SendMonitorEvent(FabricEndpointFrameSentEvt, f.Command, connectionId)
+	}
 }
 
 func (fe *fabricEndpoint) addSubscription(
@@ -168,13 +299,28 @@ func (fe *fabricEndpoint) addSubscription(
 				data, err := marshalMessagePayload(message)
 				if err == nil {
 					resp, ok := convertPayloadToResponseObj(message)
-					if ok && resp != nil && resp.BrokerDestination != nil {
-						fe.server.SendMessageToClient(
-							resp.BrokerDestination.ConnectionId,
-							resp.BrokerDestination.Destination,
-							data)
+					var brokerDestination *model.BrokerDestinationConfig
+					isPrivate := false
+					if ok && resp != nil {
+						brokerDestination = resp.BrokerDestination
+						if brokerDestination == nil && resp.Id != nil {
+							brokerDestination, isPrivate = fe.getPrivateRequestDestination(*resp.Id)
+						}
+					}
+					if brokerDestination == nil && isPrivate {
+						log.Warn("Dropping response %s on channel %s: several clients sent private requests with the same id",
+							resp.Id.String(), channelName)
+					} else if brokerDestination != nil {
+						fe.sendMessageToClient(
+							brokerDestination.ConnectionId,
+							brokerDestination.Destination,
+							data,
+							fe.traceOptions(message, resp)...)
 					} else {
-						fe.server.SendMessage(fe.config.TopicPrefix+channelName, data)
+						fe.sendMessage(fe.config.TopicPrefix+channelName, data, fe.traceOptions(message, resp)...)
+					}
+					if ok && resp != nil && resp.Id != nil {
+						fe.requestAnswered(*resp.Id)
 					}
 				}
 			},
@@ -191,6 +337,9 @@ func (fe *fabricEndpoint) addSubscription(
 		fe.chanMappings[channelName] = chanMap
 	}
 	chanMap.subs[conId+"#"+subId] = true
+	if fe.presence != nil {
+		fe.presence.subscribe(conId, channelName)
+	}
 	fe.bus.SendMonitorEvent(FabricEndpointSubscribeEvt, channelName, nil)
 }
 
@@ -250,12 +399,18 @@ func (fe *fabricEndpoint) removeSubscription(conId string, subId string, destina
 					fe.bus.GetChannelManager().DestroyChannel(channelName)
 				}
 			}
+			if fe.presence != nil {
+				fe.presence.unsubscribe(conId, channelName)
+			}
 			fe.bus.SendMonitorEvent(FabricEndpointUnsubscribeEvt, channelName, nil)
 		}
 	}
 }
 
-func (fe *fabricEndpoint) bridgeMessage(destination string, message []byte, connectionId string) {
+// bridgeMessage sends the application request received from a client to the bus. the trace context of the SEND
+// frame, if any, is continued with the span of bridging the request and propagated to the service through the
+// request metadata.
+func (fe *fabricEndpoint) bridgeMessage(destination string, f *frame.Frame, connectionId string) {
 	var channelName string
 	isPrivateRequest := false
 
@@ -269,7 +424,7 @@ func (fe *fabricEndpoint) bridgeMessage(destination string, message []byte, conn
 	}
 
 	var req model.Request
-	err := json.Unmarshal(message, &req)
+	err := json.Unmarshal(f.Body, &req)
 	if err != nil {
 		log.Warn("Failed to deserialize request for channel %s", channelName)
 		return
@@ -280,11 +435,269 @@ func (fe *fabricEndpoint) bridgeMessage(destination string, message []byte, conn
 			Destination:  fe.config.UserQueuePrefix + channelName,
 			ConnectionId: connectionId,
 		}
+		if req.Id != nil {
+			fe.rememberPrivateRequest(*req.Id, req.BrokerDestination)
+		}
 	}
 
+	ctx := tracing.Extract(context.Background(), tracing.FrameHeaderCarrier{Frame: f})
+	ctx, span := tracing.Tracer().Start(ctx, channelName+" receive",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String(tracing.ChannelAttribute, channelName),
+			attribute.String(tracing.DestinationAttribute, destination),
+			attribute.String(tracing.ConnectionAttribute, connectionId)))
+	defer span.End()
+
+	req.Metadata = fe.getRequestMetadata(connectionId)
+	req.Principal = req.Metadata.Principal
+	tracing.InjectMetadata(ctx, req.Metadata)
+	if req.Id != nil && fe.hasChannelMapping(channelName) {
+		fe.trackPendingRequest(*req.Id, connectionId, span.SpanContext())
+	}
 	fe.bus.SendRequestMessage(channelName, &req, nil)
 }
 
+// connectionEstablished remembers the metadata of the client connection for the requests sent over it. the
+// principal of the requests is the one the WebSocket upgrade request was authenticated as, the STOMP login is
+// not verified by the broker and never becomes the principal of requests.
+func (fe *fabricEndpoint) connectionEstablished(connEvent *stompserver.ConnEvent) *model.RequestMetadata {
+	metadata := &model.RequestMetadata{
+		Transport:    model.TransportSTOMP,
+		RemoteAddr:   connEvent.RemoteAddr,
+		ConnectionId: connEvent.ConnId,
+		Principal:    model.PrincipalFromContext(connEvent.RequestContext),
+	}
+	fe.connLock.Lock()
+	defer fe.connLock.Unlock()
+	fe.connMetadata[connEvent.ConnId] = metadata
+	return metadata
+}
+
+// getRequestMetadata returns new metadata for a request sent over the client connection
+func (fe *fabricEndpoint) getRequestMetadata(connId string) *model.RequestMetadata {
+	fe.connLock.RLock()
+	defer fe.connLock.RUnlock()
+	metadata := model.RequestMetadata{Transport: model.TransportSTOMP, ConnectionId: connId}
+	if connMetadata, ok := fe.connMetadata[connId]; ok {
+		metadata = *connMetadata
+	}
+	return &metadata
+}
+
+// rememberPrivateRequest stores the broker destination of a private request so that
+// responses with the same id are sent only to the requesting client.
+func (fe *fabricEndpoint) rememberPrivateRequest(reqId uuid.UUID, dest *model.BrokerDestinationConfig) {
+	fe.privateReqLock.Lock()
+	defer fe.privateReqLock.Unlock()
+
+	destinations, ok := fe.privateRequests[reqId]
+	if !ok {
+		destinations = make(map[string]*model.BrokerDestinationConfig)
+		fe.privateRequests[reqId] = destinations
+	}
+	if _, exists := destinations[dest.ConnectionId]; exists {
+		return
+	}
+	destinations[dest.ConnectionId] = dest
+	connRequests := append(fe.privateReqByConn[dest.ConnectionId], reqId)
+	if len(connRequests) > maxPrivateRequestsPerConnection {
+		// forget the oldest request of the connection
+		fe.forgetPrivateRequest(connRequests[0], dest.ConnectionId)
+		connRequests = connRequests[1:]
+	}
+	fe.privateReqByConn[dest.ConnectionId] = connRequests
+}
+
+// forgetPrivateRequest removes the private request of the connection. Must be called with the privateReqLock held.
+func (fe *fabricEndpoint) forgetPrivateRequest(reqId uuid.UUID, connId string) {
+	destinations := fe.privateRequests[reqId]
+	delete(destinations, connId)
+	if len(destinations) == 0 {
+		delete(fe.privateRequests, reqId)
+	}
+}
+
+// getPrivateRequestDestination returns the broker destination of the private request with the given id
+// and true if the id belongs to a private request. if several connections sent a private request with
+// the same id the destination is nil, since the response can't be attributed to either of them.
+func (fe *fabricEndpoint) getPrivateRequestDestination(reqId uuid.UUID) (*model.BrokerDestinationConfig, bool) {
+	fe.privateReqLock.Lock()
+	defer fe.privateReqLock.Unlock()
+
+	destinations, ok := fe.privateRequests[reqId]
+	if !ok {
+		return nil, false
+	}
+	if len(destinations) != 1 {
+		return nil, true
+	}
+	for _, dest := range destinations {
+		return dest, true
+	}
+	return nil, true
+}
+
+func (fe *fabricEndpoint) forgetPrivateRequests(connId string) {
+	fe.privateReqLock.Lock()
+	defer fe.privateReqLock.Unlock()
+
+	for _, reqId := range fe.privateReqByConn[connId] {
+		fe.forgetPrivateRequest(reqId, connId)
+	}
+	delete(fe.privateReqByConn, connId)
+}
+
+func (fe *fabricEndpoint) hasChannelMapping(channelName string) bool {
+	fe.chanLock.RLock()
+	defer fe.chanLock.RUnlock()
+	_, ok := fe.chanMappings[channelName]
+	return ok
+}
+
+// trackPendingRequest remembers a request bridged to the bus until its response is relayed to
+// the clients. only requests sent to channels with subscribers are tracked, since responses on
+// other channels are never relayed. the requests of a connection that were answered or timed out
+// are forgotten first, and past maxPendingRequestsPerConnection the oldest request is dropped.
+func (fe *fabricEndpoint) trackPendingRequest(reqId uuid.UUID, connId string, spanContext trace.SpanContext) {
+	fe.pendingLock.Lock()
+	defer fe.pendingLock.Unlock()
+
+	connRequests := make([]uuid.UUID, 0, len(fe.pendingByConn[connId])+1)
+	for _, id := range fe.pendingByConn[connId] {
+		pending, ok := fe.pendingRequests[id]
+		if !ok || pending.connId != connId || id == reqId {
+			continue
+		}
+		if time.Since(pending.received) > pendingRequestTimeout {
+			delete(fe.pendingRequests, id)
+			continue
+		}
+		connRequests = append(connRequests, id)
+	}
+	if len(connRequests) >= maxPendingRequestsPerConnection {
+		delete(fe.pendingRequests, connRequests[0])
+		connRequests = connRequests[1:]
+	}
+	fe.pendingRequests[reqId] = &pendingRequest{connId: connId, received: time.Now(), spanContext: spanContext}
+	fe.pendingByConn[connId] = append(connRequests, reqId)
+}
+
+// getPendingRequestSpanContext returns the context of the span which bridged the pending request, if any
+func (fe *fabricEndpoint) getPendingRequestSpanContext(reqId uuid.UUID) trace.SpanContext {
+	fe.pendingLock.Lock()
+	defer fe.pendingLock.Unlock()
+	if pending, ok := fe.pendingRequests[reqId]; ok {
+		return pending.spanContext
+	}
+	return trace.SpanContext{}
+}
+
+func (fe *fabricEndpoint) requestAnswered(reqId uuid.UUID) {
+	fe.pendingLock.Lock()
+	defer fe.pendingLock.Unlock()
+	delete(fe.pendingRequests, reqId)
+}
+
+func (fe *fabricEndpoint) forgetPendingRequests(connId string) {
+	fe.pendingLock.Lock()
+	defer fe.pendingLock.Unlock()
+	for _, reqId := range fe.pendingByConn[connId] {
+		if pending, ok := fe.pendingRequests[reqId]; ok && pending.connId == connId {
+			delete(fe.pendingRequests, reqId)
+		}
+	}
+	delete(fe.pendingByConn, connId)
+}
+
+// allRequestsAnswered reports whether all the bridged requests have been answered or timed out.
+func (fe *fabricEndpoint) allRequestsAnswered() bool {
+	fe.pendingLock.Lock()
+	defer fe.pendingLock.Unlock()
+	for reqId, pending := range fe.pendingRequests {
+		if time.Since(pending.received) > pendingRequestTimeout {
+			delete(fe.pendingRequests, reqId)
+		}
+	}
+	return len(fe.pendingRequests) == 0
+}
+
+func (fe *fabricEndpoint) SendToClient(connId string, channel string, payload interface{},
+	opts ...func(*frame.Frame) error) error {
+
+	data, err := fe.getPrivateMessageData(channel, payload)
+	if err != nil {
+		return err
+	}
+	fe.sendMessageToClient(connId, fe.config.UserQueuePrefix+channel, data, opts...)
+	return nil
+}
+
+func (fe *fabricEndpoint) SendToUser(principal string, channel string, payload interface{},
+	opts ...func(*frame.Frame) error) error {
+
+	data, err := fe.getPrivateMessageData(channel, payload)
+	if err != nil {
+		return err
+	}
+	if fe.presence == nil {
+		return fmt.Errorf("unable to send message to user: fabric endpoint is not running")
+	}
+	connIds := fe.presence.getPrincipalConnections(principal)
+	if len(connIds) == 0 {
+		return fmt.Errorf("unable to send message to user: no connections found for principal '%s'", principal)
+	}
+	for _, connId := range connIds {
+		fe.sendMessageToClient(connId, fe.config.UserQueuePrefix+channel, data, opts...)
+	}
+	return nil
+}
+
+// traceOptions returns the frame options propagating the trace context of a message relayed to the clients: the
+// one of the message headers if any, otherwise the one of bridging the request the message is the response to.
+func (fe *fabricEndpoint) traceOptions(message *model.Message, resp *model.Response) []func(*frame.Frame) error {
+	ctx := tracing.ContextFromHeaders(context.Background(), message.Headers)
+	if !tracing.HasTraceContext(ctx) && resp != nil && resp.Id != nil {
+		ctx = trace.ContextWithRemoteSpanContext(ctx, fe.getPendingRequestSpanContext(*resp.Id))
+	}
+	if !tracing.HasTraceContext(ctx) {
+		return nil
+	}
+	return []func(*frame.Frame) error{tracing.InjectFrame(ctx)}
+}
+
+// sendMessage sends the message to the subscribers of the destination. the options are dropped
+// if the server can't apply them to the MESSAGE frames.
+func (fe *fabricEndpoint) sendMessage(destination string, data []byte, opts ...func(*frame.Frame) error) {
+	if sender, ok := fe.server.(stompserver.MessageFrameSender); ok && len(opts) > 0 {
+		sender.SendMessageWithOptions(destination, data, opts...)
+	} else {
+		fe.server.SendMessage(destination, data)
+	}
+}
+
+// sendMessageToClient sends the message to a single client connection. the options are dropped
+// if the server can't apply them to the MESSAGE frames.
+func (fe *fabricEndpoint) sendMessageToClient(connId string, destination string, data []byte,
+	opts ...func(*frame.Frame) error) {
+
+	if sender, ok := fe.server.(stompserver.MessageFrameSender); ok && len(opts) > 0 {
+		sender.SendMessageToClientWithOptions(connId, destination, data, opts...)
+	} else {
+		fe.server.SendMessageToClient(connId, destination, data)
+	}
+}
+
+func (fe *fabricEndpoint) getPrivateMessageData(channel string, payload interface{}) ([]byte, error) {
+	if fe.config.UserQueuePrefix == "" {
+		return nil, fmt.Errorf("unable to send private message: UserQueuePrefix is not configured")
+	}
+	if channel == "" || isProtectedDestination(channel) {
+		return nil, fmt.Errorf("unable to send private message: invalid channel '%s'", channel)
+	}
+	return marshalMessagePayload(&model.Message{Payload: payload})
+}
+
 func (fe *fabricEndpoint) getChannelNameFromSubscription(destination string) (channelName string, ok bool) {
 	if strings.HasPrefix(destination, fe.config.TopicPrefix) {
 		return destination[len(fe.config.TopicPrefix):], true
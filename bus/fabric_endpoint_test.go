@@ -4,30 +4,43 @@
 package bus
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"github.com/go-stomp/stomp/v3/frame"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 	"github.com/vmware/transport-go/model"
 	"github.com/vmware/transport-go/stompserver"
+	"github.com/vmware/transport-go/tracing"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
 	"sync"
 	"testing"
+	"time"
 )
 
 type MockStompServerMessage struct {
 	Destination string `json:"destination"`
 	Payload     []byte `json:"payload"`
 	conId       string
+	traceParent string
 }
 
 type MockStompServer struct {
-	started                           bool
-	sentMessages                      []MockStompServerMessage
-	subscribeHandlerFunction          stompserver.SubscribeHandlerFunction
-	connectionEventCallbacks          map[stompserver.StompSessionEventType]func(event *stompserver.ConnEvent)
-	unsubscribeHandlerFunction        stompserver.UnsubscribeHandlerFunction
-	applicationRequestHandlerFunction stompserver.ApplicationRequestHandlerFunction
-	wg                                *sync.WaitGroup
+	started                                bool
+	drained                                bool
+	sentMessages                           []MockStompServerMessage
+	subscribeHandlerFunction               stompserver.SubscribeHandlerFunction
+	connectionEventCallbacks               map[stompserver.StompSessionEventType]func(event *stompserver.ConnEvent)
+	unsubscribeHandlerFunction             stompserver.UnsubscribeHandlerFunction
+	applicationRequestHandlerFunction      stompserver.ApplicationRequestHandlerFunction
+	applicationRequestFrameHandlerFunction stompserver.ApplicationRequestFrameHandlerFunction
+	frameHandlerFunction                   stompserver.FrameHandlerFunction
+	idleCheckFunction                      stompserver.IdleCheckFunction
+	wg                                     *sync.WaitGroup
 }
 
 func (s *MockStompServer) Start() {
@@ -38,18 +51,36 @@ func (s *MockStompServer) Stop() {
 	s.started = false
 }
 
+func (s *MockStompServer) Drain(ctx context.Context) error {
+	s.drained = true
+	s.started = false
+	return ctx.Err()
+}
+
 func (s *MockStompServer) SendMessage(destination string, messageBody []byte) {
-	s.sentMessages = append(s.sentMessages,
-		MockStompServerMessage{Destination: destination, Payload: messageBody})
+	s.SendMessageWithOptions(destination, messageBody)
+}
 
-	if s.wg != nil {
-		s.wg.Done()
-	}
+func (s *MockStompServer) SendMessageWithOptions(destination string, messageBody []byte, opts ...func(*frame.Frame) error) {
+	s.addSentMessage(MockStompServerMessage{Destination: destination, Payload: messageBody}, opts)
 }
 
 func (s *MockStompServer) SendMessageToClient(conId string, destination string, messageBody []byte) {
-	s.sentMessages = append(s.sentMessages,
-		MockStompServerMessage{Destination: destination, Payload: messageBody, conId: conId})
+	s.SendMessageToClientWithOptions(conId, destination, messageBody)
+}
+
+func (s *MockStompServer) SendMessageToClientWithOptions(conId string, destination string, messageBody []byte,
+	opts ...func(*frame.Frame) error) {
+	s.addSentMessage(MockStompServerMessage{Destination: destination, Payload: messageBody, conId: conId}, opts)
+}
+
+func (s *MockStompServer) addSentMessage(msg MockStompServerMessage, opts []func(*frame.Frame) error) {
+	f := frame.New(frame.MESSAGE)
+	for _, opt := range opts {
+		opt(f)
+	}
+	msg.traceParent = f.Header.Get("traceparent")
+	s.sentMessages = append(s.sentMessages, msg)
 
 	if s.wg != nil {
 		s.wg.Done()
@@ -64,10 +95,31 @@ func (s *MockStompServer) OnApplicationRequest(callback stompserver.ApplicationR
 	s.applicationRequestHandlerFunction = callback
 }
 
+func (s *MockStompServer) OnApplicationRequestFrame(callback stompserver.ApplicationRequestFrameHandlerFunction) {
+	s.applicationRequestFrameHandlerFunction = callback
+	s.applicationRequestHandlerFunction = func(destination string, message []byte, connectionId string) {
+		f := frame.New(frame.SEND, frame.Destination, destination)
+		f.Body = message
+		callback(destination, f, connectionId)
+	}
+}
+
+func (s *MockStompServer) OnFrame(callback stompserver.FrameHandlerFunction) {
+	s.frameHandlerFunction = callback
+}
+
+func (s *MockStompServer) OnIdleCheck(callback stompserver.IdleCheckFunction) {
+	s.idleCheckFunction = callback
+}
+
 func (s *MockStompServer) OnSubscribeEvent(callback stompserver.SubscribeHandlerFunction) {
 	s.subscribeHandlerFunction = callback
 }
 
+func (s *MockStompServer) GetHeartBeatStats() stompserver.HeartBeatStats {
+	return stompserver.HeartBeatStats{}
+}
+
 func (s *MockStompServer) SetConnectionEventCallback(connEventType stompserver.StompSessionEventType, cb func(connEvent *stompserver.ConnEvent)) {
 	s.connectionEventCallbacks[connEventType] = cb
 	cb(&stompserver.ConnEvent{ConnId: "id"})
@@ -114,6 +166,101 @@ func TestFabricEndpoint_StartAndStop(t *testing.T) {
 	assert.Equal(t, mockServer.started, false)
 }
 
+func TestFabricEndpoint_ConnectionTimedOutEvent(t *testing.T) {
+	GetBus().GetChannelManager().CreateChannel(STOMP_SESSION_NOTIFY_CHANNEL)
+	fe, mockServer := newTestFabricEndpoint(nil, EndpointConfig{})
+	fe.Start()
+
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	var sessionEvent *StompSessionEvent
+	handler, _ := GetBus().ListenStream(STOMP_SESSION_NOTIFY_CHANNEL)
+	handler.Handle(func(message *model.Message) {
+		sessionEvent = message.Payload.(*StompSessionEvent)
+		wg.Done()
+	}, func(e error) {})
+
+	mockServer.connectionEventCallbacks[stompserver.ConnectionTimedOut](&stompserver.ConnEvent{ConnId: "con1"})
+	wg.Wait()
+	handler.Close()
+
+	assert.Equal(t, "con1", sessionEvent.Id)
+	assert.Equal(t, stompserver.ConnectionTimedOut, sessionEvent.EventType)
+}
+
+func TestFabricEndpoint_Presence(t *testing.T) {
+	bus := newTestEventBus()
+	fe, mockServer := newTestFabricEndpoint(bus,
+		EndpointConfig{TopicPrefix: "/topic", UserQueuePrefix: "/user/queue"})
+	fe.Start()
+	assert.NotNil(t, fe.presence)
+
+	bus.GetChannelManager().CreateChannel("test-service")
+
+	mockServer.connectionEventCallbacks[stompserver.ConnectionEstablished](
+		&stompserver.ConnEvent{ConnId: "con1", Principal: "user1"})
+	mockServer.subscribeHandlerFunction("con1", "sub1", "/topic/test-service", nil)
+	mockServer.subscribeHandlerFunction("con1", "sub2", "/topic/"+PRESENCE_CHANNEL, nil)
+
+	presence := fe.presence.getPresence("test-service")
+	assert.Len(t, presence, 1)
+	assert.Equal(t, "user1", presence[0].Principal)
+	assert.Equal(t, []string{"test-service"}, presence[0].Channels)
+
+	mockServer.unsubscribeHandlerFunction("con1", "sub1", "/topic/test-service")
+	assert.Len(t, fe.presence.getPresence("test-service"), 0)
+
+	mockServer.connectionEventCallbacks[stompserver.ConnectionClosed](&stompserver.ConnEvent{ConnId: "con1"})
+	assert.Len(t, bus.GetStoreManager().GetStore(PRESENCE_STORE).AllValues(), 0)
+
+	fe.Stop()
+}
+
+func TestFabricEndpoint_ConnectionAndFrameMonitorEvents(t *testing.T) {
+	bus := newTestEventBus()
+	fe, mockServer := newTestFabricEndpoint(bus, EndpointConfig{})
+	fe.Start()
+
+	var monitorEvents []*MonitorEvent
+	bus.AddMonitorEventListener(func(monitorEvt *MonitorEvent) {
+		monitorEvents = append(monitorEvents, monitorEvt)
+	}, FabricEndpointConnectionOpenedEvt, FabricEndpointConnectionClosedEvt,
+		FabricEndpointFrameReceivedEvt, FabricEndpointFrameSentEvt)
+
+	mockServer.connectionEventCallbacks[stompserver.ConnectionStarting](&stompserver.ConnEvent{ConnId: "con1"})
+	mockServer.frameHandlerFunction("con1", frame.New(frame.CONNECT), stompserver.FrameReceived)
+	mockServer.frameHandlerFunction("con1", frame.New(frame.CONNECTED), stompserver.FrameSent)
+	mockServer.connectionEventCallbacks[stompserver.ConnectionClosed](&stompserver.ConnEvent{ConnId: "con1"})
+
+	assert.Len(t, monitorEvents, 4)
+	assert.Equal(t, NewMonitorEvent(FabricEndpointConnectionOpenedEvt, "con1", nil), monitorEvents[0])
+	assert.Equal(t, NewMonitorEvent(FabricEndpointFrameReceivedEvt, frame.CONNECT, "con1"), monitorEvents[1])
+	assert.Equal(t, NewMonitorEvent(FabricEndpointFrameSentEvt, frame.CONNECTED, "con1"), monitorEvents[2])
+	assert.Equal(t, NewMonitorEvent(FabricEndpointConnectionClosedEvt, "con1", nil), monitorEvents[3])
+	fe.Stop()
+}
+
+func TestFabricEndpoint_FrameMonitorEventsWithoutBus(t *testing.T) {
+	fe, mockServer := newTestFabricEndpoint(nil, EndpointConfig{})
+	fe.Start()
+	assert.NotPanics(t, func() {
+		mockServer.frameHandlerFunction("con1", frame.New(frame.CONNECT), stompserver.FrameReceived)
+	})
+	fe.Stop()
+}
+
+func TestFabricEndpoint_Drain(t *testing.T) {
+	fe, mockServer := newTestFabricEndpoint(nil, EndpointConfig{})
+	fe.Start()
+	assert.Nil(t, fe.Drain(context.Background()))
+	assert.True(t, mockServer.drained)
+	assert.False(t, mockServer.started)
+
+	ctx, cancelFn := context.WithCancel(context.Background())
+	cancelFn()
+	assert.Equal(t, context.Canceled, fe.Drain(ctx))
+}
+
 func TestFabricEndpoint_SubscribeEvent(t *testing.T) {
 
 	bus := newTestEventBus()
@@ -391,3 +538,382 @@ func TestFabricEndpoint_BridgeMessage(t *testing.T) {
 	assert.Equal(t, receivedReq2.BrokerDestination.ConnectionId, "con2")
 	assert.Equal(t, receivedReq2.BrokerDestination.Destination, "/user/queue/request-channel")
 }
+
+func TestFabricEndpoint_BridgeMessageMetadata(t *testing.T) {
+	bus := newTestEventBus()
+	fe, mockServer := newTestFabricEndpoint(bus, EndpointConfig{TopicPrefix: "/topic", AppRequestPrefix: "/pub",
+		UserQueuePrefix: "/user/queue"})
+	fe.Start()
+	defer fe.Stop()
+
+	bus.GetChannelManager().CreateChannel("request-channel")
+	mh, _ := bus.ListenRequestStream("request-channel")
+	requests := make(chan *model.Request, 1)
+	mh.Handle(func(message *model.Message) {
+		requests <- message.Payload.(*model.Request)
+	}, func(e error) {})
+
+	mockServer.connectionEventCallbacks[stompserver.ConnectionEstablished](
+		&stompserver.ConnEvent{ConnId: "con1", Principal: "user1", RemoteAddr: "10.0.0.1:51234"})
+
+	// metadata sent by the client is ignored and the unverified STOMP login is not the principal of the requests
+	req, _ := json.Marshal(map[string]interface{}{
+		"request": "test-request", "metadata": map[string]interface{}{"principal": map[string]string{"name": "admin"}}})
+	mockServer.applicationRequestHandlerFunction("/pub/request-channel", req, "con1")
+	received := <-requests
+	assert.Equal(t, &model.RequestMetadata{
+		Transport:    model.TransportSTOMP,
+		RemoteAddr:   "10.0.0.1:51234",
+		ConnectionId: "con1",
+	}, received.Metadata)
+	assert.Nil(t, received.GetPrincipal())
+
+	// the metadata is never serialized
+	serialized, _ := json.Marshal(received)
+	assert.NotContains(t, string(serialized), "10.0.0.1")
+
+	mockServer.connectionEventCallbacks[stompserver.ConnectionClosed](&stompserver.ConnEvent{ConnId: "con1"})
+	mockServer.applicationRequestHandlerFunction("/pub/request-channel", req, "con1")
+	received = <-requests
+	assert.Equal(t, &model.RequestMetadata{Transport: model.TransportSTOMP, ConnectionId: "con1"}, received.Metadata)
+
+	// the principal the WebSocket connection was authenticated as takes precedence over the STOMP login
+	principal := &model.Principal{Name: "jane", AuthMethod: "jwt", Roles: []string{"admin"}}
+	mockServer.connectionEventCallbacks[stompserver.ConnectionEstablished](&stompserver.ConnEvent{
+		ConnId: "con2", Principal: "admin", RequestContext: model.ContextWithPrincipal(context.Background(), principal)})
+	mockServer.applicationRequestHandlerFunction("/pub/request-channel", req, "con2")
+	received = <-requests
+	assert.Equal(t, principal, received.Metadata.Principal)
+}
+
+func TestFabricEndpoint_BridgeMessageTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(trace.NewNoopTracerProvider())
+
+	bus := newTestEventBus()
+	fe, mockServer := newTestFabricEndpoint(bus, EndpointConfig{TopicPrefix: "/topic", AppRequestPrefix: "/pub"})
+	fe.Start()
+	defer fe.Stop()
+
+	bus.GetChannelManager().CreateChannel("request-channel")
+	mh, _ := bus.ListenRequestStream("request-channel")
+	messages := make(chan *model.Message, 1)
+	mh.Handle(func(message *model.Message) {
+		messages <- message
+	}, func(e error) {})
+
+	f := frame.New(frame.SEND, frame.Destination, "/pub/request-channel",
+		"traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	f.Body, _ = json.Marshal(model.Request{Request: "test-request"})
+	mockServer.applicationRequestFrameHandlerFunction("/pub/request-channel", f, "con1")
+	message := <-messages
+
+	// the spans end once the request is dispatched, which may happen after it's received
+	assert.Eventually(t, func() bool { return len(recorder.Ended()) == 2 }, time.Second, time.Millisecond)
+	spans := recorder.Ended()
+	bridgeSpan, dispatchSpan := spans[1], spans[0]
+	assert.Equal(t, "request-channel receive", bridgeSpan.Name())
+	assert.Equal(t, trace.SpanKindServer, bridgeSpan.SpanKind())
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", bridgeSpan.SpanContext().TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", bridgeSpan.Parent().SpanID().String())
+	assert.Equal(t, "request-channel send", dispatchSpan.Name())
+	assert.Equal(t, bridgeSpan.SpanContext().SpanID(), dispatchSpan.Parent().SpanID())
+
+	// the request carries the context of the bridging span, the message the one of the dispatching span
+	metadata := message.Payload.(*model.Request).Metadata
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-"+bridgeSpan.SpanContext().SpanID().String()+"-01",
+		metadata.TraceParent)
+	assert.Equal(t, []model.MessageHeader{{Label: "traceparent",
+		Value: "00-4bf92f3577b34da6a3ce929d0e0e4736-" + dispatchSpan.SpanContext().SpanID().String() + "-01"}},
+		message.Headers)
+
+	// requests without trace context start a new trace
+	f = frame.New(frame.SEND, frame.Destination, "/pub/request-channel")
+	f.Body, _ = json.Marshal(model.Request{Request: "test-request"})
+	mockServer.applicationRequestFrameHandlerFunction("/pub/request-channel", f, "con1")
+	message = <-messages
+	assert.Eventually(t, func() bool { return len(recorder.Ended()) == 4 }, time.Second, time.Millisecond)
+	assert.NotEqual(t, "4bf92f3577b34da6a3ce929d0e0e4736", recorder.Ended()[3].SpanContext().TraceID().String())
+	assert.False(t, recorder.Ended()[3].Parent().IsValid())
+	assert.NotEmpty(t, message.Payload.(*model.Request).Metadata.TraceParent)
+
+	// responses are sent to the clients with the context of the span which bridged the request
+	wg := sync.WaitGroup{}
+	mockServer.wg = &wg
+	mockServer.subscribeHandlerFunction("con1", "sub1", "/topic/request-channel", nil)
+	id := uuid.New()
+	f = frame.New(frame.SEND, frame.Destination, "/pub/request-channel",
+		"traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	f.Body, _ = json.Marshal(model.Request{Request: "test-request", Id: &id})
+	mockServer.applicationRequestFrameHandlerFunction("/pub/request-channel", f, "con1")
+	<-messages
+	assert.Eventually(t, func() bool { return len(recorder.Ended()) == 6 }, time.Second, time.Millisecond)
+	for _, span := range recorder.Ended()[4:] {
+		if span.Name() == "request-channel receive" {
+			bridgeSpan = span
+		}
+	}
+
+	wg.Add(1)
+	bus.SendResponseMessage("request-channel", &model.Response{Id: &id, Payload: "done"}, nil)
+	wg.Wait()
+	wg.Add(1)
+	bus.SendResponseMessage("request-channel", &model.Response{Payload: "broadcast"}, nil)
+	wg.Wait()
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-"+bridgeSpan.SpanContext().SpanID().String()+"-01",
+		mockServer.sentMessages[0].traceParent)
+	assert.Empty(t, mockServer.sentMessages[1].traceParent)
+}
+
+func TestFabricEndpoint_BridgeMessageWithoutFrames(t *testing.T) {
+	bus := newTestEventBus()
+	fe, mockServer := newTestFabricEndpoint(bus, EndpointConfig{TopicPrefix: "/topic", AppRequestPrefix: "/pub"})
+	mockServer.applicationRequestHandlerFunction = nil
+	mockServer.applicationRequestFrameHandlerFunction = nil
+
+	// servers which don't pass the SEND frames to the endpoint get the request payloads bridged
+	fe.server = struct{ stompserver.StompServer }{mockServer}
+	fe.initHandlers()
+	assert.Nil(t, mockServer.applicationRequestFrameHandlerFunction)
+	fe.Start()
+
+	bus.GetChannelManager().CreateChannel("request-channel")
+	mh, _ := bus.ListenRequestStream("request-channel")
+	requests := make(chan *model.Request, 1)
+	mh.Handle(func(message *model.Message) {
+		requests <- message.Payload.(*model.Request)
+	}, func(e error) {})
+
+	req, _ := json.Marshal(model.Request{Request: "test-request"})
+	mockServer.applicationRequestHandlerFunction("/pub/request-channel", req, "con1")
+	received := <-requests
+	assert.Equal(t, "test-request", received.Request)
+	assert.Equal(t, "con1", received.Metadata.ConnectionId)
+	fe.Stop()
+}
+
+func TestFabricEndpoint_PrivateResponseRouting(t *testing.T) {
+	bus := newTestEventBus()
+	fe, mockServer := newTestFabricEndpoint(bus, EndpointConfig{TopicPrefix: "/topic", AppRequestPrefix: "/pub",
+		AppRequestQueuePrefix: "/pub/queue", UserQueuePrefix: "/user/queue"})
+	fe.Start()
+
+	bus.GetChannelManager().CreateChannel("request-channel")
+	mockServer.subscribeHandlerFunction("con1", "sub1", "/topic/request-channel", nil)
+	mockServer.subscribeHandlerFunction("con2", "sub1", "/user/queue/request-channel", nil)
+
+	id := uuid.New()
+	req, _ := json.Marshal(model.Request{Request: "test-request", Id: &id})
+	mockServer.applicationRequestHandlerFunction("/pub/queue/request-channel", req, "con2")
+	dest, isPrivate := fe.getPrivateRequestDestination(id)
+	assert.True(t, isPrivate)
+	assert.Equal(t, "con2", dest.ConnectionId)
+
+	wg := sync.WaitGroup{}
+	mockServer.wg = &wg
+
+	// response without BrokerDestination is sent only to the requesting client
+	wg.Add(1)
+	bus.SendResponseMessage("request-channel", &model.Response{Id: &id, Payload: "private"}, nil)
+	wg.Wait()
+
+	assert.Len(t, mockServer.sentMessages, 1)
+	assert.Equal(t, "con2", mockServer.sentMessages[0].conId)
+	assert.Equal(t, "/user/queue/request-channel", mockServer.sentMessages[0].Destination)
+
+	// once the connection is closed the response is no longer correlated
+	mockServer.connectionEventCallbacks[stompserver.ConnectionClosed](&stompserver.ConnEvent{ConnId: "con2"})
+	dest, isPrivate = fe.getPrivateRequestDestination(id)
+	assert.Nil(t, dest)
+	assert.False(t, isPrivate)
+	assert.Len(t, fe.privateReqByConn, 0)
+
+	wg.Add(1)
+	bus.SendResponseMessage("request-channel", &model.Response{Id: &id, Payload: "public"}, nil)
+	wg.Wait()
+
+	assert.Len(t, mockServer.sentMessages, 2)
+	assert.Equal(t, "", mockServer.sentMessages[1].conId)
+	assert.Equal(t, "/topic/request-channel", mockServer.sentMessages[1].Destination)
+
+	mockServer.wg = nil
+	fe.Stop()
+}
+
+func TestFabricEndpoint_PrivateRequestsLimit(t *testing.T) {
+	fe, _ := newTestFabricEndpoint(nil, EndpointConfig{UserQueuePrefix: "/user/queue"})
+
+	firstId := uuid.New()
+	fe.rememberPrivateRequest(firstId, &model.BrokerDestinationConfig{ConnectionId: "con1"})
+	for i := 0; i < maxPrivateRequestsPerConnection; i++ {
+		fe.rememberPrivateRequest(uuid.New(), &model.BrokerDestinationConfig{ConnectionId: "con1"})
+	}
+
+	assert.Len(t, fe.privateRequests, maxPrivateRequestsPerConnection)
+	assert.Len(t, fe.privateReqByConn["con1"], maxPrivateRequestsPerConnection)
+	dest, _ := fe.getPrivateRequestDestination(firstId)
+	assert.Nil(t, dest)
+}
+
+func TestFabricEndpoint_PrivateRequestsWithTheSameId(t *testing.T) {
+	bus := newTestEventBus()
+	fe, mockServer := newTestFabricEndpoint(bus, EndpointConfig{TopicPrefix: "/topic", AppRequestPrefix: "/pub",
+		AppRequestQueuePrefix: "/pub/queue", UserQueuePrefix: "/user/queue"})
+	fe.Start()
+
+	bus.GetChannelManager().CreateChannel("request-channel")
+	mockServer.subscribeHandlerFunction("con1", "sub1", "/user/queue/request-channel", nil)
+	mockServer.subscribeHandlerFunction("con2", "sub1", "/user/queue/request-channel", nil)
+
+	// another client reusing the id of a private request doesn't take over its responses
+	id := uuid.New()
+	req, _ := json.Marshal(model.Request{Request: "test-request", Id: &id})
+	mockServer.applicationRequestHandlerFunction("/pub/queue/request-channel", req, "con1")
+	mockServer.applicationRequestHandlerFunction("/pub/queue/request-channel", req, "con2")
+	assert.Len(t, fe.privateRequests[id], 2)
+
+	wg := sync.WaitGroup{}
+	mockServer.wg = &wg
+
+	// the response can't be attributed to either of the clients and is neither sent to them nor broadcast
+	bus.SendResponseMessage("request-channel", &model.Response{Id: &id, Payload: "private"}, nil)
+	assert.Eventually(t, func() bool { return fe.allRequestsAnswered() }, time.Second, time.Millisecond)
+
+	// once one of the clients disconnects the response goes to the other one
+	mockServer.connectionEventCallbacks[stompserver.ConnectionClosed](&stompserver.ConnEvent{ConnId: "con2"})
+	wg.Add(1)
+	bus.SendResponseMessage("request-channel", &model.Response{Id: &id, Payload: "private"}, nil)
+	wg.Wait()
+
+	assert.Len(t, mockServer.sentMessages, 1)
+	assert.Equal(t, "con1", mockServer.sentMessages[0].conId)
+	assert.Equal(t, "/user/queue/request-channel", mockServer.sentMessages[0].Destination)
+
+	mockServer.wg = nil
+	fe.Stop()
+}
+
+func TestFabricEndpoint_PendingRequests(t *testing.T) {
+	bus := newTestEventBus()
+	fe, mockServer := newTestFabricEndpoint(bus, EndpointConfig{TopicPrefix: "/topic", AppRequestPrefix: "/pub"})
+	fe.Start()
+
+	bus.GetChannelManager().CreateChannel("request-channel")
+	bus.GetChannelManager().CreateChannel("no-subscribers")
+	mockServer.subscribeHandlerFunction("con1", "sub1", "/topic/request-channel", nil)
+	assert.True(t, mockServer.idleCheckFunction())
+
+	// requests to channels without subscribers are never answered over the endpoint
+	id := uuid.New()
+	req, _ := json.Marshal(model.Request{Request: "test-request", Id: &id})
+	mockServer.applicationRequestHandlerFunction("/pub/no-subscribers", req, "con1")
+	assert.True(t, mockServer.idleCheckFunction())
+
+	mockServer.applicationRequestHandlerFunction("/pub/request-channel", req, "con1")
+	assert.False(t, mockServer.idleCheckFunction())
+
+	bus.SendResponseMessage("request-channel", &model.Response{Id: &id, Payload: "done"}, nil)
+	assert.Eventually(t, func() bool { return mockServer.idleCheckFunction() }, time.Second, time.Millisecond)
+
+	// requests of closed connections and expired requests are no longer awaited
+	mockServer.applicationRequestHandlerFunction("/pub/request-channel", req, "con1")
+	assert.False(t, mockServer.idleCheckFunction())
+	mockServer.connectionEventCallbacks[stompserver.ConnectionClosed](&stompserver.ConnEvent{ConnId: "con1"})
+	assert.True(t, mockServer.idleCheckFunction())
+
+	mockServer.applicationRequestHandlerFunction("/pub/request-channel", req, "con2")
+	fe.pendingRequests[id].received = time.Now().Add(-pendingRequestTimeout - time.Second)
+	assert.True(t, mockServer.idleCheckFunction())
+	assert.Len(t, fe.pendingRequests, 0)
+
+	fe.Stop()
+}
+
+func TestFabricEndpoint_PendingRequestsAreBounded(t *testing.T) {
+	fe, _ := newTestFabricEndpoint(nil, EndpointConfig{TopicPrefix: "/topic"})
+
+	firstId := uuid.New()
+	fe.trackPendingRequest(firstId, "con1", trace.SpanContext{})
+	for i := 0; i < maxPendingRequestsPerConnection; i++ {
+		fe.trackPendingRequest(uuid.New(), "con1", trace.SpanContext{})
+	}
+	assert.Len(t, fe.pendingRequests, maxPendingRequestsPerConnection)
+	assert.Len(t, fe.pendingByConn["con1"], maxPendingRequestsPerConnection)
+	assert.Nil(t, fe.pendingRequests[firstId])
+
+	// expired requests are forgotten when the connection sends another request
+	for _, pending := range fe.pendingRequests {
+		pending.received = time.Now().Add(-pendingRequestTimeout - time.Second)
+	}
+	lastId := uuid.New()
+	fe.trackPendingRequest(lastId, "con1", trace.SpanContext{})
+	assert.Len(t, fe.pendingRequests, 1)
+	assert.Equal(t, []uuid.UUID{lastId}, fe.pendingByConn["con1"])
+
+	fe.forgetPendingRequests("con1")
+	assert.Len(t, fe.pendingRequests, 0)
+	assert.Len(t, fe.pendingByConn, 0)
+}
+
+func TestFabricEndpoint_SendToClientAndUser(t *testing.T) {
+	bus := newTestEventBus()
+	fe, mockServer := newTestFabricEndpoint(bus, EndpointConfig{TopicPrefix: "/topic", UserQueuePrefix: "/user/queue"})
+
+	err := fe.SendToUser("user1", "test-channel", "test")
+	assert.EqualError(t, err, "unable to send message to user: fabric endpoint is not running")
+
+	fe.Start()
+	authenticated := func(name string) context.Context {
+		return model.ContextWithPrincipal(context.Background(), &model.Principal{Name: name, AuthMethod: "jwt"})
+	}
+	mockServer.connectionEventCallbacks[stompserver.ConnectionEstablished](
+		&stompserver.ConnEvent{ConnId: "con1", RequestContext: authenticated("user1")})
+	mockServer.connectionEventCallbacks[stompserver.ConnectionEstablished](
+		&stompserver.ConnEvent{ConnId: "con2", RequestContext: authenticated("user1")})
+	mockServer.connectionEventCallbacks[stompserver.ConnectionEstablished](
+		&stompserver.ConnEvent{ConnId: "con3", RequestContext: authenticated("user2")})
+
+	assert.Nil(t, fe.SendToClient("con3", "test-channel", "client-message"))
+	assert.Len(t, mockServer.sentMessages, 1)
+	assert.Equal(t, "con3", mockServer.sentMessages[0].conId)
+	assert.Equal(t, "/user/queue/test-channel", mockServer.sentMessages[0].Destination)
+	assert.Equal(t, "client-message", string(mockServer.sentMessages[0].Payload))
+
+	assert.Nil(t, fe.SendToUser("user1", "test-channel", []byte{1, 2}))
+	assert.Len(t, mockServer.sentMessages, 3)
+	assert.Equal(t, "con1", mockServer.sentMessages[1].conId)
+	assert.Equal(t, "con2", mockServer.sentMessages[2].conId)
+	assert.Equal(t, []byte{1, 2}, mockServer.sentMessages[2].Payload)
+
+	// the options of the caller are applied to the MESSAGE frames, e.g. to propagate its trace context
+	traceParent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	ctx := tracing.ContextFromHeaders(context.Background(), []model.MessageHeader{{Label: "traceparent", Value: traceParent}})
+	assert.Nil(t, fe.SendToUser("user2", "test-channel", "traced", tracing.InjectFrame(ctx)))
+	assert.Len(t, mockServer.sentMessages, 4)
+	assert.Equal(t, "con3", mockServer.sentMessages[3].conId)
+	assert.Equal(t, traceParent, mockServer.sentMessages[3].traceParent)
+	assert.Empty(t, mockServer.sentMessages[2].traceParent)
+
+	err = fe.SendToUser("user3", "test-channel", "test")
+	assert.EqualError(t, err, "unable to send message to user: no connections found for principal 'user3'")
+
+	// a connection with the same STOMP login gets none of the messages of the authenticated principal
+	mockServer.connectionEventCallbacks[stompserver.ConnectionEstablished](
+		&stompserver.ConnEvent{ConnId: "con4", Principal: "user1"})
+	assert.Nil(t, fe.SendToUser("user1", "test-channel", "private"))
+	assert.Len(t, mockServer.sentMessages, 6)
+	for _, msg := range mockServer.sentMessages[4:] {
+		assert.NotEqual(t, "con4", msg.conId)
+	}
+
+	err = fe.SendToClient("con1", "", "test")
+	assert.EqualError(t, err, "unable to send private message: invalid channel ''")
+
+	fe.config.UserQueuePrefix = ""
+	err = fe.SendToClient("con1", "test-channel", "test")
+	assert.EqualError(t, err, "unable to send private message: UserQueuePrefix is not configured")
+	assert.Len(t, mockServer.sentMessages, 6)
+
+	fe.Stop()
+}
@@ -27,6 +27,14 @@ type MessageHandler interface {
 	Close()
 }
 
+// OrderedMessageHandler is implemented by the MessageHandlers of the bus which can deliver the messages
+// to the success handler one at a time, in the order in which they were sent to the channel, instead of
+// each message in its own goroutine. The success handler should return quickly as it delays the
+// delivery of the following messages.
+type OrderedMessageHandler interface {
+	HandleOrdered(successHandler MessageHandlerFunction, errorHandler MessageErrorFunction)
+}
+
 type messageHandler struct {
 	id              *uuid.UUID
 	destination     *uuid.UUID
@@ -52,6 +60,19 @@ func (msgHandler *messageHandler) Handle(successHandler MessageHandlerFunction,
 		msgHandler.channel.Name, msgHandler.wrapperFunction, false)
 }
 
+func (msgHandler *messageHandler) HandleOrdered(successHandler MessageHandlerFunction, errorHandler MessageErrorFunction) {
+	manager, ok := msgHandler.channelManager.(*busChannelManager)
+	if !ok {
+		msgHandler.Handle(successHandler, errorHandler)
+		return
+	}
+	msgHandler.successHandler = successHandler
+	msgHandler.errorHandler = errorHandler
+
+	msgHandler.subscriptionId, _ = manager.subscribeChannelHandler(
+		msgHandler.channel.Name, msgHandler.wrapperFunction, false, true)
+}
+
 func (msgHandler *messageHandler) Close() {
 	if msgHandler.subscriptionId != nil {
 		msgHandler.channelManager.UnsubscribeChannelHandler(
@@ -3,6 +3,11 @@
 
 package bus
 
+import (
+	"github.com/vmware/transport-go/model"
+	"time"
+)
+
 type MonitorEventType int32
 
 const (
@@ -17,6 +22,21 @@ const (
 	BrokerUnsubscribedEvt
 	FabricEndpointSubscribeEvt
 	FabricEndpointUnsubscribeEvt
+	RestRequestRetryEvt
+	RestCircuitBreakerOpenedEvt
+	RestCircuitBreakerHalfOpenedEvt
+	RestCircuitBreakerClosedEvt
+	ServiceCacheHitEvt
+	ServiceCacheMissEvt
+	ServiceCacheEvictedEvt
+	ChannelMessageSentEvt
+	ChannelMessageDeliveredEvt
+	FabricEndpointConnectionOpenedEvt
+	FabricEndpointConnectionClosedEvt
+	FabricEndpointFrameReceivedEvt
+	FabricEndpointFrameSentEvt
+	ServiceRequestHandledEvt
+	ServiceResponseSentEvt
 )
 
 type MonitorEventHandler func(event *MonitorEvent)
@@ -30,6 +50,14 @@ type MonitorEvent struct {
 	Data interface{}
 }
 
+// MessageDeliveryMonitorData is the data of ChannelMessageDeliveredEvt events, sent
+// once a handler subscribed to the channel has processed a message.
+type MessageDeliveryMonitorData struct {
+	Message *model.Message
+	// time elapsed between sending the message and the handler returning
+	Latency time.Duration
+}
+
 // Create a new monitor event
 func NewMonitorEvent(evtType MonitorEventType, entityName string, data interface{}) *MonitorEvent {
 	return &MonitorEvent{EventType: evtType, Data: data, EntityName: entityName}
@@ -0,0 +1,375 @@
+// Copyright 2019-2021 VMware, Inc.
+// SPDX-License-Identifier: BSD-2-Clause
+
+package bus
+
+import (
+	"github.com/google/uuid"
+	"github.com/vmware/transport-go/model"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	// PRESENCE_CHANNEL is the reserved channel on which presence join/leave events are published.
+	// Clients can also send a "getPresence" request to this channel to get the current presence list.
+	PRESENCE_CHANNEL = "transport-presence"
+	// PRESENCE_STORE is the name of the BusStore holding a ClientPresence item for
+	// every connected client, keyed by the principal and the connection id, e.g. "jane/<connection id>",
+	// or by the connection id alone for anonymous clients. The store is not synced to clients.
+	PRESENCE_STORE = "transport-presence"
+	// PRESENCE_ADMIN_ROLE is the role a principal needs to get the presence of all connected
+	// clients. Other clients only get the presence of their own connections.
+	PRESENCE_ADMIN_ROLE = "transport-presence-admin"
+
+	getPresenceRequest = "getPresence"
+
+	presenceConnected    = "connected"
+	presenceUpdated      = "updated"
+	presenceDisconnected = "disconnected"
+)
+
+type PresenceEventType string
+
+const (
+	PresenceJoin  PresenceEventType = "join"
+	PresenceLeave PresenceEventType = "leave"
+)
+
+// ClientPresence describes a single client connection and the channels it is subscribed to.
+type ClientPresence struct {
+	ConnectionId string   `json:"connectionId"`
+	Principal    string   `json:"principal,omitempty"`
+	AuthMethod   string   `json:"authMethod,omitempty"`
+	Channels     []string `json:"channels"`
+}
+
+// PresenceEvent is published on the PRESENCE_CHANNEL when a principal joins or leaves a channel.
+// Clients without a principal are tracked by their connection id. A principal connected with
+// several connections joins a channel with its first subscription and leaves it only after the
+// last of its connections unsubscribes, so reconnects don't produce spurious leave events.
+type PresenceEvent struct {
+	EventType    PresenceEventType `json:"eventType"`
+	Channel      string            `json:"channel"`
+	Principal    string            `json:"principal,omitempty"`
+	ConnectionId string            `json:"connectionId"`
+	// true if the leave was caused by a client which stopped sending heart-beats
+	TimedOut bool `json:"timedOut,omitempty"`
+}
+
+type presenceConnection struct {
+	principal  string
+	authMethod string
+	channels   map[string]int // number of subscriptions per channel
+	timedOut   bool
+}
+
+// storeKey returns the key of the connection in the presence store.
+func (pc *presenceConnection) storeKey(connId string) string {
+	if pc.principal == "" {
+		return connId
+	}
+	return pc.principal + "/" + connId
+}
+
+// memberKey returns the key used to aggregate the connections of the same principal.
+func (pc *presenceConnection) memberKey(connId string) string {
+	if pc.principal == "" {
+		return connId
+	}
+	return pc.principal
+}
+
+type presenceService struct {
+	bus            EventBus
+	store          BusStore
+	lock           sync.Mutex
+	connections    map[string]*presenceConnection
+	members        map[string]map[string]int // channel -> member key -> number of subscribed connections
+	requestHandler MessageHandler
+}
+
+func newPresenceService(bus EventBus) *presenceService {
+	ps := &presenceService{
+		bus:         bus,
+		store:       bus.GetStoreManager().CreateStore(PRESENCE_STORE),
+		connections: make(map[string]*presenceConnection),
+		members:     make(map[string]map[string]int),
+	}
+	ps.store.Initialize()
+
+	bus.GetChannelManager().CreateChannel(PRESENCE_CHANNEL)
+	ps.requestHandler, _ = bus.ListenRequestStream(PRESENCE_CHANNEL)
+	if ps.requestHandler != nil {
+		ps.requestHandler.Handle(ps.handleRequest, func(e error) {})
+	}
+	return ps
+}
+
+// isPresenceTracked returns false for the reserved channels which
+// are not relevant for the presence of a client.
+func isPresenceTracked(channel string) bool {
+	return channel != PRESENCE_CHANNEL && !strings.HasPrefix(channel, "transport-store-sync.")
+}
+
+func (ps *presenceService) getConnection(connId string) *presenceConnection {
+	pc, ok := ps.connections[connId]
+	if !ok {
+		pc = &presenceConnection{channels: make(map[string]int)}
+		ps.connections[connId] = pc
+	}
+	return pc
+}
+
+func (ps *presenceService) connectionEstablished(connId string, principal *model.Principal) {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+
+	pc := ps.getConnection(connId)
+	if principal != nil && principal.Name != "" {
+		if _, stored := ps.store.Get(pc.storeKey(connId)); stored {
+			// the connection subscribed before it was established and was stored without the principal
+			ps.store.Remove(pc.storeKey(connId), presenceUpdated)
+		}
+		pc.principal = principal.Name
+		pc.authMethod = principal.AuthMethod
+	}
+	ps.store.Put(pc.storeKey(connId), ps.toClientPresence(connId, pc), presenceConnected)
+}
+
+func (ps *presenceService) connectionTimedOut(connId string) {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+
+	if pc, ok := ps.connections[connId]; ok {
+		pc.timedOut = true
+	}
+}
+
+func (ps *presenceService) connectionClosed(connId string) {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+
+	pc, ok := ps.connections[connId]
+	if !ok {
+		return
+	}
+	// the server removes all subscriptions before closing the connection,
+	// this only cleans up after connections which were not unsubscribed.
+	for channel := range pc.channels {
+		ps.leaveChannel(connId, pc, channel)
+	}
+	delete(ps.connections, connId)
+	ps.store.Remove(pc.storeKey(connId), presenceDisconnected)
+}
+
+func (ps *presenceService) subscribe(connId string, channel string) {
+	if !isPresenceTracked(channel) {
+		return
+	}
+
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+
+	pc := ps.getConnection(connId)
+	pc.channels[channel]++
+	if pc.channels[channel] > 1 {
+		// already subscribed to the channel
+		return
+	}
+
+	channelMembers, ok := ps.members[channel]
+	if !ok {
+		channelMembers = make(map[string]int)
+		ps.members[channel] = channelMembers
+	}
+	key := pc.memberKey(connId)
+	channelMembers[key]++
+	if channelMembers[key] == 1 {
+		ps.sendPresenceEvent(&PresenceEvent{
+			EventType:    PresenceJoin,
+			Channel:      channel,
+			Principal:    pc.principal,
+			ConnectionId: connId,
+		})
+	}
+	ps.store.Put(pc.storeKey(connId), ps.toClientPresence(connId, pc), presenceUpdated)
+}
+
+func (ps *presenceService) unsubscribe(connId string, channel string) {
+	if !isPresenceTracked(channel) {
+		return
+	}
+
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+
+	pc, ok := ps.connections[connId]
+	if !ok || pc.channels[channel] == 0 {
+		return
+	}
+	pc.channels[channel]--
+	if pc.channels[channel] > 0 {
+		// the connection has other subscriptions to the same channel
+		return
+	}
+	ps.leaveChannel(connId, pc, channel)
+	ps.store.Put(pc.storeKey(connId), ps.toClientPresence(connId, pc), presenceUpdated)
+}
+
+// leaveChannel removes the connection from the channel members. Must be called with the lock held.
+func (ps *presenceService) leaveChannel(connId string, pc *presenceConnection, channel string) {
+	delete(pc.channels, channel)
+
+	channelMembers := ps.members[channel]
+	key := pc.memberKey(connId)
+	channelMembers[key]--
+	if channelMembers[key] > 0 {
+		// other connections of the same principal are still present
+		return
+	}
+	delete(channelMembers, key)
+	if len(channelMembers) == 0 {
+		delete(ps.members, channel)
+	}
+	ps.sendPresenceEvent(&PresenceEvent{
+		EventType:    PresenceLeave,
+		Channel:      channel,
+		Principal:    pc.principal,
+		ConnectionId: connId,
+		TimedOut:     pc.timedOut,
+	})
+}
+
+// getPresence returns the presence of all connections subscribed to the given
+// channel or all connections if channel is empty.
+func (ps *presenceService) getPresence(channel string) []*ClientPresence {
+	return ps.getVisiblePresence(channel, nil)
+}
+
+// getVisiblePresence returns the presence of the connections subscribed to the given channel,
+// or all connections if channel is empty, which are visible to the caller with the request
+// metadata. requests without metadata were sent on the bus by the server itself and see all
+// connections, as do the principals with the PRESENCE_ADMIN_ROLE. other principals only see
+// their own connections and anonymous clients only the connection they sent the request over.
+func (ps *presenceService) getVisiblePresence(channel string, metadata *model.RequestMetadata) []*ClientPresence {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+
+	result := make([]*ClientPresence, 0)
+	for connId, pc := range ps.connections {
+		if !isPresenceVisible(connId, pc, metadata) {
+			continue
+		}
+		if _, ok := pc.channels[channel]; channel == "" || ok {
+			result = append(result, ps.toClientPresence(connId, pc))
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ConnectionId < result[j].ConnectionId
+	})
+	return result
+}
+
+func isPresenceVisible(connId string, pc *presenceConnection, metadata *model.RequestMetadata) bool {
+	if metadata == nil || metadata.Principal.HasRole(PRESENCE_ADMIN_ROLE) {
+		return true
+	}
+	if connId == metadata.ConnectionId {
+		return true
+	}
+	caller := metadata.Principal
+	return isVerifiedPrincipal(caller) && pc.principal == caller.Name && pc.authMethod != model.AuthMethodSTOMPLogin
+}
+
+// isVerifiedPrincipal returns false for anonymous callers and for the principals of the STOMP
+// login header, which the broker does not verify.
+func isVerifiedPrincipal(principal *model.Principal) bool {
+	return principal != nil && principal.Name != "" && principal.AuthMethod != model.AuthMethodSTOMPLogin
+}
+
+// getPrincipalConnections returns the ids of all connections of the given principal. connections
+// with the unverified principal of the STOMP login header are never returned, anybody can connect
+// with any login.
+func (ps *presenceService) getPrincipalConnections(principal string) []string {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+
+	connIds := make([]string, 0)
+	for connId, pc := range ps.connections {
+		if principal != "" && pc.principal == principal && pc.authMethod != model.AuthMethodSTOMPLogin {
+			connIds = append(connIds, connId)
+		}
+	}
+	sort.Strings(connIds)
+	return connIds
+}
+
+func (ps *presenceService) handleRequest(message *model.Message) {
+	request, ok := message.Payload.(*model.Request)
+	if !ok {
+		return
+	}
+
+	response := &model.Response{
+		Id:                request.Id,
+		Destination:       PRESENCE_CHANNEL,
+		BrokerDestination: request.BrokerDestination,
+	}
+
+	switch request.Request {
+	case getPresenceRequest:
+		var channel string
+		if payload, ok := request.Payload.(map[string]interface{}); ok {
+			channel, _ = getStingProperty("channel", payload)
+		}
+		response.Payload = ps.getVisiblePresence(channel, request.Metadata)
+	default:
+		response.Error = true
+		response.ErrorCode = 1
+		response.ErrorMessage = "unsupported presence request: " + request.Request
+	}
+
+	ps.bus.SendResponseMessage(PRESENCE_CHANNEL, response, nil)
+}
+
+func (ps *presenceService) sendPresenceEvent(event *PresenceEvent) {
+	id := uuid.New()
+	ps.bus.SendResponseMessage(PRESENCE_CHANNEL, &model.Response{
+		Id:          &id,
+		Destination: PRESENCE_CHANNEL,
+		Payload:     event,
+	}, nil)
+}
+
+func (ps *presenceService) toClientPresence(connId string, pc *presenceConnection) *ClientPresence {
+	channels := make([]string, 0, len(pc.channels))
+	for channel := range pc.channels {
+		channels = append(channels, channel)
+	}
+	sort.Strings(channels)
+	return &ClientPresence{
+		ConnectionId: connId,
+		Principal:    pc.principal,
+		AuthMethod:   pc.authMethod,
+		Channels:     channels,
+	}
+}
+
+// close stops handling presence requests and removes all
+// connections from the presence store.
+func (ps *presenceService) close() {
+	if ps.requestHandler != nil {
+		ps.requestHandler.Close()
+	}
+
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+
+	for connId, pc := range ps.connections {
+		ps.store.Remove(pc.storeKey(connId), presenceDisconnected)
+	}
+	ps.connections = make(map[string]*presenceConnection)
+	ps.members = make(map[string]map[string]int)
+}
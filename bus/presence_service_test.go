@@ -0,0 +1,229 @@
+// Copyright 2019-2021 VMware, Inc.
+// SPDX-License-Identifier: BSD-2-Clause
+
+package bus
+
+import (
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/vmware/transport-go/model"
+	"sync"
+	"testing"
+)
+
+func testPresenceService() (*presenceService, EventBus) {
+	bus := newTestEventBus()
+	return newPresenceService(bus), bus
+}
+
+func listenPresenceEvents(t *testing.T, bus EventBus, wg *sync.WaitGroup) (*[]*PresenceEvent, MessageHandler) {
+	var events []*PresenceEvent
+	var lock sync.Mutex
+	mh, _ := bus.ListenStream(PRESENCE_CHANNEL)
+	mh.Handle(func(message *model.Message) {
+		resp := message.Payload.(*model.Response)
+		if evt, ok := resp.Payload.(*PresenceEvent); ok {
+			lock.Lock()
+			events = append(events, evt)
+			lock.Unlock()
+			wg.Done()
+		}
+	}, func(e error) {
+		assert.Fail(t, "Unexpected error")
+	})
+	return &events, mh
+}
+
+func TestPresenceService_JoinAndLeave(t *testing.T) {
+	ps, bus := testPresenceService()
+
+	wg := sync.WaitGroup{}
+	events, mh := listenPresenceEvents(t, bus, &wg)
+	defer mh.Close()
+
+	ps.connectionEstablished("con1", &model.Principal{Name: "user1", AuthMethod: "jwt"})
+
+	wg.Add(1)
+	ps.subscribe("con1", "channel1")
+	wg.Wait()
+
+	assert.Len(t, *events, 1)
+	assert.Equal(t, &PresenceEvent{
+		EventType:    PresenceJoin,
+		Channel:      "channel1",
+		Principal:    "user1",
+		ConnectionId: "con1",
+	}, (*events)[0])
+
+	// subscribing twice to the same channel doesn't produce a second join event
+	ps.subscribe("con1", "channel1")
+	ps.unsubscribe("con1", "channel1")
+
+	// the store is keyed by the principal and the connection
+	value, ok := ps.store.Get("user1/con1")
+	assert.True(t, ok)
+	assert.Equal(t, &ClientPresence{
+		ConnectionId: "con1",
+		Principal:    "user1",
+		AuthMethod:   "jwt",
+		Channels:     []string{"channel1"},
+	}, value)
+
+	wg.Add(1)
+	ps.unsubscribe("con1", "channel1")
+	wg.Wait()
+
+	assert.Len(t, *events, 2)
+	assert.Equal(t, PresenceLeave, (*events)[1].EventType)
+	assert.Equal(t, "channel1", (*events)[1].Channel)
+	assert.False(t, (*events)[1].TimedOut)
+
+	ps.connectionClosed("con1")
+	_, ok = ps.store.Get("user1/con1")
+	assert.False(t, ok)
+}
+
+func TestPresenceService_PrincipalWithMultipleConnections(t *testing.T) {
+	ps, bus := testPresenceService()
+
+	wg := sync.WaitGroup{}
+	events, mh := listenPresenceEvents(t, bus, &wg)
+	defer mh.Close()
+
+	ps.connectionEstablished("con1", &model.Principal{Name: "user1", AuthMethod: "jwt"})
+	wg.Add(1)
+	ps.subscribe("con1", "channel1")
+	wg.Wait()
+
+	// simulate a reconnect, the new connection is established before the old one is closed
+	ps.connectionEstablished("con2", &model.Principal{Name: "user1", AuthMethod: "jwt"})
+	ps.subscribe("con2", "channel1")
+	ps.connectionTimedOut("con1")
+	ps.unsubscribe("con1", "channel1")
+	ps.connectionClosed("con1")
+
+	assert.Len(t, *events, 1)
+	assert.Len(t, ps.getPresence("channel1"), 1)
+	assert.Equal(t, "con2", ps.getPresence("channel1")[0].ConnectionId)
+
+	// last connection of the principal leaves the channel
+	wg.Add(1)
+	ps.connectionTimedOut("con2")
+	ps.connectionClosed("con2")
+	wg.Wait()
+
+	assert.Len(t, *events, 2)
+	assert.Equal(t, &PresenceEvent{
+		EventType:    PresenceLeave,
+		Channel:      "channel1",
+		Principal:    "user1",
+		ConnectionId: "con2",
+		TimedOut:     true,
+	}, (*events)[1])
+	assert.Len(t, ps.getPresence(""), 0)
+}
+
+func TestPresenceService_AnonymousConnections(t *testing.T) {
+	ps, bus := testPresenceService()
+
+	wg := sync.WaitGroup{}
+	events, mh := listenPresenceEvents(t, bus, &wg)
+	defer mh.Close()
+
+	// anonymous connections are tracked separately
+	wg.Add(2)
+	ps.subscribe("con1", "channel1")
+	ps.subscribe("con2", "channel1")
+	wg.Wait()
+	assert.Len(t, *events, 2)
+	_, ok := ps.store.Get("con1")
+	assert.True(t, ok)
+
+	// a connection which subscribed before it was established is stored again with its principal
+	ps.connectionEstablished("con2", &model.Principal{Name: "user2", AuthMethod: "jwt"})
+	_, ok = ps.store.Get("con2")
+	assert.False(t, ok)
+	_, ok = ps.store.Get("user2/con2")
+	assert.True(t, ok)
+
+	// reserved channels are ignored
+	ps.subscribe("con1", PRESENCE_CHANNEL)
+	ps.subscribe("con1", "transport-store-sync.1")
+	assert.Equal(t, []string{"channel1"}, ps.getPresence("channel1")[0].Channels)
+	assert.Len(t, ps.getPresence(""), 2)
+
+	ps.close()
+	assert.Len(t, ps.getPresence(""), 0)
+	assert.Len(t, ps.store.AllValues(), 0)
+}
+
+func TestPresenceService_GetPresenceRequest(t *testing.T) {
+	ps, bus := testPresenceService()
+	ps.connectionEstablished("con1", &model.Principal{Name: "user1", AuthMethod: "jwt"})
+	ps.subscribe("con1", "channel1")
+	ps.connectionEstablished("con2", &model.Principal{Name: "user2", AuthMethod: "jwt"})
+	ps.subscribe("con2", "channel2")
+	ps.connectionEstablished("con3", &model.Principal{Name: "user2", AuthMethod: model.AuthMethodSTOMPLogin})
+	ps.subscribe("con3", "channel2")
+	ps.subscribe("con4", "channel2")
+
+	wg := sync.WaitGroup{}
+	var responses []*model.Response
+	mh, _ := bus.ListenStream(PRESENCE_CHANNEL)
+	mh.Handle(func(message *model.Message) {
+		resp := message.Payload.(*model.Response)
+		if _, ok := resp.Payload.(*PresenceEvent); !ok {
+			responses = append(responses, resp)
+			wg.Done()
+		}
+	}, func(e error) {})
+	defer mh.Close()
+
+	getPresence := func(metadata *model.RequestMetadata) *model.Response {
+		id := uuid.New()
+		wg.Add(1)
+		bus.SendRequestMessage(PRESENCE_CHANNEL, &model.Request{
+			Id:      &id,
+			Request: getPresenceRequest,
+			Payload: map[string]interface{}{"channel": "channel2"},
+			BrokerDestination: &model.BrokerDestinationConfig{
+				Destination:  "/user/queue/" + PRESENCE_CHANNEL,
+				ConnectionId: metadata.ConnectionId,
+			},
+			Metadata: metadata,
+		}, nil)
+		wg.Wait()
+		assert.Equal(t, &id, responses[len(responses)-1].Id)
+		return responses[len(responses)-1]
+	}
+
+	// principals only get their own connections, not the ones of unverified STOMP logins with the same name
+	resp := getPresence(&model.RequestMetadata{
+		ConnectionId: "con2", Principal: &model.Principal{Name: "user2", AuthMethod: "jwt"}})
+	assert.Equal(t, "con2", resp.BrokerDestination.ConnectionId)
+	assert.Equal(t, []*ClientPresence{
+		{ConnectionId: "con2", Principal: "user2", AuthMethod: "jwt", Channels: []string{"channel2"}},
+	}, resp.Payload)
+
+	// anonymous clients and unverified STOMP logins only get the connection they sent the request over
+	resp = getPresence(&model.RequestMetadata{
+		ConnectionId: "con3", Principal: &model.Principal{Name: "user2", AuthMethod: model.AuthMethodSTOMPLogin}})
+	assert.Equal(t, []*ClientPresence{
+		{ConnectionId: "con3", Principal: "user2", AuthMethod: model.AuthMethodSTOMPLogin, Channels: []string{"channel2"}},
+	}, resp.Payload)
+	resp = getPresence(&model.RequestMetadata{ConnectionId: "con4"})
+	assert.Equal(t, []*ClientPresence{{ConnectionId: "con4", Channels: []string{"channel2"}}}, resp.Payload)
+
+	// admins get all the connections
+	resp = getPresence(&model.RequestMetadata{ConnectionId: "con1",
+		Principal: &model.Principal{Name: "user1", AuthMethod: "jwt", Roles: []string{PRESENCE_ADMIN_ROLE}}})
+	assert.Len(t, resp.Payload, 3)
+
+	id := uuid.New()
+	wg.Add(1)
+	bus.SendRequestMessage(PRESENCE_CHANNEL, &model.Request{Id: &id, Request: "invalid"}, nil)
+	wg.Wait()
+
+	assert.True(t, responses[4].Error)
+	assert.Equal(t, "unsupported presence request: invalid", responses[4].ErrorMessage)
+}
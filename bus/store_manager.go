@@ -23,6 +23,8 @@ type StoreManager interface {
 	CreateStoreWithType(name string, itemType reflect.Type) BusStore
 	// Get a reference to the existing store. Returns nil if the store doesn't exist.
 	GetStore(name string) BusStore
+	// Get references to all the existing stores, by name.
+	GetAllStores() map[string]BusStore
 	// Deletes a store.
 	DestroyStore(name string) bool
 	// Configure galactic store sync channel for a given connection.
@@ -91,6 +93,17 @@ func (m *storeManager) GetStore(name string) BusStore {
 	return m.stores[name]
 }
 
+func (m *storeManager) GetAllStores() map[string]BusStore {
+	m.storesLock.RLock()
+	defer m.storesLock.RUnlock()
+
+	stores := make(map[string]BusStore, len(m.stores))
+	for name, store := range m.stores {
+		stores[name] = store
+	}
+	return stores
+}
+
 func (m *storeManager) DestroyStore(name string) bool {
 	m.storesLock.Lock()
 	defer m.storesLock.Unlock()
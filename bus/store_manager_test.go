@@ -49,6 +49,20 @@ func TestStoreManager_GetStore(t *testing.T) {
 	assert.Nil(t, storeManager.GetStore("invalid-store"))
 }
 
+func TestStoreManager_GetAllStores(t *testing.T) {
+	storeManager := createTestStoreManager()
+	assert.Empty(t, storeManager.GetAllStores())
+
+	store := storeManager.CreateStore("testStore")
+	stores := storeManager.GetAllStores()
+	assert.Equal(t, map[string]BusStore{"testStore": store}, stores)
+
+	// the returned map is a copy
+	storeManager.DestroyStore("testStore")
+	assert.Len(t, stores, 1)
+	assert.Empty(t, storeManager.GetAllStores())
+}
+
 func TestStoreManager_DestroyStore(t *testing.T) {
 	storeManager := createTestStoreManager()
 	storeManager.CreateStore("testStore")
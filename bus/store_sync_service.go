@@ -138,7 +138,7 @@ func (syncService *storeSyncService) openStore(
 		return
 	}
 
-	store := syncService.bus.GetStoreManager().GetStore(storeId)
+	store := syncService.getClientStore(storeId)
 	if store == nil {
 		syncService.sendErrorResponse(
 			syncClient.channelName, "Cannot open non-existing store: "+storeId, reqId)
@@ -205,7 +205,7 @@ func (syncService *storeSyncService) updateStore(
 		return
 	}
 
-	store := syncService.bus.GetStoreManager().GetStore(storeId)
+	store := syncService.getClientStore(storeId)
 	if store == nil {
 		syncService.sendErrorResponse(
 			syncClient.channelName, "Cannot update non-existing store: "+storeId, reqId)
@@ -229,6 +229,15 @@ func (syncService *storeSyncService) updateStore(
 	}
 }
 
+// getClientStore returns the store with the given id if clients are allowed to sync it. the presence
+// store holds the connection ids and principals of all clients and is never synced.
+func (syncService *storeSyncService) getClientStore(storeId string) BusStore {
+	if storeId == PRESENCE_STORE {
+		return nil
+	}
+	return syncService.bus.GetStoreManager().GetStore(storeId)
+}
+
 func getStingProperty(id string, request map[string]interface{}) (string, bool) {
 	propValue, ok := request[id]
 	if !ok || propValue == nil {
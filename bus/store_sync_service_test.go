@@ -81,6 +81,19 @@ func TestStoreSyncService_OpenStoreErrors(t *testing.T) {
 	assert.Equal(t, errors[1].Id, &id)
 	assert.True(t, errors[1].Error)
 	assert.Equal(t, errors[1].ErrorMessage, "Cannot open non-existing store: non-existing-store")
+
+	// the presence store is never synced to clients
+	bus.GetStoreManager().CreateStore(PRESENCE_STORE)
+	wg.Add(1)
+	bus.SendRequestMessage(syncChan, &model.Request{
+		Request: openStoreRequest,
+		Payload: map[string]interface{}{"storeId": PRESENCE_STORE},
+		Id:      &id,
+	}, nil)
+	wg.Wait()
+
+	assert.True(t, errors[2].Error)
+	assert.Equal(t, errors[2].ErrorMessage, "Cannot open non-existing store: "+PRESENCE_STORE)
 }
 
 func TestStoreSyncService_OpenStore(t *testing.T) {
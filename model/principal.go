@@ -0,0 +1,48 @@
+// Copyright 2019-2021 VMware, Inc.
+// SPDX-License-Identifier: BSD-2-Clause
+
+package model
+
+import "context"
+
+// AuthMethodSTOMPLogin is the AuthMethod of principals named by the login header a STOMP client connected
+// with, used for connections which were not authenticated when established. the login is not verified by the
+// broker, so it must not be relied on for authorization.
+const AuthMethodSTOMPLogin = "stomp_login"
+
+// Principal identifies the caller of a request.
+type Principal struct {
+	Name       string                 `json:"name"`             // name of the caller, e.g. the subject of a JWT or the username
+	AuthMethod string                 `json:"authMethod"`       // how the caller was authenticated, e.g. jwt, api_key, basic or mtls
+	Roles      []string               `json:"roles,omitempty"`  // roles granted to the caller
+	Claims     map[string]interface{} `json:"claims,omitempty"` // claims of the token the caller was authenticated with, if any
+}
+
+// HasRole returns whether the principal was granted the role.
+func (p *Principal) HasRole(role string) bool {
+	if p == nil {
+		return false
+	}
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+type principalContextKey struct{}
+
+// ContextWithPrincipal returns a copy of the context carrying the principal.
+func ContextWithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalContextKey{}, principal)
+}
+
+// PrincipalFromContext returns the principal carried by the context, nil if there is none.
+func PrincipalFromContext(ctx context.Context) *Principal {
+	if ctx == nil {
+		return nil
+	}
+	principal, _ := ctx.Value(principalContextKey{}).(*Principal)
+	return principal
+}
@@ -22,6 +22,28 @@ type Request struct {
 	// Response.BrokerDestination field to ensure that the response will be sent
 	// back on the correct the "private" channel.
 	BrokerDestination *BrokerDestinationConfig `json:"-"`
+	// Optional request headers, e.g. the headers of the HTTP request
+	// relayed to the service by a REST bridge, without the headers carrying
+	// credentials. Like Metadata, it is never serialized.
+	Headers map[string]string `json:"-"`
+	// The caller of the request and the context it was sent in, populated
+	// by the REST bridges of plank and the fabric endpoint. It is never
+	// serialized with the request, so clients can't impersonate other
+	// callers, and is only carried separately between cluster nodes.
+	Metadata *RequestMetadata `json:"-"`
+	// The authenticated caller of the request, populated by the REST bridge
+	// if the caller was authenticated by the authentication middleware of plank,
+	// and by the fabric endpoint if the WebSocket connection was. It is never
+	// serialized, so clients can't impersonate other callers.
+	Principal *Principal `json:"-"`
+}
+
+// GetPrincipal returns the caller of the request, nil if it is unknown
+func (r *Request) GetPrincipal() *Principal {
+	if principal := r.Metadata.GetPrincipal(); principal != nil {
+		return principal
+	}
+	return r.Principal
 }
 
 // CreateServiceRequest is a small utility function that takes request type and payload and
@@ -0,0 +1,48 @@
+// Copyright 2019-2021 VMware, Inc.
+// SPDX-License-Identifier: BSD-2-Clause
+
+package model
+
+// transports requests are received over, see RequestMetadata.Transport
+const (
+	TransportHTTP  = "http"
+	TransportSTOMP = "stomp"
+)
+
+// RequestMetadata describes the caller of a request and the context the request was sent in. it is populated
+// by the entry points of requests, the REST bridges of plank and the fabric endpoint bridging STOMP messages
+// to the bus. Request.Metadata is never serialized with the request, so clients can neither forge it nor read
+// it. requests forwarded to other cluster nodes carry it separately.
+type RequestMetadata struct {
+	Transport    string                 `json:"transport,omitempty"`    // transport the request was received over, TransportHTTP or TransportSTOMP
+	Principal    *Principal             `json:"principal,omitempty"`    // caller of the request, if known
+	RemoteAddr   string                 `json:"remoteAddr,omitempty"`   // network address of the caller
+	ConnectionId string                 `json:"connectionId,omitempty"` // id of the STOMP connection the request was received on
+	TraceParent  string                 `json:"traceParent,omitempty"`  // W3C traceparent of the caller, see https://www.w3.org/TR/trace-context
+	TraceState   string                 `json:"traceState,omitempty"`   // W3C tracestate of the caller
+	Values       map[string]interface{} `json:"values,omitempty"`       // arbitrary values, e.g. set by a REST bridge request builder
+}
+
+// Get returns the value stored under the key, nil if there is none
+func (m *RequestMetadata) Get(key string) interface{} {
+	if m == nil {
+		return nil
+	}
+	return m.Values[key]
+}
+
+// Set stores the value under the key
+func (m *RequestMetadata) Set(key string, value interface{}) {
+	if m.Values == nil {
+		m.Values = make(map[string]interface{})
+	}
+	m.Values[key] = value
+}
+
+// GetPrincipal returns the caller of the request, nil if it is unknown
+func (m *RequestMetadata) GetPrincipal() *Principal {
+	if m == nil {
+		return nil
+	}
+	return m.Principal
+}
@@ -17,6 +17,8 @@ type Response struct {
 	Error        bool        `json:"error"`
 	ErrorCode    int         `json:"errorCode"`
 	ErrorMessage string      `json:"errorMessage"`
+	// Optional HTTP status code of successful responses relayed by REST bridges, 200 if not set.
+	StatusCode int `json:"statusCode,omitempty"`
 	// If populated the response will be send to a single client
 	// on the specified destination topic.
 	BrokerDestination *BrokerDestinationConfig `json:"-"`
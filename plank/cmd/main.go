@@ -8,7 +8,9 @@ import (
 	"github.com/vmware/transport-go/plank/pkg/server"
 	"github.com/vmware/transport-go/plank/services"
 	"github.com/vmware/transport-go/plank/utils"
+	"github.com/vmware/transport-go/service"
 	"os"
+	"time"
 )
 
 var version string
@@ -39,9 +41,18 @@ func main() {
 				return err
 			}
 
-			// stock ticker service
-			if err := platformServer.RegisterService(services.NewStockTickerService(),
-				services.StockTickerServiceChannel); err != nil {
+			// stock ticker service. it calls a slow external API so limit the number of
+			// requests handled in parallel and reject requests once too many are waiting.
+			// quotes looked up in the last 30 seconds are served from the cache.
+			if err := platformServer.RegisterServiceWithOptions(services.NewStockTickerService(),
+				services.StockTickerServiceChannel, &service.ServiceOptions{
+					MaxConcurrentRequests: 4,
+					QueueDepth:            100,
+					Cache: &service.ServiceCacheOptions{
+						TTL:      30 * time.Second,
+						Requests: []string{"ticker_price_lookup"},
+					},
+				}); err != nil {
 				return err
 			}
 
@@ -0,0 +1,100 @@
+// Copyright 2019-2021 VMware, Inc.
+// SPDX-License-Identifier: BSD-2-Clause
+
+//go:build !js && !wasm
+// +build !js,!wasm
+
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vmware/transport-go/bus"
+	"github.com/vmware/transport-go/model"
+)
+
+var BusMessageCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "bus_messages_count",
+		Help: "How many messages were sent on a bus channel, by direction (request, response or error)",
+	},
+	[]string{"channel", "direction"})
+
+var BusDeliveryLatency = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Name:    "bus_message_delivery_seconds",
+		Help:    "Time elapsed between sending a message on a bus channel and a handler returning from processing it",
+		Buckets: prometheus.DefBuckets,
+	},
+	[]string{"channel"})
+
+// busHandlerCollector reports the number of handlers subscribed to the channels of a bus when scraped
+type busHandlerCollector struct {
+	boundEventBus
+	desc *prometheus.Desc
+}
+
+func newBusHandlerCollector(eventBus bus.EventBus) *busHandlerCollector {
+	return &busHandlerCollector{
+		boundEventBus: boundEventBus{eventBus: eventBus},
+		desc: prometheus.NewDesc("bus_channel_handlers",
+			"How many handlers are subscribed to a bus channel", []string{"channel"}, nil),
+	}
+}
+
+func (c *busHandlerCollector) Describe(descs chan<- *prometheus.Desc) {
+	descs <- c.desc
+}
+
+func (c *busHandlerCollector) Collect(metrics chan<- prometheus.Metric) {
+	for name, channel := range c.getEventBus().GetChannelManager().GetAllChannels() {
+		metrics <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, float64(channel.CountHandlers()), name)
+	}
+}
+
+// RegisterBusMetrics registers the bus message counter, the delivery latency histogram and the collector
+// of the channel handler counts with the registerer, and updates them with the monitor events of the bus.
+// the series of a channel are deleted once it is destroyed, so that the channels auto-created for the
+// subscriptions of fabric endpoint clients don't leave series behind.
+func RegisterBusMetrics(eventBus bus.EventBus, registerer prometheus.Registerer) error {
+	if err := registerCollectors(registerer,
+		BusMessageCounter, BusDeliveryLatency, newBusHandlerCollector(eventBus)); err != nil {
+		return err
+	}
+
+	listenMonitorEvents("bus", eventBus, func(event *bus.MonitorEvent) {
+		switch event.EventType {
+		case bus.ChannelMessageSentEvt:
+			message := event.Data.(*model.Message)
+			BusMessageCounter.WithLabelValues(event.EntityName, directionLabel(message.Direction)).Inc()
+		case bus.ChannelMessageDeliveredEvt:
+			data := event.Data.(*bus.MessageDeliveryMonitorData)
+			BusDeliveryLatency.WithLabelValues(event.EntityName).Observe(data.Latency.Seconds())
+		case bus.ChannelDestroyedEvt:
+			// the event is sent asynchronously, the channel may have been created again since
+			if !eventBus.GetChannelManager().CheckChannelExists(event.EntityName) {
+				deleteChannelSeries(event.EntityName)
+			}
+		}
+	}, bus.ChannelMessageSentEvt, bus.ChannelMessageDeliveredEvt, bus.ChannelDestroyedEvt)
+	return nil
+}
+
+// deleteChannelSeries deletes the message counter and delivery latency series of the channel
+func deleteChannelSeries(channel string) {
+	for _, direction := range []string{"request", "response", "error", unknownLabel} {
+		BusMessageCounter.DeleteLabelValues(channel, direction)
+	}
+	BusDeliveryLatency.DeleteLabelValues(channel)
+}
+
+func directionLabel(direction model.Direction) string {
+	switch direction {
+	case model.RequestDir:
+		return "request"
+	case model.ResponseDir:
+		return "response"
+	case model.ErrorDir:
+		return "error"
+	}
+	return unknownLabel
+}
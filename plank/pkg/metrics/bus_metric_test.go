@@ -0,0 +1,58 @@
+// Copyright 2019-2021 VMware, Inc.
+// SPDX-License-Identifier: BSD-2-Clause
+
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/vmware/transport-go/bus"
+	"github.com/vmware/transport-go/model"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRegisterBusMetrics(t *testing.T) {
+	eventBus := bus.NewEventBusInstance()
+	registry := prometheus.NewRegistry()
+	assert.Nil(t, RegisterBusMetrics(eventBus, registry))
+	// registering again for the same bus does not count the events twice
+	assert.Nil(t, RegisterBusMetrics(eventBus, registry))
+
+	eventBus.GetChannelManager().CreateChannel("bus-metrics")
+	wg := sync.WaitGroup{}
+	wg.Add(2)
+	mh, _ := eventBus.ListenRequestStream("bus-metrics")
+	mh.Handle(func(message *model.Message) {
+		wg.Done()
+	}, func(e error) {})
+
+	eventBus.SendRequestMessage("bus-metrics", "request", nil)
+	eventBus.SendRequestMessage("bus-metrics", "request", nil)
+	eventBus.SendResponseMessage("bus-metrics", "response", nil)
+	wg.Wait()
+
+	assert.Equal(t, float64(2), testutil.ToFloat64(BusMessageCounter.WithLabelValues("bus-metrics", "request")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(BusMessageCounter.WithLabelValues("bus-metrics", "response")))
+	assert.Nil(t, testutil.GatherAndCompare(registry, strings.NewReader(`
+# HELP bus_channel_handlers How many handlers are subscribed to a bus channel
+# TYPE bus_channel_handlers gauge
+bus_channel_handlers{channel="bus-metrics"} 1
+`), "bus_channel_handlers"))
+
+	// the delivery latency is observed once the handler has returned
+	assert.Eventually(t, func() bool {
+		count, _ := testutil.GatherAndCount(registry, "bus_message_delivery_seconds")
+		return count == 1
+	}, time.Second, 10*time.Millisecond)
+
+	// the series of destroyed channels are deleted
+	eventBus.GetChannelManager().DestroyChannel("bus-metrics")
+	assert.Eventually(t, func() bool {
+		count, _ := testutil.GatherAndCount(registry, "bus_messages_count", "bus_message_delivery_seconds")
+		return count == 0
+	}, time.Second, 10*time.Millisecond)
+}
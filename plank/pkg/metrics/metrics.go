@@ -0,0 +1,118 @@
+// Copyright 2019-2021 VMware, Inc.
+// SPDX-License-Identifier: BSD-2-Clause
+
+//go:build !js && !wasm
+// +build !js,!wasm
+
+package metrics
+
+import (
+	"errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vmware/transport-go/bus"
+	"reflect"
+	"sync"
+)
+
+// RegisterTransportMetrics registers the metrics of the bus, its stores, the fabric endpoint, the services
+// and the REST bridges with the registerer, and updates them with the monitor events of the bus. the metrics
+// are shared by all the servers of the process and report the bus they were registered for last, e.g. the
+// new bus after bus.ResetBus.
+func RegisterTransportMetrics(eventBus bus.EventBus, registerer prometheus.Registerer) error {
+	for _, register := range []func(bus.EventBus, prometheus.Registerer) error{
+		RegisterBusMetrics,
+		RegisterStoreMetrics,
+		RegisterStompMetrics,
+		RegisterServiceMetrics,
+		RegisterServiceCacheMetrics,
+		RegisterRestBridgeMetrics,
+	} {
+		if err := register(eventBus, registerer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unknownLabel is the label value of the metrics about requests and frames with client controlled names
+// which are not known to the server, keeping the number of label values bounded.
+const unknownLabel = "unknown"
+
+// eventBusCollector is implemented by the collectors reporting the state of a bus when scraped
+type eventBusCollector interface {
+	prometheus.Collector
+	getEventBus() bus.EventBus
+	bindEventBus(eventBus bus.EventBus)
+}
+
+// boundEventBus holds the bus a collector reports the state of, which is replaced when the collector is
+// registered again for another bus
+type boundEventBus struct {
+	lock     sync.RWMutex
+	eventBus bus.EventBus
+}
+
+func (b *boundEventBus) getEventBus() bus.EventBus {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	return b.eventBus
+}
+
+func (b *boundEventBus) bindEventBus(eventBus bus.EventBus) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.eventBus = eventBus
+}
+
+// registerCollectors registers the collectors with the registerer. the collectors already registered are
+// left in place, and the ones reporting the state of a bus are bound to the bus of the new collector.
+func registerCollectors(registerer prometheus.Registerer, collectors ...prometheus.Collector) error {
+	for _, collector := range collectors {
+		if err := registerer.Register(collector); err != nil {
+			// the collectors are shared by all the servers of the process
+			var alreadyRegistered prometheus.AlreadyRegisteredError
+			if !errors.As(err, &alreadyRegistered) {
+				return err
+			}
+			if alreadyRegistered.ExistingCollector == collector {
+				continue
+			}
+			existing, ok := alreadyRegistered.ExistingCollector.(eventBusCollector)
+			busCollector, isBusCollector := collector.(eventBusCollector)
+			if !ok || !isBusCollector || reflect.TypeOf(existing) != reflect.TypeOf(busCollector) {
+				return err
+			}
+			existing.bindEventBus(busCollector.getEventBus())
+		}
+	}
+	return nil
+}
+
+type monitorEventListener struct {
+	eventBus bus.EventBus
+	id       bus.MonitorEventListenerId
+}
+
+var (
+	monitorEventListenersLock sync.Mutex
+	monitorEventListeners     = make(map[string]*monitorEventListener)
+)
+
+// listenMonitorEvents adds the listener updating the metrics of the group to the bus. the listener added for
+// the group before is removed from its bus so that the events are counted once, and only for the last bus.
+func listenMonitorEvents(
+	group string, eventBus bus.EventBus, listener bus.MonitorEventHandler, eventTypes ...bus.MonitorEventType) {
+
+	monitorEventListenersLock.Lock()
+	defer monitorEventListenersLock.Unlock()
+	if previous, ok := monitorEventListeners[group]; ok {
+		if previous.eventBus == eventBus {
+			return
+		}
+		previous.eventBus.RemoveMonitorEventListener(previous.id)
+	}
+	monitorEventListeners[group] = &monitorEventListener{
+		eventBus: eventBus,
+		id:       eventBus.AddMonitorEventListener(listener, eventTypes...),
+	}
+}
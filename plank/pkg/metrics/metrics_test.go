@@ -0,0 +1,73 @@
+// Copyright 2019-2021 VMware, Inc.
+// SPDX-License-Identifier: BSD-2-Clause
+
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/vmware/transport-go/bus"
+	"strings"
+	"testing"
+)
+
+func TestRegisterTransportMetrics(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	assert.Nil(t, RegisterTransportMetrics(bus.NewEventBusInstance(), registry))
+
+	RestBridgeTimeoutCounter.WithLabelValues("transport-metrics", "GET").Inc()
+	count, err := testutil.GatherAndCount(registry, "rest_bridge_timeouts_count", "stomp_connections")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, count)
+
+	// registration errors other than already registered collectors are returned
+	assert.NotNil(t, RegisterTransportMetrics(bus.NewEventBusInstance(), &failingRegisterer{}))
+}
+
+type failingRegisterer struct {
+	prometheus.Registerer
+}
+
+func (r *failingRegisterer) Register(prometheus.Collector) error {
+	return assert.AnError
+}
+
+func TestRegisterTransportMetrics_NewBus(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	oldBus := bus.NewEventBusInstance()
+	assert.Nil(t, RegisterTransportMetrics(oldBus, registry))
+	oldBus.GetChannelManager().CreateChannel("old-channel")
+	oldBus.GetStoreManager().CreateStore("old-store")
+
+	// the metrics report the bus they were registered for last
+	newBus := bus.NewEventBusInstance()
+	assert.Nil(t, RegisterTransportMetrics(newBus, registry))
+	newBus.GetChannelManager().CreateChannel("new-channel")
+	newBus.GetStoreManager().CreateStore("new-store")
+	assert.Nil(t, testutil.GatherAndCompare(registry, strings.NewReader(`
+# HELP bus_channel_handlers How many handlers are subscribed to a bus channel
+# TYPE bus_channel_handlers gauge
+bus_channel_handlers{channel="new-channel"} 0
+# HELP store_items How many items a bus store contains
+# TYPE store_items gauge
+store_items{store="new-store"} 0
+`), "bus_channel_handlers", "store_items"))
+
+	oldBus.SendMonitorEvent(bus.ServiceCacheHitEvt, "bus-switch-service", nil)
+	newBus.SendMonitorEvent(bus.ServiceCacheHitEvt, "bus-switch-service", nil)
+	assert.Equal(t, float64(1), testutil.ToFloat64(ServiceCacheCounter.WithLabelValues("bus-switch-service", "hit")))
+}
+
+func TestRegisterCollectors_Conflict(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	assert.Nil(t, registerCollectors(registry, RestBridgeTimeoutCounter))
+	assert.Nil(t, registerCollectors(registry, RestBridgeTimeoutCounter))
+
+	// another collector of the same metrics is not ignored
+	other := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "rest_bridge_timeouts_count",
+		Help: "How many REST bridge requests timed out waiting for the response of the service",
+	}, []string{"channel", "method"})
+	assert.NotNil(t, registerCollectors(registry, other))
+}
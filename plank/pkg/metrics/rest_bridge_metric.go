@@ -0,0 +1,25 @@
+// Copyright 2019-2021 VMware, Inc.
+// SPDX-License-Identifier: BSD-2-Clause
+
+//go:build !js && !wasm
+// +build !js,!wasm
+
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vmware/transport-go/bus"
+)
+
+var RestBridgeTimeoutCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "rest_bridge_timeouts_count",
+		Help: "How many REST bridge requests timed out waiting for the response of the service",
+	},
+	[]string{"channel", "method"})
+
+// RegisterRestBridgeMetrics registers the REST bridge timeout counter with the registerer. the counter is
+// updated by the REST bridges themselves, the bus is not used.
+func RegisterRestBridgeMetrics(_ bus.EventBus, registerer prometheus.Registerer) error {
+	return registerCollectors(registerer, RestBridgeTimeoutCounter)
+}
@@ -0,0 +1,46 @@
+// Copyright 2019-2021 VMware, Inc.
+// SPDX-License-Identifier: BSD-2-Clause
+
+//go:build !js && !wasm
+// +build !js,!wasm
+
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vmware/transport-go/bus"
+)
+
+var ServiceCacheCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "service_cache_requests_count",
+		Help: "How many service requests were answered from the response cache (hit) or not (miss)",
+	},
+	[]string{"channel", "result"})
+
+var ServiceCacheEvictionCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "service_cache_evictions_count",
+		Help: "How many cached service responses were evicted because the cache was full",
+	},
+	[]string{"channel"})
+
+// RegisterServiceCacheMetrics registers the service cache counters with the registerer and
+// updates them with the cache monitor events of the bus.
+func RegisterServiceCacheMetrics(eventBus bus.EventBus, registerer prometheus.Registerer) error {
+	if err := registerCollectors(registerer, ServiceCacheCounter, ServiceCacheEvictionCounter); err != nil {
+		return err
+	}
+
+	listenMonitorEvents("service_cache", eventBus, func(event *bus.MonitorEvent) {
+		switch event.EventType {
+		case bus.ServiceCacheHitEvt:
+			ServiceCacheCounter.WithLabelValues(event.EntityName, "hit").Inc()
+		case bus.ServiceCacheMissEvt:
+			ServiceCacheCounter.WithLabelValues(event.EntityName, "miss").Inc()
+		case bus.ServiceCacheEvictedEvt:
+			ServiceCacheEvictionCounter.WithLabelValues(event.EntityName).Inc()
+		}
+	}, bus.ServiceCacheHitEvt, bus.ServiceCacheMissEvt, bus.ServiceCacheEvictedEvt)
+	return nil
+}
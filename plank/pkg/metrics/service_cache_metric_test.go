@@ -0,0 +1,29 @@
+// Copyright 2019-2021 VMware, Inc.
+// SPDX-License-Identifier: BSD-2-Clause
+
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/vmware/transport-go/bus"
+	"testing"
+)
+
+func TestRegisterServiceCacheMetrics(t *testing.T) {
+	eventBus := bus.NewEventBusInstance()
+	registry := prometheus.NewRegistry()
+	assert.Nil(t, RegisterServiceCacheMetrics(eventBus, registry))
+	// registering again for the same bus does not count the events twice
+	assert.Nil(t, RegisterServiceCacheMetrics(eventBus, registry))
+
+	eventBus.SendMonitorEvent(bus.ServiceCacheHitEvt, "joke-service", nil)
+	eventBus.SendMonitorEvent(bus.ServiceCacheHitEvt, "joke-service", nil)
+	eventBus.SendMonitorEvent(bus.ServiceCacheMissEvt, "joke-service", nil)
+	eventBus.SendMonitorEvent(bus.ServiceCacheEvictedEvt, "joke-service", nil)
+
+	assert.Equal(t, float64(2), testutil.ToFloat64(ServiceCacheCounter.WithLabelValues("joke-service", "hit")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(ServiceCacheCounter.WithLabelValues("joke-service", "miss")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(ServiceCacheEvictionCounter.WithLabelValues("joke-service")))
+}
@@ -0,0 +1,104 @@
+// Copyright 2019-2021 VMware, Inc.
+// SPDX-License-Identifier: BSD-2-Clause
+
+//go:build !js && !wasm
+// +build !js,!wasm
+
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vmware/transport-go/bus"
+	"github.com/vmware/transport-go/service"
+	"strconv"
+	"sync"
+)
+
+// maxRequestLabelsPerService is the number of distinct names of requests a service does not advertise which
+// label its metrics, the requests with other names are labelled unknown
+const maxRequestLabelsPerService = 50
+
+var ServiceRequestCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "service_requests_count",
+		Help: "How many requests a service handled, by request",
+	},
+	[]string{"channel", "request"})
+
+var ServiceRequestLatency = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Name:    "service_request_duration_seconds",
+		Help:    "Time a service spent handling a request, by request",
+		Buckets: prometheus.DefBuckets,
+	},
+	[]string{"channel", "request"})
+
+var ServiceErrorCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "service_errors_count",
+		Help: "How many error responses a service sent, by request and error code",
+	},
+	[]string{"channel", "request", "code"})
+
+// serviceRequestLabels are the names of the requests labelling the metrics of each service, besides the
+// commands the service advertises
+var serviceRequestLabels = &requestLabels{names: make(map[string]map[string]bool)}
+
+// RegisterServiceMetrics registers the service request, latency and error metrics with the registerer
+// and updates them with the service monitor events of the bus. the requests are labelled by name, but
+// since clients can send requests with any name, only the first maxRequestLabelsPerService names of the
+// requests a service does not advertise (see service.RequestCommandsProvider) are used, the requests with
+// other names are labelled unknown.
+func RegisterServiceMetrics(eventBus bus.EventBus, registerer prometheus.Registerer) error {
+	if err := registerCollectors(registerer,
+		ServiceRequestCounter, ServiceRequestLatency, ServiceErrorCounter); err != nil {
+		return err
+	}
+
+	listenMonitorEvents("service", eventBus, func(event *bus.MonitorEvent) {
+		switch event.EventType {
+		case bus.ServiceRequestHandledEvt:
+			data := event.Data.(*service.ServiceRequestMonitorData)
+			request := serviceRequestLabels.label(event.EntityName, data.Request, data.Supported)
+			ServiceRequestCounter.WithLabelValues(event.EntityName, request).Inc()
+			ServiceRequestLatency.WithLabelValues(event.EntityName, request).Observe(data.Latency.Seconds())
+		case bus.ServiceResponseSentEvt:
+			data := event.Data.(*service.ServiceResponseMonitorData)
+			if data.Error {
+				ServiceErrorCounter.WithLabelValues(event.EntityName,
+					serviceRequestLabels.label(event.EntityName, data.Request, data.Supported),
+					strconv.Itoa(data.ErrorCode)).Inc()
+			}
+		}
+	}, bus.ServiceRequestHandledEvt, bus.ServiceResponseSentEvt)
+	return nil
+}
+
+// requestLabels bounds the number of request label values of each service
+type requestLabels struct {
+	lock  sync.Mutex
+	names map[string]map[string]bool
+}
+
+// label returns the label value of the request sent to the service on the channel: its name if the
+// service advertises it or the name is one of the first maxRequestLabelsPerService names of the
+// requests the service does not advertise, unknown otherwise.
+func (l *requestLabels) label(channel string, request string, supported bool) string {
+	if supported {
+		return request
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	names, ok := l.names[channel]
+	if !ok {
+		names = make(map[string]bool)
+		l.names[channel] = names
+	}
+	if !names[request] {
+		if len(names) >= maxRequestLabelsPerService {
+			return unknownLabel
+		}
+		names[request] = true
+	}
+	return request
+}
@@ -0,0 +1,66 @@
+// Copyright 2019-2021 VMware, Inc.
+// SPDX-License-Identifier: BSD-2-Clause
+
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/vmware/transport-go/bus"
+	"github.com/vmware/transport-go/service"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRegisterServiceMetrics(t *testing.T) {
+	eventBus := bus.NewEventBusInstance()
+	registry := prometheus.NewRegistry()
+	assert.Nil(t, RegisterServiceMetrics(eventBus, registry))
+
+	eventBus.SendMonitorEvent(bus.ServiceRequestHandledEvt, "joke-service",
+		&service.ServiceRequestMonitorData{Request: "get-joke", Supported: true, Latency: 20 * time.Millisecond})
+	eventBus.SendMonitorEvent(bus.ServiceRequestHandledEvt, "joke-service",
+		&service.ServiceRequestMonitorData{Request: "get-joke", Supported: true, Latency: 40 * time.Millisecond})
+	eventBus.SendMonitorEvent(bus.ServiceResponseSentEvt, "joke-service",
+		&service.ServiceResponseMonitorData{Request: "get-joke", Supported: true})
+	eventBus.SendMonitorEvent(bus.ServiceResponseSentEvt, "joke-service",
+		&service.ServiceResponseMonitorData{Request: "get-joke", Supported: true, Error: true, ErrorCode: 500})
+
+	// the requests of services which don't advertise their commands are labelled by name as well
+	eventBus.SendMonitorEvent(bus.ServiceRequestHandledEvt, "joke-service",
+		&service.ServiceRequestMonitorData{Request: "get-pun"})
+
+	// past maxRequestLabelsPerService names the requests the service does not advertise are labelled unknown
+	for i := 1; i < maxRequestLabelsPerService; i++ {
+		eventBus.SendMonitorEvent(bus.ServiceRequestHandledEvt, "joke-service",
+			&service.ServiceRequestMonitorData{Request: "random-" + strconv.Itoa(i)})
+	}
+	eventBus.SendMonitorEvent(bus.ServiceRequestHandledEvt, "joke-service",
+		&service.ServiceRequestMonitorData{Request: "random-7f3a"})
+	eventBus.SendMonitorEvent(bus.ServiceResponseSentEvt, "joke-service",
+		&service.ServiceResponseMonitorData{Request: "random-7f3a", Error: true, ErrorCode: 403})
+	eventBus.SendMonitorEvent(bus.ServiceRequestHandledEvt, "joke-service",
+		&service.ServiceRequestMonitorData{Request: "get-pun"})
+
+	assert.Equal(t, float64(2), testutil.ToFloat64(ServiceRequestCounter.WithLabelValues("joke-service", "get-joke")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(ServiceErrorCounter.WithLabelValues("joke-service", "get-joke", "500")))
+	assert.Equal(t, float64(2), testutil.ToFloat64(ServiceRequestCounter.WithLabelValues("joke-service", "get-pun")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(ServiceRequestCounter.WithLabelValues("joke-service", "unknown")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(ServiceErrorCounter.WithLabelValues("joke-service", "unknown", "403")))
+
+	families, err := registry.Gather()
+	assert.Nil(t, err)
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			assert.NotEqual(t, "random-7f3a", metric.GetLabel()[1].GetValue())
+		}
+		if family.GetName() == "service_request_duration_seconds" {
+			histogram := family.GetMetric()[0].GetHistogram()
+			assert.Equal(t, "get-joke", family.GetMetric()[0].GetLabel()[1].GetValue())
+			assert.Equal(t, uint64(2), histogram.GetSampleCount())
+			assert.InDelta(t, 0.06, histogram.GetSampleSum(), 0.0001)
+		}
+	}
+}
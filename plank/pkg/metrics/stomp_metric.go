@@ -0,0 +1,90 @@
+// Copyright 2019-2021 VMware, Inc.
+// SPDX-License-Identifier: BSD-2-Clause
+
+//go:build !js && !wasm
+// +build !js,!wasm
+
+package metrics
+
+import (
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vmware/transport-go/bus"
+)
+
+var StompConnectionsGauge = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "stomp_connections",
+		Help: "How many STOMP clients are connected to the fabric endpoint",
+	})
+
+var StompSubscriptionsGauge = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "stomp_subscriptions",
+		Help: "How many STOMP subscriptions the clients of the fabric endpoint hold to a channel",
+	},
+	[]string{"channel"})
+
+var StompFrameCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "stomp_frames_count",
+		Help: "How many STOMP frames the fabric endpoint received (in) or sent (out), by command",
+	},
+	[]string{"direction", "command"})
+
+var StompErrorCounter = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Name: "stomp_errors_count",
+		Help: "How many ERROR frames the fabric endpoint sent to its clients",
+	})
+
+// stompCommands are the commands of the STOMP 1.2 frames, the frames with other commands are labelled unknown
+var stompCommands = map[string]bool{
+	frame.CONNECT: true, frame.STOMP: true, frame.CONNECTED: true, frame.SEND: true, frame.SUBSCRIBE: true,
+	frame.UNSUBSCRIBE: true, frame.ACK: true, frame.NACK: true, frame.BEGIN: true, frame.COMMIT: true,
+	frame.ABORT: true, frame.DISCONNECT: true, frame.MESSAGE: true, frame.RECEIPT: true, frame.ERROR: true,
+}
+
+// RegisterStompMetrics registers the fabric endpoint connection, subscription, frame and error metrics with
+// the registerer and updates them with the fabric endpoint monitor events of the bus.
+func RegisterStompMetrics(eventBus bus.EventBus, registerer prometheus.Registerer) error {
+	if err := registerCollectors(registerer,
+		StompConnectionsGauge, StompSubscriptionsGauge, StompFrameCounter, StompErrorCounter); err != nil {
+		return err
+	}
+
+	listenMonitorEvents("stomp", eventBus, func(event *bus.MonitorEvent) {
+		switch event.EventType {
+		case bus.FabricEndpointConnectionOpenedEvt:
+			StompConnectionsGauge.Inc()
+		case bus.FabricEndpointConnectionClosedEvt:
+			StompConnectionsGauge.Dec()
+		case bus.FabricEndpointSubscribeEvt:
+			StompSubscriptionsGauge.WithLabelValues(event.EntityName).Inc()
+		case bus.FabricEndpointUnsubscribeEvt:
+			StompSubscriptionsGauge.WithLabelValues(event.EntityName).Dec()
+			// forget the channels auto-created for the subscriptions of the clients once they are destroyed
+			if !eventBus.GetChannelManager().CheckChannelExists(event.EntityName) {
+				StompSubscriptionsGauge.DeleteLabelValues(event.EntityName)
+			}
+		case bus.FabricEndpointFrameReceivedEvt:
+			StompFrameCounter.WithLabelValues("in", commandLabel(event.EntityName)).Inc()
+		case bus.FabricEndpointFrameSentEvt:
+			StompFrameCounter.WithLabelValues("out", commandLabel(event.EntityName)).Inc()
+			if event.EntityName == frame.ERROR {
+				StompErrorCounter.Inc()
+			}
+		}
+	}, bus.FabricEndpointConnectionOpenedEvt, bus.FabricEndpointConnectionClosedEvt,
+		bus.FabricEndpointSubscribeEvt, bus.FabricEndpointUnsubscribeEvt,
+		bus.FabricEndpointFrameReceivedEvt, bus.FabricEndpointFrameSentEvt)
+	return nil
+}
+
+// commandLabel returns the label value of the STOMP command, unknown if it is not a STOMP 1.2 command
+func commandLabel(command string) string {
+	if !stompCommands[command] {
+		return unknownLabel
+	}
+	return command
+}
@@ -0,0 +1,49 @@
+// Copyright 2019-2021 VMware, Inc.
+// SPDX-License-Identifier: BSD-2-Clause
+
+package metrics
+
+import (
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/vmware/transport-go/bus"
+	"testing"
+)
+
+func TestRegisterStompMetrics(t *testing.T) {
+	eventBus := bus.NewEventBusInstance()
+	assert.Nil(t, RegisterStompMetrics(eventBus, prometheus.NewRegistry()))
+	eventBus.GetChannelManager().CreateChannel("stomp-metrics")
+
+	eventBus.SendMonitorEvent(bus.FabricEndpointConnectionOpenedEvt, "con1", nil)
+	eventBus.SendMonitorEvent(bus.FabricEndpointConnectionOpenedEvt, "con2", nil)
+	eventBus.SendMonitorEvent(bus.FabricEndpointConnectionClosedEvt, "con2", nil)
+	eventBus.SendMonitorEvent(bus.FabricEndpointSubscribeEvt, "stomp-metrics", nil)
+	eventBus.SendMonitorEvent(bus.FabricEndpointSubscribeEvt, "stomp-metrics", nil)
+	eventBus.SendMonitorEvent(bus.FabricEndpointUnsubscribeEvt, "stomp-metrics", nil)
+	eventBus.SendMonitorEvent(bus.FabricEndpointFrameReceivedEvt, frame.CONNECT, "con1")
+	eventBus.SendMonitorEvent(bus.FabricEndpointFrameSentEvt, frame.CONNECTED, "con1")
+	eventBus.SendMonitorEvent(bus.FabricEndpointFrameSentEvt, frame.ERROR, "con1")
+
+	assert.Equal(t, float64(1), testutil.ToFloat64(StompConnectionsGauge))
+	assert.Equal(t, float64(1), testutil.ToFloat64(StompSubscriptionsGauge.WithLabelValues("stomp-metrics")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(StompFrameCounter.WithLabelValues("in", frame.CONNECT)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(StompFrameCounter.WithLabelValues("out", frame.CONNECTED)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(StompFrameCounter.WithLabelValues("out", frame.ERROR)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(StompErrorCounter))
+
+	// frames with commands other than the STOMP commands are labelled unknown
+	eventBus.SendMonitorEvent(bus.FabricEndpointFrameReceivedEvt, "HACK-1234", "con1")
+	assert.Equal(t, float64(1), testutil.ToFloat64(StompFrameCounter.WithLabelValues("in", "unknown")))
+	assert.Equal(t, 4, testutil.CollectAndCount(StompFrameCounter))
+
+	// the subscriptions to channels which no longer exist are forgotten
+	eventBus.GetChannelManager().CreateChannel("auto-created")
+	eventBus.SendMonitorEvent(bus.FabricEndpointSubscribeEvt, "auto-created", nil)
+	assert.Equal(t, 2, testutil.CollectAndCount(StompSubscriptionsGauge))
+	eventBus.GetChannelManager().DestroyChannel("auto-created")
+	eventBus.SendMonitorEvent(bus.FabricEndpointUnsubscribeEvt, "auto-created", nil)
+	assert.Equal(t, 1, testutil.CollectAndCount(StompSubscriptionsGauge))
+}
@@ -0,0 +1,47 @@
+// Copyright 2019-2021 VMware, Inc.
+// SPDX-License-Identifier: BSD-2-Clause
+
+//go:build !js && !wasm
+// +build !js,!wasm
+
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vmware/transport-go/bus"
+)
+
+// storeCollector reports the number of items and the version of the stores of a bus when scraped
+type storeCollector struct {
+	boundEventBus
+	itemsDesc   *prometheus.Desc
+	versionDesc *prometheus.Desc
+}
+
+func newStoreCollector(eventBus bus.EventBus) *storeCollector {
+	return &storeCollector{
+		boundEventBus: boundEventBus{eventBus: eventBus},
+		itemsDesc: prometheus.NewDesc("store_items",
+			"How many items a bus store contains", []string{"store"}, nil),
+		versionDesc: prometheus.NewDesc("store_version",
+			"The current version of a bus store", []string{"store"}, nil),
+	}
+}
+
+func (c *storeCollector) Describe(descs chan<- *prometheus.Desc) {
+	descs <- c.itemsDesc
+	descs <- c.versionDesc
+}
+
+func (c *storeCollector) Collect(metrics chan<- prometheus.Metric) {
+	for name, store := range c.getEventBus().GetStoreManager().GetAllStores() {
+		items, version := store.AllValuesAndVersion()
+		metrics <- prometheus.MustNewConstMetric(c.itemsDesc, prometheus.GaugeValue, float64(len(items)), name)
+		metrics <- prometheus.MustNewConstMetric(c.versionDesc, prometheus.GaugeValue, float64(version), name)
+	}
+}
+
+// RegisterStoreMetrics registers the collector of the store sizes and versions of the bus with the registerer.
+func RegisterStoreMetrics(eventBus bus.EventBus, registerer prometheus.Registerer) error {
+	return registerCollectors(registerer, newStoreCollector(eventBus))
+}
@@ -0,0 +1,35 @@
+// Copyright 2019-2021 VMware, Inc.
+// SPDX-License-Identifier: BSD-2-Clause
+
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/vmware/transport-go/bus"
+	"strings"
+	"testing"
+)
+
+func TestRegisterStoreMetrics(t *testing.T) {
+	eventBus := bus.NewEventBusInstance()
+	registry := prometheus.NewRegistry()
+	assert.Nil(t, RegisterStoreMetrics(eventBus, registry))
+
+	store := eventBus.GetStoreManager().CreateStore("jokes")
+	store.Populate(map[string]interface{}{"joke1": "knock knock"})
+	store.Put("joke2", "why did the chicken cross the road", nil)
+	eventBus.GetStoreManager().CreateStore("empty")
+
+	assert.Nil(t, testutil.GatherAndCompare(registry, strings.NewReader(`
+# HELP store_items How many items a bus store contains
+# TYPE store_items gauge
+store_items{store="empty"} 0
+store_items{store="jokes"} 2
+# HELP store_version The current version of a bus store
+# TYPE store_version gauge
+store_version{store="empty"} 1
+store_version{store="jokes"} 2
+`)))
+}
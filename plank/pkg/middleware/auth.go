@@ -0,0 +1,175 @@
+// Copyright 2019-2021 VMware, Inc.
+// SPDX-License-Identifier: BSD-2-Clause
+
+package middleware
+
+import (
+	"context"
+	"errors"
+	"github.com/gorilla/mux"
+	"github.com/vmware/transport-go/model"
+	"github.com/vmware/transport-go/plank/utils"
+	"net/http"
+	"strings"
+)
+
+// authentication methods recorded in model.Principal.AuthMethod
+const (
+	AuthMethodJWT    = "jwt"
+	AuthMethodAPIKey = "api_key"
+	AuthMethodBasic  = "basic"
+	AuthMethodMTLS   = "mtls"
+)
+
+// ErrNoCredentials is returned by an Authenticator if the request carries no credentials it handles
+var ErrNoCredentials = errors.New("no credentials")
+
+// Authenticator authenticates the caller of HTTP requests with one kind of credentials
+type Authenticator interface {
+	// Authenticate returns the principal the credentials of the request belong to, or ErrNoCredentials if the
+	// request carries none of the credentials the authenticator handles
+	Authenticate(r *http.Request) (*model.Principal, error)
+
+	// Challenge returns the WWW-Authenticate challenge asking for the credentials, empty if there is none
+	Challenge() string
+}
+
+// AuthConfig configures how callers of the server authenticate. the methods configured are tried in the order
+// JWT, API key, basic and client certificate, and the first method the request carries credentials for decides.
+//
+// the REST bridges and the WebSocket fabric endpoint are protected, the principal a WebSocket connection was
+// authenticated as is the principal of all the requests sent over it. the health checks, the static content,
+// the single page application, the OpenAPI document and explorer and the Prometheus metrics are served
+// without authentication. the TCP fabric listener cannot authenticate its clients, and is only started
+// if AllowAnonymousFabric is set.
+type AuthConfig struct {
+	JWT                  *JWTConfig       `json:"jwt"`                    // bearer JSON Web Tokens
+	APIKey               *APIKeyConfig    `json:"api_key"`                // API keys sent in a header
+	Basic                *BasicAuthConfig `json:"basic"`                  // HTTP basic authentication
+	MTLS                 *MTLSConfig      `json:"mtls"`                   // client certificates verified during the TLS handshake
+	AllowAnonymousFabric bool             `json:"allow_anonymous_fabric"` // accept fabric connections without credentials, whose STOMP login is not verified
+}
+
+// NewAuthenticators returns the authenticators of the methods configured
+func NewAuthenticators(config *AuthConfig) ([]Authenticator, error) {
+	authenticators := make([]Authenticator, 0)
+	if config.JWT != nil {
+		authenticator, err := NewJWTAuthenticator(config.JWT)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, authenticator)
+	}
+	if config.APIKey != nil {
+		authenticator, err := NewAPIKeyAuthenticator(config.APIKey)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, authenticator)
+	}
+	if config.Basic != nil {
+		authenticator, err := NewBasicAuthenticator(config.Basic)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, authenticator)
+	}
+	if config.MTLS != nil {
+		authenticator, err := NewMTLSAuthenticator(config.MTLS)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, authenticator)
+	}
+	if len(authenticators) == 0 {
+		return nil, errors.New("no authentication method configured")
+	}
+	return authenticators, nil
+}
+
+// NewAuthenticationMiddleware returns the middleware authenticating callers with the methods configured
+func NewAuthenticationMiddleware(config *AuthConfig) (mux.MiddlewareFunc, error) {
+	authenticators, err := NewAuthenticators(config)
+	if err != nil {
+		return nil, err
+	}
+	return AuthenticationMiddleware(authenticators...), nil
+}
+
+// AuthenticationMiddleware returns the middleware rejecting requests the authenticators are unable to authenticate
+// with 401 Unauthorized. the principal of authenticated requests is available to the handlers through
+// PrincipalFromContext, and is propagated to services through model.Request by the REST bridges.
+// CORS preflight requests are passed through as browsers never send credentials with them.
+func AuthenticationMiddleware(authenticators ...Authenticator) mux.MiddlewareFunc {
+	return authenticationMiddleware(false, authenticators...)
+}
+
+// OptionalAuthenticationMiddleware returns the middleware authenticating the requests carrying credentials like
+// AuthenticationMiddleware, and passing the requests carrying none to the handler without a principal.
+func OptionalAuthenticationMiddleware(authenticators ...Authenticator) mux.MiddlewareFunc {
+	return authenticationMiddleware(true, authenticators...)
+}
+
+func authenticationMiddleware(optional bool, authenticators ...Authenticator) mux.MiddlewareFunc {
+	return func(handler http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if PrincipalFromContext(r.Context()) != nil || isPreflightRequest(r) {
+				handler.ServeHTTP(w, r)
+				return
+			}
+
+			for _, authenticator := range authenticators {
+				principal, err := authenticator.Authenticate(r)
+				if errors.Is(err, ErrNoCredentials) {
+					continue
+				}
+				if err != nil {
+					utils.Log.WithError(err).Debugf("[plank] Failed to authenticate request to %s", r.URL.Path)
+					writeUnauthorized(w, authenticator)
+					return
+				}
+				handler.ServeHTTP(w, r.WithContext(ContextWithPrincipal(r.Context(), principal)))
+				return
+			}
+			if optional {
+				handler.ServeHTTP(w, r)
+				return
+			}
+			writeUnauthorized(w, authenticators...)
+		})
+	}
+}
+
+// ContextWithPrincipal returns a copy of the context carrying the principal
+func ContextWithPrincipal(ctx context.Context, principal *model.Principal) context.Context {
+	return model.ContextWithPrincipal(ctx, principal)
+}
+
+// PrincipalFromContext returns the principal authenticated by AuthenticationMiddleware, nil if there is none
+func PrincipalFromContext(ctx context.Context) *model.Principal {
+	return model.PrincipalFromContext(ctx)
+}
+
+func writeUnauthorized(w http.ResponseWriter, authenticators ...Authenticator) {
+	for _, authenticator := range authenticators {
+		if challenge := authenticator.Challenge(); challenge != "" {
+			w.Header().Add("WWW-Authenticate", challenge)
+		}
+	}
+	http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+}
+
+func isPreflightRequest(r *http.Request) bool {
+	return r.Method == http.MethodOptions && r.Header.Get("Origin") != "" &&
+		r.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// getAuthorizationCredentials returns the credentials of the Authorization header if the scheme matches
+func getAuthorizationCredentials(r *http.Request, scheme string) (string, bool) {
+	authorization := r.Header.Get("Authorization")
+	if len(authorization) <= len(scheme) || !strings.EqualFold(authorization[:len(scheme)], scheme) ||
+		authorization[len(scheme)] != ' ' {
+		return "", false
+	}
+	return strings.TrimSpace(authorization[len(scheme)+1:]), true
+}
@@ -0,0 +1,68 @@
+// Copyright 2019-2021 VMware, Inc.
+// SPDX-License-Identifier: BSD-2-Clause
+
+package middleware
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/vmware/transport-go/model"
+	"net/http"
+)
+
+// APIKeyConfig configures the API keys callers can authenticate with. keys are stored as their SHA-256 hashes,
+// e.g. the output of `echo -n $KEY | sha256sum`
+type APIKeyConfig struct {
+	Header string   `json:"header"` // header carrying the API key (default: X-API-Key)
+	Keys   []APIKey `json:"keys"`   // the valid API keys
+}
+
+// APIKey is an API key and the principal it belongs to
+type APIKey struct {
+	Name    string   `json:"name"`       // name of the principal the key belongs to
+	KeyHash string   `json:"key_sha256"` // hex encoded SHA-256 hash of the key
+	Roles   []string `json:"roles"`      // roles granted to the principal
+}
+
+type apiKeyAuthenticator struct {
+	header string
+	keys   []APIKey
+	hashes [][]byte
+}
+
+// NewAPIKeyAuthenticator returns the Authenticator validating API keys sent in a header
+func NewAPIKeyAuthenticator(config *APIKeyConfig) (Authenticator, error) {
+	a := &apiKeyAuthenticator{header: config.Header, keys: config.Keys}
+	if a.header == "" {
+		a.header = "X-API-Key"
+	}
+	for _, key := range config.Keys {
+		hash, err := hex.DecodeString(key.KeyHash)
+		if err != nil || len(hash) != sha256.Size {
+			return nil, fmt.Errorf("invalid SHA-256 hash of API key '%s'", key.Name)
+		}
+		a.hashes = append(a.hashes, hash)
+	}
+	return a, nil
+}
+
+func (a *apiKeyAuthenticator) Challenge() string {
+	return ""
+}
+
+func (a *apiKeyAuthenticator) Authenticate(r *http.Request) (*model.Principal, error) {
+	key := r.Header.Get(a.header)
+	if key == "" {
+		return nil, ErrNoCredentials
+	}
+	hash := sha256.Sum256([]byte(key))
+	for i, expected := range a.hashes {
+		if subtle.ConstantTimeCompare(hash[:], expected) == 1 {
+			return &model.Principal{Name: a.keys[i].Name, AuthMethod: AuthMethodAPIKey, Roles: a.keys[i].Roles}, nil
+		}
+	}
+	return nil, errors.New("invalid API key")
+}
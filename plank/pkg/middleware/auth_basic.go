@@ -0,0 +1,68 @@
+// Copyright 2019-2021 VMware, Inc.
+// SPDX-License-Identifier: BSD-2-Clause
+
+package middleware
+
+import (
+	"fmt"
+	"github.com/vmware/transport-go/model"
+	"golang.org/x/crypto/bcrypt"
+	"net/http"
+)
+
+// BasicAuthConfig configures the users allowed to authenticate with HTTP basic authentication. passwords are
+// stored as their bcrypt hashes, e.g. the output of `htpasswd -nbB $USER $PASSWORD`
+type BasicAuthConfig struct {
+	Realm string          `json:"realm"` // realm sent with the challenge (default: plank)
+	Users []BasicAuthUser `json:"users"` // the users allowed to authenticate
+}
+
+// BasicAuthUser is a user allowed to authenticate with HTTP basic authentication
+type BasicAuthUser struct {
+	Username     string   `json:"username"`      // name of the user
+	PasswordHash string   `json:"password_hash"` // bcrypt hash of the password of the user
+	Roles        []string `json:"roles"`         // roles granted to the user
+}
+
+type basicAuthenticator struct {
+	realm string
+	users map[string]BasicAuthUser
+	// compared when the user is unknown so that unknown users take as long as wrong passwords
+	unknownUserHash []byte
+}
+
+// NewBasicAuthenticator returns the Authenticator validating HTTP basic authentication credentials
+func NewBasicAuthenticator(config *BasicAuthConfig) (Authenticator, error) {
+	a := &basicAuthenticator{realm: config.Realm, users: make(map[string]BasicAuthUser)}
+	if a.realm == "" {
+		a.realm = "plank"
+	}
+	for _, user := range config.Users {
+		if _, err := bcrypt.Cost([]byte(user.PasswordHash)); err != nil {
+			return nil, fmt.Errorf("invalid bcrypt password hash of user '%s': %w", user.Username, err)
+		}
+		a.users[user.Username] = user
+	}
+	a.unknownUserHash, _ = bcrypt.GenerateFromPassword([]byte(a.realm), bcrypt.DefaultCost)
+	return a, nil
+}
+
+func (a *basicAuthenticator) Challenge() string {
+	return fmt.Sprintf("Basic realm=%q, charset=\"UTF-8\"", a.realm)
+}
+
+func (a *basicAuthenticator) Authenticate(r *http.Request) (*model.Principal, error) {
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return nil, ErrNoCredentials
+	}
+	user, found := a.users[username]
+	if !found {
+		_ = bcrypt.CompareHashAndPassword(a.unknownUserHash, []byte(password))
+		return nil, fmt.Errorf("unknown user '%s'", username)
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
+		return nil, fmt.Errorf("invalid password of user '%s'", username)
+	}
+	return &model.Principal{Name: username, AuthMethod: AuthMethodBasic, Roles: user.Roles}, nil
+}
@@ -0,0 +1,425 @@
+// Copyright 2019-2021 VMware, Inc.
+// SPDX-License-Identifier: BSD-2-Clause
+
+package middleware
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/vmware/transport-go/model"
+	"github.com/vmware/transport-go/plank/utils"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// minimum time between two fetches of the JWKS triggered by tokens signed with unknown keys
+const jwksMinRefetchInterval = time.Minute
+
+// JWTConfig configures the validation of JSON Web Tokens sent as bearer tokens in the Authorization header.
+// tokens signed with RSA (RS256, RS384, RS512, PS256, PS384, PS512) and ECDSA (ES256, ES384, ES512) keys are supported.
+type JWTConfig struct {
+	JWKSFile        string `json:"jwks_file"`                        // path to the JSON Web Key Set file holding the keys tokens are signed with
+	JWKSUrl         string `json:"jwks_url"`                         // URL of the JSON Web Key Set, used if JWKSFile is not set
+	RefreshInterval int    `json:"jwks_refresh_interval_in_minutes"` // how often the keys are fetched from JWKSUrl (default: 60)
+	Issuer          string `json:"issuer"`                           // expected iss claim, not checked if empty
+	Audience        string `json:"audience"`                         // expected aud claim, not checked if empty
+	NameClaim       string `json:"name_claim"`                       // claim holding the name of the principal (default: sub)
+	RolesClaim      string `json:"roles_claim"`                      // claim holding the roles of the principal, nested claims are separated by dots like realm_access.roles (default: roles)
+	Leeway          int    `json:"leeway_in_seconds"`                // clock skew tolerated when checking the exp and nbf claims
+	RequireExp      *bool  `json:"require_exp"`                      // whether tokens without an exp claim are rejected (default: true)
+}
+
+type jwtAuthenticator struct {
+	config      *JWTConfig
+	keys        map[string]crypto.PublicKey
+	keysFetched time.Time
+	client      *http.Client
+	fetching    chan struct{} // closed once the pending fetch of the JWKS completes, nil if there is none
+	fetchErr    error         // error of the last fetch of the JWKS
+	now         func() time.Time
+	lock        sync.Mutex
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// NewJWTAuthenticator returns the Authenticator validating bearer JSON Web Tokens. the keys of a JWKSFile are
+// loaded right away, the ones of a JWKSUrl are fetched with the first token.
+func NewJWTAuthenticator(config *JWTConfig) (Authenticator, error) {
+	if config.JWKSFile == "" && config.JWKSUrl == "" {
+		return nil, errors.New("either jwks_file or jwks_url must be provided")
+	}
+	a := &jwtAuthenticator{
+		config: config,
+		client: &http.Client{Timeout: 10 * time.Second},
+		now:    time.Now,
+	}
+	if config.JWKSFile != "" {
+		jwks, err := ioutil.ReadFile(config.JWKSFile)
+		if err != nil {
+			return nil, err
+		}
+		if a.keys, err = parseJWKS(jwks); err != nil {
+			return nil, fmt.Errorf("invalid JWKS file %s: %w", config.JWKSFile, err)
+		}
+	}
+	return a, nil
+}
+
+func (a *jwtAuthenticator) Challenge() string {
+	return "Bearer"
+}
+
+func (a *jwtAuthenticator) Authenticate(r *http.Request) (*model.Principal, error) {
+	token, ok := getAuthorizationCredentials(r, "Bearer")
+	if !ok {
+		return nil, ErrNoCredentials
+	}
+	claims, err := a.verify(token)
+	if err != nil {
+		return nil, err
+	}
+
+	nameClaim := a.config.NameClaim
+	if nameClaim == "" {
+		nameClaim = "sub"
+	}
+	rolesClaim := a.config.RolesClaim
+	if rolesClaim == "" {
+		rolesClaim = "roles"
+	}
+	name, _ := lookupClaim(claims, nameClaim).(string)
+	if name == "" {
+		return nil, fmt.Errorf("token has no %s claim", nameClaim)
+	}
+	return &model.Principal{
+		Name:       name,
+		AuthMethod: AuthMethodJWT,
+		Roles:      getRoles(lookupClaim(claims, rolesClaim)),
+		Claims:     claims,
+	}, nil
+}
+
+// verify checks the signature and the registered claims of the token and returns its claims
+func (a *jwtAuthenticator) verify(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+	var header jwtHeader
+	if err := decodeJWTSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("malformed token header: %w", err)
+	}
+	key, err := a.getKey(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed token signature: %w", err)
+	}
+	if err = verifyJWTSignature(header.Alg, key, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+
+	var claims map[string]interface{}
+	if err = decodeJWTSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("malformed token claims: %w", err)
+	}
+	now := a.now()
+	leeway := time.Duration(a.config.Leeway) * time.Second
+	exp, ok := claims["exp"].(float64)
+	if !ok && (a.config.RequireExp == nil || *a.config.RequireExp) {
+		return nil, errors.New("token has no exp claim")
+	}
+	if ok && now.Add(-leeway).After(time.Unix(int64(exp), 0)) {
+		return nil, errors.New("token expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(leeway).Before(time.Unix(int64(nbf), 0)) {
+		return nil, errors.New("token not valid yet")
+	}
+	if a.config.Issuer != "" && claims["iss"] != a.config.Issuer {
+		return nil, fmt.Errorf("unexpected token issuer %v", claims["iss"])
+	}
+	if a.config.Audience != "" && !containsAudience(claims["aud"], a.config.Audience) {
+		return nil, fmt.Errorf("token not issued for audience %s", a.config.Audience)
+	}
+	return claims, nil
+}
+
+// getKey returns the key with the id, fetching the keys from the JWKS URL if they are stale or the key is unknown.
+// the JWKS is fetched without holding the lock, concurrent callers wait for the pending fetch instead of starting
+// their own.
+func (a *jwtAuthenticator) getKey(kid string) (crypto.PublicKey, error) {
+	a.lock.Lock()
+	if a.config.JWKSFile == "" {
+		refreshInterval := time.Duration(a.config.RefreshInterval) * time.Minute
+		if refreshInterval <= 0 {
+			refreshInterval = 60 * time.Minute
+		}
+		stale := a.now().Sub(a.keysFetched) > refreshInterval
+		unknown := findKey(a.keys, kid) == nil && a.now().Sub(a.keysFetched) > jwksMinRefetchInterval
+		if a.fetching != nil || stale || unknown {
+			fetching := a.fetching
+			if fetching == nil {
+				fetching = make(chan struct{})
+				a.fetching = fetching
+				a.keysFetched = a.now()
+				a.lock.Unlock()
+				keys, err := a.fetchKeys()
+				a.lock.Lock()
+				if err == nil {
+					a.keys = keys
+				}
+				a.fetchErr = err
+				a.fetching = nil
+				close(fetching)
+			} else {
+				a.lock.Unlock()
+				<-fetching
+				a.lock.Lock()
+			}
+			// keep using the keys fetched before if the JWKS is unavailable
+			if a.fetchErr != nil {
+				if findKey(a.keys, kid) == nil {
+					err := a.fetchErr
+					a.lock.Unlock()
+					return nil, err
+				}
+				utils.Log.WithError(a.fetchErr).Warnln("[plank] Failed to refresh JWKS, using the keys fetched before")
+			}
+		}
+	}
+	key := findKey(a.keys, kid)
+	a.lock.Unlock()
+	if key != nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("no key found for key id '%s'", kid)
+}
+
+// fetchKeys fetches and parses the JWKS from the JWKS URL
+func (a *jwtAuthenticator) fetchKeys() (map[string]crypto.PublicKey, error) {
+	rsp, err := a.client.Get(a.config.JWKSUrl)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch JWKS: %s", rsp.Status)
+	}
+	jwks, err := ioutil.ReadAll(rsp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+	keys, err := parseJWKS(jwks)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWKS: %w", err)
+	}
+	return keys, nil
+}
+
+// findKey returns the key with the id, or the only key of the set if the token names none
+func findKey(keys map[string]crypto.PublicKey, kid string) crypto.PublicKey {
+	if key, found := keys[kid]; found {
+		return key
+	}
+	if kid == "" && len(keys) == 1 {
+		for _, key := range keys {
+			return key
+		}
+	}
+	return nil
+}
+
+// parseJWKS returns the RSA and EC signing keys of the JSON Web Key Set by their key id
+func parseJWKS(jwks []byte) (map[string]crypto.PublicKey, error) {
+	var keySet struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(jwks, &keySet); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]crypto.PublicKey)
+	for _, jwk := range keySet.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		switch jwk.Kty {
+		case "RSA":
+			n, err := decodeBigInt(jwk.N)
+			if err != nil {
+				return nil, fmt.Errorf("invalid modulus of key '%s': %w", jwk.Kid, err)
+			}
+			e, err := decodeBigInt(jwk.E)
+			if err != nil || !e.IsInt64() {
+				return nil, fmt.Errorf("invalid exponent of key '%s'", jwk.Kid)
+			}
+			keys[jwk.Kid] = &rsa.PublicKey{N: n, E: int(e.Int64())}
+		case "EC":
+			var curve elliptic.Curve
+			switch jwk.Crv {
+			case "P-256":
+				curve = elliptic.P256()
+			case "P-384":
+				curve = elliptic.P384()
+			case "P-521":
+				curve = elliptic.P521()
+			default:
+				return nil, fmt.Errorf("unsupported curve %s of key '%s'", jwk.Crv, jwk.Kid)
+			}
+			x, err := decodeBigInt(jwk.X)
+			if err != nil {
+				return nil, fmt.Errorf("invalid x coordinate of key '%s': %w", jwk.Kid, err)
+			}
+			y, err := decodeBigInt(jwk.Y)
+			if err != nil {
+				return nil, fmt.Errorf("invalid y coordinate of key '%s': %w", jwk.Kid, err)
+			}
+			keys[jwk.Kid] = &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+		}
+	}
+	return keys, nil
+}
+
+// verifyJWTSignature verifies the signature of the signed content with the key according to the algorithm
+func verifyJWTSignature(alg string, key crypto.PublicKey, signed string, signature []byte) error {
+	if len(alg) != 5 {
+		return fmt.Errorf("unsupported token algorithm '%s'", alg)
+	}
+	var hash crypto.Hash
+	switch alg[2:] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported token algorithm '%s'", alg)
+	}
+	hasher := hash.New()
+	hasher.Write([]byte(signed))
+	digest := hasher.Sum(nil)
+
+	switch alg[:2] {
+	case "RS", "PS":
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("token algorithm '%s' does not match the key", alg)
+		}
+		if alg[:2] == "RS" {
+			if rsa.VerifyPKCS1v15(rsaKey, hash, digest, signature) != nil {
+				return errors.New("invalid token signature")
+			}
+		} else if rsa.VerifyPSS(rsaKey, hash, digest, signature, nil) != nil {
+			return errors.New("invalid token signature")
+		}
+	case "ES":
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("token algorithm '%s' does not match the key", alg)
+		}
+		size := (ecKey.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return errors.New("invalid token signature")
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(ecKey, digest, r, s) {
+			return errors.New("invalid token signature")
+		}
+	default:
+		return fmt.Errorf("unsupported token algorithm '%s'", alg)
+	}
+	return nil
+}
+
+func decodeJWTSegment(segment string, v interface{}) error {
+	decoded, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.NewDecoder(bytes.NewReader(decoded)).Decode(v)
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	if len(decoded) == 0 {
+		return nil, errors.New("empty value")
+	}
+	return new(big.Int).SetBytes(decoded), nil
+}
+
+// lookupClaim returns the claim at the dot separated path, nil if there is none
+func lookupClaim(claims map[string]interface{}, path string) interface{} {
+	var value interface{} = claims
+	for _, name := range strings.Split(path, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = object[name]
+	}
+	return value
+}
+
+// getRoles returns the roles of a claim holding either an array of roles or space separated roles like scope
+func getRoles(claim interface{}) []string {
+	switch v := claim.(type) {
+	case string:
+		return strings.Fields(v)
+	case []interface{}:
+		roles := make([]string, 0, len(v))
+		for _, role := range v {
+			if s, ok := role.(string); ok {
+				roles = append(roles, s)
+			}
+		}
+		return roles
+	}
+	return nil
+}
+
+func containsAudience(aud interface{}, audience string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == audience
+	case []interface{}:
+		for _, a := range v {
+			if a == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,93 @@
+// Copyright 2019-2021 VMware, Inc.
+// SPDX-License-Identifier: BSD-2-Clause
+
+package middleware
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/gobwas/glob"
+	"github.com/vmware/transport-go/model"
+	"net/http"
+)
+
+// MTLSConfig configures the authentication of callers with the client certificates verified during the TLS
+// handshake, see TLSCertConfig.ClientCAFile of the server. the organizational units of the certificate subject
+// are granted to the principal as roles.
+type MTLSConfig struct {
+	NameFrom     string   `json:"name_from"`     // certificate field the principal name is taken from: common_name (default), email or dns
+	AllowedNames []string `json:"allowed_names"` // glob patterns of the principal names allowed to authenticate, any if empty
+}
+
+type mtlsAuthenticator struct {
+	nameFrom     string
+	allowedNames []glob.Glob
+}
+
+// NewMTLSAuthenticator returns the Authenticator identifying callers by their verified client certificate
+func NewMTLSAuthenticator(config *MTLSConfig) (Authenticator, error) {
+	a := &mtlsAuthenticator{nameFrom: config.NameFrom}
+	switch a.nameFrom {
+	case "":
+		a.nameFrom = "common_name"
+	case "common_name", "email", "dns":
+	default:
+		return nil, fmt.Errorf("unsupported certificate field '%s'", config.NameFrom)
+	}
+	for _, name := range config.AllowedNames {
+		pattern, err := glob.Compile(name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid glob pattern '%s': %w", name, err)
+		}
+		a.allowedNames = append(a.allowedNames, pattern)
+	}
+	return a, nil
+}
+
+func (a *mtlsAuthenticator) Challenge() string {
+	return ""
+}
+
+func (a *mtlsAuthenticator) Authenticate(r *http.Request) (*model.Principal, error) {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return nil, ErrNoCredentials
+	}
+	if len(r.TLS.VerifiedChains) == 0 {
+		return nil, errors.New("client certificate not verified")
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+	name := getCertificateName(cert, a.nameFrom)
+	if name == "" {
+		return nil, fmt.Errorf("client certificate has no %s", a.nameFrom)
+	}
+	if len(a.allowedNames) > 0 && !matchesAny(a.allowedNames, name) {
+		return nil, fmt.Errorf("client certificate of '%s' not allowed", name)
+	}
+	return &model.Principal{Name: name, AuthMethod: AuthMethodMTLS, Roles: cert.Subject.OrganizationalUnit}, nil
+}
+
+func getCertificateName(cert *x509.Certificate, nameFrom string) string {
+	switch nameFrom {
+	case "email":
+		if len(cert.EmailAddresses) > 0 {
+			return cert.EmailAddresses[0]
+		}
+	case "dns":
+		if len(cert.DNSNames) > 0 {
+			return cert.DNSNames[0]
+		}
+	default:
+		return cert.Subject.CommonName
+	}
+	return ""
+}
+
+func matchesAny(patterns []glob.Glob, value string) bool {
+	for _, pattern := range patterns {
+		if pattern.Match(value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,391 @@
+// Copyright 2019-2021 VMware, Inc.
+// SPDX-License-Identifier: BSD-2-Clause
+
+package middleware
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/vmware/transport-go/model"
+	"golang.org/x/crypto/bcrypt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func encodeSegment(v interface{}) string {
+	encoded, _ := json.Marshal(v)
+	return base64.RawURLEncoding.EncodeToString(encoded)
+}
+
+// signTestToken returns a JWT with the claims signed with the key according to the algorithm
+func signTestToken(t *testing.T, alg, kid string, key crypto.Signer, claims map[string]interface{}) string {
+	signed := encodeSegment(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"}) + "." + encodeSegment(claims)
+	digest := sha256.Sum256([]byte(signed))
+	var signature []byte
+	var err error
+	switch alg {
+	case "RS256":
+		signature, err = rsa.SignPKCS1v15(rand.Reader, key.(*rsa.PrivateKey), crypto.SHA256, digest[:])
+	case "PS256":
+		signature, err = rsa.SignPSS(rand.Reader, key.(*rsa.PrivateKey), crypto.SHA256, digest[:], nil)
+	case "ES256":
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, key.(*ecdsa.PrivateKey), digest[:])
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+	}
+	assert.Nil(t, err)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func newTestJWKS(rsaKey *rsa.PrivateKey, ecKey *ecdsa.PrivateKey) []byte {
+	jwks, _ := json.Marshal(map[string]interface{}{"keys": []map[string]string{
+		{
+			"kty": "RSA", "kid": "rsa-key", "use": "sig",
+			"n": base64.RawURLEncoding.EncodeToString(rsaKey.N.Bytes()),
+			"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(rsaKey.E)).Bytes()),
+		},
+		{
+			"kty": "EC", "kid": "ec-key", "crv": "P-256",
+			"x": base64.RawURLEncoding.EncodeToString(ecKey.X.Bytes()),
+			"y": base64.RawURLEncoding.EncodeToString(ecKey.Y.Bytes()),
+		},
+		{"kty": "RSA", "kid": "enc-key", "use": "enc"},
+	}})
+	return jwks
+}
+
+func newBearerRequest(token string) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/rest/items", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	return req
+}
+
+func TestJWTAuthenticator(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	jwksFile := filepath.Join(t.TempDir(), "jwks.json")
+	assert.Nil(t, ioutil.WriteFile(jwksFile, newTestJWKS(rsaKey, ecKey), 0644))
+
+	authenticator, err := NewJWTAuthenticator(&JWTConfig{
+		JWKSFile:   jwksFile,
+		Issuer:     "https://idp.example.com",
+		Audience:   "plank",
+		NameClaim:  "preferred_username",
+		RolesClaim: "realm_access.roles",
+	})
+	assert.Nil(t, err)
+
+	claims := map[string]interface{}{
+		"sub":                "1234",
+		"preferred_username": "jane",
+		"iss":                "https://idp.example.com",
+		"aud":                []string{"other", "plank"},
+		"exp":                time.Now().Add(time.Hour).Unix(),
+		"realm_access":       map[string]interface{}{"roles": []string{"admin", "user"}},
+	}
+	for _, test := range []struct {
+		alg, kid string
+		key      crypto.Signer
+	}{{"RS256", "rsa-key", rsaKey}, {"PS256", "rsa-key", rsaKey}, {"ES256", "ec-key", ecKey}} {
+		principal, err := authenticator.Authenticate(newBearerRequest(signTestToken(t, test.alg, test.kid, test.key, claims)))
+		assert.Nil(t, err, test.alg)
+		assert.Equal(t, "jane", principal.Name)
+		assert.Equal(t, AuthMethodJWT, principal.AuthMethod)
+		assert.Equal(t, []string{"admin", "user"}, principal.Roles)
+		assert.Equal(t, "1234", principal.Claims["sub"])
+	}
+
+	_, err = authenticator.Authenticate(httptest.NewRequest(http.MethodGet, "http://localhost/rest/items", nil))
+	assert.Equal(t, ErrNoCredentials, err)
+
+	// the key must match the algorithm
+	_, err = authenticator.Authenticate(newBearerRequest(signTestToken(t, "ES256", "rsa-key", ecKey, claims)))
+	assert.EqualError(t, err, "token algorithm 'ES256' does not match the key")
+
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	_, err = authenticator.Authenticate(newBearerRequest(signTestToken(t, "RS256", "rsa-key", otherKey, claims)))
+	assert.EqualError(t, err, "invalid token signature")
+
+	_, err = authenticator.Authenticate(newBearerRequest(signTestToken(t, "RS256", "enc-key", rsaKey, claims)))
+	assert.EqualError(t, err, "no key found for key id 'enc-key'")
+
+	token := signTestToken(t, "RS256", "rsa-key", rsaKey, claims)
+	_, err = authenticator.Authenticate(newBearerRequest(token[:len(token)-4] + "AAAA"))
+	assert.EqualError(t, err, "invalid token signature")
+
+	unsigned := encodeSegment(map[string]string{"alg": "none", "kid": "rsa-key"}) + "." + encodeSegment(claims) + "."
+	_, err = authenticator.Authenticate(newBearerRequest(unsigned))
+	assert.EqualError(t, err, "unsupported token algorithm 'none'")
+
+	invalidClaims := []struct {
+		claim    string
+		value    interface{}
+		expected string
+	}{
+		{"exp", time.Now().Add(-time.Minute).Unix(), "token expired"},
+		{"nbf", time.Now().Add(time.Minute).Unix(), "token not valid yet"},
+		{"iss", "https://evil.com", "unexpected token issuer https://evil.com"},
+		{"aud", "other", "token not issued for audience plank"},
+		{"preferred_username", nil, "token has no preferred_username claim"},
+	}
+	for _, test := range invalidClaims {
+		tokenClaims := make(map[string]interface{})
+		for k, v := range claims {
+			tokenClaims[k] = v
+		}
+		tokenClaims[test.claim] = test.value
+		_, err = authenticator.Authenticate(newBearerRequest(signTestToken(t, "RS256", "rsa-key", rsaKey, tokenClaims)))
+		assert.EqualError(t, err, test.expected)
+	}
+
+	// tokens without an exp claim are rejected unless require_exp is turned off
+	delete(claims, "exp")
+	_, err = authenticator.Authenticate(newBearerRequest(signTestToken(t, "RS256", "rsa-key", rsaKey, claims)))
+	assert.EqualError(t, err, "token has no exp claim")
+	requireExp := false
+	authenticator.(*jwtAuthenticator).config.RequireExp = &requireExp
+	_, err = authenticator.Authenticate(newBearerRequest(signTestToken(t, "RS256", "rsa-key", rsaKey, claims)))
+	assert.Nil(t, err)
+
+	_, err = NewJWTAuthenticator(&JWTConfig{})
+	assert.NotNil(t, err)
+}
+
+func TestJWTAuthenticator_JWKSUrl(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	fetches := 0
+	jwksServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		_, _ = w.Write(newTestJWKS(rsaKey, ecKey))
+	}))
+	defer jwksServer.Close()
+
+	authenticator, err := NewJWTAuthenticator(&JWTConfig{JWKSUrl: jwksServer.URL, RolesClaim: "scope"})
+	assert.Nil(t, err)
+	now := time.Now()
+	authenticator.(*jwtAuthenticator).now = func() time.Time {
+		return now
+	}
+	assert.Equal(t, 0, fetches)
+
+	claims := map[string]interface{}{"sub": "service-account", "scope": "read write", "exp": now.Add(24 * time.Hour).Unix()}
+	principal, err := authenticator.Authenticate(newBearerRequest(signTestToken(t, "ES256", "ec-key", ecKey, claims)))
+	assert.Nil(t, err)
+	assert.Equal(t, "service-account", principal.Name)
+	assert.Equal(t, []string{"read", "write"}, principal.Roles)
+	assert.Equal(t, 1, fetches)
+
+	// unknown keys trigger a new fetch at most once a minute
+	_, err = authenticator.Authenticate(newBearerRequest(signTestToken(t, "RS256", "new-key", rsaKey, claims)))
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, fetches)
+	now = now.Add(2 * time.Minute)
+	_, err = authenticator.Authenticate(newBearerRequest(signTestToken(t, "RS256", "new-key", rsaKey, claims)))
+	assert.NotNil(t, err)
+	assert.Equal(t, 2, fetches)
+
+	// the keys fetched before are used while the JWKS is unavailable
+	jwksServer.Close()
+	now = now.Add(2 * time.Hour)
+	_, err = authenticator.Authenticate(newBearerRequest(signTestToken(t, "RS256", "rsa-key", rsaKey, claims)))
+	assert.Nil(t, err)
+}
+
+func TestJWTAuthenticator_ConcurrentJWKSFetch(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	var fetches int32
+	release := make(chan struct{})
+	jwksServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		<-release
+		_, _ = w.Write(newTestJWKS(rsaKey, ecKey))
+	}))
+	defer jwksServer.Close()
+
+	authenticator, err := NewJWTAuthenticator(&JWTConfig{JWKSUrl: jwksServer.URL})
+	assert.Nil(t, err)
+	token := signTestToken(t, "RS256", "rsa-key", rsaKey,
+		map[string]interface{}{"sub": "service-account", "exp": time.Now().Add(time.Hour).Unix()})
+
+	// all the requests wait for the single pending fetch
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := authenticator.Authenticate(newBearerRequest(token))
+			assert.Nil(t, err)
+		}()
+	}
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&fetches) == 1 }, time.Second, 10*time.Millisecond)
+
+	// the lock is not held while fetching
+	a := authenticator.(*jwtAuthenticator)
+	a.lock.Lock()
+	assert.NotNil(t, a.fetching)
+	a.lock.Unlock()
+
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fetches))
+}
+
+func TestAPIKeyAuthenticator(t *testing.T) {
+	hash := sha256.Sum256([]byte("secret-key"))
+	authenticator, err := NewAPIKeyAuthenticator(&APIKeyConfig{Keys: []APIKey{
+		{Name: "ci", KeyHash: hex.EncodeToString(hash[:]), Roles: []string{"deployer"}},
+	}})
+	assert.Nil(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/rest/items", nil)
+	_, err = authenticator.Authenticate(req)
+	assert.Equal(t, ErrNoCredentials, err)
+
+	req.Header.Set("X-API-Key", "secret-key")
+	principal, err := authenticator.Authenticate(req)
+	assert.Nil(t, err)
+	assert.Equal(t, &model.Principal{Name: "ci", AuthMethod: AuthMethodAPIKey, Roles: []string{"deployer"}}, principal)
+
+	req.Header.Set("X-API-Key", "wrong-key")
+	_, err = authenticator.Authenticate(req)
+	assert.EqualError(t, err, "invalid API key")
+
+	_, err = NewAPIKeyAuthenticator(&APIKeyConfig{Keys: []APIKey{{Name: "ci", KeyHash: "secret-key"}}})
+	assert.EqualError(t, err, "invalid SHA-256 hash of API key 'ci'")
+}
+
+func TestBasicAuthenticator(t *testing.T) {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("s3cr3t"), bcrypt.MinCost)
+	authenticator, err := NewBasicAuthenticator(&BasicAuthConfig{Users: []BasicAuthUser{
+		{Username: "jane", PasswordHash: string(hash), Roles: []string{"admin"}},
+	}})
+	assert.Nil(t, err)
+	assert.Equal(t, "Basic realm=\"plank\", charset=\"UTF-8\"", authenticator.Challenge())
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/rest/items", nil)
+	_, err = authenticator.Authenticate(req)
+	assert.Equal(t, ErrNoCredentials, err)
+
+	req.SetBasicAuth("jane", "s3cr3t")
+	principal, err := authenticator.Authenticate(req)
+	assert.Nil(t, err)
+	assert.Equal(t, &model.Principal{Name: "jane", AuthMethod: AuthMethodBasic, Roles: []string{"admin"}}, principal)
+
+	req.SetBasicAuth("jane", "wrong")
+	_, err = authenticator.Authenticate(req)
+	assert.EqualError(t, err, "invalid password of user 'jane'")
+
+	req.SetBasicAuth("john", "s3cr3t")
+	_, err = authenticator.Authenticate(req)
+	assert.EqualError(t, err, "unknown user 'john'")
+
+	_, err = NewBasicAuthenticator(&BasicAuthConfig{Users: []BasicAuthUser{{Username: "jane", PasswordHash: "s3cr3t"}}})
+	assert.NotNil(t, err)
+}
+
+func TestMTLSAuthenticator(t *testing.T) {
+	authenticator, err := NewMTLSAuthenticator(&MTLSConfig{AllowedNames: []string{"*.services.example.com"}})
+	assert.Nil(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "https://localhost/rest/items", nil)
+	req.TLS = &tls.ConnectionState{}
+	_, err = authenticator.Authenticate(req)
+	assert.Equal(t, ErrNoCredentials, err)
+
+	cert := &x509.Certificate{Subject: pkix.Name{
+		CommonName: "billing.services.example.com", OrganizationalUnit: []string{"billing"}},
+		EmailAddresses: []string{"billing@example.com"}}
+	req.TLS.PeerCertificates = []*x509.Certificate{cert}
+	_, err = authenticator.Authenticate(req)
+	assert.EqualError(t, err, "client certificate not verified")
+
+	req.TLS.VerifiedChains = [][]*x509.Certificate{{cert}}
+	principal, err := authenticator.Authenticate(req)
+	assert.Nil(t, err)
+	assert.Equal(t, &model.Principal{
+		Name: "billing.services.example.com", AuthMethod: AuthMethodMTLS, Roles: []string{"billing"}}, principal)
+
+	cert.Subject.CommonName = "billing.example.com"
+	_, err = authenticator.Authenticate(req)
+	assert.EqualError(t, err, "client certificate of 'billing.example.com' not allowed")
+
+	authenticator, _ = NewMTLSAuthenticator(&MTLSConfig{NameFrom: "email"})
+	principal, err = authenticator.Authenticate(req)
+	assert.Nil(t, err)
+	assert.Equal(t, "billing@example.com", principal.Name)
+
+	_, err = NewMTLSAuthenticator(&MTLSConfig{NameFrom: "serial"})
+	assert.NotNil(t, err)
+}
+
+func TestAuthenticationMiddleware(t *testing.T) {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("s3cr3t"), bcrypt.MinCost)
+	jwksFile := filepath.Join(t.TempDir(), "jwks.json")
+	assert.Nil(t, ioutil.WriteFile(jwksFile, []byte(`{"keys":[]}`), 0644))
+	mw, err := NewAuthenticationMiddleware(&AuthConfig{
+		JWT:   &JWTConfig{JWKSFile: jwksFile},
+		Basic: &BasicAuthConfig{Realm: "items", Users: []BasicAuthUser{{Username: "jane", PasswordHash: string(hash)}}},
+	})
+	assert.Nil(t, err)
+
+	var principal *model.Principal
+	handler := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal = PrincipalFromContext(r.Context())
+	}))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "http://localhost/rest/items", nil))
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	assert.Equal(t, []string{"Bearer", "Basic realm=\"items\", charset=\"UTF-8\""}, rr.Header().Values("WWW-Authenticate"))
+
+	// invalid credentials are not checked against the other methods
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, newBearerRequest("invalid"))
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	assert.Equal(t, []string{"Bearer"}, rr.Header().Values("WWW-Authenticate"))
+
+	rr = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/rest/items", nil)
+	req.SetBasicAuth("jane", "s3cr3t")
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "jane", principal.Name)
+
+	// CORS preflight requests do not carry credentials
+	principal = nil
+	rr = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodOptions, "http://localhost/rest/items", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Nil(t, principal)
+
+	_, err = NewAuthenticationMiddleware(&AuthConfig{})
+	assert.EqualError(t, err, "no authentication method configured")
+	_, err = NewAuthenticationMiddleware(&AuthConfig{JWT: &JWTConfig{JWKSFile: filepath.Join(os.TempDir(), "missing.json")}})
+	assert.NotNil(t, err)
+}
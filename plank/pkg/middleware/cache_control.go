@@ -154,6 +154,7 @@ func cacheControlWrapper(h http.Handler, globs []glob.Glob, directive *CacheCont
 
 		if !uriMatches {
 			h.ServeHTTP(w, r)
+			return
 		}
 
 		w.Header().Set("Cache-Control", directive.String())
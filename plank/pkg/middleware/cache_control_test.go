@@ -0,0 +1,35 @@
+// Copyright 2019-2021 VMware, Inc.
+// SPDX-License-Identifier: BSD-2-Clause
+
+package middleware
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCacheControlMiddleware(t *testing.T) {
+	calls := 0
+	handler := CacheControlMiddleware([]string{"/assets/**"}, NewCacheControlDirective().Public().MaxAge(time.Hour))(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			w.Write([]byte("ok"))
+		}))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/assets/js/app.js", nil))
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, "public, max-age=3600", rr.Header().Get("Cache-Control"))
+	assert.Equal(t, "ok", rr.Body.String())
+
+	// requests not matching the patterns are served once, without the Cache-Control header
+	calls = 0
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/index.html", nil))
+	assert.Equal(t, 1, calls)
+	assert.Empty(t, rr.Header().Get("Cache-Control"))
+	assert.Equal(t, "ok", rr.Body.String())
+}
@@ -0,0 +1,161 @@
+// Copyright 2019-2021 VMware, Inc.
+// SPDX-License-Identifier: BSD-2-Clause
+
+package middleware
+
+import (
+	"github.com/gobwas/glob"
+	"github.com/gorilla/mux"
+	"github.com/vmware/transport-go/plank/utils"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var (
+	defaultCorsAllowedMethods = []string{
+		http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+	defaultCorsAllowedHeaders = []string{"Accept", "Authorization", "Content-Type"}
+)
+
+// CorsPolicy defines which cross-origin requests browsers are allowed to make to the server. see
+// https://developer.mozilla.org/en-US/docs/Web/HTTP/CORS for details.
+type CorsPolicy struct {
+	AllowedOrigins   []string `json:"allowed_origins"`    // allowed origins, glob patterns like https://*.example.com are supported and * allows any origin
+	AllowedMethods   []string `json:"allowed_methods"`    // allowed methods (default: GET, HEAD, POST, PUT, PATCH, DELETE)
+	AllowedHeaders   []string `json:"allowed_headers"`    // allowed request headers, * allows any header (default: Accept, Authorization, Content-Type)
+	ExposedHeaders   []string `json:"exposed_headers"`    // response headers cross-origin clients are allowed to read
+	AllowCredentials bool     `json:"allow_credentials"`  // whether cross-origin requests may include cookies and authorization headers
+	MaxAge           int      `json:"max_age_in_seconds"` // how long browsers may cache preflight responses, the browser default if 0
+}
+
+// IsOriginAllowed returns whether the policy allows requests from the origin, e.g. https://app.example.com
+func (p *CorsPolicy) IsOriginAllowed(origin string) bool {
+	return newOriginMatcher(p.AllowedOrigins)(origin)
+}
+
+// newOriginMatcher compiles the allowed origins into a function matching origins against them, ignoring case
+func newOriginMatcher(allowedOrigins []string) func(origin string) bool {
+	patterns := make([]glob.Glob, 0, len(allowedOrigins))
+	for _, allowedOrigin := range allowedOrigins {
+		pattern, err := glob.Compile(strings.ToLower(allowedOrigin))
+		if err != nil {
+			utils.Log.Errorln("Ignoring invalid glob pattern provided as allowed CORS origin", err)
+			continue
+		}
+		patterns = append(patterns, pattern)
+	}
+	return func(origin string) bool {
+		origin = strings.ToLower(origin)
+		for _, pattern := range patterns {
+			if pattern.Match(origin) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// CorsMiddleware returns the middleware applying the CORS policy. preflight requests are answered by the
+// middleware itself and never reach the handler, actual requests from allowed origins get the CORS response
+// headers. requests from other origins are passed to the handler without CORS headers, which makes browsers
+// withhold their responses.
+func CorsMiddleware(policy *CorsPolicy) mux.MiddlewareFunc {
+	isOriginAllowed := newOriginMatcher(policy.AllowedOrigins)
+	anyOrigin := containsFold(policy.AllowedOrigins, "*")
+	allowedMethods := policy.AllowedMethods
+	if len(allowedMethods) == 0 {
+		allowedMethods = defaultCorsAllowedMethods
+	}
+	allowedHeaders := policy.AllowedHeaders
+	if len(allowedHeaders) == 0 {
+		allowedHeaders = defaultCorsAllowedHeaders
+	}
+	anyHeader := containsFold(allowedHeaders, "*")
+
+	return func(handler http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin == "" {
+				handler.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Add("Vary", "Origin")
+			requestedMethod := r.Header.Get("Access-Control-Request-Method")
+			preflight := isPreflightRequest(r)
+			if !isOriginAllowed(origin) {
+				if preflight {
+					w.WriteHeader(http.StatusForbidden)
+					return
+				}
+				handler.ServeHTTP(w, r)
+				return
+			}
+
+			// the wildcard can't be used for requests with credentials
+			allowedOrigin := origin
+			if anyOrigin && !policy.AllowCredentials {
+				allowedOrigin = "*"
+			}
+
+			if !preflight {
+				w.Header().Set("Access-Control-Allow-Origin", allowedOrigin)
+				if policy.AllowCredentials {
+					w.Header().Set("Access-Control-Allow-Credentials", "true")
+				}
+				if len(policy.ExposedHeaders) > 0 {
+					w.Header().Set("Access-Control-Expose-Headers", strings.Join(policy.ExposedHeaders, ", "))
+				}
+				handler.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+			requestedHeaders := make([]string, 0)
+			for _, header := range strings.Split(r.Header.Get("Access-Control-Request-Headers"), ",") {
+				if header = strings.TrimSpace(header); len(header) > 0 {
+					requestedHeaders = append(requestedHeaders, header)
+				}
+			}
+			if !containsFold(allowedMethods, requestedMethod) {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			for _, header := range requestedHeaders {
+				if !anyHeader && !containsFold(allowedHeaders, header) {
+					w.WriteHeader(http.StatusForbidden)
+					return
+				}
+			}
+
+			w.Header().Set("Access-Control-Allow-Origin", allowedOrigin)
+			w.Header().Set("Access-Control-Allow-Methods", strings.Join(allowedMethods, ", "))
+			if anyHeader {
+				// echo the requested headers, the wildcard can't be used for requests with credentials
+				if len(requestedHeaders) > 0 {
+					w.Header().Set("Access-Control-Allow-Headers", strings.Join(requestedHeaders, ", "))
+				}
+			} else {
+				w.Header().Set("Access-Control-Allow-Headers", strings.Join(allowedHeaders, ", "))
+			}
+			if policy.AllowCredentials {
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+			if policy.MaxAge > 0 {
+				w.Header().Set("Access-Control-Max-Age", strconv.Itoa(policy.MaxAge))
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
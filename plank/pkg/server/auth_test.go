@@ -0,0 +1,134 @@
+// Copyright 2019-2021 VMware, Inc.
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/vmware/transport-go/bus"
+	"github.com/vmware/transport-go/model"
+	"github.com/vmware/transport-go/plank/pkg/middleware"
+	"github.com/vmware/transport-go/service"
+	"github.com/vmware/transport-go/stompserver"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestPlatformServer_AuthenticatedBridge(t *testing.T) {
+	newBus := bus.ResetBus()
+	service.ResetServiceRegistry()
+	_ = newBus.GetChannelManager().CreateChannel("customer-service")
+	hash := sha256.Sum256([]byte("secret-key"))
+	config := GetBasicTestServerConfig(os.TempDir(), "stdout", "stdout", "stderr", GetTestPort(), true)
+	config.AuthConfig = &middleware.AuthConfig{APIKey: &middleware.APIKeyConfig{Keys: []middleware.APIKey{
+		{Name: "ci", KeyHash: hex.EncodeToString(hash[:]), Roles: []string{"deployer"}},
+	}}}
+	ps := NewPlatformServer(config).(*platformServer)
+	ps.eventbus = newBus
+
+	requests := make(chan *model.Request, 1)
+	mh, _ := newBus.ListenRequestStream("customer-service")
+	mh.Handle(func(message *model.Message) {
+		request := message.Payload.(model.Request)
+		requests <- &request
+		newBus.SendResponseMessage("customer-service",
+			&model.Response{Id: request.Id, Payload: "ok"}, message.DestinationId)
+	}, func(e error) {})
+	ps.SetHttpChannelBridge(&service.RESTBridgeConfig{
+		ServiceChannel:       "customer-service",
+		Uri:                  "/rest/customers",
+		Method:               http.MethodGet,
+		FabricRequestBuilder: requestBuilder,
+	})
+
+	rr := httptest.NewRecorder()
+	ps.router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "http://localhost/rest/customers", nil))
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	assert.Empty(t, requests)
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/rest/customers", nil)
+	req.Header.Set("X-API-Key", "secret-key")
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	req.RemoteAddr = "10.0.0.1:51234"
+	rr = httptest.NewRecorder()
+	ps.router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	request := <-requests
+	assert.Equal(t, "ci", request.Metadata.Principal.Name)
+	assert.True(t, request.Metadata.GetPrincipal().HasRole("deployer"))
+	assert.Same(t, request.Metadata.Principal, request.GetPrincipal())
+	assert.Same(t, request.Metadata.Principal, request.Principal)
+	assert.Equal(t, model.TransportHTTP, request.Metadata.Transport)
+	assert.Equal(t, "10.0.0.1:51234", request.Metadata.RemoteAddr)
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", request.Metadata.TraceParent)
+}
+
+func TestPlatformServer_AuthenticatedFabricEndpoint(t *testing.T) {
+	bus.ResetBus()
+	service.ResetServiceRegistry()
+	hash := sha256.Sum256([]byte("secret-key"))
+	config := GetBasicTestServerConfig(os.TempDir(), "stdout", "stdout", "stderr", GetTestPort(), true)
+	config.FabricConfig = &FabricBrokerConfig{FabricEndpoint: "/ws", EndpointConfig: &bus.EndpointConfig{}}
+	config.AuthConfig = &middleware.AuthConfig{APIKey: &middleware.APIKeyConfig{Keys: []middleware.APIKey{
+		{Name: "ci", KeyHash: hex.EncodeToString(hash[:]), Roles: []string{"deployer"}},
+	}}}
+	ps := NewPlatformServer(config).(*platformServer)
+	server := httptest.NewServer(ps.router)
+	defer server.Close()
+	wsUrl := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+
+	// the upgrade request must carry credentials
+	_, rsp, err := websocket.DefaultDialer.Dial(wsUrl, nil)
+	assert.NotNil(t, err)
+	assert.Equal(t, http.StatusUnauthorized, rsp.StatusCode)
+
+	// the principal is available to the fabric endpoint through the context of the upgrade request
+	conn, _, err := websocket.DefaultDialer.Dial(wsUrl, http.Header{"X-Api-Key": []string{"secret-key"}})
+	assert.Nil(t, err)
+	defer conn.Close()
+	rawConn, err := ps.fabricConn.Accept()
+	assert.Nil(t, err)
+	principal := model.PrincipalFromContext(rawConn.(stompserver.RequestContextProvider).RequestContext())
+	assert.Equal(t, "ci", principal.Name)
+	assert.Equal(t, middleware.AuthMethodAPIKey, principal.AuthMethod)
+}
+
+func TestPlatformServer_AnonymousFabricEndpoint(t *testing.T) {
+	bus.ResetBus()
+	service.ResetServiceRegistry()
+	config := GetBasicTestServerConfig(os.TempDir(), "stdout", "stdout", "stderr", GetTestPort(), true)
+	config.FabricConfig = &FabricBrokerConfig{FabricEndpoint: "/ws", EndpointConfig: &bus.EndpointConfig{}}
+	config.AuthConfig = &middleware.AuthConfig{
+		Basic:                &middleware.BasicAuthConfig{},
+		AllowAnonymousFabric: true,
+	}
+	ps := NewPlatformServer(config).(*platformServer)
+	server := httptest.NewServer(ps.router)
+	defer server.Close()
+	wsUrl := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+
+	conn, _, err := websocket.DefaultDialer.Dial(wsUrl, nil)
+	assert.Nil(t, err)
+	defer conn.Close()
+	rawConn, err := ps.fabricConn.Accept()
+	assert.Nil(t, err)
+	assert.Nil(t, model.PrincipalFromContext(rawConn.(stompserver.RequestContextProvider).RequestContext()))
+
+	// invalid credentials are still rejected
+	_, rsp, err := websocket.DefaultDialer.Dial(wsUrl, http.Header{"Authorization": []string{"Basic Zm9vOmJhcg=="}})
+	assert.NotNil(t, err)
+	assert.Equal(t, http.StatusUnauthorized, rsp.StatusCode)
+
+	// the TCP listener cannot authenticate its clients
+	config.AuthConfig.AllowAnonymousFabric = false
+	config.FabricConfig.UseTCP = true
+	assert.Panics(t, func() {
+		NewPlatformServer(config)
+	})
+}
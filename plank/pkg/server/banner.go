@@ -5,9 +5,11 @@ import (
 	imgcolor "image/color"
 	"os"
 	"runtime"
+	"strings"
 
 	"github.com/eliukblau/pixterm/pkg/ansimage"
 	"github.com/fatih/color"
+	"github.com/vmware/transport-go/plank/pkg/middleware"
 	"github.com/vmware/transport-go/plank/utils"
 )
 
@@ -88,13 +90,64 @@ bannerBody:
 	}
 
 	utils.InfoFprintf(ps.out, "Health endpoint\t\t")
-	_, _ = fmt.Fprintln(ps.out, "/health")
+	_, _ = fmt.Fprintln(ps.out, "/health, /health/live, /health/ready")
 
 	if ps.serverConfig.EnablePrometheus {
 		utils.InfoFprintf(ps.out, "Prometheus endpoint\t")
 		_, _ = fmt.Fprintln(ps.out, "/prometheus")
 	}
 
+	if ps.serverConfig.OpenAPIConfig != nil {
+		utils.InfoFprintf(ps.out, "OpenAPI endpoint\t")
+		_, _ = fmt.Fprint(ps.out, OpenAPIDocumentPath)
+		if !ps.serverConfig.OpenAPIConfig.DisableUI {
+			_, _ = fmt.Fprint(ps.out, ", ", ps.serverConfig.OpenAPIConfig.UIPath)
+		}
+		_, _ = fmt.Fprintln(ps.out)
+	}
+
+	if authConfig := ps.serverConfig.AuthConfig; authConfig != nil {
+		methods := make([]string, 0)
+		if authConfig.JWT != nil {
+			methods = append(methods, middleware.AuthMethodJWT)
+		}
+		if authConfig.APIKey != nil {
+			methods = append(methods, middleware.AuthMethodAPIKey)
+		}
+		if authConfig.Basic != nil {
+			methods = append(methods, middleware.AuthMethodBasic)
+		}
+		if authConfig.MTLS != nil {
+			methods = append(methods, middleware.AuthMethodMTLS)
+		}
+		utils.InfoFprintf(ps.out, "Authentication\t\t")
+		_, _ = fmt.Fprintln(ps.out, strings.Join(methods, ", "))
+		utils.InfoFprintf(ps.out, "Protected routes\t")
+		if ps.serverConfig.FabricConfig == nil {
+			_, _ = fmt.Fprintln(ps.out, "REST bridges")
+		} else if authConfig.AllowAnonymousFabric {
+			_, _ = fmt.Fprintln(ps.out, "REST bridges (anonymous fabric connections allowed)")
+		} else {
+			_, _ = fmt.Fprintln(ps.out, "REST bridges, fabric endpoint")
+		}
+	}
+
+	if ps.serverConfig.CorsPolicy != nil {
+		utils.InfoFprintf(ps.out, "CORS allowed origins\t")
+		_, _ = fmt.Fprintln(ps.out, strings.Join(ps.serverConfig.CorsPolicy.AllowedOrigins, ", "))
+	}
+
+	if tracingConfig := ps.serverConfig.TracingConfig; tracingConfig != nil {
+		exporter := tracingConfig.Exporter
+		if tracingConfig.SpanExporter != nil {
+			exporter = "custom"
+		} else if exporter == "" {
+			exporter = TracingExporterStdout
+		}
+		utils.InfoFprintf(ps.out, "Tracing exporter\t")
+		_, _ = fmt.Fprintln(ps.out, exporter)
+	}
+
 	_, _ = fmt.Fprintln(ps.out)
 
 }
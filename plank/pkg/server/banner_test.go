@@ -3,6 +3,7 @@ package server
 import (
 	"github.com/stretchr/testify/assert"
 	"github.com/vmware/transport-go/bus"
+	"github.com/vmware/transport-go/plank/pkg/middleware"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -109,6 +110,31 @@ func TestPrintBanner_FabricConfig(t *testing.T) {
 	assert.Contains(t, string(logContents), "Fabric endpoint\t\t/ws")
 }
 
+func TestPrintBanner_AuthConfig(t *testing.T) {
+	testRoot := filepath.Join(os.TempDir(), "plank-tests")
+	_ = os.MkdirAll(testRoot, 0755)
+	testLogFile := filepath.Join(testRoot, "testlog.log")
+	defer os.RemoveAll(testRoot)
+
+	cfg := GetBasicTestServerConfig(testRoot, testLogFile, testLogFile, testLogFile, 9981, false)
+	cfg.FabricConfig = &FabricBrokerConfig{FabricEndpoint: "/ws", EndpointConfig: &bus.EndpointConfig{}}
+	cfg.AuthConfig = &middleware.AuthConfig{Basic: &middleware.BasicAuthConfig{}, MTLS: &middleware.MTLSConfig{}}
+	_, _, testServerInterface := CreateTestServer(cfg)
+	testServer := testServerInterface.(*platformServer)
+
+	// act
+	testServer.printBanner()
+
+	// assert
+	logContents, err := ioutil.ReadFile(testLogFile)
+	if err != nil {
+		assert.Fail(t, err.Error())
+	}
+
+	assert.Contains(t, string(logContents), "Authentication\t\tbasic, mtls")
+	assert.Contains(t, string(logContents), "Protected routes\tREST bridges, fabric endpoint")
+}
+
 func TestPrintBanner_FabricConfig_TCP(t *testing.T) {
 	testRoot := filepath.Join(os.TempDir(), "plank-tests")
 	_ = os.MkdirAll(testRoot, 0755)
@@ -236,3 +262,27 @@ func TestPrintBanner_Prometheus(t *testing.T) {
 	assert.Contains(t, string(logContents), "Port\t\t\t9981")
 	assert.Contains(t, string(logContents), "Prometheus endpoint\t/prometheus")
 }
+
+func TestPrintBanner_OpenAPI(t *testing.T) {
+	testRoot := filepath.Join(os.TempDir(), "plank-tests")
+	_ = os.MkdirAll(testRoot, 0755)
+	testLogFile := filepath.Join(testRoot, "testlog.log")
+	defer os.RemoveAll(testRoot)
+
+	cfg := GetBasicTestServerConfig(testRoot, testLogFile, testLogFile, testLogFile, 9981, false)
+	cfg.OpenAPIConfig = &OpenAPIConfig{}
+	_, _, testServerInterface := CreateTestServer(cfg)
+	testServer := testServerInterface.(*platformServer)
+
+	// act
+	testServer.printBanner()
+
+	// assert
+	logContents, err := ioutil.ReadFile(testLogFile)
+	if err != nil {
+		assert.Fail(t, err.Error())
+	}
+
+	assert.FileExists(t, testLogFile)
+	assert.Contains(t, string(logContents), "OpenAPI endpoint\t/openapi.json, /openapi")
+}
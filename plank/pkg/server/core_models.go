@@ -5,13 +5,14 @@ package server
 
 import (
 	"crypto/tls"
+	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 	"github.com/vmware/transport-go/bus"
-	"github.com/vmware/transport-go/model"
 	"github.com/vmware/transport-go/plank/pkg/middleware"
 	"github.com/vmware/transport-go/plank/utils"
 	"github.com/vmware/transport-go/service"
 	"github.com/vmware/transport-go/stompserver"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	"io"
 	"net/http"
 	"os"
@@ -21,19 +22,23 @@ import (
 
 // PlatformServerConfig holds all the core configuration needed for the functionality of Plank
 type PlatformServerConfig struct {
-	RootDir           string              `json:"root_dir"`                       // root directory the server should base itself on
-	StaticDir         []string            `json:"static_dir"`                     // static content folders that HTTP server should serve
-	SpaConfig         *SpaConfig          `json:"spa_config"`                     // single page application configuration
-	Host              string              `json:"host"`                           // hostname for the server
-	Port              int                 `json:"port"`                           // port for the server
-	LogConfig         *utils.LogConfig    `json:"log_config"`                     // log configuration (plank, Http access and error logs)
-	FabricConfig      *FabricBrokerConfig `json:"fabric_config"`                  // Fabric (websocket) configuration
-	TLSCertConfig     *TLSCertConfig      `json:"tls_config"`                     // TLS certificate configuration
-	EnablePrometheus  bool                `json:"enable_prometheus"`              // whether to enable Prometheus for runtime metrics
-	Debug             bool                `json:"debug"`                          // enable debug logging
-	NoBanner          bool                `json:"no_banner"`                      // start server without displaying the banner
-	ShutdownTimeout   time.Duration       `json:"shutdown_timeout_in_minutes"`    // graceful server shutdown timeout in minutes
-	RestBridgeTimeout time.Duration       `json:"rest_bridge_timeout_in_minutes"` // rest bridge timeout in minutes
+	RootDir           string                 `json:"root_dir"`                       // root directory the server should base itself on
+	StaticDir         []string               `json:"static_dir"`                     // static content folders that HTTP server should serve
+	SpaConfig         *SpaConfig             `json:"spa_config"`                     // single page application configuration
+	Host              string                 `json:"host"`                           // hostname for the server
+	Port              int                    `json:"port"`                           // port for the server
+	LogConfig         *utils.LogConfig       `json:"log_config"`                     // log configuration (plank, Http access and error logs)
+	FabricConfig      *FabricBrokerConfig    `json:"fabric_config"`                  // Fabric (websocket) configuration
+	TLSCertConfig     *TLSCertConfig         `json:"tls_config"`                     // TLS certificate configuration
+	EnablePrometheus  bool                   `json:"enable_prometheus"`              // whether to enable Prometheus for runtime metrics
+	OpenAPIConfig     *OpenAPIConfig         `json:"openapi_config"`                 // OpenAPI description of the REST bridges, if enabled
+	CorsPolicy        *middleware.CorsPolicy `json:"cors_policy"`                    // CORS policy applied to every HTTP endpoint and the fabric endpoint
+	AuthConfig        *middleware.AuthConfig `json:"auth_config"`                    // authentication required to call the REST bridges and connect to the fabric endpoint, if configured
+	TracingConfig     *TracingConfig         `json:"tracing_config"`                 // OpenTelemetry tracing of the requests handled by the server, if enabled
+	Debug             bool                   `json:"debug"`                          // enable debug logging
+	NoBanner          bool                   `json:"no_banner"`                      // start server without displaying the banner
+	ShutdownTimeout   time.Duration          `json:"shutdown_timeout_in_minutes"`    // graceful server shutdown timeout in minutes
+	RestBridgeTimeout time.Duration          `json:"rest_bridge_timeout_in_minutes"` // rest bridge timeout in minutes
 }
 
 // TLSCertConfig wraps around key information for TLS configuration
@@ -41,6 +46,27 @@ type TLSCertConfig struct {
 	CertFile                  string `json:"cert_file"`                   // path to certificate file
 	KeyFile                   string `json:"key_file"`                    // path to private key file
 	SkipCertificateValidation bool   `json:"skip_certificate_validation"` // whether to skip certificate validation (useful for self-signed cert)
+	ClientCAFile              string `json:"client_ca_file"`              // path to CA certificates client certificates are verified with, if clients may authenticate with certificates
+}
+
+// OpenAPIConfig defines the info section of the OpenAPI document served at /openapi.json and where the API
+// explorer page is served from
+type OpenAPIConfig struct {
+	Title       string `json:"title"`       // title of the API (default: Plank)
+	Version     string `json:"version"`     // version of the API (default: 1.0.0)
+	Description string `json:"description"` // description of the API
+	UIPath      string `json:"ui_path"`     // URI to serve the API explorer page at (default: /openapi)
+	DisableUI   bool   `json:"disable_ui"`  // do not serve the API explorer page
+}
+
+// TracingConfig defines how the OpenTelemetry spans recorded for the requests handled by the server are sampled
+// and exported. the trace context of the callers is propagated regardless of the configuration.
+type TracingConfig struct {
+	Exporter     string                `json:"exporter"`     // exporter of the spans: stdout, file or none (default: stdout)
+	File         string                `json:"file"`         // file the spans are appended to as JSON if the exporter is file
+	ServiceName  string                `json:"service_name"` // name of the service the spans are recorded for (default: plank)
+	SampleRatio  float64               `json:"sample_ratio"` // ratio of new traces recorded between 0 and 1, traces of callers follow their decision (default: 1)
+	SpanExporter sdktrace.SpanExporter `json:"-"`            // custom exporter of the spans, e.g. an OTLP exporter, used instead of the Exporter
 }
 
 // FabricBrokerConfig defines the endpoint for WebSocket as well as detailed endpoint configuration
@@ -53,15 +79,16 @@ type FabricBrokerConfig struct {
 
 // PlatformServer exposes public API methods that control the behavior of the Plank instance.
 type PlatformServer interface {
-	StartServer(syschan chan os.Signal)                                         // start server
-	StopServer()                                                                // stop server
-	RegisterService(svc service.FabricService, svcChannel string) error         // register a new service at given channel
-	SetHttpChannelBridge(bridgeConfig *service.RESTBridgeConfig)                // set up a REST bridge for a service
-	SetStaticRoute(prefix, fullpath string, middlewareFn ...mux.MiddlewareFunc) // set up a static content route
-	SetHttpPathPrefixChannelBridge(bridgeConfig *service.RESTBridgeConfig)      // set up a REST bridge for a path prefix for a service.
-	CustomizeTLSConfig(tls *tls.Config) error                                   // used to replace default tls.Config for HTTP server with a custom config
-	GetRestBridgeSubRoute(uri, method string) (*mux.Route, error)               // get *mux.Route that maps to the provided uri and method
-	GetMiddlewareManager() middleware.MiddlewareManager                         // get middleware manager
+	StartServer(syschan chan os.Signal)                                                                             // start server
+	StopServer()                                                                                                    // stop server
+	RegisterService(svc service.FabricService, svcChannel string) error                                             // register a new service at given channel
+	RegisterServiceWithOptions(svc service.FabricService, svcChannel string, options *service.ServiceOptions) error // register a new service with request delivery options
+	SetHttpChannelBridge(bridgeConfig *service.RESTBridgeConfig)                                                    // set up a REST bridge for a service
+	SetStaticRoute(prefix, fullpath string, middlewareFn ...mux.MiddlewareFunc)                                     // set up a static content route
+	SetHttpPathPrefixChannelBridge(bridgeConfig *service.RESTBridgeConfig)                                          // set up a REST bridge for a path prefix for a service.
+	CustomizeTLSConfig(tls *tls.Config) error                                                                       // used to replace default tls.Config for HTTP server with a custom config
+	GetRestBridgeSubRoute(uri, method string) (*mux.Route, error)                                                   // get *mux.Route that maps to the provided uri and method
+	GetMiddlewareManager() middleware.MiddlewareManager                                                             // get middleware manager
 
 }
 
@@ -72,6 +99,9 @@ type platformServer struct {
 	eventbus                     bus.EventBus                      // event bus pointer
 	serverConfig                 *PlatformServerConfig             // server config instance
 	middlewareManager            middleware.MiddlewareManager      // middleware maanger instance
+	authMiddleware               mux.MiddlewareFunc                // authentication middleware applied to REST bridges, if configured
+	fabricAuthMiddleware         mux.MiddlewareFunc                // authentication middleware applied to the fabric WebSocket upgrade requests, if configured
+	tracerProvider               *sdktrace.TracerProvider          // tracer provider exporting the spans, if tracing is enabled
 	router                       *mux.Router                       // *mux.Router instance
 	routerConcurrencyProtection  *int32                            // atomic int32 to protect the main router being concurrently written to
 	out                          io.Writer                         // platform log output pointer
@@ -81,12 +111,16 @@ type platformServer struct {
 	ServerAvailability           *ServerAvailability               // server availability (not much used other than for internal monitoring for now)
 	lock                         sync.Mutex                        // lock
 	messageBridgeMap             map[string]*MessageBridge
+	bridgeConfigMap              map[string]*service.RESTBridgeConfig // internal map to store endpoint handler key - REST bridge config mappings
 }
 
 // MessageBridge is a conduit used for returning service responses as HTTP responses
 type MessageBridge struct {
-	ServiceListenStream bus.MessageHandler  // message handler returned by bus.ListenStream responsible for relaying back messages as HTTP responses
-	payloadChannel      chan *model.Message // internal golang channel used for passing bus responses/errors across goroutines
+	ServiceListenStream bus.MessageHandler            // message handler returned by bus.ListenStream responsible for relaying back messages as HTTP responses
+	serviceChannel      string                        // service channel whose responses are relayed
+	lock                sync.Mutex                    // lock
+	pending             map[uuid.UUID]*pendingRequest // requests waiting for their responses, by request id
+	timedOut            map[uuid.UUID]time.Time       // ids of recently timed out requests
 }
 
 // ServerAvailability contains boolean fields to indicate what components of the system are available or not
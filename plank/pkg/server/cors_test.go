@@ -0,0 +1,205 @@
+// Copyright 2019-2021 VMware, Inc.
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/vmware/transport-go/model"
+	"github.com/vmware/transport-go/plank/pkg/middleware"
+	"github.com/vmware/transport-go/service"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newCorsTestServer(policy *middleware.CorsPolicy) *platformServer {
+	ps := newOpenAPITestServer(nil)
+	ps.serverConfig.CorsPolicy = policy
+	ps.loadGlobalHttpHandler(ps.router)
+	mh, _ := ps.eventbus.ListenRequestStream("customer-service")
+	mh.Handle(func(message *model.Message) {
+		request := message.Payload.(model.Request)
+		ps.eventbus.SendResponseMessage("customer-service",
+			&model.Response{Id: request.Id, Payload: "ok"}, message.DestinationId)
+	}, func(e error) {})
+
+	ps.SetHttpChannelBridge(&service.RESTBridgeConfig{
+		ServiceChannel:       "customer-service",
+		Uri:                  "/rest/customers",
+		Method:               http.MethodPost,
+		AllowOptions:         false,
+		FabricRequestBuilder: requestBuilder,
+	})
+	return ps
+}
+
+func newPreflightRequest(origin, method, headers string) *http.Request {
+	req := httptest.NewRequest(http.MethodOptions, "http://localhost/rest/customers", nil)
+	req.Header.Set("Origin", origin)
+	req.Header.Set("Access-Control-Request-Method", method)
+	if headers != "" {
+		req.Header.Set("Access-Control-Request-Headers", headers)
+	}
+	return req
+}
+
+func TestPlatformServer_CorsPreflight(t *testing.T) {
+	ps := newCorsTestServer(&middleware.CorsPolicy{
+		AllowedOrigins:   []string{"https://*.example.com"},
+		AllowedMethods:   []string{http.MethodGet, http.MethodPost},
+		AllowCredentials: true,
+		MaxAge:           600,
+	})
+
+	// preflight requests are answered although the bridge does not allow OPTIONS
+	rr := httptest.NewRecorder()
+	ps.HttpServer.Handler.ServeHTTP(rr, newPreflightRequest("https://app.example.com", http.MethodPost, "content-type"))
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	assert.Equal(t, "https://app.example.com", rr.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "GET, POST", rr.Header().Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, "Accept, Authorization, Content-Type", rr.Header().Get("Access-Control-Allow-Headers"))
+	assert.Equal(t, "true", rr.Header().Get("Access-Control-Allow-Credentials"))
+	assert.Equal(t, "600", rr.Header().Get("Access-Control-Max-Age"))
+	assert.Contains(t, rr.Header().Values("Vary"), "Origin")
+
+	rr = httptest.NewRecorder()
+	ps.HttpServer.Handler.ServeHTTP(rr, newPreflightRequest("https://evil.com", http.MethodPost, ""))
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	assert.Empty(t, rr.Header().Get("Access-Control-Allow-Origin"))
+
+	rr = httptest.NewRecorder()
+	ps.HttpServer.Handler.ServeHTTP(rr, newPreflightRequest("https://app.example.com", http.MethodDelete, ""))
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+
+	rr = httptest.NewRecorder()
+	ps.HttpServer.Handler.ServeHTTP(rr, newPreflightRequest("https://app.example.com", http.MethodPost, "X-Custom"))
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+
+	// plain OPTIONS requests are still rejected
+	rr = httptest.NewRecorder()
+	ps.HttpServer.Handler.ServeHTTP(rr, httptest.NewRequest(http.MethodOptions, "http://localhost/rest/customers", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+	assert.Equal(t, "POST", rr.Header().Get("Allow"))
+}
+
+func TestPlatformServer_CorsActualRequest(t *testing.T) {
+	ps := newCorsTestServer(&middleware.CorsPolicy{
+		AllowedOrigins: []string{"*"},
+		AllowedHeaders: []string{"*"},
+		ExposedHeaders: []string{"X-Request-Id"},
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/rest/customers", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	rr := httptest.NewRecorder()
+	ps.HttpServer.Handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "*", rr.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "X-Request-Id", rr.Header().Get("Access-Control-Expose-Headers"))
+	assert.Empty(t, rr.Header().Get("Access-Control-Allow-Credentials"))
+
+	// any requested header is echoed back
+	rr = httptest.NewRecorder()
+	ps.HttpServer.Handler.ServeHTTP(rr, newPreflightRequest("https://app.example.com", http.MethodPost, "X-Custom, X-Other"))
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	assert.Equal(t, "X-Custom, X-Other", rr.Header().Get("Access-Control-Allow-Headers"))
+}
+
+func TestPlatformServer_CorsMiddlewarePerBridge(t *testing.T) {
+	ps := newCorsTestServer(nil)
+
+	// without a policy OPTIONS requests are rejected like before
+	rr := httptest.NewRecorder()
+	ps.HttpServer.Handler.ServeHTTP(rr, newPreflightRequest("https://app.example.com", http.MethodPost, ""))
+	assert.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+
+	mm := ps.GetMiddlewareManager()
+	route, err := mm.GetRouteByUriAndMethod("/rest/customers", http.MethodPost)
+	assert.Nil(t, err)
+	err = mm.SetNewMiddleware(route, []mux.MiddlewareFunc{
+		middleware.CorsMiddleware(&middleware.CorsPolicy{AllowedOrigins: []string{"https://app.example.com"}}),
+	})
+	assert.Nil(t, err)
+
+	rr = httptest.NewRecorder()
+	ps.HttpServer.Handler.ServeHTTP(rr, newPreflightRequest("https://APP.example.com", http.MethodPost, ""))
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	assert.Equal(t, "https://APP.example.com", rr.Header().Get("Access-Control-Allow-Origin"))
+}
+
+func TestPlatformServer_CorsMiddlewareSameUri(t *testing.T) {
+	ps := newCorsTestServer(nil)
+	ps.SetHttpChannelBridge(&service.RESTBridgeConfig{
+		ServiceChannel:       "customer-service",
+		Uri:                  "/rest/customers",
+		Method:               http.MethodGet,
+		FabricRequestBuilder: requestBuilder,
+	})
+
+	// the preflight requests reach the bridge of the method they are made for, not the first one of the URI
+	mm := ps.GetMiddlewareManager()
+	route, err := mm.GetRouteByUriAndMethod("/rest/customers", http.MethodGet)
+	assert.Nil(t, err)
+	assert.Nil(t, mm.SetNewMiddleware(route, []mux.MiddlewareFunc{
+		middleware.CorsMiddleware(&middleware.CorsPolicy{AllowedOrigins: []string{"https://app.example.com"}}),
+	}))
+
+	rr := httptest.NewRecorder()
+	ps.HttpServer.Handler.ServeHTTP(rr, newPreflightRequest("https://app.example.com", http.MethodGet, ""))
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	rr = httptest.NewRecorder()
+	ps.HttpServer.Handler.ServeHTTP(rr, newPreflightRequest("https://app.example.com", http.MethodPost, ""))
+	assert.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+}
+
+func TestPlatformServer_CorsMiddlewarePathPrefix(t *testing.T) {
+	ps := newCorsTestServer(nil)
+	ps.SetHttpPathPrefixChannelBridge(&service.RESTBridgeConfig{
+		ServiceChannel:       "customer-service",
+		Uri:                  "/rest/orders",
+		FabricRequestBuilder: requestBuilder,
+	})
+
+	// OPTIONS requests are not relayed to the service unless the bridge allows them
+	req := newPreflightRequest("https://app.example.com", http.MethodPut, "")
+	req.URL.Path = "/rest/orders/1"
+	rr := httptest.NewRecorder()
+	ps.HttpServer.Handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+
+	mm := ps.GetMiddlewareManager()
+	route, err := mm.GetRouteByUriAndMethod("/rest/orders", AllMethodsWildcard)
+	assert.Nil(t, err)
+	assert.Nil(t, mm.SetNewMiddleware(route, []mux.MiddlewareFunc{
+		middleware.CorsMiddleware(&middleware.CorsPolicy{AllowedOrigins: []string{"https://app.example.com"}}),
+	}))
+	rr = httptest.NewRecorder()
+	ps.HttpServer.Handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	assert.Equal(t, "https://app.example.com", rr.Header().Get("Access-Control-Allow-Origin"))
+}
+
+func TestFabricOriginGuard(t *testing.T) {
+	guard := fabricOriginGuard(&middleware.CorsPolicy{AllowedOrigins: []string{"https://app.example.com"}},
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	tests := []struct {
+		origin   string
+		expected int
+	}{
+		{"", http.StatusOK},
+		{"https://app.example.com", http.StatusOK},
+		{"http://localhost", http.StatusOK},
+		{"https://evil.com", http.StatusForbidden},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost/ws", nil)
+		if test.origin != "" {
+			req.Header.Set("Origin", test.origin)
+		}
+		rr := httptest.NewRecorder()
+		guard.ServeHTTP(rr, req)
+		assert.Equal(t, test.expected, rr.Code, test.origin)
+	}
+}
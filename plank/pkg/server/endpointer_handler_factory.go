@@ -2,19 +2,52 @@ package server
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
+	"github.com/google/uuid"
 	"github.com/vmware/transport-go/model"
+	"github.com/vmware/transport-go/plank/pkg/metrics"
+	"github.com/vmware/transport-go/plank/pkg/middleware"
 	"github.com/vmware/transport-go/plank/utils"
 	"github.com/vmware/transport-go/service"
+	"github.com/vmware/transport-go/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"net/http"
 	"strings"
 	"time"
 )
 
+// headers carrying the credentials of the caller, which are never relayed to the services
+var credentialHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+	"X-Api-Key":           true,
+}
+
+// copyRequestHeaders returns the headers of the HTTP request relayed to the service, except for the headers
+// carrying credentials, including the header of the API keys configured for the server.
+func (ps *platformServer) copyRequestHeaders(r *http.Request) map[string]string {
+	apiKeyHeader := ""
+	if authConfig := ps.serverConfig.AuthConfig; authConfig != nil && authConfig.APIKey != nil {
+		apiKeyHeader = http.CanonicalHeaderKey(authConfig.APIKey.Header)
+	}
+	headers := make(map[string]string, len(r.Header))
+	for k := range r.Header {
+		key := http.CanonicalHeaderKey(k)
+		if credentialHeaders[key] || key == apiKeyHeader {
+			continue
+		}
+		headers[k] = r.Header.Get(k)
+	}
+	return headers
+}
+
 // buildEndpointHandler builds a http.HandlerFunc that wraps Transport Bus operations in an HTTP request-response cycle.
-// service channel, request builder and rest bridge timeout are passed as parameters.
-func (ps *platformServer) buildEndpointHandler(svcChannel string, reqBuilder service.RequestBuilder, restBridgeTimeout time.Duration, msgChan chan *model.Message) http.HandlerFunc {
+// service channel, request builder, rest bridge timeout and the message bridge relaying the responses of the
+// service channel are passed as parameters.
+func (ps *platformServer) buildEndpointHandler(svcChannel string, reqBuilder service.RequestBuilder, restBridgeTimeout time.Duration, messageBridge *MessageBridge) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
 			if r := recover(); r != nil {
@@ -29,77 +62,90 @@ func (ps *platformServer) buildEndpointHandler(svcChannel string, reqBuilder ser
 
 		// relay the request to transport channel
 		reqModel := reqBuilder(w, r)
-		err := ps.eventbus.SendRequestMessage(svcChannel, reqModel, reqModel.Id)
+		if reqModel.Headers == nil {
+			reqModel.Headers = ps.copyRequestHeaders(r)
+		}
+		reqModel.Metadata = buildRequestMetadata(r, reqModel.Metadata)
+		reqModel.Principal = reqModel.Metadata.Principal
+
+		// continue the trace of the caller, or start a new one, and propagate it to the service
+		spanCtx, span := tracing.Tracer().Start(
+			tracing.ContextFromMetadata(context.Background(), reqModel.Metadata), r.Method+" "+svcChannel,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.method", r.Method),
+				attribute.String("http.target", r.URL.Path),
+				attribute.String(tracing.ChannelAttribute, svcChannel),
+				attribute.String(tracing.RequestAttribute, reqModel.Request)))
+		defer span.End()
+		tracing.InjectMetadata(spanCtx, reqModel.Metadata)
+
+		// wait for the response to this very request, identified by the request id
+		msgChan := messageBridge.register(&reqModel)
+		timedOut := true
+		defer func() {
+			messageBridge.release(reqModel.Id, timedOut)
+		}()
+
+		if err := ps.eventbus.SendRequestMessage(svcChannel, reqModel, reqModel.Id); err != nil {
+			utils.Log.WithError(err).Errorf("Unable to send request to channel %s:", svcChannel)
+		}
 
 		// get a response from the channel, render the results using ResponseWriter and log the data/error
 		// to the console as well.
 		select {
 		case <-ctx.Done():
+			span.SetStatus(codes.Error, "request timed out")
+			metrics.RestBridgeTimeoutCounter.WithLabelValues(svcChannel, r.Method).Inc()
 			http.Error(
 				w,
 				fmt.Sprintf("No response received from service channel in %s, request timed out", restBridgeTimeout.String()), 500)
 		case msg := <-msgChan:
-			if msg.Error != nil {
-				utils.Log.WithError(msg.Error).Errorf(
-					"Error received from channel %s:", svcChannel)
-				http.Error(w, msg.Error.Error(), 500)
-			} else {
-				// only send the actual user payloadChannel not wrapper information
-				response := msg.Payload.(*model.Response)
-				var respBody interface{}
-				if response.Error {
-					if response.Payload != nil {
-						respBody = response.Payload
-					} else {
-						respBody = response
-					}
-				} else {
-					respBody = response.Payload
-				}
-
-				// if our Message is an error and it has a code, lets send that back to the client.
-				if response.Error {
-
-					// we have to set the headers for the error response
-					for k, v := range response.Headers {
-						w.Header().Set(k, v)
-					}
-
-					// deal with the response body now, if set.
-					n, e := json.Marshal(respBody)
-					if e != nil {
-
-						w.WriteHeader(response.ErrorCode)
-						w.Write([]byte(response.ErrorMessage))
-						return
-
-					} else {
-						w.WriteHeader(response.ErrorCode)
-						w.Write(n)
-						return
-					}
-				} else {
-					// if the response has headers, set those headers. particularly if you're sending around
-					// byte array data for things like zip files etc.
-					for k, v := range response.Headers {
-						w.Header().Set(k, v)
-						if strings.ToLower(k) == "content-type" {
-							respBody, err = utils.ConvertInterfaceToByteArray(v, respBody)
-						}
-					}
+			timedOut = false
+			if getResponseStreamEvent(msg) != nil {
+				// the service streams the response as a series of responses
+				ps.streamResponse(w, r, svcChannel, &reqModel, msg, msgChan, restBridgeTimeout)
+				return
+			}
+			writeServiceResponse(w, r, svcChannel, msg)
+		}
+	}
+}
 
-					var respBodyBytes []byte
-					// ensure respBody is properly converted to a byte slice as Content-Type header might not be
-					// set in the request and the restBody could be in a format that is not a byte slice.
-					respBodyBytes, err = ensureResponseInByteSlice(respBody)
+// buildRequestMetadata populates the metadata of a request relayed by a REST bridge with the details of the HTTP
+// request. values the request builder set are kept. the bridge identifies itself as the sender of the request
+// with a random service.ResponseStreamOwnerKey value, only it can cancel the response stream of the request.
+func buildRequestMetadata(r *http.Request, metadata *model.RequestMetadata) *model.RequestMetadata {
+	if metadata == nil {
+		metadata = &model.RequestMetadata{}
+	}
+	metadata.Transport = model.TransportHTTP
+	metadata.Set(service.ResponseStreamOwnerKey, uuid.NewString())
+	if metadata.Principal == nil {
+		metadata.Principal = middleware.PrincipalFromContext(r.Context())
+	}
+	if metadata.RemoteAddr == "" {
+		metadata.RemoteAddr = r.RemoteAddr
+	}
+	if metadata.TraceParent == "" {
+		metadata.TraceParent = r.Header.Get(tracing.TraceParentHeader)
+		metadata.TraceState = r.Header.Get(tracing.TraceStateHeader)
+	}
+	return metadata
+}
 
-					// write the non-error payload back.
-					if _, err = w.Write(respBodyBytes); err != nil {
-						utils.Log.WithError(err).Errorf("Error received from channel %s:", svcChannel)
-						http.Error(w, err.Error(), 500)
-					}
-				}
-			}
+// etagMatches reports whether the If-None-Match header value matches the entity tag. weak comparison
+// is used as defined for If-None-Match by RFC 7232.
+func etagMatches(ifNoneMatch string, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
 		}
 	}
+	return false
 }
@@ -4,20 +4,52 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/vmware/transport-go/bus"
 	"github.com/vmware/transport-go/model"
+	"github.com/vmware/transport-go/plank/pkg/metrics"
+	"github.com/vmware/transport-go/plank/pkg/middleware"
 	"github.com/vmware/transport-go/service"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"testing"
 	"time"
 )
 
+// newTestMessageBridge creates a message bridge for test-chan and answers the requests sent on the channel
+// with the messages returned by respond. responses are sent on the bus, error messages are passed to the
+// bridge directly as the bus doesn't relay their destination.
+func newTestMessageBridge(t *testing.T, b bus.EventBus, respond func(request model.Request) *model.Message) *MessageBridge {
+	mb, err := newMessageBridge(b, "test-chan")
+	assert.Nil(t, err)
+	if respond == nil {
+		return mb
+	}
+	mh, _ := b.ListenRequestStream("test-chan")
+	mh.Handle(func(message *model.Message) {
+		msg := respond(message.Payload.(model.Request))
+		if msg.Error != nil {
+			msg.DestinationId = message.DestinationId
+			mb.dispatch(msg)
+			return
+		}
+		response := msg.Payload.(*model.Response)
+		response.Id = message.DestinationId
+		b.SendResponseMessage("test-chan", response, message.DestinationId)
+	}, func(e error) {})
+	return mb
+}
+
 func TestBuildEndpointHandler_Timeout(t *testing.T) {
 	b := bus.ResetBus()
 	service.ResetServiceRegistry()
-	msgChan := make(chan *model.Message, 1)
 	_ = b.GetChannelManager().CreateChannel("test-chan")
 	port := GetTestPort()
 	config := GetBasicTestServerConfig(os.TempDir(), "stdout", "stdout", "stderr", port, true)
@@ -28,50 +60,52 @@ func TestBuildEndpointHandler_Timeout(t *testing.T) {
 			Payload: nil,
 			Request: "test-request",
 		}
-	}, 5*time.Millisecond, msgChan), "GET", "http://localhost", nil, "request timed out")
+	}, 5*time.Millisecond, newTestMessageBridge(t, b, nil)), "GET", "http://localhost", nil, "request timed out")
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.RestBridgeTimeoutCounter.WithLabelValues("test-chan", "GET")))
 }
 
 func TestBuildEndpointHandler_ChanResponseErr(t *testing.T) {
 	b := bus.ResetBus()
 	service.ResetServiceRegistry()
-	msgChan := make(chan *model.Message, 1)
 	_ = b.GetChannelManager().CreateChannel("test-chan")
 	port := GetTestPort()
 	config := GetBasicTestServerConfig(os.TempDir(), "stdout", "stdout", "stderr", port, true)
 	ps := NewPlatformServer(config).(*platformServer)
 	ps.eventbus = b
+	mb := newTestMessageBridge(t, b, func(request model.Request) *model.Message {
+		return &model.Message{Error: fmt.Errorf("test error")}
+	})
 	assert.HTTPErrorf(t, ps.buildEndpointHandler("test-chan", func(w http.ResponseWriter, r *http.Request) model.Request {
 		uId := &uuid.UUID{}
-		msgChan <- &model.Message{Error: fmt.Errorf("test error")}
 		return model.Request{
 			Id:      uId,
 			Payload: nil,
 			Request: "test-request",
 		}
-	}, 5*time.Second, msgChan), "GET", "http://localhost", nil, "test error")
+	}, 5*time.Second, mb), "GET", "http://localhost", nil, "test error")
 }
 
 func TestBuildEndpointHandler_SuccessResponse(t *testing.T) {
 	b := bus.ResetBus()
 	service.ResetServiceRegistry()
-	msgChan := make(chan *model.Message, 1)
 	_ = b.GetChannelManager().CreateChannel("test-chan")
 	port := GetTestPort()
 	config := GetBasicTestServerConfig(os.TempDir(), "stdout", "stdout", "stderr", port, true)
 	ps := NewPlatformServer(config).(*platformServer)
 	ps.eventbus = b
-	assert.HTTPBodyContains(t, ps.buildEndpointHandler("test-chan", func(w http.ResponseWriter, r *http.Request) model.Request {
-		uId := &uuid.UUID{}
-		msgChan <- &model.Message{Payload: &model.Response{
-			Id:      uId,
+	mb := newTestMessageBridge(t, b, func(request model.Request) *model.Message {
+		return &model.Message{Payload: &model.Response{
 			Payload: []byte("{\"error\": false}"),
 		}}
+	})
+	assert.HTTPBodyContains(t, ps.buildEndpointHandler("test-chan", func(w http.ResponseWriter, r *http.Request) model.Request {
+		uId := &uuid.UUID{}
 		return model.Request{
 			Id:      uId,
 			Payload: nil,
 			Request: "test-request",
 		}
-	}, 5*time.Second, msgChan), "GET", "http://localhost", nil, "{\"error\": false}")
+	}, 5*time.Second, mb), "GET", "http://localhost", nil, "{\"error\": false}")
 }
 
 func TestBuildEndpointHandler_ErrorResponse(t *testing.T) {
@@ -83,7 +117,6 @@ func TestBuildEndpointHandler_ErrorResponse(t *testing.T) {
 	ps := NewPlatformServer(config).(*platformServer)
 	ps.eventbus = b
 
-	msgChan := make(chan *model.Message, 1)
 	uId := &uuid.UUID{}
 	rsp := &model.Response{
 		Id:        uId,
@@ -93,21 +126,22 @@ func TestBuildEndpointHandler_ErrorResponse(t *testing.T) {
 	}
 	expected, _ := json.Marshal(rsp.Payload)
 
+	mb := newTestMessageBridge(t, b, func(request model.Request) *model.Message {
+		return &model.Message{Payload: rsp}
+	})
 	assert.HTTPBodyContains(t, ps.buildEndpointHandler("test-chan", func(w http.ResponseWriter, r *http.Request) model.Request {
-		msgChan <- &model.Message{Payload: rsp}
 		return model.Request{
 			Id:      uId,
 			Payload: nil,
 			Request: "test-request",
 		}
 
-	}, 5*time.Second, msgChan), "GET", "http://localhost", nil, string(expected))
+	}, 5*time.Second, mb), "GET", "http://localhost", nil, string(expected))
 }
 
 func TestBuildEndpointHandler_ErrorResponseAlternative(t *testing.T) {
 	b := bus.ResetBus()
 	service.ResetServiceRegistry()
-	msgChan := make(chan *model.Message, 1)
 	_ = b.GetChannelManager().CreateChannel("test-chan")
 	port := GetTestPort()
 	config := GetBasicTestServerConfig(os.TempDir(), "stdout", "stdout", "stderr", port, true)
@@ -121,21 +155,22 @@ func TestBuildEndpointHandler_ErrorResponseAlternative(t *testing.T) {
 		Error:     true,
 	}
 
+	mb := newTestMessageBridge(t, b, func(request model.Request) *model.Message {
+		return &model.Message{Payload: rsp}
+	})
 	assert.HTTPBodyContains(t, ps.buildEndpointHandler("test-chan", func(w http.ResponseWriter, r *http.Request) model.Request {
-		msgChan <- &model.Message{Payload: rsp}
 		return model.Request{
 			Id:      uId,
 			Payload: nil,
 			Request: "test-request",
 		}
 
-	}, 5*time.Second, msgChan), "GET", "http://localhost", nil, "418")
+	}, 5*time.Second, mb), "GET", "http://localhost", nil, "418")
 }
 
 func TestBuildEndpointHandler_CatchPanic(t *testing.T) {
 	b := bus.ResetBus()
 	service.ResetServiceRegistry()
-	msgChan := make(chan *model.Message, 1)
 	_ = b.GetChannelManager().CreateChannel("test-chan")
 	port := GetTestPort()
 	config := GetBasicTestServerConfig(os.TempDir(), "stdout", "stdout", "stderr", port, true)
@@ -147,5 +182,148 @@ func TestBuildEndpointHandler_CatchPanic(t *testing.T) {
 			Payload: nil,
 			Request: "test-request",
 		}
-	}, 5*time.Second, msgChan), "GET", "http://localhost", nil, "Internal Server Error")
+	}, 5*time.Second, newTestMessageBridge(t, b, nil)), "GET", "http://localhost", nil, "Internal Server Error")
+}
+
+func TestBuildEndpointHandler_NotModified(t *testing.T) {
+	b := bus.ResetBus()
+	service.ResetServiceRegistry()
+	_ = b.GetChannelManager().CreateChannel("test-chan")
+	port := GetTestPort()
+	config := GetBasicTestServerConfig(os.TempDir(), "stdout", "stdout", "stderr", port, true)
+	ps := NewPlatformServer(config).(*platformServer)
+	ps.eventbus = b
+	mb := newTestMessageBridge(t, b, func(request model.Request) *model.Message {
+		return &model.Message{Payload: &model.Response{
+			Payload: []byte("{\"joke\": \"knock knock\"}"),
+			Headers: map[string]string{"ETag": "\"abc\"", "Cache-Control": "max-age=60"},
+		}}
+	})
+	handler := ps.buildEndpointHandler("test-chan", func(w http.ResponseWriter, r *http.Request) model.Request {
+		return model.Request{
+			Id:      &uuid.UUID{},
+			Request: "test-request",
+		}
+	}, 5*time.Second, mb)
+
+	// the current entity tag is answered with 304 Not Modified and no body
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+	req.Header.Set("If-None-Match", "\"xyz\", W/\"abc\"")
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNotModified, rr.Code)
+	assert.Equal(t, "\"abc\"", rr.Header().Get("ETag"))
+	assert.Equal(t, "max-age=60", rr.Header().Get("Cache-Control"))
+	assert.Empty(t, rr.Body.String())
+
+	// a stale entity tag gets the full response
+	rr = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+	req.Header.Set("If-None-Match", "\"xyz\"")
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "\"abc\"", rr.Header().Get("ETag"))
+	assert.Equal(t, "{\"joke\": \"knock knock\"}", rr.Body.String())
+
+	// only GET and HEAD requests are answered conditionally
+	rr = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "http://localhost", nil)
+	req.Header.Set("If-None-Match", "\"abc\"")
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "{\"joke\": \"knock knock\"}", rr.Body.String())
+}
+
+func TestBuildEndpointHandler_RequestMetadata(t *testing.T) {
+	b := bus.ResetBus()
+	ps := newMessageBridgeTestServer(b)
+	requests := make(chan model.Request, 1)
+	mb := newTestMessageBridge(t, b, func(request model.Request) *model.Message {
+		requests <- request
+		return &model.Message{Payload: &model.Response{Payload: "ok"}}
+	})
+	handler := ps.buildEndpointHandler("test-chan", func(w http.ResponseWriter, r *http.Request) model.Request {
+		metadata := &model.RequestMetadata{}
+		metadata.Set("tenant", r.URL.Query().Get("tenant"))
+		return model.Request{Id: &uuid.UUID{}, Request: "test-request", Metadata: metadata}
+	}, 5*time.Second, mb)
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/test?tenant=acme", nil)
+	req.RemoteAddr = "10.0.0.1:51234"
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	request := <-requests
+	assert.Equal(t, model.TransportHTTP, request.Metadata.Transport)
+	assert.Equal(t, "10.0.0.1:51234", request.Metadata.RemoteAddr)
+	assert.Equal(t, "acme", request.Metadata.Get("tenant"))
+	assert.Nil(t, request.Metadata.GetPrincipal())
+}
+
+func TestBuildEndpointHandler_CredentialHeadersAreNotRelayed(t *testing.T) {
+	b := bus.ResetBus()
+	ps := newMessageBridgeTestServer(b)
+	ps.serverConfig.AuthConfig = &middleware.AuthConfig{APIKey: &middleware.APIKeyConfig{Header: "x-service-key"}}
+	requests := make(chan model.Request, 1)
+	mb := newTestMessageBridge(t, b, func(request model.Request) *model.Message {
+		requests <- request
+		return &model.Message{Payload: &model.Response{Payload: "ok"}}
+	})
+	handler := ps.buildEndpointHandler("test-chan", func(w http.ResponseWriter, r *http.Request) model.Request {
+		return model.Request{Id: &uuid.UUID{}, Request: "test-request"}
+	}, 5*time.Second, mb)
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/test", nil)
+	req.Header.Set("Authorization", "Bearer token")
+	req.Header.Set("Proxy-Authorization", "Basic secret")
+	req.Header.Set("Cookie", "session=secret")
+	req.Header.Set("X-API-Key", "secret")
+	req.Header.Set("X-Service-Key", "secret")
+	req.Header.Set("X-Canary", "true")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	request := <-requests
+	assert.Equal(t, map[string]string{"X-Canary": "true"}, request.Headers)
+}
+
+func TestBuildEndpointHandler_Tracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(trace.NewNoopTracerProvider())
+
+	b := bus.ResetBus()
+	ps := newMessageBridgeTestServer(b)
+	requests := make(chan *model.Message, 1)
+	mh, _ := b.ListenRequestStream("test-chan")
+	mh.Handle(func(message *model.Message) {
+		requests <- message
+	}, func(e error) {})
+	mb := newTestMessageBridge(t, b, nil)
+	handler := ps.buildEndpointHandler("test-chan", func(w http.ResponseWriter, r *http.Request) model.Request {
+		return model.Request{Id: &uuid.UUID{}, Request: "test-request"}
+	}, 50*time.Millisecond, mb)
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/test", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+
+	// the server span continues the trace of the caller and is the parent of the dispatch span
+	message := <-requests
+	spans := recorder.Ended()
+	assert.Len(t, spans, 2)
+	dispatchSpan, serverSpan := spans[0], spans[1]
+	assert.Equal(t, "GET test-chan", serverSpan.Name())
+	assert.Equal(t, trace.SpanKindServer, serverSpan.SpanKind())
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", serverSpan.SpanContext().TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", serverSpan.Parent().SpanID().String())
+	assert.Equal(t, codes.Error, serverSpan.Status().Code)
+	assert.Equal(t, "test-chan send", dispatchSpan.Name())
+	assert.Equal(t, serverSpan.SpanContext().SpanID(), dispatchSpan.Parent().SpanID())
+
+	request := message.Payload.(model.Request)
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-"+serverSpan.SpanContext().SpanID().String()+"-01",
+		request.Metadata.TraceParent)
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-"+dispatchSpan.SpanContext().SpanID().String()+"-01",
+		message.Headers[0].Value)
 }
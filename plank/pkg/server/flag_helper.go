@@ -100,6 +100,10 @@ func (f *serverConfigFactory) Prometheus() bool {
 	return viper.GetBool(utils.PlatformServerFlagConstants["Prometheus"]["FlagName"])
 }
 
+func (f *serverConfigFactory) OpenAPI() bool {
+	return viper.GetBool(utils.PlatformServerFlagConstants["OpenAPI"]["FlagName"])
+}
+
 func (f *serverConfigFactory) RestBridgeTimeout() int64 {
 	return viper.GetInt64(utils.PlatformServerFlagConstants["RestBridgeTimeout"]["FlagName"])
 }
@@ -221,6 +225,10 @@ func (f *serverConfigFactory) configureFlagsInFlagSet(fs *flag.FlagSet) {
 		utils.PlatformServerFlagConstants["Prometheus"]["FlagName"],
 		false,
 		utils.PlatformServerFlagConstants["Prometheus"]["Description"])
+	fs.Bool(
+		utils.PlatformServerFlagConstants["OpenAPI"]["FlagName"],
+		false,
+		utils.PlatformServerFlagConstants["OpenAPI"]["Description"])
 	fs.Int64(
 		utils.PlatformServerFlagConstants["RestBridgeTimeout"]["FlagName"],
 		1,
@@ -42,6 +42,7 @@ func TestFlagHelper_ParseDefaultFlags(t *testing.T) {
 	assert.False(t, f.Debug())
 	assert.False(t, f.NoBanner())
 	assert.False(t, f.Prometheus())
+	assert.False(t, f.OpenAPI())
 	assert.EqualValues(t, 1, f.RestBridgeTimeout())
 }
 
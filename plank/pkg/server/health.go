@@ -0,0 +1,97 @@
+// Copyright 2019-2021 VMware, Inc.
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/vmware/transport-go/service"
+	"net/http"
+	"time"
+)
+
+const (
+	// maximum time the /health/ready endpoint waits for the health checks of the services
+	healthCheckTimeout = 5 * time.Second
+
+	fabricEndpointComponent   = "fabric-endpoint"
+	brokerConnectionComponent = "broker-connections"
+)
+
+// PlatformHealthReport is the body of the /health/live and /health/ready endpoints. The report is DOWN
+// if any of the services or components (broker connections, fabric endpoint) is DOWN.
+type PlatformHealthReport struct {
+	Status     service.HealthStatus                  `json:"status"`
+	Services   map[string]*service.HealthCheckResult `json:"services,omitempty"`
+	Components map[string]*service.HealthCheckResult `json:"components,omitempty"`
+}
+
+// liveHealthHandler reports the liveness of the process. as long as the HTTP server responds Plank is alive.
+func (ps *platformServer) liveHealthHandler(w http.ResponseWriter, r *http.Request) {
+	writeHealthReport(w, &PlatformHealthReport{Status: service.HealthStatusUp})
+}
+
+// readyHealthHandler reports the readiness of Plank with the details of all services and components.
+// responds with 503 Service Unavailable if anything is DOWN so orchestration layers like k8s stop
+// routing traffic to the instance.
+func (ps *platformServer) readyHealthHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancelFn := context.WithTimeout(r.Context(), healthCheckTimeout)
+	defer cancelFn()
+	writeHealthReport(w, ps.checkHealth(ctx))
+}
+
+// checkHealth aggregates the health of the registered services, the broker connections and the fabric endpoint.
+func (ps *platformServer) checkHealth(ctx context.Context) *PlatformHealthReport {
+	servicesHealth := service.GetServiceRegistry().CheckHealth(ctx)
+	report := &PlatformHealthReport{
+		Status:     servicesHealth.Status,
+		Services:   servicesHealth.Services,
+		Components: make(map[string]*service.HealthCheckResult),
+	}
+
+	if ps.serverConfig.FabricConfig != nil {
+		if ps.eventbus.IsFabricEndpointRunning() {
+			report.Components[fabricEndpointComponent] = &service.HealthCheckResult{Status: service.HealthStatusUp}
+		} else {
+			report.Components[fabricEndpointComponent] = &service.HealthCheckResult{
+				Status:  service.HealthStatusDown,
+				Message: "fabric endpoint is not running",
+			}
+		}
+	}
+
+	// the broker connections are reported together, so that the report doesn't disclose their ids
+	if connections := ps.eventbus.GetBrokerConnections(); len(connections) > 0 {
+		lost := 0
+		for _, conn := range connections {
+			if !conn.IsConnected() {
+				lost++
+			}
+		}
+		result := &service.HealthCheckResult{Status: service.HealthStatusUp}
+		if lost > 0 {
+			result = &service.HealthCheckResult{
+				Status:  service.HealthStatusDown,
+				Message: fmt.Sprintf("%d of %d broker connections are lost", lost, len(connections)),
+			}
+		}
+		report.Components[brokerConnectionComponent] = result
+	}
+
+	for _, result := range report.Components {
+		if result.Status != service.HealthStatusUp {
+			report.Status = service.HealthStatusDown
+		}
+	}
+	return report
+}
+
+func writeHealthReport(w http.ResponseWriter, report *PlatformHealthReport) {
+	w.Header().Set("Content-Type", "application/json")
+	if report.Status != service.HealthStatusUp {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(w).Encode(report)
+}
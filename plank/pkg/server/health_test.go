@@ -0,0 +1,135 @@
+// Copyright 2019-2021 VMware, Inc.
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/vmware/transport-go/bridge"
+	"github.com/vmware/transport-go/bus"
+	"github.com/vmware/transport-go/model"
+	"github.com/vmware/transport-go/service"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+type healthCheckTestService struct {
+	result *service.HealthCheckResult
+}
+
+func (s *healthCheckTestService) HandleServiceRequest(request *model.Request, core service.FabricServiceCore) {
+}
+
+func (s *healthCheckTestService) CheckHealth(ctx context.Context) *service.HealthCheckResult {
+	return s.result
+}
+
+type healthTestBrokerConnection struct {
+	bridge.Connection
+	id        uuid.UUID
+	connected bool
+}
+
+func (c *healthTestBrokerConnection) GetId() *uuid.UUID {
+	return &c.id
+}
+
+func (c *healthTestBrokerConnection) IsConnected() bool {
+	return c.connected
+}
+
+type healthTestEventBus struct {
+	bus.EventBus
+	connections []bridge.Connection
+}
+
+func (b *healthTestEventBus) GetBrokerConnections() []bridge.Connection {
+	return b.connections
+}
+
+func newHealthTestServer() *platformServer {
+	newBus := bus.ResetBus()
+	service.ResetServiceRegistry()
+	config := GetBasicTestServerConfig(os.TempDir(), "stdout", "stdout", "stderr", GetTestPort(), true)
+	ps := NewPlatformServer(config).(*platformServer)
+	ps.eventbus = newBus
+	return ps
+}
+
+func getHealthReport(t *testing.T, handler http.HandlerFunc) (int, *PlatformHealthReport) {
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/health/ready", nil))
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	var report PlatformHealthReport
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &report))
+	return rec.Code, &report
+}
+
+func TestPlatformServer_HealthLive(t *testing.T) {
+	ps := newHealthTestServer()
+	code, report := getHealthReport(t, ps.liveHealthHandler)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, service.HealthStatusUp, report.Status)
+}
+
+func TestPlatformServer_HealthReady(t *testing.T) {
+	ps := newHealthTestServer()
+	ps.serverConfig.FabricConfig = nil
+	_ = ps.RegisterService(&healthCheckTestService{
+		result: &service.HealthCheckResult{Status: service.HealthStatusUp},
+	}, "healthy-service")
+
+	code, report := getHealthReport(t, ps.readyHealthHandler)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, service.HealthStatusUp, report.Status)
+	assert.Equal(t, service.HealthStatusUp, report.Services["healthy-service"].Status)
+
+	_ = ps.RegisterService(&healthCheckTestService{
+		result: &service.HealthCheckResult{Status: service.HealthStatusDown, Message: "database is down"},
+	}, "unhealthy-service")
+	code, report = getHealthReport(t, ps.readyHealthHandler)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, service.HealthStatusDown, report.Status)
+	assert.Equal(t, "database is down", report.Services["unhealthy-service"].Message)
+}
+
+func TestPlatformServer_HealthReadyFabricEndpoint(t *testing.T) {
+	ps := newHealthTestServer()
+	ps.serverConfig.FabricConfig = GetTestFabricBrokerConfig()
+
+	// the fabric endpoint is started only when the server starts
+	code, report := getHealthReport(t, ps.readyHealthHandler)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, &service.HealthCheckResult{
+		Status:  service.HealthStatusDown,
+		Message: "fabric endpoint is not running",
+	}, report.Components[fabricEndpointComponent])
+}
+
+func TestPlatformServer_HealthReadyBrokerConnections(t *testing.T) {
+	ps := newHealthTestServer()
+	ps.serverConfig.FabricConfig = nil
+	lost := &healthTestBrokerConnection{id: uuid.New()}
+	testBus := &healthTestEventBus{EventBus: ps.eventbus, connections: []bridge.Connection{
+		&healthTestBrokerConnection{id: uuid.New(), connected: true}, lost,
+	}}
+	ps.eventbus = testBus
+
+	// the report doesn't disclose the ids of the connections
+	code, report := getHealthReport(t, ps.readyHealthHandler)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, map[string]*service.HealthCheckResult{
+		brokerConnectionComponent: {Status: service.HealthStatusDown, Message: "1 of 2 broker connections are lost"},
+	}, report.Components)
+
+	lost.connected = true
+	code, report = getHealthReport(t, ps.readyHealthHandler)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, service.HealthStatusUp, report.Components[brokerConnectionComponent].Status)
+}
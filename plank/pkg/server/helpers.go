@@ -36,6 +36,7 @@ func generatePlatformServerConfig(f *serverConfigFactory) (*PlatformServerConfig
 	requestPrefix := f.RequestPrefix()
 	requestQueuePrefix := f.RequestQueuePrefix()
 	prometheus := f.Prometheus()
+	openAPI := f.OpenAPI()
 	restBridgeTimeout := f.RestBridgeTimeout()
 
 	// if config file flag is provided, read directly from the file
@@ -103,7 +104,7 @@ func generatePlatformServerConfig(f *serverConfigFactory) (*PlatformServerConfig
 		RestBridgeTimeout: time.Duration(restBridgeTimeout) * time.Minute,
 	}
 
-	if len(certKey) > 0 && len(certKey) > 0 {
+	if len(cert) > 0 && len(certKey) > 0 {
 		var err error
 		certKey, err = filepath.Abs(certKey)
 		if err != nil {
@@ -117,6 +118,10 @@ func generatePlatformServerConfig(f *serverConfigFactory) (*PlatformServerConfig
 		serverConfig.TLSCertConfig = &TLSCertConfig{CertFile: cert, KeyFile: certKey}
 	}
 
+	if openAPI {
+		serverConfig.OpenAPIConfig = &OpenAPIConfig{}
+	}
+
 	if len(strings.TrimSpace(spaPath)) > 0 {
 		var err error
 		serverConfig.SpaConfig, err = NewSpaConfig(spaPath)
@@ -141,19 +146,6 @@ func generatePlatformServerConfig(f *serverConfigFactory) (*PlatformServerConfig
 	return serverConfig, nil
 }
 
-// ensureResponseInByteSlice takes body as an interface not knowing whether it is already converted to []byte or not.
-// if it is not of []byte type it marshals the payload using json.Marshal. otherwise, the input
-// byte slice is returned as-is.
-func ensureResponseInByteSlice(body interface{}) (bytes []byte, err error) {
-	switch body.(type) {
-	case []byte:
-		bytes, err = body.([]byte), nil
-	default:
-		bytes, err = json.Marshal(body)
-	}
-	return
-}
-
 // sanitizeConfigRootPath takes *PlatformServerConfig, ensures the path specified by RootDir field exists.
 // if RootDir is empty then the current working directory will be populated. if for some reason the path
 // cannot be accessed it'll cause a panic.
@@ -1,7 +1,6 @@
 package server
 
 import (
-	"encoding/json"
 	"github.com/spf13/pflag"
 	"github.com/stretchr/testify/assert"
 	"os"
@@ -64,6 +63,23 @@ func TestGeneratePlatformServerConfig_CertConfig(t *testing.T) {
 	assert.EqualValues(t, dummyKey, config.TLSCertConfig.KeyFile)
 }
 
+func TestGeneratePlatformServerConfig_CertKeyWithoutCert(t *testing.T) {
+	// arrange
+	f := &serverConfigFactory{}
+	pflag.CommandLine = pflag.NewFlagSet("", pflag.ExitOnError)
+	dummyKey := filepath.Join(os.TempDir(), "plank-tests", "key.pem")
+
+	// act
+	testArgs := []string{"", "--cert-key", dummyKey}
+	f.configureFlags(pflag.CommandLine)
+	f.parseFlags(testArgs)
+	config, err := generatePlatformServerConfig(f)
+
+	// assert
+	assert.Nil(t, err)
+	assert.Nil(t, config.TLSCertConfig)
+}
+
 func TestGeneratePlatformServerConfig_SpaConfig(t *testing.T) {
 	// arrange
 	f := &serverConfigFactory{}
@@ -125,20 +141,3 @@ func TestGeneratePlatformServerConfig_ConfigFile(t *testing.T) {
 	assert.EqualValues(t, "/", config.SpaConfig.BaseUri)
 	assert.EqualValues(t, "public/assets:/assets", config.SpaConfig.StaticAssets[0])
 }
-
-func TestMarshalResponseBody_byteSlice(t *testing.T) {
-	payload := []byte("hello")
-	results, err := ensureResponseInByteSlice(payload)
-
-	assert.Nil(t, err)
-	assert.EqualValues(t, payload, results)
-}
-
-func TestMarshalResponseBody_nonByteSlice(t *testing.T) {
-	payload := PlatformServerConfig{}
-	jsonMarshalled, _ := json.Marshal(payload)
-	results, err := ensureResponseInByteSlice(payload)
-
-	assert.Nil(t, err)
-	assert.EqualValues(t, jsonMarshalled, results)
-}
\ No newline at end of file
@@ -1,6 +1,9 @@
 package server
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"github.com/gorilla/mux"
 	"github.com/prometheus/client_golang/prometheus"
@@ -8,16 +11,20 @@ import (
 	"github.com/sirupsen/logrus"
 	"github.com/vmware/transport-go/bus"
 	"github.com/vmware/transport-go/model"
+	"github.com/vmware/transport-go/plank/pkg/metrics"
 	"github.com/vmware/transport-go/plank/pkg/middleware"
 	"github.com/vmware/transport-go/plank/utils"
 	"github.com/vmware/transport-go/service"
 	"github.com/vmware/transport-go/stompserver"
+	"io/ioutil"
 	"log"
 	"net/http"
 	_ "net/http/pprof"
+	"net/url"
 	"path/filepath"
 	"reflect"
 	"runtime"
+	"strings"
 	"time"
 )
 
@@ -36,6 +43,7 @@ func (ps *platformServer) initialize() {
 	// initialize HTTP endpoint handlers map
 	ps.endpointHandlerMap = map[string]http.HandlerFunc{}
 	ps.serviceChanToBridgeEndpoints = make(map[string][]string, 0)
+	ps.bridgeConfigMap = make(map[string]*service.RESTBridgeConfig)
 
 	// initialize log output streams
 	if err = ps.serverConfig.LogConfig.PrepareLogFiles(); err != nil {
@@ -64,6 +72,29 @@ func (ps *platformServer) initialize() {
 		utils.Log.Debugln("Debug logging and profiling enabled. Available types of profiles at http://localhost:6060/debug/pprof")
 	}
 
+	// build the authentication middlewares protecting the REST bridges and the fabric endpoint, if configured
+	if authConfig := ps.serverConfig.AuthConfig; authConfig != nil {
+		var authenticators []middleware.Authenticator
+		if authenticators, err = middleware.NewAuthenticators(authConfig); err != nil {
+			panic(err)
+		}
+		ps.authMiddleware = middleware.AuthenticationMiddleware(authenticators...)
+		ps.fabricAuthMiddleware = ps.authMiddleware
+		if authConfig.AllowAnonymousFabric {
+			ps.fabricAuthMiddleware = middleware.OptionalAuthenticationMiddleware(authenticators...)
+		} else if ps.serverConfig.FabricConfig != nil && ps.serverConfig.FabricConfig.UseTCP {
+			panic(errors.New("the fabric TCP listener cannot authenticate clients, " +
+				"set allow_anonymous_fabric in auth_config to accept them"))
+		}
+	}
+
+	// record the spans of the requests handled by the server, if enabled
+	if ps.serverConfig.TracingConfig != nil {
+		if err = ps.configureTracing(); err != nil {
+			panic(err)
+		}
+	}
+
 	// set a new route handler
 	ps.router = mux.NewRouter().Schemes("http", "https").Subrouter()
 
@@ -74,6 +105,15 @@ func (ps *platformServer) initialize() {
 	ps.router.Path("/health").Name("/health").Handler(
 		middleware.CacheControlMiddleware([]string{"/health"}, middleware.NewCacheControlDirective().NoStore())(ps.endpointHandlerMap["/health"]))
 
+	// register /health/live and /health/ready reporting the detailed status of the services and components
+	// for liveness and readiness probes.
+	ps.endpointHandlerMap["/health/live"] = ps.liveHealthHandler
+	ps.endpointHandlerMap["/health/ready"] = ps.readyHealthHandler
+	for _, healthPath := range []string{"/health/live", "/health/ready"} {
+		ps.router.Path(healthPath).Name(healthPath).Methods(http.MethodGet).Handler(
+			middleware.CacheControlMiddleware([]string{healthPath}, middleware.NewCacheControlDirective().NoStore())(ps.endpointHandlerMap[healthPath]))
+	}
+
 	// register a reserved path /prometheus for runtime metrics, if enabled
 	if ps.serverConfig.EnablePrometheus {
 		ps.endpointHandlerMap["/prometheus"] = middleware.BasicSecurityHeaderMiddleware()(promhttp.HandlerFor(
@@ -83,6 +123,16 @@ func (ps *platformServer) initialize() {
 			})).(http.HandlerFunc)
 		ps.router.Path("/prometheus").Name("/prometheus").Methods(http.MethodGet).Handler(
 			ps.endpointHandlerMap["/prometheus"])
+
+		// expose the metrics of the bus, stores, fabric endpoint, services and REST bridges
+		if err = metrics.RegisterTransportMetrics(ps.eventbus, prometheus.DefaultRegisterer); err != nil {
+			utils.Log.WithError(err).Warnln("failed to register transport metrics")
+		}
+	}
+
+	// register a reserved path /openapi.json describing the REST bridges and the API explorer page, if enabled
+	if ps.serverConfig.OpenAPIConfig != nil {
+		ps.configureOpenAPI()
 	}
 
 	// register static paths
@@ -100,6 +150,19 @@ func (ps *platformServer) initialize() {
 		ErrorLog:     log.New(ps.serverConfig.LogConfig.GetErrorLogFilePointer(), "ERROR ", log.LstdFlags),
 	}
 
+	// request client certificates verified with the client CAs so that callers can authenticate with them
+	if ps.serverConfig.TLSCertConfig != nil && ps.serverConfig.TLSCertConfig.ClientCAFile != "" {
+		clientCAs, err := ioutil.ReadFile(ps.serverConfig.TLSCertConfig.ClientCAFile)
+		if err != nil {
+			panic(err)
+		}
+		clientCAPool := x509.NewCertPool()
+		if !clientCAPool.AppendCertsFromPEM(clientCAs) {
+			panic(fmt.Errorf("no certificates found in %s", ps.serverConfig.TLSCertConfig.ClientCAFile))
+		}
+		ps.HttpServer.TLSConfig = &tls.Config{ClientCAs: clientCAPool, ClientAuth: tls.VerifyClientCertIfGiven}
+	}
+
 	// set up a listener to receive REST bridge configs for services and set them up according to their specs
 	lcmChanHandler, err := ps.eventbus.ListenStreamForDestination(service.LifecycleManagerChannelName, ps.eventbus.GetId())
 	if err != nil {
@@ -166,11 +229,23 @@ func (ps *platformServer) configureFabric() {
 	if ps.serverConfig.FabricConfig.UseTCP {
 		ps.fabricConn, err = stompserver.NewTcpConnectionListener(fmt.Sprintf(":%d", ps.serverConfig.FabricConfig.TCPPort))
 	} else {
+		// the WebSocket endpoint is served by its own router behind the authentication of the upgrade request, and
+		// a guard checking the origin of the request against the CORS policy, as browsers do not enforce CORS on
+		// WebSocket connections
+		fabricRouter := mux.NewRouter()
+		var fabricHandler http.Handler = fabricRouter
+		if ps.fabricAuthMiddleware != nil {
+			fabricHandler = ps.fabricAuthMiddleware(fabricHandler)
+		}
+		if ps.serverConfig.CorsPolicy != nil {
+			fabricHandler = fabricOriginGuard(ps.serverConfig.CorsPolicy, fabricHandler)
+		}
+		ps.router.Path(ps.serverConfig.FabricConfig.FabricEndpoint).Handler(fabricHandler)
 		ps.fabricConn, err = stompserver.NewWebSocketConnectionFromExistingHttpServer(
 			ps.HttpServer,
-			ps.router,
+			fabricRouter,
 			ps.serverConfig.FabricConfig.FabricEndpoint,
-			nil) // TODO: consider tightening access by allowing configuring allowedOrigins
+			nil)
 	}
 
 	// if creation of listener fails, crash and burn
@@ -211,3 +286,19 @@ func (ps *platformServer) configureSPA() {
 		Name(endpointHandlerMapKey).
 		Handler(spaConfigCacheControlMiddleware(ps.endpointHandlerMap[endpointHandlerMapKey]))
 }
+
+// fabricOriginGuard rejects WebSocket connections from origins other than the server's own that the CORS policy
+// does not allow
+func fabricOriginGuard(policy *middleware.CorsPolicy, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if origin := r.Header.Get("Origin"); origin != "" && !policy.IsOriginAllowed(origin) {
+			u, err := url.Parse(origin)
+			if err != nil || !strings.EqualFold(u.Host, r.Host) {
+				utils.Log.Warnf("[plank] Rejected fabric connection from origin '%s'", origin)
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,109 @@
+// Copyright 2019-2021 VMware, Inc.
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"github.com/google/uuid"
+	"github.com/vmware/transport-go/bus"
+	"github.com/vmware/transport-go/model"
+	"github.com/vmware/transport-go/plank/utils"
+	"time"
+)
+
+// how long the ids of timed out requests are remembered to tell late responses from unrelated ones
+const lateResponseRetention = time.Minute
+
+// pendingRequest is a request waiting for its responses
+type pendingRequest struct {
+	messages chan *model.Message // passes the responses to the request
+	released chan struct{}       // closed once the request stops waiting for responses
+}
+
+// newMessageBridge creates a bridge relaying the responses sent on the service channel
+// to the HTTP requests waiting for them.
+func newMessageBridge(eventBus bus.EventBus, serviceChannel string) (*MessageBridge, error) {
+	handler, err := eventBus.ListenStream(serviceChannel)
+	if err != nil {
+		return nil, err
+	}
+	mb := &MessageBridge{
+		ServiceListenStream: handler,
+		serviceChannel:      serviceChannel,
+		pending:             make(map[uuid.UUID]*pendingRequest),
+		timedOut:            make(map[uuid.UUID]time.Time),
+	}
+	handler.Handle(mb.dispatch, func(err error) {
+		utils.Log.WithError(err).Debugf("[plank] Error received from service channel '%s'", serviceChannel)
+	})
+	return mb, nil
+}
+
+// register starts waiting for the responses of the request. requests without an id, or with the zero id
+// many request builders use, or with the id of another request in flight get a new unique id, as the
+// responses are correlated with the requests by their ids. all the responses to the request are relayed
+// until the request is released, which allows streamed responses to be relayed.
+func (mb *MessageBridge) register(request *model.Request) <-chan *model.Message {
+	mb.lock.Lock()
+	defer mb.lock.Unlock()
+
+	for request.Id == nil || *request.Id == uuid.Nil || mb.pending[*request.Id] != nil {
+		id := uuid.New()
+		request.Id = &id
+	}
+	pending := &pendingRequest{
+		messages: make(chan *model.Message, 1),
+		released: make(chan struct{}),
+	}
+	mb.pending[*request.Id] = pending
+	return pending.messages
+}
+
+// release stops waiting for the responses of the request with the given id.
+func (mb *MessageBridge) release(id *uuid.UUID, timedOut bool) {
+	mb.lock.Lock()
+	defer mb.lock.Unlock()
+
+	if pending, waiting := mb.pending[*id]; waiting {
+		close(pending.released)
+		delete(mb.pending, *id)
+	}
+	now := time.Now()
+	for timedOutId, at := range mb.timedOut {
+		if now.Sub(at) > lateResponseRetention {
+			delete(mb.timedOut, timedOutId)
+		}
+	}
+	if timedOut {
+		mb.timedOut[*id] = now
+	}
+}
+
+// dispatch passes the message to the request waiting for it. responses nobody is waiting for, like
+// broadcasts, responses to requests sent by other clients, late responses or responses arriving after
+// the request got the single response it was waiting for, are discarded.
+func (mb *MessageBridge) dispatch(message *model.Message) {
+	id := message.DestinationId
+	if response, ok := message.Payload.(*model.Response); ok && id == nil {
+		id = response.Id
+	}
+	if id == nil {
+		return
+	}
+
+	mb.lock.Lock()
+	pending, waiting := mb.pending[*id]
+	_, timedOut := mb.timedOut[*id]
+	mb.lock.Unlock()
+
+	if waiting {
+		select {
+		case pending.messages <- message:
+		case <-pending.released:
+		}
+	} else if timedOut {
+		utils.Log.Warnf(
+			"[plank] Discarding response for request %s received from service channel '%s' after the request timed out",
+			id.String(), mb.serviceChannel)
+	}
+}
@@ -0,0 +1,169 @@
+// Copyright 2019-2021 VMware, Inc.
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/vmware/transport-go/bus"
+	"github.com/vmware/transport-go/model"
+	"github.com/vmware/transport-go/service"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newMessageBridgeTestServer(b bus.EventBus) *platformServer {
+	service.ResetServiceRegistry()
+	_ = b.GetChannelManager().CreateChannel("test-chan")
+	config := GetBasicTestServerConfig(os.TempDir(), "stdout", "stdout", "stderr", GetTestPort(), true)
+	ps := NewPlatformServer(config).(*platformServer)
+	ps.eventbus = b
+	return ps
+}
+
+func TestMessageBridge_Register(t *testing.T) {
+	b := bus.NewEventBusInstance()
+	_, err := newMessageBridge(b, "test-chan")
+	assert.NotNil(t, err)
+
+	_ = b.GetChannelManager().CreateChannel("test-chan")
+	mb, err := newMessageBridge(b, "test-chan")
+	assert.Nil(t, err)
+
+	// requests without an id or with the zero id get a unique id
+	noId, zeroId := &model.Request{}, &model.Request{Id: &uuid.UUID{}}
+	mb.register(noId)
+	mb.register(zeroId)
+	assert.NotEqual(t, uuid.Nil, *noId.Id)
+	assert.NotEqual(t, uuid.Nil, *zeroId.Id)
+	assert.NotEqual(t, *noId.Id, *zeroId.Id)
+
+	// ids of requests in flight are not reused
+	id := uuid.New()
+	first, second := &model.Request{Id: &id}, &model.Request{Id: &id}
+	mb.register(first)
+	mb.register(second)
+	assert.Equal(t, id, *first.Id)
+	assert.NotEqual(t, id, *second.Id)
+
+	for _, request := range []*model.Request{noId, zeroId, first, second} {
+		mb.release(request.Id, false)
+	}
+	assert.Len(t, mb.pending, 0)
+	assert.Len(t, mb.timedOut, 0)
+}
+
+func TestMessageBridge_DiscardsOrphans(t *testing.T) {
+	b := bus.NewEventBusInstance()
+	_ = b.GetChannelManager().CreateChannel("test-chan")
+	mb, _ := newMessageBridge(b, "test-chan")
+
+	request := &model.Request{}
+	responseChan := mb.register(request)
+
+	// broadcasts and responses to other requests are not relayed
+	otherId := uuid.New()
+	b.SendResponseMessage("test-chan", &model.Response{Payload: "broadcast"}, nil)
+	b.SendResponseMessage("test-chan", &model.Response{Id: &otherId, Payload: "other"}, &otherId)
+	b.SendResponseMessage("test-chan", &model.Response{Id: request.Id, Payload: "mine"}, request.Id)
+
+	select {
+	case msg := <-responseChan:
+		assert.Equal(t, "mine", msg.Payload.(*model.Response).Payload)
+	case <-time.After(time.Second):
+		assert.Fail(t, "no response received")
+	}
+	select {
+	case msg := <-responseChan:
+		assert.Fail(t, "unexpected response", msg.Payload)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	// late responses of timed out requests are discarded
+	lateRequest := &model.Request{}
+	lateChan := mb.register(lateRequest)
+	mb.release(lateRequest.Id, true)
+	mb.dispatch(&model.Message{Payload: &model.Response{Id: lateRequest.Id}, DestinationId: lateRequest.Id})
+	assert.Len(t, lateChan, 0)
+	assert.Contains(t, mb.timedOut, *lateRequest.Id)
+}
+
+func TestBuildEndpointHandler_TimedOutRequestIsReleased(t *testing.T) {
+	b := bus.ResetBus()
+	ps := newMessageBridgeTestServer(b)
+	mb := newTestMessageBridge(t, b, nil)
+
+	handler := ps.buildEndpointHandler("test-chan", func(w http.ResponseWriter, r *http.Request) model.Request {
+		return model.Request{Id: &uuid.UUID{}, Request: "test-request"}
+	}, 5*time.Millisecond, mb)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+	assert.Equal(t, 500, rr.Code)
+
+	mb.lock.Lock()
+	defer mb.lock.Unlock()
+	assert.Len(t, mb.pending, 0)
+	assert.Len(t, mb.timedOut, 1)
+}
+
+// TestBuildEndpointHandler_ConcurrentRequestsAreIsolated sends many concurrent HTTP requests whose responses
+// arrive out of order, mixed with broadcasts and responses to other clients, and checks that every HTTP
+// request gets the response to its own request.
+func TestBuildEndpointHandler_ConcurrentRequestsAreIsolated(t *testing.T) {
+	b := bus.ResetBus()
+	ps := newMessageBridgeTestServer(b)
+
+	mh, _ := b.ListenRequestStream("test-chan")
+	mh.Handle(func(message *model.Message) {
+		request := message.Payload.(model.Request)
+		go func() {
+			time.Sleep(time.Duration(rand.Intn(5)) * time.Millisecond)
+			// noise from other clients of the channel
+			otherId := uuid.New()
+			b.SendResponseMessage("test-chan", &model.Response{Payload: []byte("broadcast")}, nil)
+			b.SendResponseMessage("test-chan", &model.Response{Id: &otherId, Payload: []byte("other")}, &otherId)
+			b.SendResponseMessage("test-chan",
+				&model.Response{Id: message.DestinationId, Payload: []byte(request.Payload.(string))}, message.DestinationId)
+		}()
+	}, func(e error) {})
+
+	mb, _ := newMessageBridge(b, "test-chan")
+	handler := ps.buildEndpointHandler("test-chan", func(w http.ResponseWriter, r *http.Request) model.Request {
+		// like most request builders, use the zero id for every request
+		return model.Request{Id: &uuid.UUID{}, Request: "echo", Payload: r.URL.Query().Get("message")}
+	}, 5*time.Second, mb)
+
+	const clients, requestsPerClient = 20, 25
+	wg := sync.WaitGroup{}
+	var lock sync.Mutex
+	mismatches := make([]string, 0)
+	for c := 0; c < clients; c++ {
+		wg.Add(1)
+		go func(c int) {
+			defer wg.Done()
+			for i := 0; i < requestsPerClient; i++ {
+				message := fmt.Sprintf("client-%d-request-%d", c, i)
+				rr := httptest.NewRecorder()
+				handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "http://localhost/?message="+message, nil))
+				if rr.Body.String() != message {
+					lock.Lock()
+					mismatches = append(mismatches, fmt.Sprintf("%s got %s", message, rr.Body.String()))
+					lock.Unlock()
+				}
+			}
+		}(c)
+	}
+	wg.Wait()
+
+	assert.Empty(t, mismatches)
+	mb.lock.Lock()
+	defer mb.lock.Unlock()
+	assert.Len(t, mb.pending, 0)
+}
@@ -10,7 +10,7 @@ import (
 )
 
 type NoDirFileSystem struct {
-	fs http.Dir
+	fs http.FileSystem
 }
 
 type neuteredStatFile struct {
@@ -0,0 +1,204 @@
+// Copyright 2019-2021 VMware, Inc.
+// SPDX-License-Identifier: BSD-2-Clause
+
+// renders the OpenAPI document served by Plank at /openapi.json and lets the user try the operations out.
+(function () {
+    'use strict';
+
+    var specUrl = '/openapi.json';
+
+    // el creates an element with the given class and text content. text is never interpreted as HTML.
+    function el(tag, className, text) {
+        var element = document.createElement(tag);
+        if (className) {
+            element.className = className;
+        }
+        if (text !== undefined) {
+            element.textContent = text;
+        }
+        return element;
+    }
+
+    // resolveSchema inlines the schemas referenced from the schema so they can be displayed in one piece.
+    // recursive references are left as they are.
+    function resolveSchema(spec, schema, seen) {
+        if (!schema || typeof schema !== 'object') {
+            return schema;
+        }
+        seen = seen || [];
+        if (schema.$ref) {
+            var name = schema.$ref.replace('#/components/schemas/', '');
+            var referenced = spec.components && spec.components.schemas && spec.components.schemas[name];
+            if (!referenced || seen.indexOf(name) >= 0) {
+                return {$ref: schema.$ref};
+            }
+            return resolveSchema(spec, referenced, seen.concat([name]));
+        }
+        var resolved = Array.isArray(schema) ? [] : {};
+        Object.keys(schema).forEach(function (key) {
+            resolved[key] = resolveSchema(spec, schema[key], seen);
+        });
+        return resolved;
+    }
+
+    function renderSchema(spec, schema) {
+        return el('pre', null, JSON.stringify(resolveSchema(spec, schema), null, 2));
+    }
+
+    function renderParameters(parameters, inputs) {
+        var table = el('table');
+        var header = el('tr');
+        ['Name', 'In', 'Value'].forEach(function (title) {
+            header.appendChild(el('th', null, title));
+        });
+        table.appendChild(header);
+        parameters.forEach(function (parameter) {
+            var row = el('tr');
+            row.appendChild(el('td', 'path', parameter.name + (parameter.required ? ' *' : '')));
+            row.appendChild(el('td', null, parameter.in));
+            var input = el('input');
+            if (parameter.schema && parameter.schema.pattern) {
+                input.placeholder = parameter.schema.pattern;
+            }
+            inputs.push({parameter: parameter, input: input});
+            var cell = el('td');
+            cell.appendChild(input);
+            row.appendChild(cell);
+            table.appendChild(row);
+        });
+        return table;
+    }
+
+    // execute sends the request described by the operation with the values entered by the user
+    function execute(method, path, inputs, body, output) {
+        var query = [];
+        inputs.forEach(function (entry) {
+            var value = entry.input.value;
+            if (entry.parameter.in === 'path') {
+                path = path.replace('{' + entry.parameter.name + '}', encodeURIComponent(value));
+            } else if (value !== '') {
+                query.push(encodeURIComponent(entry.parameter.name) + '=' + encodeURIComponent(value));
+            }
+        });
+        var url = path + (query.length ? '?' + query.join('&') : '');
+        var init = {method: method.toUpperCase(), headers: {}};
+        if (body) {
+            init.body = body.value;
+            init.headers['Content-Type'] = 'application/json';
+        }
+
+        output.textContent = '';
+        fetch(url, init).then(function (response) {
+            return response.text().then(function (text) {
+                var status = el('p', 'status' + (response.ok ? '' : ' error'),
+                    init.method + ' ' + url + ' - ' + response.status + ' ' + response.statusText);
+                try {
+                    text = JSON.stringify(JSON.parse(text), null, 2);
+                } catch (e) {
+                    // not JSON, display as is
+                }
+                output.appendChild(status);
+                output.appendChild(el('pre', null, text));
+            });
+        }).catch(function (err) {
+            output.appendChild(el('p', 'status error', String(err)));
+        });
+    }
+
+    function renderOperation(spec, method, path, operation) {
+        var container = el('section', 'operation');
+        var summary = el('div', 'summary');
+        summary.appendChild(el('span', 'method ' + method, method));
+        summary.appendChild(el('span', 'path', path));
+        summary.appendChild(el('span', null, operation.summary || ''));
+        summary.addEventListener('click', function () {
+            container.classList.toggle('open');
+        });
+        container.appendChild(summary);
+
+        var details = el('div', 'details');
+        if (operation.description) {
+            details.appendChild(el('p', null, operation.description));
+        }
+
+        var inputs = [];
+        if (operation.parameters && operation.parameters.length) {
+            details.appendChild(el('h3', null, 'Parameters'));
+            details.appendChild(renderParameters(operation.parameters, inputs));
+        }
+
+        var body = null;
+        if (operation.requestBody) {
+            var requestSchema = operation.requestBody.content['application/json'].schema;
+            details.appendChild(el('h3', null, 'Request body'));
+            details.appendChild(renderSchema(spec, requestSchema));
+            body = el('textarea');
+            body.value = '{}';
+            details.appendChild(body);
+        }
+
+        details.appendChild(el('h3', null, 'Responses'));
+        Object.keys(operation.responses).forEach(function (code) {
+            var response = operation.responses[code];
+            details.appendChild(el('p', null, code + ': ' + response.description));
+            if (response.content && response.content['application/json']) {
+                details.appendChild(renderSchema(spec, response.content['application/json'].schema));
+            }
+        });
+
+        var output = el('div');
+        var button = el('button', null, 'Execute');
+        button.addEventListener('click', function () {
+            execute(method, path, inputs, body, output);
+        });
+        details.appendChild(button);
+        details.appendChild(output);
+        container.appendChild(details);
+        return container;
+    }
+
+    function render(spec) {
+        document.title = spec.info.title + ' API explorer';
+        document.getElementById('title').textContent = spec.info.title;
+        document.getElementById('version').textContent = spec.info.version;
+        document.getElementById('description').textContent = spec.info.description || '';
+
+        // group the operations by tag
+        var operationsByTag = {};
+        Object.keys(spec.paths).sort().forEach(function (path) {
+            Object.keys(spec.paths[path]).forEach(function (method) {
+                var operation = spec.paths[path][method];
+                var tag = (operation.tags && operation.tags[0]) || 'default';
+                (operationsByTag[tag] = operationsByTag[tag] || []).push(
+                    renderOperation(spec, method, path, operation));
+            });
+        });
+
+        var main = document.getElementById('operations');
+        main.textContent = '';
+        var tags = spec.tags || [];
+        if (!tags.length) {
+            main.appendChild(el('p', 'placeholder', 'No REST bridges are set up.'));
+        }
+        tags.forEach(function (tag) {
+            main.appendChild(el('h2', 'tag', tag.name));
+            if (tag.description) {
+                main.appendChild(el('p', 'tag-description', tag.description));
+            }
+            (operationsByTag[tag.name] || []).forEach(function (operation) {
+                main.appendChild(operation);
+            });
+        });
+    }
+
+    fetch(specUrl).then(function (response) {
+        if (!response.ok) {
+            throw new Error(specUrl + ' responded with ' + response.status);
+        }
+        return response.json();
+    }).then(render).catch(function (err) {
+        var main = document.getElementById('operations');
+        main.textContent = '';
+        main.appendChild(el('p', 'status error', 'Unable to load the API description: ' + err.message));
+    });
+})();
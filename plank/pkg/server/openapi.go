@@ -0,0 +1,484 @@
+// Copyright 2019-2021 VMware, Inc.
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"embed"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"github.com/vmware/transport-go/plank/pkg/middleware"
+	"github.com/vmware/transport-go/plank/utils"
+	"github.com/vmware/transport-go/service"
+	"io/fs"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	OpenAPIDocumentPath = "/openapi.json" // URI the OpenAPI document is served at
+	OpenAPIVersion      = "3.0.3"         // version of the OpenAPI specification the document conforms to
+)
+
+//go:embed openapi-ui
+var openAPIUIFs embed.FS
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	rawMessageType    = reflect.TypeOf(json.RawMessage{})
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	schemaNameRegex   = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+)
+
+// openAPIDocument is the subset of the OpenAPI 3 document model needed to describe the REST bridges
+type openAPIDocument struct {
+	OpenAPI    string                                  `json:"openapi"`
+	Info       openAPIInfo                             `json:"info"`
+	Tags       []*openAPITag                           `json:"tags,omitempty"`
+	Paths      map[string]map[string]*openAPIOperation `json:"paths"`
+	Components *openAPIComponents                      `json:"components,omitempty"`
+}
+
+type openAPIInfo struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+type openAPITag struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+type openAPIOperation struct {
+	OperationId string                      `json:"operationId"`
+	Summary     string                      `json:"summary,omitempty"`
+	Description string                      `json:"description,omitempty"`
+	Tags        []string                    `json:"tags,omitempty"`
+	Parameters  []*openAPIParameter         `json:"parameters,omitempty"`
+	RequestBody *openAPIRequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*openAPIResponse `json:"responses"`
+}
+
+type openAPIParameter struct {
+	Name     string      `json:"name"`
+	In       string      `json:"in"`
+	Required bool        `json:"required,omitempty"`
+	Schema   *jsonSchema `json:"schema"`
+}
+
+type openAPIRequestBody struct {
+	Required bool                         `json:"required,omitempty"`
+	Content  map[string]*openAPIMediaType `json:"content"`
+}
+
+type openAPIResponse struct {
+	Description string                       `json:"description"`
+	Content     map[string]*openAPIMediaType `json:"content,omitempty"`
+}
+
+type openAPIMediaType struct {
+	Schema *jsonSchema `json:"schema"`
+}
+
+type openAPIComponents struct {
+	Schemas map[string]*jsonSchema `json:"schemas,omitempty"`
+}
+
+// jsonSchema is the subset of the OpenAPI schema object generated from Go types
+type jsonSchema struct {
+	Ref                  string                 `json:"$ref,omitempty"`
+	Type                 string                 `json:"type,omitempty"`
+	Format               string                 `json:"format,omitempty"`
+	Pattern              string                 `json:"pattern,omitempty"`
+	Nullable             bool                   `json:"nullable,omitempty"`
+	Items                *jsonSchema            `json:"items,omitempty"`
+	Properties           map[string]*jsonSchema `json:"properties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	AdditionalProperties *jsonSchema            `json:"additionalProperties,omitempty"`
+}
+
+// configureOpenAPI registers the reserved path /openapi.json serving the OpenAPI document of the REST bridges
+// and, unless disabled, the API explorer page rendering it.
+func (ps *platformServer) configureOpenAPI() {
+	config := ps.serverConfig.OpenAPIConfig
+	if len(config.Title) == 0 {
+		config.Title = "Plank"
+	}
+	if len(config.Version) == 0 {
+		config.Version = "1.0.0"
+	}
+	if len(config.UIPath) == 0 {
+		config.UIPath = "/openapi"
+	}
+	config.UIPath = utils.SanitizeUrl(config.UIPath, false)
+
+	// the document is generated for every request as the REST bridges can change at runtime
+	ps.endpointHandlerMap[OpenAPIDocumentPath] = func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(ps.buildOpenAPIDocument()); err != nil {
+			utils.Log.WithError(err).Errorln("[plank] Failed to encode the OpenAPI document")
+		}
+	}
+	ps.router.Path(OpenAPIDocumentPath).Name(OpenAPIDocumentPath).Methods(http.MethodGet).Handler(
+		middleware.CacheControlMiddleware([]string{OpenAPIDocumentPath}, middleware.NewCacheControlDirective().NoStore())(
+			ps.endpointHandlerMap[OpenAPIDocumentPath]))
+
+	if !config.DisableUI {
+		uiFs, _ := fs.Sub(openAPIUIFs, "openapi-ui")
+		ps.setFileSystemRoute(config.UIPath, http.FS(uiFs))
+	}
+}
+
+// buildOpenAPIDocument describes the REST bridges currently set up in an OpenAPI 3 document. each bridge is an
+// operation tagged with its service channel, its path parameters are taken from the URI template and its request
+// body and response are described with the types declared in the bridge config. path prefix bridges have no
+// equivalent in OpenAPI and are left out.
+func (ps *platformServer) buildOpenAPIDocument() *openAPIDocument {
+	config := ps.serverConfig.OpenAPIConfig
+	doc := &openAPIDocument{
+		OpenAPI: OpenAPIVersion,
+		Info: openAPIInfo{
+			Title:       config.Title,
+			Version:     config.Version,
+			Description: config.Description,
+		},
+		Paths: make(map[string]map[string]*openAPIOperation),
+	}
+
+	ps.lock.Lock()
+	bridgeConfigs := make([]*service.RESTBridgeConfig, 0, len(ps.bridgeConfigMap))
+	for key, bridgeConfig := range ps.bridgeConfigMap {
+		if !strings.HasSuffix(key, "-"+AllMethodsWildcard) {
+			bridgeConfigs = append(bridgeConfigs, bridgeConfig)
+		}
+	}
+	ps.lock.Unlock()
+	sort.Slice(bridgeConfigs, func(i, j int) bool {
+		if bridgeConfigs[i].Uri == bridgeConfigs[j].Uri {
+			return bridgeConfigs[i].Method < bridgeConfigs[j].Method
+		}
+		return bridgeConfigs[i].Uri < bridgeConfigs[j].Uri
+	})
+
+	schemas := newSchemaGenerator()
+	tags := make(map[string]bool)
+	for _, bridgeConfig := range bridgeConfigs {
+		path, parameters := parsePathTemplate(bridgeConfig.Uri)
+		for _, queryParam := range bridgeConfig.QueryParams {
+			parameters = append(parameters, &openAPIParameter{
+				Name: queryParam, In: "query", Schema: &jsonSchema{Type: "string"}})
+		}
+
+		operation := &openAPIOperation{
+			OperationId: buildOperationId(bridgeConfig.Method, path),
+			Summary:     bridgeConfig.Summary,
+			Description: bridgeConfig.Description,
+			Tags:        []string{bridgeConfig.ServiceChannel},
+			Parameters:  parameters,
+			Responses: map[string]*openAPIResponse{
+				"200": {
+					Description: "Response of the service",
+					Content: map[string]*openAPIMediaType{
+						"application/json": {Schema: schemas.schemaFor(bridgeConfig.ResponseType)},
+					},
+				},
+				"default": {Description: "Error response of the service"},
+			},
+		}
+		if bridgeConfig.RequestBinding != nil {
+			operation.Parameters, operation.RequestBody = describeRequestBinding(
+				schemas, bridgeConfig.RequestBinding, operation.Parameters)
+		}
+		if bridgeConfig.RequestType != nil {
+			operation.RequestBody = &openAPIRequestBody{
+				Required: true,
+				Content: map[string]*openAPIMediaType{
+					"application/json": {Schema: schemas.schemaFor(bridgeConfig.RequestType)},
+				},
+			}
+		}
+
+		if doc.Paths[path] == nil {
+			doc.Paths[path] = make(map[string]*openAPIOperation)
+		}
+		doc.Paths[path][strings.ToLower(bridgeConfig.Method)] = operation
+
+		if !tags[bridgeConfig.ServiceChannel] {
+			tags[bridgeConfig.ServiceChannel] = true
+			doc.Tags = append(doc.Tags, &openAPITag{
+				Name:        bridgeConfig.ServiceChannel,
+				Description: fmt.Sprintf("REST bridges of the '%s' service channel", bridgeConfig.ServiceChannel),
+			})
+		}
+	}
+	sort.Slice(doc.Tags, func(i, j int) bool {
+		return doc.Tags[i].Name < doc.Tags[j].Name
+	})
+
+	if len(schemas.schemas) > 0 {
+		doc.Components = &openAPIComponents{Schemas: schemas.schemas}
+	}
+	return doc
+}
+
+// parsePathTemplate converts a mux URI template into an OpenAPI path and its path parameters.
+// variables with a pattern like {id:[0-9]+} become {id} with the pattern in the parameter schema.
+func parsePathTemplate(uri string) (string, []*openAPIParameter) {
+	var path strings.Builder
+	parameters := make([]*openAPIParameter, 0)
+	for i := 0; i < len(uri); i++ {
+		if uri[i] != '{' {
+			path.WriteByte(uri[i])
+			continue
+		}
+
+		// find the matching closing brace, patterns can contain braces themselves
+		depth, end := 0, -1
+		for j := i; j < len(uri) && end < 0; j++ {
+			switch uri[j] {
+			case '{':
+				depth++
+			case '}':
+				if depth--; depth == 0 {
+					end = j
+				}
+			}
+		}
+		if end < 0 {
+			path.WriteString(uri[i:])
+			break
+		}
+
+		name, pattern := uri[i+1:end], ""
+		if idx := strings.Index(name, ":"); idx >= 0 {
+			name, pattern = name[:idx], "^"+name[idx+1:]+"$"
+		}
+		path.WriteString("{" + name + "}")
+		parameters = append(parameters, &openAPIParameter{
+			Name:     name,
+			In:       "path",
+			Required: true,
+			Schema:   &jsonSchema{Type: "string", Pattern: pattern},
+		})
+		i = end
+	}
+	return path.String(), parameters
+}
+
+// describeRequestBinding adds the query and header parameters bound by the request binding to the parameters,
+// types the path parameters and describes the JSON or form body the binding decodes, if any.
+func describeRequestBinding(schemas *schemaGenerator, binding *service.RequestBinding,
+	parameters []*openAPIParameter) ([]*openAPIParameter, *openAPIRequestBody) {
+
+	body := &jsonSchema{Type: "object", Properties: make(map[string]*jsonSchema)}
+	form := &jsonSchema{Type: "object", Properties: make(map[string]*jsonSchema)}
+	formMediaType := "application/x-www-form-urlencoded"
+	for _, field := range binding.Fields() {
+		schema := schemas.schemaFor(field.Field.Type)
+		schema.Nullable = false
+		switch field.In {
+		case "path":
+			for _, parameter := range parameters {
+				if parameter.In == "path" && parameter.Name == field.Name {
+					schema.Pattern = parameter.Schema.Pattern
+					parameter.Schema = schema
+				}
+			}
+		case "query", "header":
+			parameters = append(parameters, &openAPIParameter{
+				Name: field.Name, In: field.In, Required: field.Required, Schema: schema})
+		case "form":
+			if field.Field.Type.Kind() == reflect.Slice && field.Field.Type.Elem().Kind() == reflect.Uint8 {
+				schema = &jsonSchema{Type: "string", Format: "binary"}
+				formMediaType = "multipart/form-data"
+			}
+			form.Properties[field.Name] = schema
+			if field.Required {
+				form.Required = append(form.Required, field.Name)
+			}
+		case "body":
+			body.Properties[field.Name] = schema
+			if field.Required {
+				body.Required = append(body.Required, field.Name)
+			}
+		}
+	}
+
+	content := make(map[string]*openAPIMediaType)
+	if len(body.Properties) > 0 {
+		content["application/json"] = &openAPIMediaType{Schema: body}
+	}
+	if len(form.Properties) > 0 {
+		content[formMediaType] = &openAPIMediaType{Schema: form}
+	}
+	if len(content) == 0 {
+		return parameters, nil
+	}
+	return parameters, &openAPIRequestBody{
+		Required: len(body.Required) > 0 || len(form.Required) > 0,
+		Content:  content,
+	}
+}
+
+// buildOperationId derives a unique operation id from the method and the path, e.g. get_rest_stock-ticker_symbol
+func buildOperationId(method, path string) string {
+	segments := []string{strings.ToLower(method)}
+	for _, segment := range strings.Split(path, "/") {
+		if segment = strings.Trim(segment, "{}"); len(segment) > 0 {
+			segments = append(segments, segment)
+		}
+	}
+	return strings.Join(segments, "_")
+}
+
+// schemaGenerator generates schemas of Go types as they are encoded by encoding/json. named struct types are
+// added to the schemas and referenced, which also takes care of recursive types.
+type schemaGenerator struct {
+	schemas map[string]*jsonSchema
+	names   map[reflect.Type]string
+}
+
+func newSchemaGenerator() *schemaGenerator {
+	return &schemaGenerator{
+		schemas: make(map[string]*jsonSchema),
+		names:   make(map[reflect.Type]string),
+	}
+}
+
+// schemaFor returns the schema of the type. nil types and interfaces accept any value.
+func (g *schemaGenerator) schemaFor(t reflect.Type) *jsonSchema {
+	if t == nil {
+		return &jsonSchema{}
+	}
+	nullable := false
+	for t.Kind() == reflect.Ptr {
+		t, nullable = t.Elem(), true
+	}
+
+	schema := g.typeSchema(t)
+	if nullable && len(schema.Ref) == 0 && len(schema.Type) > 0 {
+		schema.Nullable = true
+	}
+	return schema
+}
+
+func (g *schemaGenerator) typeSchema(t reflect.Type) *jsonSchema {
+	switch {
+	case t == timeType:
+		return &jsonSchema{Type: "string", Format: "date-time"}
+	case t == rawMessageType:
+		return &jsonSchema{}
+	case t.Implements(jsonMarshalerType) || reflect.PtrTo(t).Implements(jsonMarshalerType):
+		// the encoding is up to the type
+		return &jsonSchema{}
+	case t.Implements(textMarshalerType) || reflect.PtrTo(t).Implements(textMarshalerType):
+		if t.PkgPath() == "github.com/google/uuid" && t.Name() == "UUID" {
+			return &jsonSchema{Type: "string", Format: "uuid"}
+		}
+		return &jsonSchema{Type: "string"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &jsonSchema{Type: "boolean"}
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint64, reflect.Uintptr:
+		return &jsonSchema{Type: "integer", Format: "int64"}
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &jsonSchema{Type: "integer", Format: "int32"}
+	case reflect.Float32:
+		return &jsonSchema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &jsonSchema{Type: "number", Format: "double"}
+	case reflect.String:
+		return &jsonSchema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 && t.Kind() == reflect.Slice {
+			// byte slices are encoded as base64 strings
+			return &jsonSchema{Type: "string", Format: "byte"}
+		}
+		return &jsonSchema{Type: "array", Items: g.schemaFor(t.Elem())}
+	case reflect.Map:
+		return &jsonSchema{Type: "object", AdditionalProperties: g.schemaFor(t.Elem())}
+	case reflect.Struct:
+		if len(t.Name()) == 0 {
+			return g.structSchema(t)
+		}
+		return &jsonSchema{Ref: "#/components/schemas/" + g.schemaName(t)}
+	default:
+		// interfaces accept any value, channels and functions can't be encoded
+		return &jsonSchema{}
+	}
+}
+
+// schemaName returns the name of the named struct type in the schemas, generating its schema the first time.
+// types of different packages sharing a name are told apart by their package name.
+func (g *schemaGenerator) schemaName(t reflect.Type) string {
+	if name, ok := g.names[t]; ok {
+		return name
+	}
+
+	name := schemaNameRegex.ReplaceAllString(t.Name(), "_")
+	if _, taken := g.schemas[name]; taken {
+		pkg := t.PkgPath()[strings.LastIndex(t.PkgPath(), "/")+1:]
+		name = schemaNameRegex.ReplaceAllString(pkg+"."+t.Name(), "_")
+		for i := 2; g.schemas[name] != nil; i++ {
+			name = fmt.Sprintf("%s.%s_%d", pkg, schemaNameRegex.ReplaceAllString(t.Name(), "_"), i)
+		}
+	}
+
+	// reserve the name before generating the schema in case the type refers to itself
+	g.names[t] = name
+	g.schemas[name] = &jsonSchema{}
+	*g.schemas[name] = *g.structSchema(t)
+	return name
+}
+
+// structSchema generates the schema of the struct following the encoding/json rules for field names,
+// ignored fields and embedded structs.
+func (g *schemaGenerator) structSchema(t reflect.Type) *jsonSchema {
+	schema := &jsonSchema{Type: "object", Properties: make(map[string]*jsonSchema)}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+
+		fieldType := field.Type
+		if field.Anonymous && len(name) == 0 {
+			for fieldType.Kind() == reflect.Ptr {
+				fieldType = fieldType.Elem()
+			}
+			if fieldType.Kind() == reflect.Struct {
+				// the fields of embedded structs are promoted to the embedding struct
+				for propName, propSchema := range g.structSchema(fieldType).Properties {
+					if _, exists := schema.Properties[propName]; !exists {
+						schema.Properties[propName] = propSchema
+					}
+				}
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if len(name) == 0 {
+			name = field.Name
+		}
+
+		if strings.Contains(options, "string") {
+			schema.Properties[name] = &jsonSchema{Type: "string"}
+		} else {
+			schema.Properties[name] = g.schemaFor(field.Type)
+		}
+	}
+	return schema
+}
@@ -0,0 +1,223 @@
+// Copyright 2019-2021 VMware, Inc.
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/vmware/transport-go/bus"
+	"github.com/vmware/transport-go/model"
+	"github.com/vmware/transport-go/service"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type openAPITestAddress struct {
+	Street string `json:"street"`
+	City   string `json:"city,omitempty"`
+}
+
+type openAPITestAudit struct {
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type openAPITestCustomer struct {
+	openAPITestAudit
+	Id        uuid.UUID              `json:"id"`
+	Name      string                 `json:"name"`
+	Age       int32                  `json:"age,omitempty"`
+	Balance   int64                  `json:"balance,string"`
+	Address   *openAPITestAddress    `json:"address"`
+	Tags      []string               `json:"tags"`
+	Avatar    []byte                 `json:"avatar"`
+	Extra     map[string]interface{} `json:"extra"`
+	Referrer  *openAPITestCustomer   `json:"referrer"`
+	Ignored   string                 `json:"-"`
+	Untagged  bool
+	internal  string
+	Callbacks map[string]func() error `json:"-"`
+}
+
+func newOpenAPITestServer(openAPIConfig *OpenAPIConfig) *platformServer {
+	newBus := bus.ResetBus()
+	service.ResetServiceRegistry()
+	_ = newBus.GetChannelManager().CreateChannel("customer-service")
+	_ = newBus.GetChannelManager().CreateChannel("other-service")
+	config := GetBasicTestServerConfig(os.TempDir(), "stdout", "stdout", "stderr", GetTestPort(), true)
+	config.OpenAPIConfig = openAPIConfig
+	ps := NewPlatformServer(config).(*platformServer)
+	ps.eventbus = newBus
+	return ps
+}
+
+func requestBuilder(w http.ResponseWriter, r *http.Request) model.Request {
+	return model.Request{Id: &uuid.UUID{}, Request: "test"}
+}
+
+func getOpenAPIDocument(t *testing.T, ps *platformServer) map[string]interface{} {
+	rec := httptest.NewRecorder()
+	ps.router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://localhost/openapi.json", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	var doc map[string]interface{}
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &doc))
+	return doc
+}
+
+func TestPlatformServer_OpenAPIDocument(t *testing.T) {
+	ps := newOpenAPITestServer(&OpenAPIConfig{Title: "Customers", Description: "Customer API"})
+	ps.SetHttpChannelBridge(&service.RESTBridgeConfig{
+		ServiceChannel:       "customer-service",
+		Uri:                  "/rest/customers/{id:[0-9a-f-]+}",
+		Method:               http.MethodGet,
+		Summary:              "Get a customer",
+		QueryParams:          []string{"fields"},
+		ResponseType:         reflect.TypeOf(&openAPITestCustomer{}),
+		FabricRequestBuilder: requestBuilder,
+	})
+	ps.SetHttpChannelBridge(&service.RESTBridgeConfig{
+		ServiceChannel:       "customer-service",
+		Uri:                  "/rest/customers",
+		Method:               http.MethodPost,
+		RequestType:          reflect.TypeOf(openAPITestCustomer{}),
+		ResponseType:         reflect.TypeOf([]*openAPITestCustomer{}),
+		FabricRequestBuilder: requestBuilder,
+	})
+	ps.SetHttpChannelBridge(&service.RESTBridgeConfig{
+		ServiceChannel:       "other-service",
+		Uri:                  "/rest/other",
+		Method:               http.MethodGet,
+		FabricRequestBuilder: requestBuilder,
+	})
+	ps.SetHttpPathPrefixChannelBridge(&service.RESTBridgeConfig{
+		ServiceChannel:       "other-service",
+		Uri:                  "/rest/prefix",
+		FabricRequestBuilder: requestBuilder,
+	})
+
+	doc := getOpenAPIDocument(t, ps)
+	assert.Equal(t, OpenAPIVersion, doc["openapi"])
+	assert.Equal(t, map[string]interface{}{
+		"title": "Customers", "version": "1.0.0", "description": "Customer API"}, doc["info"])
+	assert.Len(t, doc["tags"], 2)
+
+	// path prefix bridges are not described
+	paths := doc["paths"].(map[string]interface{})
+	assert.Len(t, paths, 3)
+	assert.Contains(t, paths, "/rest/other")
+
+	getCustomer := paths["/rest/customers/{id}"].(map[string]interface{})["get"].(map[string]interface{})
+	assert.Equal(t, "get_rest_customers_id", getCustomer["operationId"])
+	assert.Equal(t, "Get a customer", getCustomer["summary"])
+	assert.Equal(t, []interface{}{"customer-service"}, getCustomer["tags"])
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"name": "id", "in": "path", "required": true,
+			"schema": map[string]interface{}{"type": "string", "pattern": "^[0-9a-f-]+$"}},
+		map[string]interface{}{"name": "fields", "in": "query",
+			"schema": map[string]interface{}{"type": "string"}},
+	}, getCustomer["parameters"])
+	assert.Nil(t, getCustomer["requestBody"])
+	assert.Equal(t, map[string]interface{}{"$ref": "#/components/schemas/openAPITestCustomer"},
+		getCustomer["responses"].(map[string]interface{})["200"].(map[string]interface{})["content"].(map[string]interface{})["application/json"].(map[string]interface{})["schema"])
+
+	postCustomer := paths["/rest/customers"].(map[string]interface{})["post"].(map[string]interface{})
+	assert.Equal(t, map[string]interface{}{"$ref": "#/components/schemas/openAPITestCustomer"},
+		postCustomer["requestBody"].(map[string]interface{})["content"].(map[string]interface{})["application/json"].(map[string]interface{})["schema"])
+
+	schemas := doc["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+	assert.Len(t, schemas, 2)
+	assert.Equal(t, map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"street": map[string]interface{}{"type": "string"},
+			"city":   map[string]interface{}{"type": "string"},
+		},
+	}, schemas["openAPITestAddress"])
+	properties := schemas["openAPITestCustomer"].(map[string]interface{})["properties"].(map[string]interface{})
+	assert.Equal(t, map[string]interface{}{
+		"createdAt": map[string]interface{}{"type": "string", "format": "date-time"},
+		"id":        map[string]interface{}{"type": "string", "format": "uuid"},
+		"name":      map[string]interface{}{"type": "string"},
+		"age":       map[string]interface{}{"type": "integer", "format": "int32"},
+		"balance":   map[string]interface{}{"type": "string"},
+		"address":   map[string]interface{}{"$ref": "#/components/schemas/openAPITestAddress"},
+		"tags":      map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+		"avatar":    map[string]interface{}{"type": "string", "format": "byte"},
+		"extra":     map[string]interface{}{"type": "object", "additionalProperties": map[string]interface{}{}},
+		"referrer":  map[string]interface{}{"$ref": "#/components/schemas/openAPITestCustomer"},
+		"Untagged":  map[string]interface{}{"type": "boolean"},
+	}, properties)
+}
+
+func TestPlatformServer_OpenAPIDocumentFollowsBridgeChanges(t *testing.T) {
+	ps := newOpenAPITestServer(&OpenAPIConfig{})
+	ps.SetHttpChannelBridge(&service.RESTBridgeConfig{
+		ServiceChannel:       "customer-service",
+		Uri:                  "/rest/customers",
+		Method:               http.MethodGet,
+		FabricRequestBuilder: requestBuilder,
+	})
+	assert.Contains(t, getOpenAPIDocument(t, ps)["paths"], "/rest/customers")
+
+	ps.loadGlobalHttpHandler(ps.clearHttpChannelBridgesForService("customer-service"))
+	assert.Empty(t, getOpenAPIDocument(t, ps)["paths"])
+	assert.Nil(t, getOpenAPIDocument(t, ps)["components"])
+}
+
+func TestPlatformServer_OpenAPIExplorer(t *testing.T) {
+	ps := newOpenAPITestServer(&OpenAPIConfig{UIPath: "/api-docs/"})
+
+	rec := httptest.NewRecorder()
+	ps.router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://localhost/api-docs/", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "openapi-ui.js")
+
+	rec = httptest.NewRecorder()
+	ps.router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://localhost/api-docs/openapi-ui.js", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "/openapi.json")
+}
+
+func TestPlatformServer_OpenAPIDisabled(t *testing.T) {
+	for _, ps := range []*platformServer{newOpenAPITestServer(nil), newOpenAPITestServer(&OpenAPIConfig{DisableUI: true})} {
+		rec := httptest.NewRecorder()
+		ps.router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://localhost/openapi/", nil))
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	}
+
+	rec := httptest.NewRecorder()
+	newOpenAPITestServer(nil).router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://localhost/openapi.json", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestParsePathTemplate(t *testing.T) {
+	path, parameters := parsePathTemplate("/rest/{from}/to/{id:[0-9]{2,4}}")
+	assert.Equal(t, "/rest/{from}/to/{id}", path)
+	assert.Len(t, parameters, 2)
+	assert.Equal(t, "from", parameters[0].Name)
+	assert.Empty(t, parameters[0].Schema.Pattern)
+	assert.Equal(t, "id", parameters[1].Name)
+	assert.Equal(t, "^[0-9]{2,4}$", parameters[1].Schema.Pattern)
+
+	path, parameters = parsePathTemplate("/rest/plain")
+	assert.Equal(t, "/rest/plain", path)
+	assert.Empty(t, parameters)
+}
+
+func TestSchemaGenerator_NameCollisions(t *testing.T) {
+	type Response struct {
+		Local string `json:"local"`
+	}
+	g := newSchemaGenerator()
+	assert.Equal(t, "#/components/schemas/Response", g.schemaFor(reflect.TypeOf(Response{})).Ref)
+	assert.Equal(t, "#/components/schemas/model.Response", g.schemaFor(reflect.TypeOf(&model.Response{})).Ref)
+	assert.Equal(t, "#/components/schemas/Response", g.schemaFor(reflect.TypeOf(Response{})).Ref)
+	assert.Equal(t, &jsonSchema{}, g.schemaFor(nil))
+}
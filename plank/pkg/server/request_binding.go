@@ -0,0 +1,58 @@
+// Copyright 2019-2021 VMware, Inc.
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/vmware/transport-go/model"
+	"github.com/vmware/transport-go/plank/utils"
+	"github.com/vmware/transport-go/service"
+	"net/http"
+)
+
+// boundPayloadKey is the context key of the payload bound from the HTTP request
+type boundPayloadKey struct{}
+
+// buildBridgeHandler builds the endpoint handler of the REST bridge. bridges with a request binding bind the HTTP
+// request before the service is invoked and answer requests that can't be bound with the binding error.
+func (ps *platformServer) buildBridgeHandler(bridgeConfig *service.RESTBridgeConfig, messageBridge *MessageBridge) http.HandlerFunc {
+	binding := bridgeConfig.RequestBinding
+	if binding == nil {
+		return ps.buildEndpointHandler(
+			bridgeConfig.ServiceChannel, bridgeConfig.FabricRequestBuilder, ps.serverConfig.RestBridgeTimeout, messageBridge)
+	}
+
+	endpointHandler := ps.buildEndpointHandler(bridgeConfig.ServiceChannel,
+		func(w http.ResponseWriter, r *http.Request) model.Request {
+			return model.Request{Id: &uuid.UUID{}, Request: binding.Request, Payload: r.Context().Value(boundPayloadKey{})}
+		}, ps.serverConfig.RestBridgeTimeout, messageBridge)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		payload, err := binding.Bind(r, mux.Vars(r))
+		if err != nil {
+			writeBindingError(w, err)
+			return
+		}
+		endpointHandler(w, r.WithContext(context.WithValue(r.Context(), boundPayloadKey{}, payload)))
+	}
+}
+
+// writeBindingError answers the request with the error returned by service.RequestBinding.Bind
+func writeBindingError(w http.ResponseWriter, err error) {
+	var bindingErr *service.BindingError
+	if !errors.As(err, &bindingErr) {
+		bindingErr = &service.BindingError{Code: http.StatusInternalServerError, Message: err.Error()}
+	}
+	if bindingErr.Code >= http.StatusInternalServerError {
+		utils.Log.WithError(bindingErr).Errorln("[plank] Unable to bind request")
+	}
+	body, _ := json.Marshal(bindingErr)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(bindingErr.Code)
+	_, _ = w.Write(body)
+}
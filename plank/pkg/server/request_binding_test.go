@@ -0,0 +1,122 @@
+// Copyright 2019-2021 VMware, Inc.
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/vmware/transport-go/model"
+	"github.com/vmware/transport-go/service"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type bindingTestOrder struct {
+	CustomerId int      `path:"customerId"`
+	DryRun     bool     `query:"dryRun"`
+	Tenant     string   `header:"X-Tenant" validate:"required"`
+	Items      []string `json:"items" validate:"required,max=3"`
+	Note       string   `json:"note"`
+}
+
+func newBindingTestServer(t *testing.T, requests chan *model.Request) *platformServer {
+	ps := newOpenAPITestServer(&OpenAPIConfig{})
+	mh, err := ps.eventbus.ListenRequestStream("customer-service")
+	assert.Nil(t, err)
+	mh.Handle(func(message *model.Message) {
+		request := message.Payload.(model.Request)
+		requests <- &request
+		ps.eventbus.SendResponseMessage("customer-service",
+			&model.Response{Id: request.Id, Payload: "created"}, message.DestinationId)
+	}, func(e error) {})
+
+	ps.SetHttpChannelBridge(&service.RESTBridgeConfig{
+		ServiceChannel: "customer-service",
+		Uri:            "/rest/customers/{customerId:[0-9]+}/orders",
+		Method:         http.MethodPost,
+		RequestBinding: &service.RequestBinding{Request: "create-order", PayloadType: reflect.TypeOf(bindingTestOrder{})},
+	})
+	return ps
+}
+
+func TestPlatformServer_RequestBinding(t *testing.T) {
+	requests := make(chan *model.Request, 1)
+	ps := newBindingTestServer(t, requests)
+
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/rest/customers/42/orders?dryRun=true",
+		strings.NewReader(`{"items":["apple","pear"]}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Tenant", "acme")
+	rec := httptest.NewRecorder()
+	ps.router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "\"created\"", rec.Body.String())
+
+	request := <-requests
+	assert.Equal(t, "create-order", request.Request)
+	assert.Equal(t, "acme", request.Headers["X-Tenant"])
+	assert.Equal(t, &bindingTestOrder{
+		CustomerId: 42, DryRun: true, Tenant: "acme", Items: []string{"apple", "pear"}}, request.Payload)
+}
+
+func TestPlatformServer_RequestBindingErrors(t *testing.T) {
+	requests := make(chan *model.Request, 1)
+	ps := newBindingTestServer(t, requests)
+
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/rest/customers/42/orders?dryRun=maybe",
+		strings.NewReader(`{"items":["a","b","c","d"]}`))
+	rec := httptest.NewRecorder()
+	ps.router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	var bindingErr service.BindingError
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &bindingErr))
+	assert.Equal(t, []*service.FieldError{
+		{Field: "dryRun", In: "query", Message: "must be a boolean"},
+		{Field: "X-Tenant", In: "header", Message: "is required"},
+		{Field: "items", In: "body", Message: "must contain at most 3 items"},
+	}, bindingErr.Fields)
+
+	req = httptest.NewRequest(http.MethodPost, "http://localhost/rest/customers/42/orders", strings.NewReader("apple"))
+	req.Header.Set("Content-Type", "text/plain")
+	rec = httptest.NewRecorder()
+	ps.router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnsupportedMediaType, rec.Code)
+
+	// the service is never invoked
+	assert.Empty(t, requests)
+}
+
+func TestPlatformServer_RequestBindingOpenAPI(t *testing.T) {
+	ps := newBindingTestServer(t, make(chan *model.Request, 1))
+
+	doc := getOpenAPIDocument(t, ps)
+	operation := doc["paths"].(map[string]interface{})["/rest/customers/{customerId}/orders"].(map[string]interface{})["post"].(map[string]interface{})
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"name": "customerId", "in": "path", "required": true,
+			"schema": map[string]interface{}{"type": "integer", "format": "int64", "pattern": "^[0-9]+$"}},
+		map[string]interface{}{"name": "dryRun", "in": "query",
+			"schema": map[string]interface{}{"type": "boolean"}},
+		map[string]interface{}{"name": "X-Tenant", "in": "header", "required": true,
+			"schema": map[string]interface{}{"type": "string"}},
+	}, operation["parameters"])
+	assert.Equal(t, map[string]interface{}{
+		"required": true,
+		"content": map[string]interface{}{
+			"application/json": map[string]interface{}{
+				"schema": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"items": map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+						"note":  map[string]interface{}{"type": "string"},
+					},
+					"required": []interface{}{"items"},
+				},
+			},
+		},
+	}, operation["requestBody"])
+}
@@ -0,0 +1,413 @@
+// Copyright 2019-2021 VMware, Inc.
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/vmware/transport-go/model"
+	"github.com/vmware/transport-go/plank/utils"
+	"github.com/vmware/transport-go/service"
+	"mime"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const problemContentType = "application/problem+json"
+
+// responseEncoder encodes the payloads of service responses for the media types it supports
+type responseEncoder struct {
+	contentType string   // Content-Type of the encoded payloads
+	mediaTypes  []string // media types the encoder is chosen for
+	encode      func(payload interface{}) ([]byte, error)
+}
+
+// responseEncoders are the encoders the REST bridge chooses from based on the Accept header, the first one
+// being the default.
+var responseEncoders = []*responseEncoder{
+	{
+		contentType: "application/json",
+		mediaTypes:  []string{"application/json"},
+		encode:      json.Marshal,
+	},
+	{
+		contentType: "application/msgpack",
+		mediaTypes:  []string{"application/msgpack", "application/x-msgpack", "application/vnd.msgpack"},
+		encode:      utils.EncodeMessagePack,
+	},
+	{
+		contentType: "application/xml",
+		mediaTypes:  []string{"application/xml", "text/xml"},
+		encode:      encodeXML,
+	},
+	{
+		contentType: "text/plain; charset=utf-8",
+		mediaTypes:  []string{"text/plain"},
+		encode:      encodeText,
+	},
+}
+
+// supports returns whether the encoder is chosen for the media type. JSON based media types such as
+// application/problem+json are all handled by the JSON encoder.
+func (e *responseEncoder) supports(mediaType string) bool {
+	for _, supported := range e.mediaTypes {
+		if supported == mediaType {
+			return true
+		}
+	}
+	return e == responseEncoders[0] && strings.HasSuffix(mediaType, "+json")
+}
+
+// mediaRange is a media range of an Accept header with its quality
+type mediaRange struct {
+	mediaType string
+	quality   float64
+}
+
+// negotiateResponseEncoder chooses the encoder of the response from the Accept header of the request. the
+// encoder of the Content-Type set by the service, if any, is chosen unless the client rules it out, otherwise
+// it is preferred when the client accepts several media types equally. returns nil if none of the media types
+// accepted by the client is supported.
+func negotiateResponseEncoder(accept string, serviceContentType string) *responseEncoder {
+	preferred, serviceSet := responseEncoders[0], false
+	if mediaType, _, err := mime.ParseMediaType(serviceContentType); err == nil {
+		for _, encoder := range responseEncoders {
+			if encoder.supports(mediaType) {
+				preferred, serviceSet = encoder, true
+				break
+			}
+		}
+	}
+	if strings.TrimSpace(accept) == "" {
+		return preferred
+	}
+
+	ranges := make([]mediaRange, 0)
+	for _, value := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(value))
+		if err != nil {
+			continue
+		}
+		quality := 1.0
+		if q, ok := params["q"]; ok {
+			if quality, err = strconv.ParseFloat(q, 64); err != nil {
+				continue
+			}
+		}
+		ranges = append(ranges, mediaRange{mediaType: mediaType, quality: quality})
+	}
+
+	// the media type of the service is kept even if the client prefers others, e.g. browsers accepting */*
+	// with a lower quality than HTML and XML still get JSON from a service sending JSON
+	if serviceSet && acceptedQuality(ranges, preferred) > 0 {
+		return preferred
+	}
+
+	// encoders ruled out by a more specific media range are skipped, e.g. application/json for
+	// "*/*, application/json;q=0"
+	candidates := make([]*responseEncoder, 0)
+	accepted := make([]mediaRange, 0, len(ranges))
+	for _, r := range ranges {
+		if r.quality > 0 {
+			accepted = append(accepted, r)
+		}
+	}
+	sort.SliceStable(accepted, func(i, j int) bool {
+		return accepted[i].quality > accepted[j].quality
+	})
+	for _, r := range accepted {
+		switch {
+		case r.mediaType == "*/*":
+			candidates = append(append(candidates, preferred), responseEncoders...)
+		case strings.HasSuffix(r.mediaType, "/*"):
+			prefix := strings.TrimSuffix(r.mediaType, "*")
+			if strings.HasPrefix(preferred.mediaTypes[0], prefix) {
+				candidates = append(candidates, preferred)
+			}
+			// primary media types first, so that text/* is answered with text/plain rather than text/xml
+			for _, encoder := range responseEncoders {
+				if strings.HasPrefix(encoder.mediaTypes[0], prefix) {
+					candidates = append(candidates, encoder)
+				}
+			}
+			for _, encoder := range responseEncoders {
+				for _, mediaType := range encoder.mediaTypes {
+					if strings.HasPrefix(mediaType, prefix) {
+						candidates = append(candidates, encoder)
+					}
+				}
+			}
+		case preferred.supports(r.mediaType):
+			candidates = append(candidates, preferred)
+		default:
+			for _, encoder := range responseEncoders {
+				if encoder.supports(r.mediaType) {
+					candidates = append(candidates, encoder)
+				}
+			}
+		}
+	}
+	for _, encoder := range candidates {
+		if acceptedQuality(ranges, encoder) > 0 {
+			return encoder
+		}
+	}
+	return nil
+}
+
+// acceptedQuality returns the quality of the most specific media range matching a media type of the encoder,
+// zero if the client doesn't accept any of them.
+func acceptedQuality(ranges []mediaRange, encoder *responseEncoder) float64 {
+	quality, specificity := 0.0, 0
+	for _, r := range ranges {
+		matched := 0
+		switch {
+		case encoder.supports(r.mediaType):
+			matched = 3
+		case r.mediaType == "*/*":
+			matched = 1
+		case strings.HasSuffix(r.mediaType, "/*"):
+			for _, mediaType := range encoder.mediaTypes {
+				if strings.HasPrefix(mediaType, strings.TrimSuffix(r.mediaType, "*")) {
+					matched = 2
+				}
+			}
+		}
+		if matched > specificity {
+			quality, specificity = r.quality, matched
+		}
+	}
+	return quality
+}
+
+// writeServiceResponse writes the response received from the service channel. successful responses are sent
+// with the status code set by the service, 200 by default, error responses with their error code. payloads are
+// encoded as negotiated with the client unless the service already encoded them, service.FabricError payloads
+// and errors are sent as RFC 7807 problem details.
+func writeServiceResponse(w http.ResponseWriter, r *http.Request, svcChannel string, msg *model.Message) {
+	if msg.Error != nil {
+		utils.Log.WithError(msg.Error).Errorf("Error received from channel %s:", svcChannel)
+		var problem service.FabricError
+		var problemPtr *service.FabricError
+		switch {
+		case errors.As(msg.Error, &problem):
+		case errors.As(msg.Error, &problemPtr) && problemPtr != nil:
+			problem = *problemPtr
+		default:
+			problem = service.GetFabricError(
+				http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError, msg.Error.Error())
+		}
+		writeProblem(w, problem, http.StatusInternalServerError)
+		return
+	}
+
+	response := msg.Payload.(*model.Response)
+	for k, v := range response.Headers {
+		w.Header().Set(k, v)
+	}
+	serviceContentType := w.Header().Get("Content-Type")
+
+	if response.Error {
+		statusCode := validStatusCode(response.ErrorCode, http.StatusInternalServerError)
+		switch problem := response.Payload.(type) {
+		case service.FabricError:
+			writeProblem(w, problem, statusCode)
+			return
+		case *service.FabricError:
+			if problem != nil {
+				writeProblem(w, *problem, statusCode)
+				return
+			}
+		}
+
+		// only send the payload of the error, or the error itself if there is none
+		var respBody interface{} = response
+		if response.Payload != nil {
+			respBody = response.Payload
+		}
+		encoder := negotiateResponseEncoder(r.Header.Get("Accept"), serviceContentType)
+		if encoder == nil {
+			encoder = responseEncoders[0]
+		}
+		body, err := encoder.encode(respBody)
+		if err != nil {
+			w.Header().Del("Content-Type")
+			w.WriteHeader(statusCode)
+			_, _ = w.Write([]byte(response.ErrorMessage))
+			return
+		}
+		w.Header().Set("Content-Type", encoder.contentType)
+		w.Header().Add("Vary", "Accept")
+		w.WriteHeader(statusCode)
+		_, _ = w.Write(body)
+		return
+	}
+
+	// answer conditional GET and HEAD requests for cached responses that haven't changed without a body
+	if etag := response.Headers["ETag"]; etag != "" && (r.Method == http.MethodGet || r.Method == http.MethodHead) &&
+		etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	statusCode := validStatusCode(response.StatusCode, http.StatusOK)
+	if statusCode == http.StatusNoContent || statusCode == http.StatusNotModified {
+		w.Header().Del("Content-Type")
+		w.WriteHeader(statusCode)
+		return
+	}
+
+	// payloads already encoded by the service, e.g. files or pre-rendered documents, are sent as they are
+	var body []byte
+	switch payload := response.Payload.(type) {
+	case []byte:
+		body = payload
+	case string:
+		if mediaType, _, err := mime.ParseMediaType(serviceContentType); err == nil &&
+			mediaType != "application/json" && !strings.HasSuffix(mediaType, "+json") {
+			body = []byte(payload)
+		}
+	}
+
+	if body == nil {
+		encoder := negotiateResponseEncoder(r.Header.Get("Accept"), serviceContentType)
+		if encoder == nil {
+			supported := make([]string, 0)
+			for _, e := range responseEncoders {
+				supported = append(supported, e.mediaTypes...)
+			}
+			writeProblem(w, service.GetFabricError(http.StatusText(http.StatusNotAcceptable), http.StatusNotAcceptable,
+				fmt.Sprintf("supported media types are %s", strings.Join(supported, ", "))), http.StatusNotAcceptable)
+			return
+		}
+		var err error
+		if body, err = encoder.encode(response.Payload); err != nil {
+			utils.Log.WithError(err).Errorf("Unable to encode response received from channel %s:", svcChannel)
+			writeProblem(w, service.GetFabricError(http.StatusText(http.StatusInternalServerError),
+				http.StatusInternalServerError, err.Error()), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", encoder.contentType)
+		w.Header().Add("Vary", "Accept")
+	}
+
+	w.WriteHeader(statusCode)
+	if _, err := w.Write(body); err != nil {
+		utils.Log.WithError(err).Errorf("Error received from channel %s:", svcChannel)
+	}
+}
+
+// writeProblem writes the problem as an application/problem+json body. problems without status get statusCode.
+func writeProblem(w http.ResponseWriter, problem service.FabricError, statusCode int) {
+	if problem.Status == 0 {
+		problem.Status = statusCode
+	}
+	body, _ := json.Marshal(problem)
+	w.Header().Del("Content-Length")
+	w.Header().Set("Content-Type", problemContentType)
+	w.WriteHeader(validStatusCode(problem.Status, statusCode))
+	_, _ = w.Write(body)
+}
+
+// validStatusCode returns the status code, or defaultCode if it can't be written by net/http
+func validStatusCode(statusCode int, defaultCode int) int {
+	if statusCode < 100 || statusCode > 999 {
+		return defaultCode
+	}
+	return statusCode
+}
+
+// encodeText encodes strings, numbers, booleans, errors and fmt.Stringer values as plain text. other
+// values are written as JSON.
+func encodeText(payload interface{}) ([]byte, error) {
+	switch v := payload.(type) {
+	case nil:
+		return []byte{}, nil
+	case string:
+		return []byte(v), nil
+	case []byte:
+		return v, nil
+	case error:
+		return []byte(v.Error()), nil
+	case fmt.Stringer:
+		return []byte(v.String()), nil
+	}
+	switch reflect.Indirect(reflect.ValueOf(payload)).Kind() {
+	case reflect.Bool, reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return []byte(fmt.Sprint(reflect.Indirect(reflect.ValueOf(payload)).Interface())), nil
+	}
+	return json.Marshal(payload)
+}
+
+var xmlNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9._-]*$`)
+
+// encodeXML encodes structs with encoding/xml, honouring their xml tags. other values, and structs encoding/xml
+// can't encode, are written as a <response> document mirroring their JSON encoding.
+func encodeXML(payload interface{}) ([]byte, error) {
+	if payload != nil && reflect.Indirect(reflect.ValueOf(payload)).Kind() == reflect.Struct {
+		if encoded, err := xml.Marshal(payload); err == nil {
+			return append([]byte(xml.Header), encoded...), nil
+		}
+	}
+
+	encoded, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.UseNumber()
+	if err = decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	writeXMLElement(&buf, "response", value)
+	return buf.Bytes(), nil
+}
+
+// writeXMLElement writes the value decoded from JSON as an element. objects become child elements named
+// after their keys, or <entry key="..."> elements for keys which aren't XML names, arrays become <item> elements.
+func writeXMLElement(buf *bytes.Buffer, name string, value interface{}) {
+	startTag := "<" + name + ">"
+	if !xmlNamePattern.MatchString(name) {
+		var key bytes.Buffer
+		_ = xml.EscapeText(&key, []byte(name))
+		name, startTag = "entry", "<entry key=\""+key.String()+"\">"
+	}
+	switch v := value.(type) {
+	case nil:
+		buf.WriteString(strings.TrimSuffix(startTag, ">") + "/>")
+		return
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		buf.WriteString(startTag)
+		for _, key := range keys {
+			writeXMLElement(buf, key, v[key])
+		}
+	case []interface{}:
+		buf.WriteString(startTag)
+		for _, item := range v {
+			writeXMLElement(buf, "item", item)
+		}
+	default:
+		buf.WriteString(startTag)
+		_ = xml.EscapeText(buf, []byte(fmt.Sprint(v)))
+	}
+	buf.WriteString("</" + name + ">")
+}
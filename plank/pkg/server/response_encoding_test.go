@@ -0,0 +1,183 @@
+// Copyright 2019-2021 VMware, Inc.
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/vmware/transport-go/bus"
+	"github.com/vmware/transport-go/model"
+	"github.com/vmware/transport-go/service"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type encodingTestItem struct {
+	Name  string `json:"name" xml:"name,attr"`
+	Count int    `json:"count" xml:"count"`
+}
+
+// serveTestResponse relays the request to a channel answering with the message and returns the recorded response
+func serveTestResponse(t *testing.T, msg *model.Message, accept string) *httptest.ResponseRecorder {
+	b := bus.ResetBus()
+	ps := newMessageBridgeTestServer(b)
+	mb := newTestMessageBridge(t, b, func(request model.Request) *model.Message {
+		return msg
+	})
+	handler := ps.buildEndpointHandler("test-chan", func(w http.ResponseWriter, r *http.Request) model.Request {
+		return model.Request{Id: &uuid.UUID{}, Request: "test-request"}
+	}, 5*time.Second, mb)
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	handler.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestBuildEndpointHandler_SuccessStatusCodes(t *testing.T) {
+	rr := serveTestResponse(t, &model.Message{Payload: &model.Response{
+		StatusCode: http.StatusCreated,
+		Payload:    &encodingTestItem{Name: "apple", Count: 2},
+		Headers:    map[string]string{"Location": "/rest/items/apple"},
+	}}, "")
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	assert.Equal(t, "/rest/items/apple", rr.Header().Get("Location"))
+	assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+	assert.Equal(t, "{\"name\":\"apple\",\"count\":2}", rr.Body.String())
+
+	rr = serveTestResponse(t, &model.Message{Payload: &model.Response{
+		StatusCode: http.StatusNoContent,
+		Payload:    "ignored",
+		Headers:    map[string]string{"Content-Type": "application/json"},
+	}}, "")
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	assert.Empty(t, rr.Header().Get("Content-Type"))
+	assert.Empty(t, rr.Body.String())
+
+	// invalid status codes are ignored
+	rr = serveTestResponse(t, &model.Message{Payload: &model.Response{StatusCode: 42, Payload: "ok"}}, "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "\"ok\"", rr.Body.String())
+}
+
+func TestBuildEndpointHandler_ContentNegotiation(t *testing.T) {
+	item := &encodingTestItem{Name: "apple", Count: 2}
+	newMessage := func() *model.Message {
+		return &model.Message{Payload: &model.Response{Payload: item}}
+	}
+
+	rr := serveTestResponse(t, newMessage(), "application/msgpack")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "application/msgpack", rr.Header().Get("Content-Type"))
+	assert.Equal(t, "Accept", rr.Header().Get("Vary"))
+	assert.Equal(t, []byte{0x82, 0xa4, 'n', 'a', 'm', 'e', 0xa5, 'a', 'p', 'p', 'l', 'e', 0xa5, 'c', 'o', 'u', 'n', 't', 0x02},
+		rr.Body.Bytes())
+
+	rr = serveTestResponse(t, newMessage(), "text/html, application/xml;q=0.9, */*;q=0.1")
+	assert.Equal(t, "application/xml", rr.Header().Get("Content-Type"))
+	assert.Equal(t, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n"+
+		"<encodingTestItem name=\"apple\"><count>2</count></encodingTestItem>", rr.Body.String())
+
+	rr = serveTestResponse(t, &model.Message{Payload: &model.Response{Payload: 42}}, "text/*")
+	assert.Equal(t, "text/plain; charset=utf-8", rr.Header().Get("Content-Type"))
+	assert.Equal(t, "42", rr.Body.String())
+
+	// the service Content-Type is preferred if the client accepts anything
+	rr = serveTestResponse(t, &model.Message{Payload: &model.Response{
+		Payload: map[string]interface{}{"count": 2},
+		Headers: map[string]string{"Content-Type": "application/xml"},
+	}}, "*/*")
+	assert.Equal(t, "application/xml", rr.Header().Get("Content-Type"))
+	assert.Equal(t, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<response><count>2</count></response>", rr.Body.String())
+
+	// payloads encoded by the service are sent as they are
+	rr = serveTestResponse(t, &model.Message{Payload: &model.Response{
+		Payload: "<b>hello</b>",
+		Headers: map[string]string{"Content-Type": "text/html"},
+	}}, "application/json")
+	assert.Equal(t, "text/html", rr.Header().Get("Content-Type"))
+	assert.Equal(t, "<b>hello</b>", rr.Body.String())
+
+	rr = serveTestResponse(t, newMessage(), "image/png")
+	assert.Equal(t, http.StatusNotAcceptable, rr.Code)
+	assert.Equal(t, problemContentType, rr.Header().Get("Content-Type"))
+}
+
+func TestBuildEndpointHandler_ProblemDetails(t *testing.T) {
+	rr := serveTestResponse(t, &model.Message{Payload: &model.Response{
+		Error:     true,
+		ErrorCode: http.StatusNotFound,
+		Payload:   service.GetFabricError("Not Found", 0, "no such item"),
+		Headers:   map[string]string{"Content-Type": "application/json", "X-Item": "apple"},
+	}}, "application/xml")
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.Equal(t, problemContentType, rr.Header().Get("Content-Type"))
+	assert.Equal(t, "apple", rr.Header().Get("X-Item"))
+	var problem service.FabricError
+	assert.Nil(t, json.Unmarshal(rr.Body.Bytes(), &problem))
+	assert.Equal(t, "Not Found", problem.Title)
+	assert.Equal(t, http.StatusNotFound, problem.Status)
+	assert.Equal(t, "no such item", problem.Detail)
+
+	// errors of the bus carry their status if they are problems
+	fabricError := service.GetFabricError("Conflict", http.StatusConflict, "item exists")
+	rr = serveTestResponse(t, &model.Message{Error: &fabricError}, "")
+	assert.Equal(t, http.StatusConflict, rr.Code)
+	assert.Equal(t, problemContentType, rr.Header().Get("Content-Type"))
+
+	rr = serveTestResponse(t, &model.Message{Error: assert.AnError}, "")
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+	assert.Nil(t, json.Unmarshal(rr.Body.Bytes(), &problem))
+	assert.Equal(t, assert.AnError.Error(), problem.Detail)
+
+	// other error payloads are negotiated like successful ones
+	rr = serveTestResponse(t, &model.Message{Payload: &model.Response{
+		Error: true, ErrorCode: http.StatusBadRequest, Payload: "invalid item"}}, "text/plain")
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, "invalid item", rr.Body.String())
+}
+
+func TestNegotiateResponseEncoder(t *testing.T) {
+	contentTypes := func(accept, serviceContentType string) string {
+		if encoder := negotiateResponseEncoder(accept, serviceContentType); encoder != nil {
+			return encoder.contentType
+		}
+		return ""
+	}
+	assert.Equal(t, "application/json", contentTypes("", ""))
+	assert.Equal(t, "application/xml", contentTypes("", "text/xml; charset=utf-8"))
+	assert.Equal(t, "application/json", contentTypes("application/problem+json", ""))
+	assert.Equal(t, "application/msgpack", contentTypes("application/json;q=0.5, application/x-msgpack", ""))
+	assert.Equal(t, "application/json", contentTypes("application/*", ""))
+	assert.Equal(t, "application/xml", contentTypes("application/*", "application/xml"))
+	assert.Equal(t, "", contentTypes("application/json;q=0", ""))
+	assert.Equal(t, "", contentTypes("text/html", ""))
+
+	// the media type of the service is kept unless the client rules it out
+	browserAccept := "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"
+	assert.Equal(t, "application/json", contentTypes(browserAccept, "application/json"))
+	assert.Equal(t, "application/xml", contentTypes(browserAccept, ""))
+	assert.Equal(t, "application/msgpack", contentTypes("application/xml, application/*;q=0.1", "application/msgpack"))
+	assert.Equal(t, "application/xml", contentTypes("application/json;q=0, application/xml;q=0.5", "application/json"))
+	assert.Equal(t, "application/xml", contentTypes("application/json;q=0, text/xml, */*;q=0.5", "application/json"))
+	assert.Equal(t, "application/msgpack", contentTypes("*/*, application/json;q=0", "application/json"))
+	assert.Equal(t, "", contentTypes("text/html", "application/json"))
+}
+
+func TestEncodeXML(t *testing.T) {
+	encoded, err := encodeXML(map[string]interface{}{
+		"items": []interface{}{"a & b", nil}, "2nd key": true, "nested": map[string]int{"n": 1}})
+	assert.Nil(t, err)
+	assert.Equal(t, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<response><entry key=\"2nd key\">true</entry>"+
+		"<items><item>a &amp; b</item><item/></items><nested><n>1</n></nested></response>", string(encoded))
+
+	_, err = encodeXML(func() {})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,249 @@
+// Copyright 2019-2021 VMware, Inc.
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/vmware/transport-go/model"
+	"github.com/vmware/transport-go/plank/utils"
+	"github.com/vmware/transport-go/service"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	ndjsonContentType         = "application/x-ndjson"
+	serverSentEventsMediaType = "text/event-stream"
+)
+
+// getResponseStreamEvent returns the stream event carried by the message, or nil if the message is not part
+// of a response stream.
+func getResponseStreamEvent(msg *model.Message) *service.ResponseStreamEvent {
+	if response, ok := msg.Payload.(*model.Response); ok {
+		if event, ok := response.Payload.(*service.ResponseStreamEvent); ok {
+			return event
+		}
+	}
+	return nil
+}
+
+// responseStreamWriter writes the responses of a service response stream as a chunked HTTP response, either
+// as newline delimited JSON or, if the client accepts them, as server-sent events. every event is flushed
+// as soon as it is written.
+type responseStreamWriter struct {
+	w                http.ResponseWriter
+	controller       *http.ResponseController
+	serverSentEvents bool
+	headerWritten    bool
+}
+
+// maxBufferedStreamEvents is the maximum number of events of a response stream received ahead of the next event
+// to write. events are buffered while waiting for an event which arrives out of order.
+const maxBufferedStreamEvents = 1024
+
+// streamResponse relays the response stream of the request, starting with its first message, until the service
+// closes the stream. the responses of a stream can arrive out of order, they are written in the order of their
+// sequence numbers. if the client disconnects, or the stream doesn't advance for idleTimeout, e.g. because the
+// service doesn't send anything or an event is lost, the stream is canceled by sending a
+// service.CancelResponseStreamRequest to the service.
+func (ps *platformServer) streamResponse(w http.ResponseWriter, r *http.Request, svcChannel string,
+	request *model.Request, first *model.Message, msgChan <-chan *model.Message, idleTimeout time.Duration) {
+
+	sw := &responseStreamWriter{
+		w:                w,
+		controller:       http.NewResponseController(w),
+		serverSentEvents: strings.Contains(r.Header.Get("Accept"), serverSentEventsMediaType),
+	}
+	// streams may outlive the write timeout of the server
+	_ = sw.controller.SetWriteDeadline(time.Time{})
+
+	idleTimer := time.NewTimer(idleTimeout)
+	defer idleTimer.Stop()
+
+	next := 1
+	received := map[int]*model.Message{getResponseStreamEvent(first).Sequence: first}
+	for {
+		advanced := false
+		for msg, ok := received[next]; ok; msg, ok = received[next] {
+			delete(received, next)
+			next++
+			advanced = true
+			if done := sw.write(msg); done {
+				return
+			}
+		}
+		// only the progress of the stream resets the idle timer, events buffered while waiting for
+		// a missing one don't
+		if advanced {
+			if !idleTimer.Stop() {
+				select {
+				case <-idleTimer.C:
+				default:
+				}
+			}
+			idleTimer.Reset(idleTimeout)
+		}
+		if len(received) > maxBufferedStreamEvents {
+			utils.Log.Warnf("[plank] Event %d of response stream of request %s on channel '%s' is missing, canceling the stream",
+				next, request.Id.String(), svcChannel)
+			sw.writeError(http.StatusInternalServerError, fmt.Sprintf("Event %d of the response stream was not received", next))
+			ps.cancelResponseStream(svcChannel, request)
+			return
+		}
+
+		select {
+		case <-r.Context().Done():
+			utils.Log.Debugf("[plank] Client disconnected, canceling response stream of request %s on channel '%s'",
+				request.Id.String(), svcChannel)
+			ps.cancelResponseStream(svcChannel, request)
+			return
+		case <-idleTimer.C:
+			utils.Log.Warnf("[plank] No response received from service channel '%s' in %s, canceling response stream of request %s",
+				svcChannel, idleTimeout.String(), request.Id.String())
+			if len(received) > 0 {
+				sw.writeError(http.StatusInternalServerError,
+					fmt.Sprintf("Event %d of the response stream was not received in %s", next, idleTimeout.String()))
+			} else {
+				sw.writeError(http.StatusInternalServerError,
+					fmt.Sprintf("No response received from service channel in %s, request timed out", idleTimeout.String()))
+			}
+			ps.cancelResponseStream(svcChannel, request)
+			return
+		case msg := <-msgChan:
+			event := getResponseStreamEvent(msg)
+			if event == nil {
+				// errors sent outside the stream, e.g. by a service panicking, end the stream
+				if response, ok := msg.Payload.(*model.Response); ok && response.Error {
+					sw.writeError(response.ErrorCode, response.ErrorMessage)
+					return
+				}
+				utils.Log.Debugf("[plank] Discarding response to request %s on channel '%s' which is not part of its response stream",
+					request.Id.String(), svcChannel)
+				continue
+			}
+			if event.Sequence >= next {
+				received[event.Sequence] = msg
+			}
+		}
+	}
+}
+
+// cancelResponseStream tells the service to stop streaming the response to the request. the cancel request
+// carries the metadata identifying the bridge as the sender of the streamed request.
+func (ps *platformServer) cancelResponseStream(svcChannel string, request *model.Request) {
+	id := uuid.New()
+	cancelRequest := model.Request{Id: &id, Request: service.CancelResponseStreamRequest, Payload: *request.Id}
+	if request.Metadata != nil {
+		cancelRequest.Metadata = &model.RequestMetadata{Transport: request.Metadata.Transport}
+		cancelRequest.Metadata.Set(service.ResponseStreamOwnerKey, request.Metadata.Get(service.ResponseStreamOwnerKey))
+	}
+	if err := ps.eventbus.SendRequestMessage(svcChannel, cancelRequest, &id); err != nil {
+		utils.Log.WithError(err).Warnf("[plank] Unable to cancel response stream of request %s on channel '%s'",
+			request.Id.String(), svcChannel)
+	}
+}
+
+// write writes the message of the stream and returns whether it is the last one.
+func (sw *responseStreamWriter) write(msg *model.Message) bool {
+	response := msg.Payload.(*model.Response)
+	event := response.Payload.(*service.ResponseStreamEvent)
+	if !sw.headerWritten && !response.Error {
+		sw.writeHeader(response.Headers)
+	}
+
+	switch {
+	case response.Error:
+		sw.writeError(response.ErrorCode, response.ErrorMessage)
+	case event.Done:
+		if sw.serverSentEvents {
+			sw.writeServerSentEvent(event.Sequence, "done", "done")
+		}
+	case sw.serverSentEvents:
+		data, ok := event.Data.(string)
+		if !ok {
+			encoded, err := json.Marshal(event.Data)
+			if err != nil {
+				utils.Log.WithError(err).Errorf("[plank] Unable to encode event %d of response stream", event.Sequence)
+				return false
+			}
+			data = string(encoded)
+		}
+		sw.writeServerSentEvent(event.Sequence, event.Event, data)
+	default:
+		line, err := json.Marshal(event.Data)
+		if err != nil {
+			utils.Log.WithError(err).Errorf("[plank] Unable to encode event %d of response stream", event.Sequence)
+			return false
+		}
+		_, _ = sw.w.Write(append(line, '\n'))
+	}
+	_ = sw.controller.Flush()
+	return event.Done || response.Error
+}
+
+// writeHeader sends the headers of the stream. the headers set by the service are kept except for the
+// ones describing the body, which is encoded by the bridge.
+func (sw *responseStreamWriter) writeHeader(headers map[string]string) {
+	for k, v := range headers {
+		switch strings.ToLower(k) {
+		case "content-type", "content-length", "etag":
+		default:
+			sw.w.Header().Set(k, v)
+		}
+	}
+	if sw.serverSentEvents {
+		sw.w.Header().Set("Content-Type", serverSentEventsMediaType)
+	} else {
+		sw.w.Header().Set("Content-Type", ndjsonContentType)
+	}
+	sw.w.Header().Set("Cache-Control", "no-cache")
+	sw.w.WriteHeader(http.StatusOK)
+	sw.headerWritten = true
+}
+
+// writeError ends the stream with an error. errors occurring before anything is written are sent
+// with their status code, later errors are sent as the last line or event of the stream.
+func (sw *responseStreamWriter) writeError(errorCode int, errorMessage string) {
+	if errorCode < 100 || errorCode > 999 {
+		errorCode = http.StatusInternalServerError
+	}
+	body, _ := json.Marshal(map[string]interface{}{
+		"error":        true,
+		"errorCode":    errorCode,
+		"errorMessage": errorMessage,
+	})
+	if !sw.headerWritten {
+		sw.w.Header().Set("Content-Type", "application/json")
+		sw.w.WriteHeader(errorCode)
+		sw.headerWritten = true
+		_, _ = sw.w.Write(body)
+		return
+	}
+	if sw.serverSentEvents {
+		sw.writeServerSentEvent(0, "error", string(body))
+	} else {
+		_, _ = sw.w.Write(append(body, '\n'))
+	}
+	_ = sw.controller.Flush()
+}
+
+// writeServerSentEvent writes an event in the text/event-stream format. multi-line data is split into
+// several data fields.
+func (sw *responseStreamWriter) writeServerSentEvent(id int, event string, data string) {
+	var b strings.Builder
+	if id > 0 {
+		b.WriteString(fmt.Sprintf("id: %d\n", id))
+	}
+	if event = strings.NewReplacer("\r", "", "\n", "").Replace(event); len(event) > 0 {
+		b.WriteString("event: " + event + "\n")
+	}
+	for _, line := range strings.Split(strings.ReplaceAll(data, "\r\n", "\n"), "\n") {
+		b.WriteString("data: " + line + "\n")
+	}
+	b.WriteString("\n")
+	_, _ = sw.w.Write([]byte(b.String()))
+}
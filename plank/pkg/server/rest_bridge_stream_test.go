@@ -0,0 +1,222 @@
+// Copyright 2019-2021 VMware, Inc.
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/vmware/transport-go/bus"
+	"github.com/vmware/transport-go/model"
+	"github.com/vmware/transport-go/service"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// respondWithStream answers the requests sent on test-chan with the responses, in reverse order
+func respondWithStream(b bus.EventBus, responses ...*model.Response) {
+	mh, _ := b.ListenRequestStream("test-chan")
+	mh.Handle(func(message *model.Message) {
+		for i := len(responses) - 1; i >= 0; i-- {
+			response := *responses[i]
+			response.Id = message.DestinationId
+			b.SendResponseMessage("test-chan", &response, message.DestinationId)
+		}
+	}, func(e error) {})
+}
+
+func newStreamResponse(sequence int, event string, data interface{}) *model.Response {
+	return &model.Response{
+		Payload: &service.ResponseStreamEvent{Sequence: sequence, Event: event, Data: data},
+		Headers: map[string]string{"Content-Type": "application/json", "X-Export": "customers"},
+	}
+}
+
+func newStreamCompletion(sequence int) *model.Response {
+	return &model.Response{Payload: &service.ResponseStreamEvent{Sequence: sequence, Done: true}}
+}
+
+func newStreamTestHandler(t *testing.T, b bus.EventBus, idleTimeout time.Duration) http.HandlerFunc {
+	ps := newMessageBridgeTestServer(b)
+	mb, err := newMessageBridge(b, "test-chan")
+	assert.Nil(t, err)
+	return ps.buildEndpointHandler("test-chan", func(w http.ResponseWriter, r *http.Request) model.Request {
+		return model.Request{Id: &uuid.UUID{}, Request: "export"}
+	}, idleTimeout, mb)
+}
+
+func TestBuildEndpointHandler_StreamNDJSON(t *testing.T) {
+	b := bus.ResetBus()
+	handler := newStreamTestHandler(t, b, 5*time.Second)
+	respondWithStream(b,
+		newStreamResponse(1, "", map[string]string{"name": "alice"}),
+		newStreamResponse(2, "", map[string]string{"name": "bob"}),
+		newStreamResponse(3, "progress", 100),
+		newStreamCompletion(4))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "application/x-ndjson", rr.Header().Get("Content-Type"))
+	assert.Equal(t, "customers", rr.Header().Get("X-Export"))
+	assert.Equal(t, "{\"name\":\"alice\"}\n{\"name\":\"bob\"}\n100\n", rr.Body.String())
+	assert.True(t, rr.Flushed)
+}
+
+func TestBuildEndpointHandler_StreamServerSentEvents(t *testing.T) {
+	b := bus.ResetBus()
+	handler := newStreamTestHandler(t, b, 5*time.Second)
+	respondWithStream(b,
+		newStreamResponse(1, "", "line one\nline two"),
+		newStreamResponse(2, "progress", map[string]int{"percent": 100}),
+		newStreamCompletion(3))
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+	req.Header.Set("Accept", "text/event-stream")
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "text/event-stream", rr.Header().Get("Content-Type"))
+	assert.Equal(t, "no-cache", rr.Header().Get("Cache-Control"))
+	assert.Equal(t, "id: 1\ndata: line one\ndata: line two\n\n"+
+		"id: 2\nevent: progress\ndata: {\"percent\":100}\n\n"+
+		"id: 3\nevent: done\ndata: done\n\n", rr.Body.String())
+}
+
+func TestBuildEndpointHandler_StreamError(t *testing.T) {
+	b := bus.ResetBus()
+	handler := newStreamTestHandler(t, b, 5*time.Second)
+	failure := newStreamCompletion(2)
+	failure.Error, failure.ErrorCode, failure.ErrorMessage = true, 503, "export failed"
+	respondWithStream(b, newStreamResponse(1, "", "first"), failure)
+
+	// errors after the first event end the stream
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "\"first\"\n{\"error\":true,\"errorCode\":503,\"errorMessage\":\"export failed\"}\n", rr.Body.String())
+
+	// errors before the first event are sent with their status code
+	b = bus.ResetBus()
+	handler = newStreamTestHandler(t, b, 5*time.Second)
+	failure.Payload = &service.ResponseStreamEvent{Sequence: 1, Done: true}
+	respondWithStream(b, failure)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+	assert.Equal(t, 503, rr.Code)
+	assert.Equal(t, "{\"error\":true,\"errorCode\":503,\"errorMessage\":\"export failed\"}", rr.Body.String())
+}
+
+func TestBuildEndpointHandler_StreamIdleTimeout(t *testing.T) {
+	b := bus.ResetBus()
+	handler := newStreamTestHandler(t, b, 20*time.Millisecond)
+	// the stream is never completed
+	respondWithStream(b, newStreamResponse(1, "", "first"))
+	cancelRequests := listenForCancelRequests(b)
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "\"first\"\n{\"error\":true,\"errorCode\":500,")
+	assert.Contains(t, rr.Body.String(), "request timed out")
+	select {
+	case <-cancelRequests:
+	case <-time.After(time.Second):
+		assert.Fail(t, "stream not canceled")
+	}
+}
+
+func TestBuildEndpointHandler_StreamMissingEvent(t *testing.T) {
+	// the events received after a missing one don't keep the stream alive
+	b := bus.ResetBus()
+	handler := newStreamTestHandler(t, b, 50*time.Millisecond)
+	respondWithStream(b, newStreamResponse(1, "", "first"), newStreamResponse(3, "", "third"), newStreamResponse(4, "", "fourth"))
+	cancelRequests := listenForCancelRequests(b)
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+	assert.Equal(t, "\"first\"\n{\"error\":true,\"errorCode\":500,"+
+		"\"errorMessage\":\"Event 2 of the response stream was not received in 50ms\"}\n", rr.Body.String())
+	select {
+	case <-cancelRequests:
+	case <-time.After(time.Second):
+		assert.Fail(t, "stream not canceled")
+	}
+
+	// the events buffered while waiting for a missing one are bounded
+	b = bus.ResetBus()
+	handler = newStreamTestHandler(t, b, 5*time.Second)
+	responses := []*model.Response{newStreamResponse(1, "", "first")}
+	for i := 3; i <= maxBufferedStreamEvents+3; i++ {
+		responses = append(responses, newStreamResponse(i, "", i))
+	}
+	respondWithStream(b, responses...)
+	cancelRequests = listenForCancelRequests(b)
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+	assert.Contains(t, rr.Body.String(), "Event 2 of the response stream was not received\"}\n")
+	select {
+	case <-cancelRequests:
+	case <-time.After(time.Second):
+		assert.Fail(t, "stream not canceled")
+	}
+}
+
+func listenForCancelRequests(b bus.EventBus) chan model.Request {
+	cancelRequests := make(chan model.Request, 1)
+	mh, _ := b.ListenRequestStream("test-chan")
+	mh.Handle(func(message *model.Message) {
+		if request := message.Payload.(model.Request); request.Request == service.CancelResponseStreamRequest {
+			cancelRequests <- request
+		}
+	}, func(e error) {})
+	return cancelRequests
+}
+
+// TestBuildEndpointHandler_StreamClientDisconnect streams the responses of a real service and checks that the
+// stream is canceled once the client disconnects.
+func TestBuildEndpointHandler_StreamClientDisconnect(t *testing.T) {
+	b := bus.ResetBus()
+	handler := newStreamTestHandler(t, b, 5*time.Second)
+	streams := make(chan *service.ResponseStream, 1)
+	assert.Nil(t, service.GetServiceRegistry().RegisterService(&tickingService{streams: streams}, "test-chan"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	rr := httptest.NewRecorder()
+	handled := make(chan bool)
+	go func() {
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "http://localhost", nil).WithContext(ctx))
+		handled <- true
+	}()
+
+	stream := <-streams
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	select {
+	case <-handled:
+	case <-time.After(time.Second):
+		assert.Fail(t, "handler did not return")
+	}
+	select {
+	case <-stream.Done():
+		assert.True(t, stream.Canceled())
+	case <-time.After(time.Second):
+		assert.Fail(t, "stream not canceled")
+	}
+}
+
+type tickingService struct {
+	streams chan *service.ResponseStream
+}
+
+func (ts *tickingService) HandleServiceRequest(request *model.Request, core service.FabricServiceCore) {
+	stream := core.(service.ResponseStreamer).OpenResponseStream(request)
+	ts.streams <- stream
+	for i := 0; stream.Send(i) == nil; i++ {
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -16,6 +16,7 @@ import (
 	"os/signal"
 	"path"
 	"reflect"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"syscall"
@@ -23,7 +24,6 @@ import (
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
-	"github.com/vmware/transport-go/model"
 
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
@@ -109,6 +109,19 @@ func NewPlatformServerFromConfig(configPath string) (PlatformServer, error) {
 		if !path.IsAbs(config.TLSCertConfig.KeyFile) {
 			config.TLSCertConfig.KeyFile = path.Clean(path.Join(config.RootDir, config.TLSCertConfig.KeyFile))
 		}
+
+		if config.TLSCertConfig.ClientCAFile != "" && !path.IsAbs(config.TLSCertConfig.ClientCAFile) {
+			config.TLSCertConfig.ClientCAFile = path.Clean(path.Join(config.RootDir, config.TLSCertConfig.ClientCAFile))
+		}
+	}
+
+	if config.AuthConfig != nil && config.AuthConfig.JWT != nil && config.AuthConfig.JWT.JWKSFile != "" &&
+		!path.IsAbs(config.AuthConfig.JWT.JWKSFile) {
+		config.AuthConfig.JWT.JWKSFile = path.Clean(path.Join(config.RootDir, config.AuthConfig.JWT.JWKSFile))
+	}
+
+	if config.TracingConfig != nil && config.TracingConfig.File != "" && !path.IsAbs(config.TracingConfig.File) {
+		config.TracingConfig.File = path.Clean(path.Join(config.RootDir, config.TracingConfig.File))
 	}
 
 	ps.serverConfig = &config
@@ -262,8 +275,10 @@ func (ps *platformServer) StopServer() {
 		utils.Log.Errorln(err)
 	}
 
+	// drain the fabric endpoint so the connected clients are notified of the shutdown and
+	// messages already in flight get delivered before the connections are closed
 	if ps.fabricConn != nil {
-		err = ps.eventbus.StopFabricEndpoint()
+		err = ps.eventbus.DrainFabricEndpoint(shutdownCtx)
 		if err != nil {
 			utils.Log.Errorln(err)
 		}
@@ -273,15 +288,23 @@ func (ps *platformServer) StopServer() {
 	// wait for all teardown jobs to be done. if shutdown deadline arrives earlier
 	// the main thread will be terminated forcefully
 	wg.Wait()
+
+	// export the spans recorded during the shutdown
+	ps.shutdownTracing(shutdownCtx)
 }
 
 // SetStaticRoute adds a route where static resources will be served
 func (ps *platformServer) SetStaticRoute(prefix, fullpath string, middlewareFn ...mux.MiddlewareFunc) {
+	ps.setFileSystemRoute(prefix, http.Dir(fullpath), middlewareFn...)
+}
+
+// setFileSystemRoute serves the files of fileSystem under the prefix. directories without an index.html are not listed.
+func (ps *platformServer) setFileSystemRoute(prefix string, fileSystem http.FileSystem, middlewareFn ...mux.MiddlewareFunc) {
 	ps.router.Handle(prefix, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, prefix+"/", http.StatusMovedPermanently)
 	}))
 
-	ndir := NoDirFileSystem{http.Dir(fullpath)}
+	ndir := NoDirFileSystem{fileSystem}
 	endpointHandlerMapKey := prefix + "*"
 	compositeHandler := http.StripPrefix(prefix, middleware.BasicSecurityHeaderMiddleware()(http.FileServer(ndir)))
 
@@ -295,8 +318,16 @@ func (ps *platformServer) SetStaticRoute(prefix, fullpath string, middlewareFn .
 
 // RegisterService registers a Fabric service with Bifrost
 func (ps *platformServer) RegisterService(svc service.FabricService, svcChannel string) error {
+	return ps.RegisterServiceWithOptions(svc, svcChannel, nil)
+}
+
+// RegisterServiceWithOptions registers a Fabric service with Bifrost. The options control how the requests
+// are delivered to the service, e.g. the maximum number of requests handled concurrently.
+func (ps *platformServer) RegisterServiceWithOptions(
+	svc service.FabricService, svcChannel string, options *service.ServiceOptions) error {
+
 	sr := service.GetServiceRegistry()
-	err := sr.RegisterService(svc, svcChannel)
+	err := sr.RegisterServiceWithOptions(svc, svcChannel, options)
 	svcType := reflect.TypeOf(svc)
 
 	if err == nil {
@@ -334,15 +365,13 @@ func (ps *platformServer) SetHttpChannelBridge(bridgeConfig *service.RESTBridgeC
 	}
 
 	if _, exists := ps.messageBridgeMap[bridgeConfig.ServiceChannel]; !exists {
-		handler, _ := ps.eventbus.ListenStream(bridgeConfig.ServiceChannel)
-		handler.Handle(func(message *model.Message) {
-			ps.messageBridgeMap[bridgeConfig.ServiceChannel].payloadChannel <- message
-		}, func(err error) {})
-
-		ps.messageBridgeMap[bridgeConfig.ServiceChannel] = &MessageBridge{
-			ServiceListenStream: handler,
-			payloadChannel:      make(chan *model.Message, 100),
+		messageBridge, err := newMessageBridge(ps.eventbus, bridgeConfig.ServiceChannel)
+		if err != nil {
+			utils.Log.WithError(err).Errorf(
+				"[plank] Unable to bridge service channel '%s' to REST endpoints", bridgeConfig.ServiceChannel)
+			return
 		}
+		ps.messageBridgeMap[bridgeConfig.ServiceChannel] = messageBridge
 	}
 
 	// NOTE: mux.Router does not have mutex or any locking mechanism so it could sometimes lead to concurrency write
@@ -352,27 +381,31 @@ func (ps *platformServer) SetHttpChannelBridge(bridgeConfig *service.RESTBridgeC
 		time.Sleep(1 * time.Nanosecond)
 	}
 
-	// build endpoint handler
-	ps.endpointHandlerMap[endpointHandlerKey] = ps.buildEndpointHandler(
-		bridgeConfig.ServiceChannel,
-		bridgeConfig.FabricRequestBuilder,
-		ps.serverConfig.RestBridgeTimeout,
-		ps.messageBridgeMap[bridgeConfig.ServiceChannel].payloadChannel)
-
-	ps.serviceChanToBridgeEndpoints[bridgeConfig.ServiceChannel] = append(
-		ps.serviceChanToBridgeEndpoints[bridgeConfig.ServiceChannel], endpointHandlerKey)
-
 	permittedMethods := []string{bridgeConfig.Method}
 	if bridgeConfig.AllowHead {
 		permittedMethods = append(permittedMethods, http.MethodHead)
 	}
+
+	// build endpoint handler
+	ps.endpointHandlerMap[endpointHandlerKey] = ps.authenticate(ps.buildBridgeHandler(
+		bridgeConfig, ps.messageBridgeMap[bridgeConfig.ServiceChannel]))
 	if bridgeConfig.AllowOptions {
 		permittedMethods = append(permittedMethods, http.MethodOptions)
+	} else {
+		// OPTIONS requests are still routed to the bridge so that a CORS middleware set through MiddlewareManager
+		// can answer the preflight requests made for the bridge. the rest are rejected with 405
+		ps.endpointHandlerMap[endpointHandlerKey] = rejectOptionsHandler(
+			ps.endpointHandlerMap[endpointHandlerKey], permittedMethods)
 	}
 
+	ps.serviceChanToBridgeEndpoints[bridgeConfig.ServiceChannel] = append(
+		ps.serviceChanToBridgeEndpoints[bridgeConfig.ServiceChannel], endpointHandlerKey)
+	ps.bridgeConfigMap[endpointHandlerKey] = bridgeConfig
+
 	ps.router.
 		Path(bridgeConfig.Uri).
-		Methods(permittedMethods...).
+		Methods(append(permittedMethods, http.MethodOptions)...).
+		MatcherFunc(matchPreflightRequests(permittedMethods)).
 		Name(fmt.Sprintf("%s-%s", bridgeConfig.Uri, bridgeConfig.Method)).
 		Handler(ps.endpointHandlerMap[endpointHandlerKey])
 	if !atomic.CompareAndSwapInt32(ps.routerConcurrencyProtection, 1, 0) {
@@ -386,6 +419,7 @@ func (ps *platformServer) SetHttpChannelBridge(bridgeConfig *service.RESTBridgeC
 
 // SetHttpPathPrefixChannelBridge establishes a conduit between the transport service channel and a path prefix
 // every request on this prefix will be sent through to the target service, all methods, all sub paths, lock, stock and barrel.
+// OPTIONS requests are only sent through if the bridge config allows them.
 func (ps *platformServer) SetHttpPathPrefixChannelBridge(bridgeConfig *service.RESTBridgeConfig) {
 	ps.lock.Lock()
 	defer ps.lock.Unlock()
@@ -404,26 +438,28 @@ func (ps *platformServer) SetHttpPathPrefixChannelBridge(bridgeConfig *service.R
 	}
 
 	if _, exists := ps.messageBridgeMap[bridgeConfig.ServiceChannel]; !exists {
-		handler, _ := ps.eventbus.ListenStream(bridgeConfig.ServiceChannel)
-		handler.Handle(func(message *model.Message) {
-			ps.messageBridgeMap[bridgeConfig.ServiceChannel].payloadChannel <- message
-		}, func(err error) {})
-
-		ps.messageBridgeMap[bridgeConfig.ServiceChannel] = &MessageBridge{
-			ServiceListenStream: handler,
-			payloadChannel:      make(chan *model.Message, 100),
+		messageBridge, err := newMessageBridge(ps.eventbus, bridgeConfig.ServiceChannel)
+		if err != nil {
+			utils.Log.WithError(err).Errorf(
+				"[plank] Unable to bridge service channel '%s' to REST endpoints", bridgeConfig.ServiceChannel)
+			return
 		}
+		ps.messageBridgeMap[bridgeConfig.ServiceChannel] = messageBridge
 	}
 
 	// build endpoint handler
-	ps.endpointHandlerMap[endpointHandlerKey] = ps.buildEndpointHandler(
-		bridgeConfig.ServiceChannel,
-		bridgeConfig.FabricRequestBuilder,
-		ps.serverConfig.RestBridgeTimeout,
-		ps.messageBridgeMap[bridgeConfig.ServiceChannel].payloadChannel)
+	ps.endpointHandlerMap[endpointHandlerKey] = ps.authenticate(ps.buildBridgeHandler(
+		bridgeConfig, ps.messageBridgeMap[bridgeConfig.ServiceChannel]))
+	if !bridgeConfig.AllowOptions {
+		// like for the other bridges, preflight requests can be answered by a CORS middleware set through
+		// MiddlewareManager and the other OPTIONS requests are rejected
+		ps.endpointHandlerMap[endpointHandlerKey] = rejectOptionsHandler(ps.endpointHandlerMap[endpointHandlerKey],
+			[]string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete})
+	}
 
 	ps.serviceChanToBridgeEndpoints[bridgeConfig.ServiceChannel] = append(
 		ps.serviceChanToBridgeEndpoints[bridgeConfig.ServiceChannel], endpointHandlerKey)
+	ps.bridgeConfigMap[endpointHandlerKey] = bridgeConfig
 
 	// NOTE: mux.Router does not have mutex or any locking mechanism so it could sometimes lead to concurrency write
 	// panics. the following is to ensure the modification to ps.router can happen only once per thread
@@ -496,7 +532,10 @@ func (ps *platformServer) clearHttpChannelBridgesForService(serviceChannel strin
 		if lookupMap[name] {
 			utils.Log.Debugf("[plank] route '%s' will be overridden so not copying over to the new router instance", name)
 		} else {
-			newRouter.Name(name).Path(path).Methods(methods...).Handler(handler)
+			route := newRouter.Name(name).Path(path).Methods(methods...).Handler(handler)
+			if _, isBridge := ps.bridgeConfigMap[name]; isBridge {
+				route.MatcherFunc(matchPreflightRequests(methods))
+			}
 		}
 		return nil
 	})
@@ -507,6 +546,7 @@ func (ps *platformServer) clearHttpChannelBridgesForService(serviceChannel strin
 	for _, handlerKey := range existingMappings {
 		utils.Log.Infof("[plank] Removing existing service - REST mapping '%s' for service '%s'", handlerKey, serviceChannel)
 		delete(ps.endpointHandlerMap, handlerKey)
+		delete(ps.bridgeConfigMap, handlerKey)
 	}
 	return newRouter
 }
@@ -523,11 +563,56 @@ func (ps *platformServer) loadGlobalHttpHandler(h *mux.Router) {
 	ps.lock.Lock()
 	defer ps.lock.Unlock()
 	ps.router = h
+
+	// the CORS policy wraps the router instead of being set as a router middleware so that preflight requests
+	// are answered for every route, regardless of the methods the route was registered with
+	var handler http.Handler = ps.router
+	if ps.serverConfig.CorsPolicy != nil {
+		handler = middleware.CorsMiddleware(ps.serverConfig.CorsPolicy)(handler)
+	}
 	ps.HttpServer.Handler = handlers.RecoveryHandler()(
 		handlers.CompressHandler(
 			handlers.ProxyHeaders(
 				handlers.CombinedLoggingHandler(
-					ps.serverConfig.LogConfig.GetAccessLogFilePointer(), ps.router))))
+					ps.serverConfig.LogConfig.GetAccessLogFilePointer(), handler))))
+}
+
+// authenticate wraps the REST bridge handler with the authentication middleware, if authentication is configured
+func (ps *platformServer) authenticate(handler http.HandlerFunc) http.HandlerFunc {
+	if ps.authMiddleware == nil {
+		return handler
+	}
+	return ps.authMiddleware(handler).ServeHTTP
+}
+
+// rejectOptionsHandler answers OPTIONS requests with 405 Method Not Allowed and passes the others to the handler
+func rejectOptionsHandler(handler http.HandlerFunc, permittedMethods []string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions {
+			w.Header().Set("Allow", strings.Join(permittedMethods, ", "))
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// matchPreflightRequests matches the requests other than CORS preflight requests, and the preflight requests
+// made for one of the methods. preflight requests are routed to the bridge of the method they are made for,
+// not to the first bridge of the URI
+func matchPreflightRequests(methods []string) mux.MatcherFunc {
+	return func(r *http.Request, _ *mux.RouteMatch) bool {
+		requestedMethod := r.Header.Get("Access-Control-Request-Method")
+		if r.Method != http.MethodOptions || r.Header.Get("Origin") == "" || requestedMethod == "" {
+			return true
+		}
+		for _, method := range methods {
+			if method == requestedMethod {
+				return true
+			}
+		}
+		return false
+	}
 }
 
 func (ps *platformServer) checkPortAvailability() {
@@ -174,6 +174,17 @@ func TestSmokeTests_HealthEndpoint(t *testing.T) {
 			assert.Contains(t, string(bodyBytes), "OK")
 		})
 
+		t.Run("/health/ready returns JSON report", func(t2 *testing.T) {
+			cl := http.DefaultClient
+			rsp, err := cl.Get(fmt.Sprintf("%s/health/ready", baseUrl))
+			assert.Nil(t2, err)
+			defer rsp.Body.Close()
+			bodyBytes, _ := ioutil.ReadAll(rsp.Body)
+			assert.EqualValues(t2, http.StatusOK, rsp.StatusCode)
+			assert.Contains(t2, string(bodyBytes), `"status":"UP"`)
+			assert.Equal(t2, "no-store", rsp.Header.Get("Cache-Control"))
+		})
+
 		testServer.StopServer()
 		wg.Done()
 	})
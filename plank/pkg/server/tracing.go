@@ -0,0 +1,107 @@
+// Copyright 2019-2021 VMware, Inc.
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"github.com/vmware/transport-go/plank/utils"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"os"
+	"strings"
+)
+
+// exporters of TracingConfig.Exporter
+const (
+	TracingExporterStdout = "stdout"
+	TracingExporterFile   = "file"
+	TracingExporterNone   = "none"
+)
+
+// configureTracing installs the tracer provider recording the spans of the requests handled by the server
+// as the global OpenTelemetry tracer provider
+func (ps *platformServer) configureTracing() error {
+	config := ps.serverConfig.TracingConfig
+	exporter, err := newSpanExporter(config)
+	if err != nil {
+		return err
+	}
+
+	serviceName := config.ServiceName
+	if serviceName == "" {
+		serviceName = "plank"
+	}
+	sampleRatio := config.SampleRatio
+	if sampleRatio <= 0 || sampleRatio > 1 {
+		sampleRatio = 1
+	}
+
+	options := []sdktrace.TracerProviderOption{
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(serviceName))),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))),
+	}
+	if exporter != nil {
+		options = append(options, sdktrace.WithBatcher(exporter))
+	}
+	ps.tracerProvider = sdktrace.NewTracerProvider(options...)
+	otel.SetTracerProvider(ps.tracerProvider)
+	return nil
+}
+
+// shutdownTracing exports the spans which are still buffered and stops the tracer provider
+func (ps *platformServer) shutdownTracing(ctx context.Context) {
+	if ps.tracerProvider == nil {
+		return
+	}
+	if err := ps.tracerProvider.Shutdown(ctx); err != nil {
+		utils.Log.WithError(err).Errorln("[plank] Failed to export the remaining spans")
+	}
+}
+
+// newSpanExporter returns the exporter of the spans configured, nil if the spans should not be exported
+func newSpanExporter(config *TracingConfig) (sdktrace.SpanExporter, error) {
+	if config.SpanExporter != nil {
+		return config.SpanExporter, nil
+	}
+
+	switch strings.ToLower(config.Exporter) {
+	case "", TracingExporterStdout:
+		return stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case TracingExporterFile:
+		if config.File == "" {
+			return nil, fmt.Errorf("no file configured for the %s span exporter", TracingExporterFile)
+		}
+		file, err := os.OpenFile(config.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(file))
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		return &fileSpanExporter{SpanExporter: exporter, file: file}, nil
+	case TracingExporterNone:
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unknown span exporter %s", config.Exporter)
+}
+
+// fileSpanExporter closes the file the spans are written to when the exporter is shut down
+type fileSpanExporter struct {
+	sdktrace.SpanExporter
+	file *os.File
+}
+
+func (e *fileSpanExporter) Shutdown(ctx context.Context) error {
+	err := e.SpanExporter.Shutdown(ctx)
+	if closeErr := e.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
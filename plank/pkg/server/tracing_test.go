@@ -0,0 +1,68 @@
+// Copyright 2019-2021 VMware, Inc.
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/vmware/transport-go/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPlatformServer_ConfigureTracing(t *testing.T) {
+	defer otel.SetTracerProvider(trace.NewNoopTracerProvider())
+
+	spansFile := filepath.Join(t.TempDir(), "spans.json")
+	ps := &platformServer{serverConfig: &PlatformServerConfig{
+		TracingConfig: &TracingConfig{Exporter: TracingExporterFile, File: spansFile, ServiceName: "test-service"},
+	}}
+	assert.Nil(t, ps.configureTracing())
+	assert.Equal(t, ps.tracerProvider, otel.GetTracerProvider())
+
+	_, span := tracing.Tracer().Start(context.Background(), "test-span")
+	span.End()
+	ps.shutdownTracing(context.Background())
+
+	data, err := os.ReadFile(spansFile)
+	assert.Nil(t, err)
+	var exported struct {
+		Name     string
+		Resource []map[string]interface{}
+	}
+	assert.Nil(t, json.Unmarshal(data, &exported))
+	assert.Equal(t, "test-span", exported.Name)
+	assert.Contains(t, exported.Resource, map[string]interface{}{
+		"Key": "service.name", "Value": map[string]interface{}{"Type": "STRING", "Value": "test-service"}})
+}
+
+func TestPlatformServer_ConfigureTracingExporters(t *testing.T) {
+	defer otel.SetTracerProvider(trace.NewNoopTracerProvider())
+
+	// custom exporters take precedence
+	exporter := tracetest.NewInMemoryExporter()
+	ps := &platformServer{serverConfig: &PlatformServerConfig{
+		TracingConfig: &TracingConfig{Exporter: "unknown", SpanExporter: exporter},
+	}}
+	assert.Nil(t, ps.configureTracing())
+	_, span := tracing.Tracer().Start(context.Background(), "test-span")
+	span.End()
+	assert.Nil(t, ps.tracerProvider.ForceFlush(context.Background()))
+	assert.Len(t, exporter.GetSpans(), 1)
+	ps.shutdownTracing(context.Background())
+
+	ps.serverConfig.TracingConfig = &TracingConfig{Exporter: TracingExporterNone}
+	assert.Nil(t, ps.configureTracing())
+
+	ps.serverConfig.TracingConfig = &TracingConfig{Exporter: "unknown"}
+	assert.EqualError(t, ps.configureTracing(), "unknown span exporter unknown")
+
+	ps.serverConfig.TracingConfig = &TracingConfig{Exporter: TracingExporterFile}
+	assert.EqualError(t, ps.configureTracing(), "no file configured for the file span exporter")
+}
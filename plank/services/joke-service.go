@@ -21,12 +21,16 @@ type Joke struct {
 
 // JokeService will return a terrible joke, on demand.
 type JokeService struct {
-	core service.FabricServiceCore
+	core   service.FabricServiceCore
+	router *service.RequestRouter
 }
 
 // NewJokeService will return an instance of JokeService.
 func NewJokeService() *JokeService {
-	return &JokeService{}
+	js := &JokeService{}
+	js.router = service.NewRequestRouter().
+		HandleWithDescription("get-joke", "returns a terrible joke", nil, js.getJoke)
+	return js
 }
 
 // Init will fire when the service is being registered by the fabric, it passes a reference of the same core
@@ -44,16 +48,16 @@ func (js *JokeService) Init(core service.FabricServiceCore) error {
 // HandleServiceRequest will listen for incoming requests with the command 'get-joke' and will then return a terrible
 // Joke back to the requesting component.
 func (js *JokeService) HandleServiceRequest(request *model.Request, core service.FabricServiceCore) {
-	switch request.Request {
-	case "get-joke":
-		js.getJoke(request, core)
-	default:
-		core.HandleUnknownRequest(request)
-	}
+	js.router.HandleServiceRequest(request, core)
+}
+
+// SupportedCommands returns the requests supported by the service.
+func (js *JokeService) SupportedCommands() []service.RequestCommand {
+	return js.router.SupportedCommands()
 }
 
 // getJoke calls our terrible joke service, and returns the response or error back to the requester.
-func (js *JokeService) getJoke(request *model.Request, core service.FabricServiceCore) {
+func (js *JokeService) getJoke(request *model.Request, payload interface{}, core service.FabricServiceCore) {
 
 	// make API call using inbuilt RestService to make network calls.
 	core.RestServiceRequest(&service.RestServiceRequest{
@@ -84,6 +88,8 @@ func (js *JokeService) GetRESTBridgeConfig() []*service.RESTBridgeConfig {
 			Method:         http.MethodGet,
 			AllowHead:      true,
 			AllowOptions:   true,
+			Summary:        "Get a terrible joke",
+			ResponseType:   reflect.TypeOf(&Joke{}),
 			FabricRequestBuilder: func(w http.ResponseWriter, r *http.Request) model.Request {
 				return model.Request{
 					Id:                &uuid.UUID{},
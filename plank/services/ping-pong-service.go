@@ -4,7 +4,6 @@
 package services
 
 import (
-	"encoding/json"
 	"fmt"
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
@@ -12,6 +11,7 @@ import (
 	"github.com/vmware/transport-go/service"
 	"io/ioutil"
 	"net/http"
+	"reflect"
 	"time"
 )
 
@@ -23,11 +23,21 @@ const (
 // this service has two requests named "ping-post" and "ping-get", the first accepts the payload and expects it to be of
 // a POJO type (e.g. {"anything": "here"}), whereas the second expects the payload to be a pure string.
 // a request made through the Event Bus API like bus.RequestOnce() will be routed to HandleServiceRequest()
-// which will match the request's Request to the list of available service request types and return the response.
-type PingPongService struct{}
+// which passes it to the service.RequestRouter to invoke the handler registered for the request's Request.
+type PingPongService struct {
+	router *service.RequestRouter
+}
 
 func NewPingPongService() *PingPongService {
-	return &PingPongService{}
+	ps := &PingPongService{}
+	ps.router = service.NewRequestRouter().
+		// ping-post request type accepts the payload as a POJO
+		HandleWithDescription("ping-post", "echoes the posted object with a timestamp",
+			reflect.TypeOf(map[string]interface{}{}), ps.pingPost).
+		// ping-get request type accepts the payload as a string
+		HandleWithDescription("ping-get", "echoes the message with a timestamp",
+			reflect.TypeOf(""), ps.pingGet)
+	return ps
 }
 
 // Init will fire when the service is being registered by the fabric, it passes a reference of the same core
@@ -42,30 +52,34 @@ func (ps *PingPongService) Init(core service.FabricServiceCore) error {
 	return nil
 }
 
-// HandleServiceRequest routes the incoming request and based on the Request property of request, it invokes the
-// appropriate handler logic defined and separated by a switch statement like the one shown below.
+// HandleServiceRequest routes the incoming request to the handler registered with the request router for
+// the Request property of request. requests of unknown types are rejected by the router.
 func (ps *PingPongService) HandleServiceRequest(request *model.Request, core service.FabricServiceCore) {
-	switch request.Request {
-	// ping-post request type accepts the payload as a POJO
-	case "ping-post":
-		m := make(map[string]interface{})
-		m["timestamp"] = time.Now().Unix()
-		err := json.Unmarshal(request.Payload.([]byte), &m)
-		if err != nil {
-			core.SendErrorResponse(request, 400, err.Error())
-		} else {
-			core.SendResponse(request, m)
-		}
-	// ping-get request type accepts the payload as a string
-	case "ping-get":
-		rsp := make(map[string]interface{})
-		val := request.Payload.(string)
-		rsp["payload"] = val + "-response"
-		rsp["timestamp"] = time.Now().Unix()
-		core.SendResponse(request, rsp)
-	default:
-		core.HandleUnknownRequest(request)
+	ps.router.HandleServiceRequest(request, core)
+}
+
+// SupportedCommands returns the requests supported by the service, advertised to the other cluster nodes
+// when cluster discovery is enabled.
+func (ps *PingPongService) SupportedCommands() []service.RequestCommand {
+	return ps.router.SupportedCommands()
+}
+
+func (ps *PingPongService) pingPost(request *model.Request, payload interface{}, core service.FabricServiceCore) {
+	// copy the posted object, the payload may be shared with the caller
+	posted := payload.(map[string]interface{})
+	rsp := make(map[string]interface{}, len(posted)+1)
+	for k, v := range posted {
+		rsp[k] = v
 	}
+	rsp["timestamp"] = time.Now().Unix()
+	core.SendResponse(request, rsp)
+}
+
+func (ps *PingPongService) pingGet(request *model.Request, payload interface{}, core service.FabricServiceCore) {
+	rsp := make(map[string]interface{})
+	rsp["payload"] = payload.(string) + "-response"
+	rsp["timestamp"] = time.Now().Unix()
+	core.SendResponse(request, rsp)
 }
 
 // OnServiceReady contains logic that handles the service initialization that needs to be carried out
@@ -102,6 +116,9 @@ func (ps *PingPongService) GetRESTBridgeConfig() []*service.RESTBridgeConfig {
 			Method:         http.MethodPost,
 			AllowHead:      true,
 			AllowOptions:   true,
+			Summary:        "Echo the JSON object posted with a timestamp",
+			RequestType:    reflect.TypeOf(map[string]interface{}{}),
+			ResponseType:   reflect.TypeOf(map[string]interface{}{}),
 			FabricRequestBuilder: func(w http.ResponseWriter, r *http.Request) model.Request {
 				body, _ := ioutil.ReadAll(r.Body)
 				return model.CreateServiceRequest("ping-post", body)
@@ -113,6 +130,8 @@ func (ps *PingPongService) GetRESTBridgeConfig() []*service.RESTBridgeConfig {
 			Method:         http.MethodGet,
 			AllowHead:      true,
 			AllowOptions:   true,
+			Summary:        "Echo the message query parameter",
+			QueryParams:    []string{"message"},
 			FabricRequestBuilder: func(w http.ResponseWriter, r *http.Request) model.Request {
 				return model.Request{Id: &uuid.UUID{}, Request: "ping-get", Payload: r.URL.Query().Get("message")}
 			},
@@ -121,6 +140,7 @@ func (ps *PingPongService) GetRESTBridgeConfig() []*service.RESTBridgeConfig {
 			ServiceChannel: PingPongServiceChan,
 			Uri:            "/rest/ping-pong/{from}/{to}/{message}",
 			Method:         http.MethodGet,
+			Summary:        "Echo a message sent from someone to someone",
 			FabricRequestBuilder: func(w http.ResponseWriter, r *http.Request) model.Request {
 				pathParams := mux.Vars(r)
 				return model.Request{
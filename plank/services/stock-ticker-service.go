@@ -9,6 +9,7 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"reflect"
 	"sync"
 	"time"
 
@@ -49,49 +50,60 @@ type TickerSnapshotData struct {
 type StockTickerService struct {
 	tickerListenersMap map[string]*time.Ticker
 	lock               sync.RWMutex
+	router             *service.RequestRouter
 }
 
 // NewStockTickerService returns a new instance of StockTickerService
 func NewStockTickerService() *StockTickerService {
-	return &StockTickerService{
+	ps := &StockTickerService{
 		tickerListenersMap: make(map[string]*time.Ticker),
 	}
+	// both requests accept a {"symbol": "<TICKER_SYMBOL>"} payload
+	ps.router = service.NewRequestRouter().
+		HandleWithDescription("ticker_price_lookup", "looks up the current price of a stock",
+			reflect.TypeOf(map[string]string{}), ps.lookupTickerPrice).
+		HandleWithDescription("ticker_price_update_stream", "streams the price of a stock every thirty seconds",
+			reflect.TypeOf(map[string]string{}), ps.streamTickerPrice)
+	return ps
 }
 
 // HandleServiceRequest accepts incoming requests and schedules a job to fetch stock price from
 // a third party API and return the results back to the user.
 func (ps *StockTickerService) HandleServiceRequest(request *model.Request, core service.FabricServiceCore) {
-	switch request.Request {
-	case "ticker_price_lookup":
-		input := request.Payload.(map[string]string)
-		response, err := queryStockTickerAPI(input["symbol"])
-		if err != nil {
-			core.SendErrorResponse(request, 400, err.Error())
-			return
-		}
-		// send the response back to the client
-		core.SendResponse(request, response)
-		break
-
-	case "ticker_price_update_stream":
-		// parse the request and extract user input from key "symbol"
-		input := request.Payload.(map[string]interface{})
-		symbol := input["symbol"].(string)
-
-		// get the price immediately for the first request
-		response, err := queryStockTickerAPI(symbol)
-		if err != nil {
-			core.SendErrorResponse(request, 400, err.Error())
-			return
-		}
-		// send the response back to the client
-		core.SendResponse(request, response)
+	ps.router.HandleServiceRequest(request, core)
+}
+
+// SupportedCommands returns the requests supported by the service.
+func (ps *StockTickerService) SupportedCommands() []service.RequestCommand {
+	return ps.router.SupportedCommands()
+}
+
+func (ps *StockTickerService) lookupTickerPrice(request *model.Request, payload interface{}, core service.FabricServiceCore) {
+	input := payload.(map[string]string)
+	response, err := queryStockTickerAPI(input["symbol"])
+	if err != nil {
+		core.SendErrorResponse(request, 400, err.Error())
+		return
+	}
+	// send the response back to the client
+	core.SendResponse(request, response)
+}
 
-		// set a ticker that fires every 30 seconds and keep it in a map for later disposal
-		ps.subscribeToTickerUpdates(symbol, request, core)
-	default:
-		core.HandleUnknownRequest(request)
+func (ps *StockTickerService) streamTickerPrice(request *model.Request, payload interface{}, core service.FabricServiceCore) {
+	// extract user input from key "symbol"
+	symbol := payload.(map[string]string)["symbol"]
+
+	// get the price immediately for the first request
+	response, err := queryStockTickerAPI(symbol)
+	if err != nil {
+		core.SendErrorResponse(request, 400, err.Error())
+		return
 	}
+	// send the response back to the client
+	core.SendResponse(request, response)
+
+	// set a ticker that fires every 30 seconds and keep it in a map for later disposal
+	ps.subscribeToTickerUpdates(symbol, request, core)
 }
 
 // OnServiceReady sets up a listener to monitor the client STOMP sessions disconnecting from
@@ -140,6 +152,7 @@ func (ps *StockTickerService) GetRESTBridgeConfig() []*service.RESTBridgeConfig
 			Method:         http.MethodGet,
 			AllowHead:      true,
 			AllowOptions:   true,
+			Summary:        "Look up the current price of a stock",
 			FabricRequestBuilder: func(w http.ResponseWriter, r *http.Request) model.Request {
 				pathParams := mux.Vars(r)
 				return model.Request{
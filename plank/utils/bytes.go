@@ -11,6 +11,9 @@ import (
 // ConvertInterfaceToByteArray converts the interface i into a byte array. Depending on the
 // value of mimeType being of JSON type, either JSON Marshaller is used or the interface is
 // just straight cast to a byte array.
+//
+// Deprecated: the REST bridges encode the responses according to the Accept header of the request
+// and no longer use it. Use json.Marshal to encode JSON values.
 func ConvertInterfaceToByteArray(mimeType string, i interface{}) (results []byte, err error) {
 	// use JSON Marshaller for application/json mime type
 	if strings.Contains(mimeType, "json") {
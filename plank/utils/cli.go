@@ -97,6 +97,10 @@ var PlatformServerFlagConstants = map[string]map[string]string{
 		"FlagName":    "prometheus",
 		"Description": "Enable Prometheus for basic runtime metrics",
 	},
+	"OpenAPI": {
+		"FlagName":    "openapi",
+		"Description": "Serve the OpenAPI description of the REST bridges at /openapi.json and an API explorer at /openapi",
+	},
 	"RestBridgeTimeout": {
 		"FlagName":    "rest-bridge-timeout",
 		"Description": "Time in minutes before a REST endpoint for a service request to timeout",
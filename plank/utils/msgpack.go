@@ -0,0 +1,25 @@
+// Copyright 2019-2021 VMware, Inc.
+// SPDX-License-Identifier: BSD-2-Clause
+
+package utils
+
+import (
+	"bytes"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// EncodeMessagePack encodes the value in the MessagePack format (https://msgpack.org). the json tags of struct
+// fields are honored, integers are encoded in their smallest representation and the keys of map[string]interface{}
+// and map[string]string values are sorted. unlike with JSON, []byte values are encoded as MessagePack binaries
+// and time.Time values as timestamps, and json.Marshaler implementations are not used.
+func EncodeMessagePack(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := msgpack.NewEncoder(&buf)
+	encoder.SetCustomStructTag("json")
+	encoder.UseCompactInts(true)
+	encoder.SetSortMapKeys(true)
+	if err := encoder.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
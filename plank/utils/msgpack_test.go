@@ -0,0 +1,53 @@
+// Copyright 2019-2021 VMware, Inc.
+// SPDX-License-Identifier: BSD-2-Clause
+
+package utils
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestEncodeMessagePack(t *testing.T) {
+	tests := []struct {
+		value    interface{}
+		expected []byte
+	}{
+		{nil, []byte{0xc0}},
+		{true, []byte{0xc3}},
+		{false, []byte{0xc2}},
+		{5, []byte{0x05}},
+		{-3, []byte{0xfd}},
+		{200, []byte{0xcc, 0xc8}},
+		{-100, []byte{0xd0, 0x9c}},
+		{1000, []byte{0xcd, 0x03, 0xe8}},
+		{-1000, []byte{0xd1, 0xfc, 0x18}},
+		{70000, []byte{0xce, 0x00, 0x01, 0x11, 0x70}},
+		{uint64(1) << 63, []byte{0xcf, 0x80, 0, 0, 0, 0, 0, 0, 0}},
+		{1.5, []byte{0xcb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0}},
+		{"hi", []byte{0xa2, 'h', 'i'}},
+		{[]byte{1, 2}, []byte{0xc4, 0x02, 0x01, 0x02}},
+		{[]int{1, 2}, []byte{0x92, 0x01, 0x02}},
+		{map[string]interface{}{"b": 2, "a": 1}, []byte{0x82, 0xa1, 'a', 0x01, 0xa1, 'b', 0x02}},
+		{struct {
+			Name    string `json:"name"`
+			Ignored string `json:"-"`
+			Empty   string `json:"empty,omitempty"`
+		}{Name: "x", Ignored: "y"}, []byte{0x81, 0xa4, 'n', 'a', 'm', 'e', 0xa1, 'x'}},
+	}
+	for _, test := range tests {
+		encoded, err := EncodeMessagePack(test.value)
+		assert.Nil(t, err)
+		assert.Equal(t, test.expected, encoded, "%v", test.value)
+	}
+
+	// longer strings and arrays use the sized formats
+	encoded, _ := EncodeMessagePack(strings.Repeat("a", 40))
+	assert.Equal(t, []byte{0xd9, 40}, encoded[:2])
+	encoded, _ = EncodeMessagePack(make([]int, 20))
+	assert.Equal(t, []byte{0xdc, 0, 20}, encoded[:3])
+
+	_, err := EncodeMessagePack(func() {})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,689 @@
+// Copyright 2019-2021 VMware, Inc.
+// SPDX-License-Identifier: BSD-2-Clause
+
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/vmware/transport-go/bridge"
+	"github.com/vmware/transport-go/bus"
+	"github.com/vmware/transport-go/model"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// ClusterDiscoveryChannel is the galactic channel the cluster nodes advertise their services on.
+	ClusterDiscoveryChannel = "transport-cluster-discovery"
+
+	// clusterNodeChannelPrefix prefixes the galactic channel each node receives forwarded requests
+	// and responses on.
+	clusterNodeChannelPrefix = "transport-cluster-node-"
+
+	defaultClusterHeartbeatInterval = 5 * time.Second
+	defaultClusterRequestTimeout    = 30 * time.Second
+
+	clusterHeartbeatMsg = "heartbeat"
+	clusterLeaveMsg     = "leave"
+	clusterRequestMsg   = "request"
+	clusterResponseMsg  = "response"
+)
+
+// ClusterConfig configures the discovery of fabric services across multiple nodes (e.g. Plank instances)
+// connected to the same message broker. Plank doesn't connect to the broker on its own, the application
+// connects with bus.EventBus.ConnectBroker and passes the connection to ServiceRegistry.EnableClusterDiscovery.
+//
+// the metadata and headers of the forwarded requests, like the principal of the caller, are forwarded to
+// the remote nodes only when SharedSecret is set. all messages are then signed with the secret and the
+// messages which are not signed with it are dropped, so other clients of the broker can't forge them.
+type ClusterConfig struct {
+	Connection        bridge.Connection // connection to the shared broker
+	TopicPrefix       string            // prefix of the broker destinations to subscribe to, e.g. /topic/
+	PubPrefix         string            // prefix of the broker destinations to publish to, e.g. /pub/topic/
+	NodeName          string            // human readable name of the node, defaults to the hostname
+	HeartbeatInterval time.Duration     // interval of the heartbeats advertising the services, defaults to 5s
+	NodeExpiry        time.Duration     // time after the last heartbeat a node expires, defaults to 3 heartbeats
+	RequestTimeout    time.Duration     // maximum time to wait for the next response to a forwarded request, defaults to 30s
+	SharedSecret      string            // optional secret shared by the nodes the cluster messages are signed with
+}
+
+// VersionedService Optional interface, if implemented by a fabric service, its version is advertised
+// to the other cluster nodes.
+type VersionedService interface {
+	GetServiceVersion() string
+}
+
+// RequestCommandsProvider Optional interface, if implemented by a fabric service, its supported commands
+// are advertised to the other cluster nodes. RequestRouter implements the interface, so services embedding
+// a RequestRouter advertise their commands automatically.
+type RequestCommandsProvider interface {
+	SupportedCommands() []RequestCommand
+}
+
+// ClusterServiceInfo describes a fabric service available on a cluster node.
+type ClusterServiceInfo struct {
+	Channel  string           `json:"channel"`
+	Version  string           `json:"version,omitempty"`
+	Commands []RequestCommand `json:"commands,omitempty"`
+}
+
+// ClusterNode describes a cluster node and the fabric services it provides.
+type ClusterNode struct {
+	Id       string                `json:"id"`
+	Name     string                `json:"name"`
+	Services []*ClusterServiceInfo `json:"services"`
+	LastSeen time.Time             `json:"lastSeen"`
+}
+
+// ClusterRegistry is the cluster-wide view of the fabric services. Requests sent to the channel of a
+// service which is not registered locally but is provided by a remote node are forwarded to that node
+// through the broker, and its responses are delivered back on the local channel.
+type ClusterRegistry interface {
+	// GetLocalNode returns the description of the local node.
+	GetLocalNode() *ClusterNode
+
+	// GetNodes returns the remote nodes which are alive, sorted by id.
+	GetNodes() []*ClusterNode
+
+	// GetServiceNodes returns the remote nodes providing the service at the given channel.
+	GetServiceNodes(serviceChannelName string) []*ClusterNode
+
+	// GetClusterServiceChannels returns the channels of all local and remote services, sorted.
+	GetClusterServiceChannels() []string
+
+	// Stop notifies the other nodes the local node is leaving and stops forwarding requests.
+	Stop() error
+}
+
+// clusterMessage is the envelope of all messages exchanged between the cluster nodes.
+type clusterMessage struct {
+	Type     string          `json:"type"`
+	NodeId   string          `json:"nodeId"`
+	Node     *ClusterNode    `json:"node,omitempty"`
+	Channel  string          `json:"channel,omitempty"`
+	Request  *model.Request  `json:"request,omitempty"`
+	Response *model.Response `json:"response,omitempty"`
+	// number of responses to the forwarded request, set once the last response (e.g. the end of
+	// a response stream) is sent. responses can be received out of order.
+	Count int `json:"count,omitempty"`
+	// metadata and headers of the forwarded request, which are never serialized with model.Request
+	// so that clients can't forge them. they are forwarded only between nodes sharing a secret.
+	Metadata *model.RequestMetadata `json:"metadata,omitempty"`
+	Headers  map[string]string      `json:"headers,omitempty"`
+}
+
+// signedClusterMessage wraps a cluster message signed with the shared secret of the cluster.
+type signedClusterMessage struct {
+	Message   json.RawMessage `json:"message"`
+	Signature string          `json:"signature"`
+}
+
+// forwardedRequest is a request forwarded to a remote node which is waiting for its responses
+type forwardedRequest struct {
+	channel  string
+	nodeId   string
+	timer    *time.Timer
+	received int
+	count    int
+}
+
+type clusterRegistry struct {
+	lock             sync.RWMutex
+	config           ClusterConfig
+	serviceRegistry  ServiceRegistry
+	bus              bus.EventBus
+	nodeId           string
+	nodeChannel      string
+	nodes            map[string]*ClusterNode
+	forwarders       map[string]bus.MessageHandler
+	nextNode         uint32
+	discoveryHandler bus.MessageHandler
+	nodeHandler      bus.MessageHandler
+	stopChan         chan struct{}
+	stopped          bool
+	forwardedLock    sync.Mutex
+	forwarded        map[uuid.UUID]*forwardedRequest
+}
+
+func newClusterRegistry(
+	serviceRegistry ServiceRegistry, eventBus bus.EventBus, config *ClusterConfig) (*clusterRegistry, error) {
+
+	if config == nil || config.Connection == nil {
+		return nil, fmt.Errorf("unable to enable cluster discovery: broker connection is not configured")
+	}
+
+	cr := &clusterRegistry{
+		config:          *config,
+		serviceRegistry: serviceRegistry,
+		bus:             eventBus,
+		nodeId:          uuid.New().String(),
+		nodes:           make(map[string]*ClusterNode),
+		forwarders:      make(map[string]bus.MessageHandler),
+		stopChan:        make(chan struct{}),
+		forwarded:       make(map[uuid.UUID]*forwardedRequest),
+	}
+	cr.nodeChannel = clusterNodeChannelPrefix + cr.nodeId
+
+	if cr.config.NodeName == "" {
+		cr.config.NodeName, _ = os.Hostname()
+	}
+	if cr.config.HeartbeatInterval <= 0 {
+		cr.config.HeartbeatInterval = defaultClusterHeartbeatInterval
+	}
+	if cr.config.NodeExpiry <= 0 {
+		cr.config.NodeExpiry = 3 * cr.config.HeartbeatInterval
+	}
+	if cr.config.RequestTimeout <= 0 {
+		cr.config.RequestTimeout = defaultClusterRequestTimeout
+	}
+
+	var err error
+	if cr.discoveryHandler, err = cr.listenGalacticChannel(ClusterDiscoveryChannel); err != nil {
+		return nil, err
+	}
+	if cr.nodeHandler, err = cr.listenGalacticChannel(cr.nodeChannel); err != nil {
+		cr.discoveryHandler.Close()
+		return nil, err
+	}
+
+	cr.sendHeartbeat()
+	go cr.heartbeatLoop()
+	return cr, nil
+}
+
+func (cr *clusterRegistry) listenGalacticChannel(channelName string) (bus.MessageHandler, error) {
+	channelManager := cr.bus.GetChannelManager()
+	channelManager.CreateChannel(channelName)
+	if err := channelManager.MarkChannelAsGalactic(
+		channelName, cr.config.TopicPrefix+channelName, cr.config.Connection); err != nil {
+		return nil, err
+	}
+
+	mh, err := cr.bus.ListenStream(channelName)
+	if err != nil {
+		return nil, err
+	}
+	mh.Handle(cr.handleClusterMessage, func(e error) {})
+	return mh, nil
+}
+
+func (cr *clusterRegistry) heartbeatLoop() {
+	ticker := time.NewTicker(cr.config.HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			cr.expireNodes()
+			cr.sendHeartbeat()
+		case <-cr.stopChan:
+			return
+		}
+	}
+}
+
+func (cr *clusterRegistry) GetLocalNode() *ClusterNode {
+	node := &ClusterNode{
+		Id:       cr.nodeId,
+		Name:     cr.config.NodeName,
+		Services: make([]*ClusterServiceInfo, 0),
+		LastSeen: time.Now(),
+	}
+	for _, chanName := range cr.serviceRegistry.GetAllServiceChannels() {
+		svc, err := cr.serviceRegistry.GetService(chanName)
+		if err != nil {
+			continue
+		}
+		info := &ClusterServiceInfo{Channel: chanName}
+		if versioned, ok := svc.(VersionedService); ok {
+			info.Version = versioned.GetServiceVersion()
+		}
+		if provider, ok := svc.(RequestCommandsProvider); ok {
+			info.Commands = provider.SupportedCommands()
+		}
+		node.Services = append(node.Services, info)
+	}
+	sort.Slice(node.Services, func(i, j int) bool {
+		return node.Services[i].Channel < node.Services[j].Channel
+	})
+	return node
+}
+
+func (cr *clusterRegistry) GetNodes() []*ClusterNode {
+	cr.lock.RLock()
+	defer cr.lock.RUnlock()
+	nodes := make([]*ClusterNode, 0, len(cr.nodes))
+	for _, node := range cr.nodes {
+		nodes = append(nodes, node)
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].Id < nodes[j].Id
+	})
+	return nodes
+}
+
+func (cr *clusterRegistry) GetServiceNodes(serviceChannelName string) []*ClusterNode {
+	nodes := make([]*ClusterNode, 0)
+	for _, node := range cr.GetNodes() {
+		for _, info := range node.Services {
+			if info.Channel == serviceChannelName {
+				nodes = append(nodes, node)
+				break
+			}
+		}
+	}
+	return nodes
+}
+
+func (cr *clusterRegistry) GetClusterServiceChannels() []string {
+	channels := make(map[string]bool)
+	for _, chanName := range cr.serviceRegistry.GetAllServiceChannels() {
+		channels[chanName] = true
+	}
+	for _, node := range cr.GetNodes() {
+		for _, info := range node.Services {
+			channels[info.Channel] = true
+		}
+	}
+	result := make([]string, 0, len(channels))
+	for chanName := range channels {
+		result = append(result, chanName)
+	}
+	sort.Strings(result)
+	return result
+}
+
+func (cr *clusterRegistry) Stop() error {
+	cr.lock.Lock()
+	if cr.stopped {
+		cr.lock.Unlock()
+		return fmt.Errorf("cluster discovery is already stopped")
+	}
+	cr.stopped = true
+	close(cr.stopChan)
+	for chanName, mh := range cr.forwarders {
+		mh.Close()
+		delete(cr.forwarders, chanName)
+	}
+	cr.nodes = make(map[string]*ClusterNode)
+	cr.lock.Unlock()
+
+	cr.forwardedLock.Lock()
+	for id, forwarded := range cr.forwarded {
+		forwarded.timer.Stop()
+		delete(cr.forwarded, id)
+	}
+	cr.forwardedLock.Unlock()
+
+	err := cr.publish(ClusterDiscoveryChannel, &clusterMessage{Type: clusterLeaveMsg, NodeId: cr.nodeId})
+
+	cr.discoveryHandler.Close()
+	cr.nodeHandler.Close()
+	channelManager := cr.bus.GetChannelManager()
+	channelManager.MarkChannelAsLocal(ClusterDiscoveryChannel)
+	channelManager.MarkChannelAsLocal(cr.nodeChannel)
+	channelManager.DestroyChannel(cr.nodeChannel)
+	return err
+}
+
+func (cr *clusterRegistry) isStopped() bool {
+	cr.lock.RLock()
+	defer cr.lock.RUnlock()
+	return cr.stopped
+}
+
+func (cr *clusterRegistry) sendHeartbeat() {
+	if err := cr.publish(ClusterDiscoveryChannel, &clusterMessage{
+		Type:   clusterHeartbeatMsg,
+		NodeId: cr.nodeId,
+		Node:   cr.GetLocalNode(),
+	}); err != nil {
+		log.Printf("failed to send cluster heartbeat: %s", err.Error())
+	}
+}
+
+func (cr *clusterRegistry) publish(channelName string, message *clusterMessage) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	if cr.config.SharedSecret != "" {
+		if data, err = json.Marshal(&signedClusterMessage{Message: data, Signature: cr.sign(data)}); err != nil {
+			return err
+		}
+	}
+	return cr.config.Connection.SendJSONMessage(cr.config.PubPrefix+channelName, data)
+}
+
+func (cr *clusterRegistry) sign(data []byte) string {
+	mac := hmac.New(sha256.New, []byte(cr.config.SharedSecret))
+	mac.Write(data)
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verify returns the cluster message wrapped in the signed message, or an error if it's not signed with
+// the shared secret.
+func (cr *clusterRegistry) verify(data []byte) ([]byte, error) {
+	var signedMsg signedClusterMessage
+	if err := json.Unmarshal(data, &signedMsg); err != nil {
+		return nil, err
+	}
+	signature, err := base64.StdEncoding.DecodeString(signedMsg.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature")
+	}
+	expected, _ := base64.StdEncoding.DecodeString(cr.sign(signedMsg.Message))
+	if !hmac.Equal(signature, expected) {
+		return nil, fmt.Errorf("invalid signature")
+	}
+	return signedMsg.Message, nil
+}
+
+func (cr *clusterRegistry) handleClusterMessage(message *model.Message) {
+	data, ok := message.Payload.([]byte)
+	if !ok {
+		return
+	}
+	if cr.config.SharedSecret != "" {
+		var err error
+		if data, err = cr.verify(data); err != nil {
+			log.Printf("dropped cluster message: %s", err.Error())
+			return
+		}
+	}
+	var clusterMsg clusterMessage
+	if err := json.Unmarshal(data, &clusterMsg); err != nil {
+		log.Printf("failed to unmarshal cluster message: %s", err.Error())
+		return
+	}
+	if clusterMsg.NodeId == cr.nodeId || cr.isStopped() {
+		return
+	}
+
+	switch clusterMsg.Type {
+	case clusterHeartbeatMsg:
+		if clusterMsg.Node != nil {
+			clusterMsg.Node.Id = clusterMsg.NodeId
+			clusterMsg.Node.LastSeen = time.Now()
+			cr.lock.Lock()
+			cr.nodes[clusterMsg.NodeId] = clusterMsg.Node
+			cr.lock.Unlock()
+			cr.syncForwarders()
+		}
+	case clusterLeaveMsg:
+		cr.lock.Lock()
+		delete(cr.nodes, clusterMsg.NodeId)
+		cr.lock.Unlock()
+		cr.syncForwarders()
+	case clusterRequestMsg:
+		if clusterMsg.Request != nil {
+			// the caller of the request is trusted only if the message is signed by a node of the cluster
+			if cr.config.SharedSecret != "" {
+				clusterMsg.Request.Metadata = clusterMsg.Metadata
+				clusterMsg.Request.Principal = clusterMsg.Metadata.GetPrincipal()
+				clusterMsg.Request.Headers = clusterMsg.Headers
+			}
+			cr.handleRemoteRequest(clusterMsg.NodeId, clusterMsg.Channel, clusterMsg.Request)
+		}
+	case clusterResponseMsg:
+		if clusterMsg.Response != nil && clusterMsg.Response.Id != nil &&
+			cr.acceptForwardedResponse(clusterMsg.NodeId, clusterMsg.Channel, *clusterMsg.Response.Id, clusterMsg.Count) {
+			cr.bus.SendResponseMessage(clusterMsg.Channel, clusterMsg.Response, clusterMsg.Response.Id)
+		}
+	}
+}
+
+func (cr *clusterRegistry) expireNodes() {
+	expired := false
+	cr.lock.Lock()
+	for nodeId, node := range cr.nodes {
+		if time.Since(node.LastSeen) > cr.config.NodeExpiry {
+			delete(cr.nodes, nodeId)
+			expired = true
+		}
+	}
+	cr.lock.Unlock()
+	if expired {
+		cr.syncForwarders()
+	}
+}
+
+func (cr *clusterRegistry) isLocalService(serviceChannelName string) bool {
+	_, err := cr.serviceRegistry.GetService(serviceChannelName)
+	return err == nil
+}
+
+// syncForwarders makes sure the requests for the services provided only by remote nodes are forwarded.
+func (cr *clusterRegistry) syncForwarders() {
+	remoteChannels := make(map[string]bool)
+	for _, node := range cr.GetNodes() {
+		for _, info := range node.Services {
+			if !cr.isLocalService(info.Channel) {
+				remoteChannels[info.Channel] = true
+			}
+		}
+	}
+
+	cr.lock.Lock()
+	defer cr.lock.Unlock()
+	if cr.stopped {
+		return
+	}
+
+	for chanName, mh := range cr.forwarders {
+		if !remoteChannels[chanName] {
+			mh.Close()
+			delete(cr.forwarders, chanName)
+		}
+	}
+	for chanName := range remoteChannels {
+		if _, found := cr.forwarders[chanName]; found {
+			continue
+		}
+		cr.bus.GetChannelManager().CreateChannel(chanName)
+		mh, err := cr.bus.ListenRequestStream(chanName)
+		if err != nil {
+			continue
+		}
+		chanName := chanName
+		mh.Handle(func(message *model.Message) {
+			cr.forwardRequest(chanName, message)
+		}, func(e error) {})
+		cr.forwarders[chanName] = mh
+	}
+}
+
+// forwardRequest forwards a request for a service which is not registered locally to a remote node
+// providing it. the nodes are picked in a round-robin fashion.
+func (cr *clusterRegistry) forwardRequest(serviceChannelName string, message *model.Message) {
+	if cr.isLocalService(serviceChannelName) {
+		return
+	}
+	request, ok := message.Payload.(*model.Request)
+	if !ok {
+		req, ok := message.Payload.(model.Request)
+		if !ok {
+			return
+		}
+		request = &req
+	}
+	if message.DestinationId != nil {
+		request.Id = message.DestinationId
+	}
+	if request.Id == nil {
+		log.Printf("unable to forward request without id to the cluster service '%s'", serviceChannelName)
+		return
+	}
+
+	nodes := cr.GetServiceNodes(serviceChannelName)
+	if len(nodes) == 0 {
+		cr.sendErrorResponse(serviceChannelName, request, 503,
+			fmt.Sprintf("no cluster node provides service \"%s\"", serviceChannelName))
+		return
+	}
+	node := nodes[int(atomic.AddUint32(&cr.nextNode, 1)-1)%len(nodes)]
+
+	clusterMsg := &clusterMessage{
+		Type:    clusterRequestMsg,
+		NodeId:  cr.nodeId,
+		Channel: serviceChannelName,
+		Request: request,
+	}
+	if cr.config.SharedSecret != "" {
+		clusterMsg.Metadata, clusterMsg.Headers = request.Metadata, request.Headers
+	}
+
+	cr.trackForwardedRequest(*request.Id, serviceChannelName, node.Id)
+	if err := cr.publish(clusterNodeChannelPrefix+node.Id, clusterMsg); err != nil {
+		cr.forgetForwardedRequest(*request.Id)
+		cr.sendErrorResponse(serviceChannelName, request, 503,
+			fmt.Sprintf("unable to forward request to cluster node \"%s\": %s", node.Id, err.Error()))
+	}
+}
+
+// trackForwardedRequest remembers the request forwarded to a remote node until its last response is
+// received, or no response is received for RequestTimeout.
+func (cr *clusterRegistry) trackForwardedRequest(id uuid.UUID, serviceChannelName string, nodeId string) {
+	cr.forwardedLock.Lock()
+	defer cr.forwardedLock.Unlock()
+	if forwarded, found := cr.forwarded[id]; found {
+		forwarded.timer.Stop()
+	}
+	cr.forwarded[id] = &forwardedRequest{
+		channel: serviceChannelName,
+		nodeId:  nodeId,
+		timer:   time.AfterFunc(cr.config.RequestTimeout, func() { cr.forgetForwardedRequest(id) }),
+	}
+}
+
+func (cr *clusterRegistry) forgetForwardedRequest(id uuid.UUID) {
+	cr.forwardedLock.Lock()
+	defer cr.forwardedLock.Unlock()
+	if forwarded, found := cr.forwarded[id]; found {
+		forwarded.timer.Stop()
+		delete(cr.forwarded, id)
+	}
+}
+
+// acceptForwardedResponse reports whether the response received from a remote node answers a request
+// forwarded to that node. responses to unknown requests are dropped, so remote nodes can't inject
+// responses into the local channels.
+func (cr *clusterRegistry) acceptForwardedResponse(nodeId string, serviceChannelName string, id uuid.UUID, count int) bool {
+	cr.forwardedLock.Lock()
+	defer cr.forwardedLock.Unlock()
+	forwarded, found := cr.forwarded[id]
+	if !found || forwarded.nodeId != nodeId || forwarded.channel != serviceChannelName {
+		return false
+	}
+	forwarded.received++
+	if count > 0 {
+		forwarded.count = count
+	}
+	if forwarded.count > 0 && forwarded.received >= forwarded.count {
+		forwarded.timer.Stop()
+		delete(cr.forwarded, id)
+	} else {
+		forwarded.timer.Reset(cr.config.RequestTimeout)
+	}
+	return true
+}
+
+// handleRemoteRequest handles a request forwarded by a remote node with the local service and sends the
+// responses back to the node. the responses are relayed until the last response of a response stream,
+// or until no response is sent for RequestTimeout.
+func (cr *clusterRegistry) handleRemoteRequest(originNodeId string, serviceChannelName string, request *model.Request) {
+	replyTo := clusterNodeChannelPrefix + originNodeId
+	reply := func(response *model.Response, count int) {
+		if err := cr.publish(replyTo, &clusterMessage{
+			Type:     clusterResponseMsg,
+			NodeId:   cr.nodeId,
+			Channel:  serviceChannelName,
+			Response: response,
+			Count:    count,
+		}); err != nil {
+			log.Printf("failed to send response to cluster node '%s': %s", originNodeId, err.Error())
+		}
+	}
+
+	if !cr.isLocalService(serviceChannelName) || request.Id == nil {
+		reply(&model.Response{
+			Id:           request.Id,
+			Destination:  serviceChannelName,
+			Error:        true,
+			ErrorCode:    503,
+			ErrorMessage: fmt.Sprintf("service \"%s\" is not available on cluster node \"%s\"", serviceChannelName, cr.nodeId),
+		}, 1)
+		return
+	}
+
+	mh, err := cr.bus.ListenStreamForDestination(serviceChannelName, request.Id)
+	if err != nil {
+		return
+	}
+	var lock sync.Mutex
+	var closeOnce sync.Once
+	closeHandler := func() {
+		closeOnce.Do(mh.Close)
+	}
+	relayed, total := 0, 0
+	timer := time.AfterFunc(cr.config.RequestTimeout, closeHandler)
+	mh.Handle(func(message *model.Message) {
+		response, ok := message.Payload.(*model.Response)
+		if !ok {
+			return
+		}
+		count := getResponseCount(response)
+		lock.Lock()
+		relayed++
+		if count > 0 {
+			total = count
+		}
+		if total > 0 && relayed >= total {
+			timer.Stop()
+			closeHandler()
+		} else {
+			timer.Reset(cr.config.RequestTimeout)
+		}
+		lock.Unlock()
+		reply(response, count)
+	}, func(e error) {})
+	cr.bus.SendRequestMessage(serviceChannelName, request, request.Id)
+}
+
+// getResponseCount returns the number of responses to the request if the response is the only response
+// to the request, or the last one of a response stream. returns 0 for the other responses of a stream.
+func getResponseCount(response *model.Response) int {
+	switch event := response.Payload.(type) {
+	case *ResponseStreamEvent:
+		if event.Done {
+			return event.Sequence
+		}
+		return 0
+	case *RestStreamEvent:
+		if event.Done {
+			return event.Sequence
+		}
+		return 0
+	}
+	return 1
+}
+
+func (cr *clusterRegistry) sendErrorResponse(
+	serviceChannelName string, request *model.Request, errorCode int, errorMessage string) {
+
+	cr.bus.SendResponseMessage(serviceChannelName, &model.Response{
+		Id:           request.Id,
+		Destination:  serviceChannelName,
+		Error:        true,
+		ErrorCode:    errorCode,
+		ErrorMessage: errorMessage,
+	}, request.Id)
+}
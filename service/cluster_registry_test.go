@@ -0,0 +1,528 @@
+// Copyright 2019-2021 VMware, Inc.
+// SPDX-License-Identifier: BSD-2-Clause
+
+package service
+
+import (
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/vmware/transport-go/bridge"
+	"github.com/vmware/transport-go/model"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mockClusterBroker delivers the messages sent by any of its connections to all subscriptions of the destination.
+type mockClusterBroker struct {
+	lock          sync.Mutex
+	subscriptions map[string][]*mockClusterSubscription
+}
+
+func newMockClusterBroker() *mockClusterBroker {
+	return &mockClusterBroker{subscriptions: make(map[string][]*mockClusterSubscription)}
+}
+
+func (b *mockClusterBroker) connect() *mockClusterConnection {
+	id := uuid.New()
+	return &mockClusterConnection{id: &id, broker: b}
+}
+
+func (b *mockClusterBroker) publish(destination string, payload []byte) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for _, sub := range b.subscriptions[destination] {
+		sub.c <- model.GenerateResponse(&model.MessageConfig{Payload: payload, Destination: destination})
+	}
+}
+
+func (b *mockClusterBroker) unsubscribe(sub *mockClusterSubscription) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	subs := b.subscriptions[sub.destination]
+	for i, s := range subs {
+		if s == sub {
+			b.subscriptions[sub.destination] = append(subs[:i], subs[i+1:]...)
+			close(sub.c)
+			return
+		}
+	}
+}
+
+type mockClusterSubscription struct {
+	id          *uuid.UUID
+	destination string
+	c           chan *model.Message
+	broker      *mockClusterBroker
+}
+
+func (s *mockClusterSubscription) GetId() *uuid.UUID                  { return s.id }
+func (s *mockClusterSubscription) GetMsgChannel() chan *model.Message { return s.c }
+func (s *mockClusterSubscription) GetDestination() string             { return s.destination }
+func (s *mockClusterSubscription) Unsubscribe() error {
+	s.broker.unsubscribe(s)
+	return nil
+}
+
+type mockClusterConnection struct {
+	id     *uuid.UUID
+	broker *mockClusterBroker
+}
+
+func (c *mockClusterConnection) GetId() *uuid.UUID { return c.id }
+
+func (c *mockClusterConnection) Subscribe(destination string) (bridge.Subscription, error) {
+	id := uuid.New()
+	sub := &mockClusterSubscription{
+		id: &id, destination: destination, c: make(chan *model.Message, 100), broker: c.broker}
+	c.broker.lock.Lock()
+	c.broker.subscriptions[destination] = append(c.broker.subscriptions[destination], sub)
+	c.broker.lock.Unlock()
+	return sub, nil
+}
+
+func (c *mockClusterConnection) SubscribeReplyDestination(destination string) (bridge.Subscription, error) {
+	return c.Subscribe(destination)
+}
+
+func (c *mockClusterConnection) Disconnect() error { return nil }
+
+func (c *mockClusterConnection) SendJSONMessage(destination string, payload []byte, opts ...func(*frame.Frame) error) error {
+	c.broker.publish(destination, payload)
+	return nil
+}
+
+func (c *mockClusterConnection) SendMessage(
+	destination, contentType string, payload []byte, opts ...func(*frame.Frame) error) error {
+	c.broker.publish(destination, payload)
+	return nil
+}
+
+func (c *mockClusterConnection) SendMessageWithReplyDestination(
+	destination, replyDestination, contentType string, payload []byte, opts ...func(*frame.Frame) error) error {
+	c.broker.publish(destination, payload)
+	return nil
+}
+
+func (c *mockClusterConnection) IsConnected() bool { return true }
+
+type clusterTestService struct {
+	*RequestRouter
+}
+
+func newClusterTestService() *clusterTestService {
+	svc := &clusterTestService{RequestRouter: NewRequestRouter()}
+	svc.HandleWithDescription("echo", "echoes the payload", reflect.TypeOf(""),
+		func(request *model.Request, payload interface{}, core FabricServiceCore) {
+			core.SendResponse(request, "echo: "+payload.(string))
+		})
+	svc.Handle("count", nil, func(request *model.Request, payload interface{}, core FabricServiceCore) {
+		stream := core.(ResponseStreamer).OpenResponseStream(request)
+		for i := 1; i <= 3; i++ {
+			stream.Send(i)
+		}
+		stream.Close()
+	})
+	svc.Handle("silent", nil, func(request *model.Request, payload interface{}, core FabricServiceCore) {})
+	svc.Handle("whoami", nil, func(request *model.Request, payload interface{}, core FabricServiceCore) {
+		name, traceParent := "anonymous", "none"
+		if principal := request.GetPrincipal(); principal != nil {
+			name = principal.Name
+		}
+		if request.Metadata != nil {
+			traceParent = request.Metadata.TraceParent
+		}
+		tenant, found := request.Headers["X-Tenant"]
+		if !found {
+			tenant = "none"
+		}
+		core.SendResponse(request, strings.Join([]string{name, tenant, traceParent}, " "))
+	})
+	return svc
+}
+
+func (s *clusterTestService) GetServiceVersion() string {
+	return "1.2.0"
+}
+
+func newClusterTestServiceRegistry() *serviceRegistry {
+	registry := newTestServiceRegistry()
+	registry.lifecycleManager = newTestServiceLifecycleManager(registry).(*serviceLifecycleManager)
+	return registry
+}
+
+func newClusterTestConfig(broker *mockClusterBroker, name string) *ClusterConfig {
+	return &ClusterConfig{
+		Connection:        broker.connect(),
+		TopicPrefix:       "/topic/",
+		PubPrefix:         "/topic/",
+		NodeName:          name,
+		HeartbeatInterval: 20 * time.Millisecond,
+		RequestTimeout:    time.Second,
+	}
+}
+
+func TestServiceRegistry_EnableClusterDiscovery(t *testing.T) {
+	registry := newClusterTestServiceRegistry()
+	_, err := registry.EnableClusterDiscovery(nil)
+	assert.EqualError(t, err, "unable to enable cluster discovery: broker connection is not configured")
+	assert.Nil(t, registry.GetClusterRegistry())
+
+	cr, err := registry.EnableClusterDiscovery(newClusterTestConfig(newMockClusterBroker(), "node"))
+	assert.Nil(t, err)
+	assert.Equal(t, cr, registry.GetClusterRegistry())
+
+	_, err = registry.EnableClusterDiscovery(newClusterTestConfig(newMockClusterBroker(), "node"))
+	assert.EqualError(t, err, "unable to enable cluster discovery: cluster discovery is already enabled")
+
+	assert.Nil(t, cr.Stop())
+	assert.EqualError(t, cr.Stop(), "cluster discovery is already stopped")
+	assert.Nil(t, registry.GetClusterRegistry())
+}
+
+func TestClusterRegistry_Discovery(t *testing.T) {
+	broker := newMockClusterBroker()
+
+	registry1 := newClusterTestServiceRegistry()
+	registry1.RegisterService(newClusterTestService(), "cluster-service")
+	cr1, err := registry1.EnableClusterDiscovery(newClusterTestConfig(broker, "node-1"))
+	assert.Nil(t, err)
+
+	registry2 := newClusterTestServiceRegistry()
+	registry2.RegisterService(&mockFabricService{}, "local-service")
+	cr2, err := registry2.EnableClusterDiscovery(newClusterTestConfig(broker, "node-2"))
+	assert.Nil(t, err)
+
+	assert.Eventually(t, func() bool {
+		return len(cr1.GetNodes()) == 1 && len(cr2.GetNodes()) == 1
+	}, time.Second, 5*time.Millisecond)
+
+	nodes := cr2.GetServiceNodes("cluster-service")
+	assert.Len(t, nodes, 1)
+	assert.Equal(t, cr1.GetLocalNode().Id, nodes[0].Id)
+	assert.Equal(t, "node-1", nodes[0].Name)
+	assert.Equal(t, &ClusterServiceInfo{
+		Channel: "cluster-service",
+		Version: "1.2.0",
+		Commands: []RequestCommand{
+			{Command: "count"},
+			{Command: "echo", Description: "echoes the payload", PayloadType: "string"},
+			{Command: "silent"},
+			{Command: "whoami"},
+		},
+	}, nodes[0].Services[0])
+	assert.Len(t, cr2.GetServiceNodes("missing-service"), 0)
+	assert.Equal(t, []string{"cluster-service", "local-service"}, cr2.GetClusterServiceChannels())
+
+	// the node leaving the cluster is removed immediately
+	assert.Nil(t, cr1.Stop())
+	assert.Eventually(t, func() bool {
+		return len(cr2.GetNodes()) == 0
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, []string{"local-service"}, cr2.GetClusterServiceChannels())
+	assert.Nil(t, cr2.Stop())
+}
+
+func TestClusterRegistry_NodeExpiry(t *testing.T) {
+	broker := newMockClusterBroker()
+
+	registry1 := newClusterTestServiceRegistry()
+	cr1, _ := registry1.EnableClusterDiscovery(newClusterTestConfig(broker, "node-1"))
+	registry2 := newClusterTestServiceRegistry()
+	cr2, _ := registry2.EnableClusterDiscovery(newClusterTestConfig(broker, "node-2"))
+
+	assert.Eventually(t, func() bool {
+		return len(cr2.GetNodes()) == 1
+	}, time.Second, 5*time.Millisecond)
+
+	// stop the heartbeats of node 1 without notifying the cluster
+	cr1.(*clusterRegistry).lock.Lock()
+	cr1.(*clusterRegistry).stopped = true
+	close(cr1.(*clusterRegistry).stopChan)
+	cr1.(*clusterRegistry).lock.Unlock()
+
+	assert.Eventually(t, func() bool {
+		return len(cr2.GetNodes()) == 0
+	}, time.Second, 5*time.Millisecond)
+	assert.Nil(t, cr2.Stop())
+}
+
+func TestClusterRegistry_RemoteRequests(t *testing.T) {
+	broker := newMockClusterBroker()
+
+	registry1 := newClusterTestServiceRegistry()
+	registry1.RegisterService(newClusterTestService(), "cluster-service")
+	config1 := newClusterTestConfig(broker, "node-1")
+	config1.SharedSecret = "cluster-secret"
+	cr1, _ := registry1.EnableClusterDiscovery(config1)
+
+	registry2 := newClusterTestServiceRegistry()
+	config2 := newClusterTestConfig(broker, "node-2")
+	config2.SharedSecret = "cluster-secret"
+	cr2, _ := registry2.EnableClusterDiscovery(config2)
+
+	assert.Eventually(t, func() bool {
+		return len(cr2.GetServiceNodes("cluster-service")) == 1
+	}, time.Second, 5*time.Millisecond)
+
+	sendRequest := func(request *model.Request) *model.Response {
+		id := uuid.New()
+		request.Id = &id
+		mh, _ := registry2.bus.ListenOnceForDestination("cluster-service", &id)
+		responseChan := make(chan *model.Response, 1)
+		mh.Handle(func(message *model.Message) {
+			responseChan <- message.Payload.(*model.Response)
+		}, func(e error) {})
+		registry2.bus.SendRequestMessage("cluster-service", request, &id)
+
+		select {
+		case response := <-responseChan:
+			assert.Equal(t, &id, response.Id)
+			return response
+		case <-time.After(2 * time.Second):
+			assert.Fail(t, "no response received")
+			return &model.Response{}
+		}
+	}
+
+	// the request for the remote service is handled by node 1
+	response := sendRequest(&model.Request{Request: "echo", Payload: "hello"})
+	assert.False(t, response.Error)
+	assert.Equal(t, "echo: hello", response.Payload)
+
+	response = sendRequest(&model.Request{Request: "unknown"})
+	assert.True(t, response.Error)
+	assert.Equal(t, 403, response.ErrorCode)
+
+	// the caller and the headers of the request are forwarded along with it between nodes sharing a secret
+	response = sendRequest(&model.Request{
+		Request: "whoami",
+		Headers: map[string]string{"X-Tenant": "acme"},
+		Metadata: &model.RequestMetadata{
+			Principal:   &model.Principal{Name: "jane", AuthMethod: "jwt"},
+			TraceParent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		},
+	})
+	assert.False(t, response.Error)
+	whoami := strings.Fields(response.Payload.(string))
+	assert.Equal(t, "jane", whoami[0])
+	assert.Equal(t, "acme", whoami[1])
+	assert.Contains(t, whoami[2], "4bf92f3577b34da6a3ce929d0e0e4736")
+
+	// once the service is unregistered from node 1 the request is rejected by the node
+	registry1.UnregisterService("cluster-service")
+	response = sendRequest(&model.Request{Request: "echo", Payload: "hello"})
+	assert.True(t, response.Error)
+	assert.Equal(t, 503, response.ErrorCode)
+
+	// and node 2 stops forwarding requests after the next heartbeat
+	assert.Eventually(t, func() bool {
+		return len(cr2.GetServiceNodes("cluster-service")) == 0
+	}, time.Second, 5*time.Millisecond)
+	cr2.(*clusterRegistry).lock.RLock()
+	assert.Len(t, cr2.(*clusterRegistry).forwarders, 0)
+	cr2.(*clusterRegistry).lock.RUnlock()
+
+	assert.Nil(t, cr1.Stop())
+	assert.Nil(t, cr2.Stop())
+}
+
+func newClusterTestNodes(t *testing.T) (*serviceRegistry, *clusterRegistry, *clusterRegistry) {
+	broker := newMockClusterBroker()
+
+	registry1 := newClusterTestServiceRegistry()
+	registry1.RegisterService(newClusterTestService(), "cluster-service")
+	cr1, _ := registry1.EnableClusterDiscovery(newClusterTestConfig(broker, "node-1"))
+
+	registry2 := newClusterTestServiceRegistry()
+	config := newClusterTestConfig(broker, "node-2")
+	config.RequestTimeout = 100 * time.Millisecond
+	cr2, _ := registry2.EnableClusterDiscovery(config)
+
+	assert.Eventually(t, func() bool {
+		return len(cr2.GetServiceNodes("cluster-service")) == 1
+	}, time.Second, 5*time.Millisecond)
+	return registry2, cr1.(*clusterRegistry), cr2.(*clusterRegistry)
+}
+
+func getForwardedRequestCount(cr *clusterRegistry) int {
+	cr.forwardedLock.Lock()
+	defer cr.forwardedLock.Unlock()
+	return len(cr.forwarded)
+}
+
+func TestClusterRegistry_RemoteResponseStreams(t *testing.T) {
+	registry2, cr1, cr2 := newClusterTestNodes(t)
+
+	id := uuid.New()
+	mh, _ := registry2.bus.ListenStreamForDestination("cluster-service", &id)
+	responseChan := make(chan *model.Response, 10)
+	mh.Handle(func(message *model.Message) {
+		responseChan <- message.Payload.(*model.Response)
+	}, func(e error) {})
+	defer mh.Close()
+	registry2.bus.SendRequestMessage("cluster-service", &model.Request{Id: &id, Request: "count"}, &id)
+
+	// all the events of the stream and its completion marker are relayed
+	sequences := make(map[float64]bool)
+	for i := 0; i < 4; i++ {
+		select {
+		case response := <-responseChan:
+			event := response.Payload.(map[string]interface{})
+			sequences[event["sequence"].(float64)] = true
+		case <-time.After(2 * time.Second):
+			assert.Fail(t, "stream response not received")
+		}
+	}
+	assert.Equal(t, map[float64]bool{1: true, 2: true, 3: true, 4: true}, sequences)
+	assert.Equal(t, 0, getForwardedRequestCount(cr2))
+
+	assert.Nil(t, cr1.Stop())
+	assert.Nil(t, cr2.Stop())
+}
+
+func TestClusterRegistry_UnknownRemoteResponses(t *testing.T) {
+	registry2, cr1, cr2 := newClusterTestNodes(t)
+
+	responseChan := make(chan *model.Response, 10)
+	mh, _ := registry2.bus.ListenStream("cluster-service")
+	mh.Handle(func(message *model.Message) {
+		responseChan <- message.Payload.(*model.Response)
+	}, func(e error) {})
+	defer mh.Close()
+
+	// responses to requests which were not forwarded to the node are dropped
+	injectedId := uuid.New()
+	assert.Nil(t, cr1.publish(cr2.nodeChannel, &clusterMessage{
+		Type:     clusterResponseMsg,
+		NodeId:   cr1.nodeId,
+		Channel:  "cluster-service",
+		Response: &model.Response{Id: &injectedId, Payload: "injected"},
+	}))
+
+	// and so are responses to forwarded requests which timed out
+	id := uuid.New()
+	registry2.bus.SendRequestMessage("cluster-service", &model.Request{Id: &id, Request: "silent"}, &id)
+	assert.Eventually(t, func() bool {
+		return getForwardedRequestCount(cr2) == 1
+	}, time.Second, time.Millisecond)
+	assert.Eventually(t, func() bool {
+		return getForwardedRequestCount(cr2) == 0
+	}, time.Second, time.Millisecond)
+	assert.Nil(t, cr1.publish(cr2.nodeChannel, &clusterMessage{
+		Type:     clusterResponseMsg,
+		NodeId:   cr1.nodeId,
+		Channel:  "cluster-service",
+		Response: &model.Response{Id: &id, Payload: "late"},
+		Count:    1,
+	}))
+
+	select {
+	case response := <-responseChan:
+		assert.Fail(t, "unexpected response", response.Payload)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	assert.Nil(t, cr1.Stop())
+	assert.Nil(t, cr2.Stop())
+}
+
+func sendClusterWhoAmIRequest(t *testing.T, registry *serviceRegistry) string {
+	id := uuid.New()
+	mh, _ := registry.bus.ListenOnceForDestination("cluster-service", &id)
+	responseChan := make(chan *model.Response, 1)
+	mh.Handle(func(message *model.Message) {
+		responseChan <- message.Payload.(*model.Response)
+	}, func(e error) {})
+	registry.bus.SendRequestMessage("cluster-service", &model.Request{
+		Id:       &id,
+		Request:  "whoami",
+		Headers:  map[string]string{"X-Tenant": "acme"},
+		Metadata: &model.RequestMetadata{Principal: &model.Principal{Name: "jane", AuthMethod: "jwt"}},
+	}, &id)
+
+	select {
+	case response := <-responseChan:
+		return response.Payload.(string)
+	case <-time.After(2 * time.Second):
+		assert.Fail(t, "no response received")
+		return ""
+	}
+}
+
+func TestClusterRegistry_UntrustedRequestCaller(t *testing.T) {
+	registry2, cr1, cr2 := newClusterTestNodes(t)
+
+	// without a shared secret the caller and the headers of the request are not forwarded
+	assert.Equal(t, "anonymous none none", sendClusterWhoAmIRequest(t, registry2))
+
+	// and forged ones are ignored by the remote node
+	id := uuid.New()
+	mh, _ := registry2.bus.ListenOnceForDestination("cluster-service", &id)
+	responseChan := make(chan *model.Response, 1)
+	mh.Handle(func(message *model.Message) {
+		responseChan <- message.Payload.(*model.Response)
+	}, func(e error) {})
+	cr2.trackForwardedRequest(id, "cluster-service", cr1.nodeId)
+	assert.Nil(t, cr2.publish(clusterNodeChannelPrefix+cr1.nodeId, &clusterMessage{
+		Type:     clusterRequestMsg,
+		NodeId:   cr2.nodeId,
+		Channel:  "cluster-service",
+		Request:  &model.Request{Id: &id, Request: "whoami"},
+		Metadata: &model.RequestMetadata{Principal: &model.Principal{Name: "admin", AuthMethod: "jwt"}},
+		Headers:  map[string]string{"X-Tenant": "acme"},
+	}))
+	select {
+	case response := <-responseChan:
+		assert.Equal(t, "anonymous none none", response.Payload)
+	case <-time.After(2 * time.Second):
+		assert.Fail(t, "no response received")
+	}
+
+	assert.Nil(t, cr1.Stop())
+	assert.Nil(t, cr2.Stop())
+}
+
+func TestClusterRegistry_SharedSecret(t *testing.T) {
+	broker := newMockClusterBroker()
+
+	registry1 := newClusterTestServiceRegistry()
+	registry1.RegisterService(newClusterTestService(), "cluster-service")
+	config1 := newClusterTestConfig(broker, "node-1")
+	config1.SharedSecret = "cluster-secret"
+	cr1, _ := registry1.EnableClusterDiscovery(config1)
+
+	// the messages of nodes which don't share the secret are dropped
+	registry2 := newClusterTestServiceRegistry()
+	config2 := newClusterTestConfig(broker, "node-2")
+	config2.SharedSecret = "other-secret"
+	cr2, _ := registry2.EnableClusterDiscovery(config2)
+
+	registry3 := newClusterTestServiceRegistry()
+	registry3.RegisterService(newClusterTestService(), "cluster-service-3")
+	cr3, _ := registry3.EnableClusterDiscovery(newClusterTestConfig(broker, "node-3"))
+
+	registry4 := newClusterTestServiceRegistry()
+	config4 := newClusterTestConfig(broker, "node-4")
+	config4.SharedSecret = "cluster-secret"
+	cr4, _ := registry4.EnableClusterDiscovery(config4)
+
+	assert.Eventually(t, func() bool {
+		return len(cr4.GetServiceNodes("cluster-service")) == 1
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, "jane acme ", sendClusterWhoAmIRequest(t, registry4))
+
+	time.Sleep(100 * time.Millisecond)
+	assert.Len(t, cr1.GetNodes(), 1)
+	assert.Equal(t, cr4.GetLocalNode().Id, cr1.GetNodes()[0].Id)
+	assert.Len(t, cr2.GetNodes(), 0)
+	assert.Len(t, cr4.GetServiceNodes("cluster-service-3"), 0)
+
+	assert.Nil(t, cr1.Stop())
+	assert.Nil(t, cr2.Stop())
+	assert.Nil(t, cr3.Stop())
+	assert.Nil(t, cr4.Stop())
+}
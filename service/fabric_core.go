@@ -38,7 +38,8 @@ type FabricServiceCore interface {
 	// HandleUnknownRequest handles unknown/unsupported/un-implemented requests,
 	HandleUnknownRequest(request *model.Request)
 
-	// RestServiceRequest will make a new RestService call.
+	// RestServiceRequest will make a new RestService call. Set RestServiceRequest.Metadata to the metadata
+	// of the request being handled to continue its trace.
 	RestServiceRequest(restRequest *RestServiceRequest,
 		successHandler model.ResponseHandlerFunction, errorHandler model.ResponseHandlerFunction)
 
@@ -47,6 +48,11 @@ type FabricServiceCore interface {
 	// Global header values can be overridden per request via the RestServiceRequest.Headers property.
 	SetHeaders(headers map[string]string)
 
+	// SetCredentialProvider sets the credential provider for a given fabric service. The provider will be
+	// applied to all requests made by this instance's RestServiceRequest method, unless overridden per
+	// request via the RestServiceRequest.Credentials property.
+	SetCredentialProvider(provider CredentialProvider)
+
 	// GenerateJSONHeaders Automatically ready to go map with json headers.
 	GenerateJSONHeaders() map[string]string
 
@@ -54,10 +60,41 @@ type FabricServiceCore interface {
 	SetDefaultJSONHeaders()
 }
 
+// StatusResponder is implemented by the FabricServiceCore of the services registered with the ServiceRegistry,
+// to answer requests with a status code, e.g.
+//
+//	core.(service.StatusResponder).SendResponseWithStatus(request, 201, item, nil)
+type StatusResponder interface {
+	// SendResponseWithStatus is the same as SendResponseWithHeaders, but sets the HTTP status code REST bridges
+	// answer with as well, for example 201 Created, 202 Accepted or 204 No Content. headers can be nil.
+	SendResponseWithStatus(request *model.Request, statusCode int, responsePayload interface{}, headers map[string]string)
+}
+
+// ResponseStreamer is implemented by the FabricServiceCore of the services registered with the ServiceRegistry,
+// to respond to requests with a stream of responses, e.g.
+//
+//	stream := core.(service.ResponseStreamer).OpenResponseStream(request)
+type ResponseStreamer interface {
+	// OpenResponseStream opens a stream to send the response to the "request" param as a series of responses
+	// on the service channel, instead of a single one. The stream has to be closed once all the responses
+	// are sent, before returning from HandleServiceRequest. Streams are bridged to chunked NDJSON or
+	// server-sent events HTTP responses by plank.
+	OpenResponseStream(request *model.Request) *ResponseStream
+}
+
 type fabricCore struct {
 	channelName string
 	bus         bus.EventBus
+	// the instance of the service the core belongs to
+	service     FabricService
 	headers     map[string]string
+	credentials CredentialProvider
+	// optional cache of the successful responses of the service
+	cache *responseCache
+	// open response streams of the service, shared by all the cores of the service
+	streams *responseStreams
+	// optional function returning the interceptors applied to the responses of the service
+	interceptors func() []*ServiceInterceptor
 }
 
 func (core *fabricCore) Bus() bus.EventBus {
@@ -75,7 +112,7 @@ func (core *fabricCore) SendResponse(request *model.Request, responsePayload int
 		Headers:           headers,
 		BrokerDestination: request.BrokerDestination,
 	}
-	core.bus.SendResponseMessage(core.channelName, response, request.Id)
+	core.sendCacheableResponse(request, response)
 }
 
 func (core *fabricCore) SendResponseWithHeaders(request *model.Request, responsePayload interface{}, headers map[string]string) {
@@ -89,7 +126,23 @@ func (core *fabricCore) SendResponseWithHeaders(request *model.Request, response
 		BrokerDestination: request.BrokerDestination,
 		Headers:           headers,
 	}
-	core.bus.SendResponseMessage(core.channelName, response, request.Id)
+	core.sendCacheableResponse(request, response)
+}
+
+func (core *fabricCore) SendResponseWithStatus(
+	request *model.Request, statusCode int, responsePayload interface{}, headers map[string]string) {
+
+	headers = core.mergeHeadersWithDefaults(headers)
+
+	response := &model.Response{
+		Id:                request.Id,
+		Destination:       core.channelName,
+		Payload:           responsePayload,
+		StatusCode:        statusCode,
+		BrokerDestination: request.BrokerDestination,
+		Headers:           headers,
+	}
+	core.sendCacheableResponse(request, response)
 }
 
 func (core *fabricCore) SendErrorResponse(
@@ -113,7 +166,7 @@ func (core *fabricCore) SendErrorResponseWithPayload(
 		ErrorMessage:      responseErrorMessage,
 		BrokerDestination: request.BrokerDestination,
 	}
-	core.bus.SendResponseMessage(core.channelName, response, request.Id)
+	core.sendResponse(request, response)
 }
 
 func (core *fabricCore) SendErrorResponseWithHeaders(
@@ -131,7 +184,7 @@ func (core *fabricCore) SendErrorResponseWithHeaders(
 		ErrorMessage:      responseErrorMessage,
 		BrokerDestination: request.BrokerDestination,
 	}
-	core.bus.SendResponseMessage(core.channelName, response, request.Id)
+	core.sendResponse(request, response)
 }
 
 func (core *fabricCore) SendErrorResponseWithHeadersAndPayload(
@@ -150,9 +203,48 @@ func (core *fabricCore) SendErrorResponseWithHeadersAndPayload(
 		ErrorMessage:      responseErrorMessage,
 		BrokerDestination: request.BrokerDestination,
 	}
+	core.sendResponse(request, response)
+}
+
+// sendCacheableResponse caches the response, if the service is cached, and sends it.
+func (core *fabricCore) sendCacheableResponse(request *model.Request, response *model.Response) {
+	if core.cache != nil {
+		core.cache.store(request, response)
+	}
+	core.sendResponse(request, response)
+}
+
+// sendResponse passes the response through the response interceptors
+// and sends it on the service channel.
+func (core *fabricCore) sendResponse(request *model.Request, response *model.Response) {
+	if core.interceptors != nil {
+		response = interceptResponse(core.interceptors(), request, response)
+		if response == nil {
+			return
+		}
+	}
+	core.bus.SendMonitorEvent(bus.ServiceResponseSentEvt, core.channelName, &ServiceResponseMonitorData{
+		Request:   request.Request,
+		Supported: isSupportedRequest(core.service, request.Request),
+		Error:     response.Error,
+		ErrorCode: response.ErrorCode,
+	})
 	core.bus.SendResponseMessage(core.channelName, response, request.Id)
 }
 
+func (core *fabricCore) OpenResponseStream(request *model.Request) *ResponseStream {
+	stream := &ResponseStream{
+		request: request,
+		core:    core,
+		owner:   responseStreamOwner(request.Metadata),
+		done:    make(chan struct{}),
+	}
+	if core.streams != nil && request.Id != nil {
+		core.streams.add(*request.Id, stream)
+	}
+	return stream
+}
+
 func (core *fabricCore) HandleUnknownRequest(request *model.Request) {
 	errorMsg := fmt.Sprintf("unsupported request for \"%s\": %s", core.channelName, request.Request)
 	core.SendErrorResponse(request, 403, errorMsg)
@@ -162,6 +254,10 @@ func (core *fabricCore) SetHeaders(headers map[string]string) {
 	core.headers = headers
 }
 
+func (core *fabricCore) SetCredentialProvider(provider CredentialProvider) {
+	core.credentials = provider
+}
+
 func (core *fabricCore) GenerateJSONHeaders() map[string]string {
 	return map[string]string{"Content-Type": "application/json"}
 }
@@ -199,12 +295,21 @@ func (core *fabricCore) RestServiceRequest(restRequest *RestServiceRequest,
 		mergedHeaders[k] = v
 	}
 	restRequest.Headers = mergedHeaders
+	if restRequest.Credentials == nil {
+		restRequest.Credentials = core.credentials
+	}
 
 	id := uuid.New()
 	request := &model.Request{
 		Id:      &id,
 		Payload: restRequest,
 	}
+	if restRequest.Metadata != nil && restRequest.Metadata.TraceParent != "" {
+		request.Metadata = &model.RequestMetadata{
+			TraceParent: restRequest.Metadata.TraceParent,
+			TraceState:  restRequest.Metadata.TraceState,
+		}
+	}
 	mh, _ := core.bus.ListenOnceForDestination(restServiceChannel, request.Id)
 	mh.Handle(func(message *model.Message) {
 		response := message.Payload.(*model.Response)
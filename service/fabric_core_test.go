@@ -141,6 +141,19 @@ func TestFabricCore_SendMethods(t *testing.T) {
 	assert.True(t, response.Error)
 	assert.Equal(t, 403, response.ErrorCode)
 	assert.Equal(t, nil, response.Payload)
+
+	wg.Add(1)
+	core.(StatusResponder).SendResponseWithStatus(&req, 201, "created", map[string]string{"Location": "/rest/items/1"})
+	wg.Wait()
+
+	assert.Equal(t, count, 7)
+	response = lastMessage.Payload.(*model.Response)
+
+	assert.Equal(t, response.Id, req.Id)
+	assert.False(t, response.Error)
+	assert.Equal(t, 201, response.StatusCode)
+	assert.Equal(t, "created", response.Payload)
+	assert.Equal(t, "/rest/items/1", response.Headers["Location"])
 }
 
 func TestFabricCore_RestServiceRequest(t *testing.T) {
@@ -322,3 +335,33 @@ func TestFabricCore_SetDefaultJSONHeadersEmpty(t *testing.T) {
 	assert.Len(t, response.Headers, 1)
 	assert.EqualValues(t, "pizza/cake", response.Headers["Content-Type"])
 }
+
+func TestFabricCore_SetCredentialProvider(t *testing.T) {
+	core := newTestFabricCore("test-channel")
+	core.Bus().GetChannelManager().CreateChannel(restServiceChannel)
+
+	wg := sync.WaitGroup{}
+	var lastRequest *model.Request
+	mh, _ := core.Bus().ListenRequestStream(restServiceChannel)
+	mh.Handle(
+		func(message *model.Message) {
+			lastRequest = message.Payload.(*model.Request)
+			wg.Done()
+		},
+		func(e error) {})
+
+	provider := NewFileBearerTokenProvider("token")
+	core.SetCredentialProvider(provider)
+
+	wg.Add(1)
+	core.RestServiceRequest(&RestServiceRequest{Uri: "test"}, nil, nil)
+	wg.Wait()
+	assert.Equal(t, provider, lastRequest.Payload.(*RestServiceRequest).Credentials)
+
+	// the provider of the request overrides the provider of the service
+	requestProvider := NewFileBearerTokenProvider("request-token")
+	wg.Add(1)
+	core.RestServiceRequest(&RestServiceRequest{Uri: "test", Credentials: requestProvider}, nil, nil)
+	wg.Wait()
+	assert.Equal(t, requestProvider, lastRequest.Payload.(*RestServiceRequest).Credentials)
+}
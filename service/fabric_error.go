@@ -9,6 +9,14 @@ type FabricError struct {
 	Instance string `json:"instance,omitempty"`
 }
 
+// Error makes FabricError usable as an error, e.g. as the error of a bus message relayed by the REST bridge.
+func (fe FabricError) Error() string {
+	if fe.Detail == "" {
+		return fe.Title
+	}
+	return fe.Title + ": " + fe.Detail
+}
+
 // GetFabricError will return a structured, standardized Error object that is compliant
 // with RFC7807 standard error properties (https://tools.ietf.org/html/rfc7807)
 func GetFabricError(message string, code int, detail string) FabricError {
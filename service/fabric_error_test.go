@@ -13,3 +13,8 @@ func TestGetFabricError(t *testing.T) {
 	assert.Equal(t, 500, fe.Status)
 
 }
+
+func TestFabricError_Error(t *testing.T) {
+	assert.Equal(t, "test: something is wrong", GetFabricError("test", 500, "something is wrong").Error())
+	assert.Equal(t, "test", GetFabricError("test", 500, "").Error())
+}
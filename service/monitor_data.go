@@ -0,0 +1,39 @@
+// Copyright 2019-2021 VMware, Inc.
+// SPDX-License-Identifier: BSD-2-Clause
+
+package service
+
+import "time"
+
+// ServiceRequestMonitorData is the data of the bus.ServiceRequestHandledEvt monitor events, sent
+// once a service has returned from handling a request.
+type ServiceRequestMonitorData struct {
+	Request   string        `json:"request"`
+	Supported bool          `json:"supported"` // whether the request is one of the commands the service advertises
+	Latency   time.Duration `json:"latency"`
+}
+
+// ServiceResponseMonitorData is the data of the bus.ServiceResponseSentEvt monitor events, sent
+// for every response a service sends, error responses included.
+type ServiceResponseMonitorData struct {
+	Request   string `json:"request"`
+	Supported bool   `json:"supported"` // whether the request is one of the commands the service advertises
+	Error     bool   `json:"error"`
+	ErrorCode int    `json:"errorCode,omitempty"`
+}
+
+// isSupportedRequest returns whether the request is one of the commands the service advertises through
+// RequestCommandsProvider, false if the service does not advertise its commands.
+func isSupportedRequest(service FabricService, request string) bool {
+	if router, ok := service.(interface{ SupportsCommand(string) bool }); ok {
+		return router.SupportsCommand(request)
+	}
+	if provider, ok := service.(RequestCommandsProvider); ok {
+		for _, command := range provider.SupportedCommands() {
+			if command.Command == request {
+				return true
+			}
+		}
+	}
+	return false
+}
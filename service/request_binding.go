@@ -0,0 +1,518 @@
+// Copyright 2019-2021 VMware, Inc.
+// SPDX-License-Identifier: BSD-2-Clause
+
+package service
+
+import (
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// DefaultMaxBindingBodySize is the maximum size of the bodies bound by a RequestBinding without MaxBodySize.
+const DefaultMaxBindingBodySize int64 = 10 << 20
+
+// RequestBinding declaratively builds the transport request of a REST bridge from the HTTP request, as an
+// alternative to writing a RequestBuilder. The payload of the request is a pointer to a new value of PayloadType,
+// a struct whose fields are bound with the following tags:
+//
+//	path:"name"       path variable of the bridge URI
+//	query:"name"      query parameter, slice fields receive all values of repeated parameters
+//	header:"Name"     HTTP request header
+//	form:"name"       field of url-encoded or multipart form bodies, []byte fields receive uploaded files
+//	default:"value"   value of path, query, header and form fields missing from the request
+//	validate:"rules"  comma separated list of required, min=n, max=n and oneof=a b c
+//
+// Exported fields without path, query, header or form tag are decoded from JSON bodies using their json tags.
+// Bodies with other content types are rejected with 415, bodies bigger than MaxBodySize with 413. Fields failing
+// to convert or to validate are reported together in a 400 BindingError. Rules other than required are only
+// checked for non-zero values, min and max apply to the value of numbers and to the length of strings, slices
+// and maps.
+type RequestBinding struct {
+	Request     string       // command of the transport request
+	PayloadType reflect.Type // struct type the HTTP request is bound to
+	MaxBodySize int64        // maximum size of the request body in bytes, defaults to DefaultMaxBindingBodySize
+}
+
+// BindingField describes a field of the payload type of a RequestBinding and where it is bound from.
+type BindingField struct {
+	Name     string              // name of the path variable, query parameter, header, form or JSON field
+	In       string              // one of "path", "query", "header", "form" or "body"
+	Required bool                // whether the field has the required validation rule
+	Default  string              // value of the default tag
+	Field    reflect.StructField // the struct field itself
+	index    []int
+	rules    string
+}
+
+// BindingError is returned by RequestBinding.Bind for HTTP requests that could not be bound. Code is the HTTP
+// status code of the error and Fields lists the fields that failed to convert or to validate.
+type BindingError struct {
+	Code    int           `json:"errorCode"`
+	Message string        `json:"errorMessage"`
+	Fields  []*FieldError `json:"fields,omitempty"`
+}
+
+// FieldError describes why a field of the HTTP request could not be bound.
+type FieldError struct {
+	Field   string `json:"field"`
+	In      string `json:"in"`
+	Message string `json:"message"`
+}
+
+func (e *BindingError) Error() string {
+	if len(e.Fields) == 0 {
+		return e.Message
+	}
+	details := make([]string, len(e.Fields))
+	for i, field := range e.Fields {
+		details[i] = fmt.Sprintf("%s %s", field.Field, field.Message)
+	}
+	return fmt.Sprintf("%s: %s", e.Message, strings.Join(details, ", "))
+}
+
+var (
+	bindingSources      = []string{"path", "query", "header", "form"}
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	durationType        = reflect.TypeOf(time.Duration(0))
+	errUnsupportedType  = errors.New("unsupported field type")
+)
+
+// Fields returns the fields of the payload type bound from the HTTP request, in the order of their declaration.
+func (b *RequestBinding) Fields() []BindingField {
+	payloadType := b.PayloadType
+	if payloadType != nil && payloadType.Kind() == reflect.Ptr {
+		payloadType = payloadType.Elem()
+	}
+	if payloadType == nil || payloadType.Kind() != reflect.Struct {
+		return nil
+	}
+	return collectBindingFields(payloadType, nil)
+}
+
+func collectBindingFields(t reflect.Type, index []int) []BindingField {
+	fields := make([]BindingField, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		fieldIndex := append(append([]int{}, index...), i)
+		if !sf.IsExported() && !sf.Anonymous {
+			continue
+		}
+		field := BindingField{Field: sf, Default: sf.Tag.Get("default"), index: fieldIndex, rules: sf.Tag.Get("validate")}
+		for _, source := range bindingSources {
+			if name, ok := sf.Tag.Lookup(source); ok {
+				field.Name, field.In = name, source
+				break
+			}
+		}
+		if field.In == "" {
+			jsonName, _, _ := strings.Cut(sf.Tag.Get("json"), ",")
+			if jsonName == "-" {
+				continue
+			}
+			// fields of embedded structs are bound as if they were declared by the payload type
+			if sf.Anonymous && jsonName == "" && sf.Type.Kind() == reflect.Struct {
+				fields = append(fields, collectBindingFields(sf.Type, fieldIndex)...)
+				continue
+			}
+			if !sf.IsExported() {
+				continue
+			}
+			if jsonName == "" {
+				jsonName = sf.Name
+			}
+			field.Name, field.In = jsonName, "body"
+		}
+		if field.Name == "" {
+			field.Name = sf.Name
+		}
+		for _, rule := range strings.Split(field.rules, ",") {
+			if strings.TrimSpace(rule) == "required" {
+				field.Required = true
+			}
+		}
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+// Bind binds the HTTP request to a new value of PayloadType and returns a pointer to it. pathParams are the
+// path variables of the request, e.g. mux.Vars(r). Returns a *BindingError if the request can't be bound.
+func (b *RequestBinding) Bind(r *http.Request, pathParams map[string]string) (interface{}, error) {
+	payloadType := b.PayloadType
+	if payloadType != nil && payloadType.Kind() == reflect.Ptr {
+		payloadType = payloadType.Elem()
+	}
+	if payloadType == nil || payloadType.Kind() != reflect.Struct {
+		return nil, &BindingError{Code: http.StatusInternalServerError,
+			Message: fmt.Sprintf("request binding of \"%s\" has no struct payload type", b.Request)}
+	}
+
+	payload := reflect.New(payloadType)
+	fields := b.Fields()
+	if err := b.bindBody(r, payload, fields); err != nil {
+		return nil, err
+	}
+	if r.MultipartForm != nil {
+		// the uploaded files are read into the payload, drop the ones spilled to disk
+		defer r.MultipartForm.RemoveAll()
+	}
+
+	bindingErr := &BindingError{Code: http.StatusBadRequest, Message: "invalid request"}
+	for _, field := range fields {
+		value := payload.Elem().FieldByIndex(field.index)
+		if field.In != "body" {
+			values := lookupBindingValues(r, pathParams, field)
+			if len(values) == 0 && field.Default != "" && value.IsZero() {
+				values = []string{field.Default}
+			}
+			if len(values) > 0 {
+				if err := setBindingValue(value, values); err != nil {
+					if errors.Is(err, errUnsupportedType) {
+						return nil, &BindingError{Code: http.StatusInternalServerError,
+							Message: fmt.Sprintf("field %s of %s has an unsupported type %s",
+								field.Field.Name, payloadType.String(), field.Field.Type.String())}
+					}
+					bindingErr.Fields = append(bindingErr.Fields, &FieldError{
+						Field: field.Name, In: field.In, Message: err.Error()})
+					continue
+				}
+			}
+		}
+		message, err := validateBindingValue(value, field.rules)
+		if err != nil {
+			return nil, &BindingError{Code: http.StatusInternalServerError,
+				Message: fmt.Sprintf("field %s of %s: %s", field.Field.Name, payloadType.String(), err.Error())}
+		}
+		if message != "" {
+			bindingErr.Fields = append(bindingErr.Fields, &FieldError{Field: field.Name, In: field.In, Message: message})
+		}
+	}
+	if len(bindingErr.Fields) > 0 {
+		return nil, bindingErr
+	}
+	return payload.Interface(), nil
+}
+
+// bindBody decodes JSON bodies into the body fields of the payload and parses form bodies, whose fields are
+// bound afterwards like the other request values.
+func (b *RequestBinding) bindBody(r *http.Request, payload reflect.Value, fields []BindingField) *BindingError {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil
+	}
+	maxBodySize := b.MaxBodySize
+	if maxBodySize <= 0 {
+		maxBodySize = DefaultMaxBindingBodySize
+	}
+	tooLarge := &BindingError{Code: http.StatusRequestEntityTooLarge,
+		Message: fmt.Sprintf("request body is larger than %d bytes", maxBodySize)}
+
+	var mediaType string
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		var err error
+		if mediaType, _, err = mime.ParseMediaType(contentType); err != nil {
+			return &BindingError{Code: http.StatusUnsupportedMediaType,
+				Message: fmt.Sprintf("invalid Content-Type %s", contentType)}
+		}
+	}
+
+	switch mediaType {
+	case "application/x-www-form-urlencoded", "multipart/form-data":
+		r.Body = http.MaxBytesReader(nil, r.Body, maxBodySize)
+		var err error
+		if mediaType == "multipart/form-data" {
+			err = r.ParseMultipartForm(maxBodySize)
+		} else {
+			err = r.ParseForm()
+		}
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				return tooLarge
+			}
+			return &BindingError{Code: http.StatusBadRequest, Message: fmt.Sprintf("malformed form body: %s", err.Error())}
+		}
+		return nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBodySize+1))
+	if err != nil {
+		return &BindingError{Code: http.StatusBadRequest, Message: fmt.Sprintf("unable to read request body: %s", err.Error())}
+	}
+	if int64(len(body)) > maxBodySize {
+		return tooLarge
+	}
+	if len(body) == 0 {
+		return nil
+	}
+	if mediaType != "" && mediaType != "application/json" && !strings.HasSuffix(mediaType, "+json") {
+		return &BindingError{Code: http.StatusUnsupportedMediaType,
+			Message: fmt.Sprintf("unsupported Content-Type %s, expected application/json or a form", mediaType)}
+	}
+
+	// the body is decoded into a struct holding only the body fields, the fields bound from the path, query,
+	// headers or form can't be set by the body
+	bodyFields := make([]BindingField, 0, len(fields))
+	structFields := make([]reflect.StructField, 0, len(fields))
+	for _, field := range fields {
+		if field.In == "body" {
+			_, options, _ := strings.Cut(field.Field.Tag.Get("json"), ",")
+			bodyFields = append(bodyFields, field)
+			structFields = append(structFields, reflect.StructField{
+				Name: fmt.Sprintf("Field%d", len(structFields)),
+				Type: field.Field.Type,
+				Tag:  reflect.StructTag(fmt.Sprintf("json:%s", strconv.Quote(field.Name+","+options))),
+			})
+		}
+	}
+	decoded := reflect.New(reflect.StructOf(structFields))
+	if err = json.Unmarshal(body, decoded.Interface()); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) && typeErr.Field != "" {
+			return &BindingError{Code: http.StatusBadRequest, Message: "invalid request", Fields: []*FieldError{
+				{Field: typeErr.Field, In: "body", Message: fmt.Sprintf("must be %s", describeType(typeErr.Type))}}}
+		}
+		return &BindingError{Code: http.StatusBadRequest, Message: fmt.Sprintf("malformed JSON body: %s", err.Error())}
+	}
+	for i, field := range bodyFields {
+		payload.Elem().FieldByIndex(field.index).Set(decoded.Elem().Field(i))
+	}
+	return nil
+}
+
+// lookupBindingValues returns the values of the request bound to the field, or nothing if the request has none.
+func lookupBindingValues(r *http.Request, pathParams map[string]string, field BindingField) []string {
+	switch field.In {
+	case "path":
+		if value, ok := pathParams[field.Name]; ok {
+			return []string{value}
+		}
+	case "query":
+		return r.URL.Query()[field.Name]
+	case "header":
+		return r.Header.Values(field.Name)
+	case "form":
+		if r.MultipartForm != nil && isByteSlice(field.Field.Type) {
+			if files := r.MultipartForm.File[field.Name]; len(files) > 0 {
+				file, err := files[0].Open()
+				if err != nil {
+					return nil
+				}
+				defer file.Close()
+				content, err := io.ReadAll(file)
+				if err != nil {
+					return nil
+				}
+				return []string{string(content)}
+			}
+		}
+		return r.PostForm[field.Name]
+	}
+	return nil
+}
+
+// setBindingValue converts the values into the type of the field and sets it. slice fields receive all
+// values, other fields only the first one.
+func setBindingValue(value reflect.Value, values []string) error {
+	if value.Kind() == reflect.Ptr {
+		elem := reflect.New(value.Type().Elem())
+		if err := setBindingValue(elem.Elem(), values); err != nil {
+			return err
+		}
+		value.Set(elem)
+		return nil
+	}
+	if value.Kind() == reflect.Slice && !isByteSlice(value.Type()) &&
+		!reflect.PtrTo(value.Type()).Implements(textUnmarshalerType) {
+		slice := reflect.MakeSlice(value.Type(), len(values), len(values))
+		for i, v := range values {
+			if err := setBindingValue(slice.Index(i), []string{v}); err != nil {
+				return err
+			}
+		}
+		value.Set(slice)
+		return nil
+	}
+	return convertBindingValue(value, values[0])
+}
+
+func convertBindingValue(value reflect.Value, s string) error {
+	if value.CanAddr() && value.Addr().Type().Implements(textUnmarshalerType) {
+		if err := value.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s)); err != nil {
+			return fmt.Errorf("must be %s", describeType(value.Type()))
+		}
+		return nil
+	}
+	if value.Type() == durationType {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("must be %s", describeType(value.Type()))
+		}
+		value.SetInt(int64(d))
+		return nil
+	}
+
+	switch value.Kind() {
+	case reflect.String:
+		value.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("must be %s", describeType(value.Type()))
+		}
+		value.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(s, 10, value.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("must be %s", describeType(value.Type()))
+		}
+		value.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(s, 10, value.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("must be %s", describeType(value.Type()))
+		}
+		value.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, value.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("must be %s", describeType(value.Type()))
+		}
+		value.SetFloat(f)
+	case reflect.Slice:
+		if !isByteSlice(value.Type()) {
+			return errUnsupportedType
+		}
+		value.SetBytes([]byte(s))
+	default:
+		return errUnsupportedType
+	}
+	return nil
+}
+
+// describeType describes the values expected for a type in field error messages
+func describeType(t reflect.Type) string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch {
+	case t == durationType:
+		return "a duration"
+	case t == reflect.TypeOf(time.Time{}):
+		return "an RFC 3339 date-time"
+	case reflect.PtrTo(t).Implements(textUnmarshalerType) && t.Name() != "":
+		return fmt.Sprintf("a valid %s", t.Name())
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return "an integer"
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "a non-negative integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.String:
+		return "a string"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	case reflect.Map, reflect.Struct:
+		return "an object"
+	}
+	return fmt.Sprintf("a valid %s", t.String())
+}
+
+func isByteSlice(t reflect.Type) bool {
+	return t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8
+}
+
+// validateBindingValue checks the value against the validation rules of its field. returns a message describing
+// the first rule the value breaks, or an error if the rules are invalid.
+func validateBindingValue(value reflect.Value, rules string) (string, error) {
+	if rules == "" {
+		return "", nil
+	}
+	for _, rule := range strings.Split(rules, ",") {
+		name, arg, _ := strings.Cut(strings.TrimSpace(rule), "=")
+		switch name {
+		case "":
+		case "required":
+			if value.IsZero() {
+				return "is required", nil
+			}
+		case "min", "max", "oneof":
+			if value.IsZero() {
+				continue
+			}
+			message, err := checkBindingRule(reflect.Indirect(value), name, arg)
+			if err != nil || message != "" {
+				return message, err
+			}
+		default:
+			return "", fmt.Errorf("unknown validation rule \"%s\"", name)
+		}
+	}
+	return "", nil
+}
+
+func checkBindingRule(value reflect.Value, name string, arg string) (string, error) {
+	if name == "oneof" {
+		options := strings.Fields(arg)
+		values := []reflect.Value{value}
+		if value.Kind() == reflect.Slice && !isByteSlice(value.Type()) {
+			values = values[:0]
+			for i := 0; i < value.Len(); i++ {
+				values = append(values, value.Index(i))
+			}
+		}
+		for _, v := range values {
+			found := false
+			for _, option := range options {
+				found = found || fmt.Sprint(v.Interface()) == option
+			}
+			if !found {
+				return fmt.Sprintf("must be one of %s", strings.Join(options, ", ")), nil
+			}
+		}
+		return "", nil
+	}
+
+	limit, err := strconv.ParseFloat(arg, 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid %s validation rule argument \"%s\"", name, arg)
+	}
+	var actual float64
+	var min, max string
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		actual, min, max = float64(value.Int()), "must be at least %s", "must be at most %s"
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		actual, min, max = float64(value.Uint()), "must be at least %s", "must be at most %s"
+	case reflect.Float32, reflect.Float64:
+		actual, min, max = value.Float(), "must be at least %s", "must be at most %s"
+	case reflect.String:
+		actual = float64(utf8.RuneCountInString(value.String()))
+		min, max = "must be at least %s characters long", "must be at most %s characters long"
+	case reflect.Slice, reflect.Map, reflect.Array:
+		actual, min, max = float64(value.Len()), "must contain at least %s items", "must contain at most %s items"
+	default:
+		return "", fmt.Errorf("%s validation rule can't be applied to %s", name, value.Type().String())
+	}
+
+	if name == "min" && actual < limit {
+		return fmt.Sprintf(min, arg), nil
+	}
+	if name == "max" && actual > limit {
+		return fmt.Sprintf(max, arg), nil
+	}
+	return "", nil
+}
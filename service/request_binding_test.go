@@ -0,0 +1,205 @@
+// Copyright 2019-2021 VMware, Inc.
+// SPDX-License-Identifier: BSD-2-Clause
+
+package service
+
+import (
+	"bytes"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type bindingTestPaging struct {
+	Page  int `query:"page" default:"1" validate:"min=1"`
+	Limit int `query:"limit" default:"20" validate:"min=1,max=100"`
+}
+
+type bindingTestRequest struct {
+	bindingTestPaging
+	Id        uuid.UUID     `path:"id"`
+	Tags      []string      `query:"tag" validate:"max=2,oneof=red blue"`
+	Since     *time.Time    `query:"since"`
+	Timeout   time.Duration `query:"timeout"`
+	Verbose   bool          `query:"verbose"`
+	Tenant    string        `header:"X-Tenant" validate:"required"`
+	Name      string        `json:"name" validate:"required,min=2"`
+	Score     float64       `json:"score" validate:"max=10"`
+	Ignored   string        `json:"-"`
+	internal  string
+	Unchanged string
+}
+
+func newBindingTestRequest(method, target, contentType, body string) *http.Request {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	if body == "" {
+		r = httptest.NewRequest(method, target, nil)
+	}
+	if contentType != "" {
+		r.Header.Set("Content-Type", contentType)
+	}
+	r.Header.Set("X-Tenant", "acme")
+	return r
+}
+
+func TestRequestBinding_Fields(t *testing.T) {
+	binding := &RequestBinding{PayloadType: reflect.TypeOf(&bindingTestRequest{})}
+	var described []string
+	for _, field := range binding.Fields() {
+		described = append(described, field.In+":"+field.Name)
+	}
+	assert.Equal(t, []string{"query:page", "query:limit", "path:id", "query:tag", "query:since", "query:timeout",
+		"query:verbose", "header:X-Tenant", "body:name", "body:score", "body:Unchanged"}, described)
+	assert.True(t, binding.Fields()[7].Required)
+	assert.Equal(t, "20", binding.Fields()[1].Default)
+
+	assert.Nil(t, (&RequestBinding{PayloadType: reflect.TypeOf("")}).Fields())
+}
+
+func TestRequestBinding_Bind(t *testing.T) {
+	binding := &RequestBinding{Request: "update", PayloadType: reflect.TypeOf(bindingTestRequest{})}
+	id := uuid.New()
+	r := newBindingTestRequest(http.MethodPut,
+		"http://localhost/customers?tag=red&tag=blue&since=2021-01-02T03:04:05Z&timeout=5s&verbose=true&page=3",
+		"application/json; charset=utf-8", `{"name":"alice","score":9.5,"Ignored":"x"}`)
+
+	payload, err := binding.Bind(r, map[string]string{"id": id.String()})
+	assert.Nil(t, err)
+	since := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+	assert.Equal(t, &bindingTestRequest{
+		bindingTestPaging: bindingTestPaging{Page: 3, Limit: 20},
+		Id:                id,
+		Tags:              []string{"red", "blue"},
+		Since:             &since,
+		Timeout:           5 * time.Second,
+		Verbose:           true,
+		Tenant:            "acme",
+		Name:              "alice",
+		Score:             9.5,
+	}, payload)
+}
+
+func TestRequestBinding_BindFieldErrors(t *testing.T) {
+	binding := &RequestBinding{PayloadType: reflect.TypeOf(bindingTestRequest{})}
+	r := newBindingTestRequest(http.MethodPost,
+		"http://localhost/customers?page=first&limit=500&tag=green&timeout=soon", "", `{"name":"a","score":11}`)
+	r.Header.Del("X-Tenant")
+
+	_, err := binding.Bind(r, map[string]string{"id": "not-a-uuid"})
+	bindingErr := err.(*BindingError)
+	assert.Equal(t, http.StatusBadRequest, bindingErr.Code)
+	assert.Equal(t, []*FieldError{
+		{Field: "page", In: "query", Message: "must be an integer"},
+		{Field: "limit", In: "query", Message: "must be at most 100"},
+		{Field: "id", In: "path", Message: "must be a valid UUID"},
+		{Field: "tag", In: "query", Message: "must be one of red, blue"},
+		{Field: "timeout", In: "query", Message: "must be a duration"},
+		{Field: "X-Tenant", In: "header", Message: "is required"},
+		{Field: "name", In: "body", Message: "must be at least 2 characters long"},
+		{Field: "score", In: "body", Message: "must be at most 10"},
+	}, bindingErr.Fields)
+	assert.Contains(t, bindingErr.Error(), "invalid request: page must be an integer, limit must be at most 100")
+
+	// body fields with the wrong type
+	_, err = binding.Bind(newBindingTestRequest(http.MethodPost, "http://localhost", "", `{"name":5}`), nil)
+	assert.Equal(t, []*FieldError{{Field: "name", In: "body", Message: "must be a string"}}, err.(*BindingError).Fields)
+
+	// missing bodies leave the body fields empty
+	_, err = binding.Bind(newBindingTestRequest(http.MethodGet, "http://localhost", "", ""), nil)
+	assert.Equal(t, []*FieldError{{Field: "name", In: "body", Message: "is required"}}, err.(*BindingError).Fields)
+}
+
+func TestRequestBinding_BindBodyOnlySetsBodyFields(t *testing.T) {
+	binding := &RequestBinding{PayloadType: reflect.TypeOf(bindingTestRequest{})}
+	r := newBindingTestRequest(http.MethodPost, "http://localhost", "application/json",
+		`{"name":"alice","Tenant":"evil","Page":7,"Limit":50,"Verbose":true,"Unchanged":"set"}`)
+	r.Header.Del("X-Tenant")
+
+	// the body neither sets nor satisfies the header field
+	_, err := binding.Bind(r, nil)
+	assert.Equal(t, []*FieldError{{Field: "X-Tenant", In: "header", Message: "is required"}}, err.(*BindingError).Fields)
+
+	r = newBindingTestRequest(http.MethodPost, "http://localhost", "application/json",
+		`{"name":"alice","Tenant":"evil","Page":7,"Limit":50,"Verbose":true,"Unchanged":"set"}`)
+	payload, err := binding.Bind(r, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, &bindingTestRequest{
+		bindingTestPaging: bindingTestPaging{Page: 1, Limit: 20},
+		Tenant:            "acme",
+		Name:              "alice",
+		Unchanged:         "set",
+	}, payload)
+}
+
+func TestRequestBinding_BindBodyErrors(t *testing.T) {
+	binding := &RequestBinding{PayloadType: reflect.TypeOf(bindingTestRequest{}), MaxBodySize: 32}
+
+	_, err := binding.Bind(newBindingTestRequest(http.MethodPost, "http://localhost", "text/plain", "alice"), nil)
+	assert.Equal(t, http.StatusUnsupportedMediaType, err.(*BindingError).Code)
+
+	_, err = binding.Bind(newBindingTestRequest(http.MethodPost, "http://localhost", "application/json", `{"name":`), nil)
+	assert.Equal(t, http.StatusBadRequest, err.(*BindingError).Code)
+	assert.Contains(t, err.Error(), "malformed JSON body")
+
+	_, err = binding.Bind(newBindingTestRequest(http.MethodPost, "http://localhost", "application/json",
+		`{"name":"`+strings.Repeat("a", 32)+`"}`), nil)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, err.(*BindingError).Code)
+
+	_, err = binding.Bind(newBindingTestRequest(http.MethodPost, "http://localhost", "application/x-www-form-urlencoded",
+		"name="+strings.Repeat("a", 32)), nil)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, err.(*BindingError).Code)
+}
+
+func TestRequestBinding_BindForms(t *testing.T) {
+	type upload struct {
+		Title   string   `form:"title" validate:"required"`
+		Labels  []string `form:"label"`
+		Content []byte   `form:"content"`
+		Public  *bool    `form:"public"`
+	}
+	binding := &RequestBinding{PayloadType: reflect.TypeOf(upload{})}
+
+	form := url.Values{"title": {"report"}, "label": {"a", "b"}, "public": {"false"}}
+	payload, err := binding.Bind(newBindingTestRequest(http.MethodPost, "http://localhost",
+		"application/x-www-form-urlencoded", form.Encode()), nil)
+	assert.Nil(t, err)
+	public := false
+	assert.Equal(t, &upload{Title: "report", Labels: []string{"a", "b"}, Public: &public}, payload)
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	_ = writer.WriteField("title", "report")
+	file, _ := writer.CreateFormFile("content", "report.txt")
+	_, _ = file.Write([]byte("file contents"))
+	_ = writer.Close()
+	payload, err = binding.Bind(newBindingTestRequest(http.MethodPost, "http://localhost",
+		writer.FormDataContentType(), body.String()), nil)
+	assert.Nil(t, err)
+	assert.Equal(t, &upload{Title: "report", Content: []byte("file contents")}, payload)
+}
+
+func TestRequestBinding_BindInvalidBindings(t *testing.T) {
+	r := newBindingTestRequest(http.MethodGet, "http://localhost?value=1", "", "")
+
+	_, err := (&RequestBinding{PayloadType: reflect.TypeOf(map[string]string{})}).Bind(r, nil)
+	assert.Equal(t, http.StatusInternalServerError, err.(*BindingError).Code)
+
+	_, err = (&RequestBinding{PayloadType: reflect.TypeOf(struct {
+		Value map[string]string `query:"value"`
+	}{})}).Bind(r, nil)
+	assert.Equal(t, http.StatusInternalServerError, err.(*BindingError).Code)
+	assert.Contains(t, err.Error(), "unsupported type")
+
+	_, err = (&RequestBinding{PayloadType: reflect.TypeOf(struct {
+		Value int `query:"value" validate:"positive"`
+	}{})}).Bind(r, nil)
+	assert.Equal(t, http.StatusInternalServerError, err.(*BindingError).Code)
+	assert.Contains(t, err.Error(), "unknown validation rule \"positive\"")
+}
@@ -0,0 +1,186 @@
+// Copyright 2019-2021 VMware, Inc.
+// SPDX-License-Identifier: BSD-2-Clause
+
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/vmware/transport-go/model"
+	"log"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+// RequestHandlerFunc handles a single request command. The payload argument holds the request
+// payload decoded into the payload type registered for the command.
+type RequestHandlerFunc func(request *model.Request, payload interface{}, core FabricServiceCore)
+
+// RequestMiddleware wraps a RequestHandlerFunc with additional logic (logging, auth, metrics etc.).
+// A middleware can stop the request from reaching the handler by not calling next, or replace the
+// payload passed to next. Middleware receives the raw request payload, which is decoded into the
+// payload type of the command only after the last middleware.
+type RequestMiddleware func(next RequestHandlerFunc) RequestHandlerFunc
+
+// RequestCommand describes a single command supported by a RequestRouter.
+type RequestCommand struct {
+	Command     string `json:"command"`
+	Description string `json:"description,omitempty"`
+	PayloadType string `json:"payloadType,omitempty"`
+}
+
+type requestRoute struct {
+	command     string
+	description string
+	payloadType reflect.Type
+	handler     RequestHandlerFunc
+}
+
+// RequestRouter dispatches fabric requests to handler functions registered per model.Request.Request
+// command, replacing the switch statement inside HandleServiceRequest. RequestRouter implements
+// FabricService so services can simply delegate to it:
+//
+//	func (s *MyService) HandleServiceRequest(request *model.Request, core service.FabricServiceCore) {
+//		s.router.HandleServiceRequest(request, core)
+//	}
+type RequestRouter struct {
+	lock       sync.RWMutex
+	routes     map[string]*requestRoute
+	middleware []RequestMiddleware
+}
+
+// NewRequestRouter creates a new, empty RequestRouter.
+func NewRequestRouter() *RequestRouter {
+	return &RequestRouter{
+		routes: make(map[string]*requestRoute),
+	}
+}
+
+// Handle registers the handler for the given command. The request payload is decoded into a value of
+// payloadType before the handler is invoked, requests with payloads which can't be decoded are answered
+// with a 400 error response. If payloadType is nil the payload is passed to the handler as is.
+// Registering a second handler for the same command replaces the first one.
+func (r *RequestRouter) Handle(command string, payloadType reflect.Type, handler RequestHandlerFunc) *RequestRouter {
+	return r.HandleWithDescription(command, "", payloadType, handler)
+}
+
+// HandleWithDescription is the same as Handle but also sets the description of the command
+// returned by SupportedCommands.
+func (r *RequestRouter) HandleWithDescription(
+	command string, description string, payloadType reflect.Type, handler RequestHandlerFunc) *RequestRouter {
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.routes[command] = &requestRoute{
+		command:     command,
+		description: description,
+		payloadType: payloadType,
+		handler:     handler,
+	}
+	return r
+}
+
+// Use adds middleware applied to all commands of the router. Middleware is invoked in the
+// order in which it was added, the first middleware being the outermost one.
+func (r *RequestRouter) Use(middleware ...RequestMiddleware) *RequestRouter {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.middleware = append(r.middleware, middleware...)
+	return r
+}
+
+// SupportedCommands returns the list of commands registered with the router, sorted by name.
+func (r *RequestRouter) SupportedCommands() []RequestCommand {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	commands := make([]RequestCommand, 0, len(r.routes))
+	for _, route := range r.routes {
+		cmd := RequestCommand{
+			Command:     route.command,
+			Description: route.description,
+		}
+		if route.payloadType != nil {
+			cmd.PayloadType = route.payloadType.String()
+		}
+		commands = append(commands, cmd)
+	}
+	sort.Slice(commands, func(i, j int) bool {
+		return commands[i].Command < commands[j].Command
+	})
+	return commands
+}
+
+// SupportsCommand returns whether a handler is registered with the router for the command.
+func (r *RequestRouter) SupportsCommand(command string) bool {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	_, ok := r.routes[command]
+	return ok
+}
+
+// HandleServiceRequest routes the request to the handler registered for its command. Requests with
+// unknown commands go through the middleware as well and are then passed to
+// FabricServiceCore.HandleUnknownRequest. Panics raised by the handler or the middleware are logged
+// and turned into a 500 error response which doesn't disclose the panic to the client.
+func (r *RequestRouter) HandleServiceRequest(request *model.Request, core FabricServiceCore) {
+	r.lock.RLock()
+	route, ok := r.routes[request.Request]
+	middleware := r.middleware
+	r.lock.RUnlock()
+
+	defer func() {
+		if rec := recover(); rec != nil {
+			log.Printf("panic while handling request \"%s\": %v", request.Request, rec)
+			core.SendErrorResponse(request, 500,
+				fmt.Sprintf("internal error while handling request \"%s\"", request.Request))
+		}
+	}()
+
+	handler := func(request *model.Request, payload interface{}, core FabricServiceCore) {
+		if !ok {
+			core.HandleUnknownRequest(request)
+			return
+		}
+		payload, err := decodeRequestPayload(payload, route.payloadType)
+		if err != nil {
+			core.SendErrorResponse(request, 400,
+				fmt.Sprintf("invalid payload for request \"%s\": %s", request.Request, err.Error()))
+			return
+		}
+		route.handler(request, payload, core)
+	}
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+	handler(request, request.Payload, core)
+}
+
+// decodeRequestPayload converts the raw request payload into a value of targetType. Payloads
+// received as []byte (e.g. HTTP request bodies) are treated as JSON documents.
+func decodeRequestPayload(payload interface{}, targetType reflect.Type) (interface{}, error) {
+	if targetType == nil {
+		return payload, nil
+	}
+	if payload != nil && reflect.TypeOf(payload).AssignableTo(targetType) {
+		return payload, nil
+	}
+
+	if rawPayload, ok := payload.([]byte); ok {
+		itemType := targetType
+		if itemType.Kind() == reflect.Ptr {
+			itemType = itemType.Elem()
+		}
+		decodedValuePtr := reflect.New(itemType)
+		if err := json.Unmarshal(rawPayload, decodedValuePtr.Interface()); err != nil {
+			return nil, err
+		}
+		if targetType.Kind() == reflect.Ptr {
+			return decodedValuePtr.Interface(), nil
+		}
+		return decodedValuePtr.Elem().Interface(), nil
+	}
+
+	return model.ConvertValueToType(payload, targetType)
+}
@@ -0,0 +1,200 @@
+// Copyright 2019-2021 VMware, Inc.
+// SPDX-License-Identifier: BSD-2-Clause
+
+package service
+
+import (
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/vmware/transport-go/model"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+type testRouterPayload struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+func listenRouterResponses(t *testing.T, core FabricServiceCore, wg *sync.WaitGroup) *[]*model.Response {
+	var responses []*model.Response
+	mh, _ := core.Bus().ListenStream("test-channel")
+	mh.Handle(func(message *model.Message) {
+		responses = append(responses, message.Payload.(*model.Response))
+		wg.Done()
+	}, func(e error) {
+		assert.Fail(t, "unexpected error")
+	})
+	return &responses
+}
+
+func newRouterTestRequest(command string, payload interface{}) *model.Request {
+	id := uuid.New()
+	return &model.Request{Id: &id, Request: command, Payload: payload}
+}
+
+func TestRequestRouter_HandleServiceRequest(t *testing.T) {
+	core := newTestFabricCore("test-channel")
+	wg := sync.WaitGroup{}
+	responses := listenRouterResponses(t, core, &wg)
+
+	router := NewRequestRouter()
+	router.Handle("struct", reflect.TypeOf(testRouterPayload{}),
+		func(request *model.Request, payload interface{}, core FabricServiceCore) {
+			core.SendResponse(request, payload.(testRouterPayload).Name)
+		}).
+		Handle("pointer", reflect.TypeOf(&testRouterPayload{}),
+			func(request *model.Request, payload interface{}, core FabricServiceCore) {
+				core.SendResponse(request, payload.(*testRouterPayload).Count)
+			}).
+		Handle("raw", nil,
+			func(request *model.Request, payload interface{}, core FabricServiceCore) {
+				core.SendResponse(request, payload)
+			})
+
+	wg.Add(1)
+	router.HandleServiceRequest(newRouterTestRequest("struct",
+		map[string]interface{}{"name": "test", "count": 1}), core)
+	wg.Wait()
+	assert.Equal(t, "test", (*responses)[0].Payload)
+
+	wg.Add(1)
+	router.HandleServiceRequest(newRouterTestRequest("pointer", []byte(`{"name": "test", "count": 5}`)), core)
+	wg.Wait()
+	assert.Equal(t, 5, (*responses)[1].Payload)
+
+	wg.Add(1)
+	router.HandleServiceRequest(newRouterTestRequest("raw", "raw-payload"), core)
+	wg.Wait()
+	assert.Equal(t, "raw-payload", (*responses)[2].Payload)
+
+	// invalid payload
+	wg.Add(1)
+	router.HandleServiceRequest(newRouterTestRequest("struct", []byte("invalid-json")), core)
+	wg.Wait()
+	assert.True(t, (*responses)[3].Error)
+	assert.Equal(t, 400, (*responses)[3].ErrorCode)
+
+	// unknown command
+	wg.Add(1)
+	router.HandleServiceRequest(newRouterTestRequest("unknown", nil), core)
+	wg.Wait()
+	assert.True(t, (*responses)[4].Error)
+	assert.Equal(t, 403, (*responses)[4].ErrorCode)
+	assert.Equal(t, "unsupported request for \"test-channel\": unknown", (*responses)[4].ErrorMessage)
+}
+
+func TestRequestRouter_RecoverFromPanic(t *testing.T) {
+	core := newTestFabricCore("test-channel")
+	wg := sync.WaitGroup{}
+	responses := listenRouterResponses(t, core, &wg)
+
+	router := NewRequestRouter()
+	router.Handle("panic", nil, func(request *model.Request, payload interface{}, core FabricServiceCore) {
+		panic("something went wrong")
+	})
+
+	wg.Add(1)
+	req := newRouterTestRequest("panic", nil)
+	router.HandleServiceRequest(req, core)
+	wg.Wait()
+
+	assert.Equal(t, req.Id, (*responses)[0].Id)
+	assert.True(t, (*responses)[0].Error)
+	assert.Equal(t, 500, (*responses)[0].ErrorCode)
+	// the panic is not disclosed to the client
+	assert.Equal(t, "internal error while handling request \"panic\"", (*responses)[0].ErrorMessage)
+}
+
+func TestRequestRouter_Middleware(t *testing.T) {
+	core := newTestFabricCore("test-channel")
+	wg := sync.WaitGroup{}
+	responses := listenRouterResponses(t, core, &wg)
+
+	var calls []string
+	router := NewRequestRouter()
+	router.Use(func(next RequestHandlerFunc) RequestHandlerFunc {
+		return func(request *model.Request, payload interface{}, core FabricServiceCore) {
+			calls = append(calls, "logger:"+request.Request)
+			next(request, payload, core)
+		}
+	}, func(next RequestHandlerFunc) RequestHandlerFunc {
+		return func(request *model.Request, payload interface{}, core FabricServiceCore) {
+			calls = append(calls, "auth")
+			if request.Payload == "forbidden" {
+				core.SendErrorResponse(request, 401, "unauthorized")
+				return
+			}
+			next(request, payload, core)
+		}
+	})
+	router.Handle("test", nil, func(request *model.Request, payload interface{}, core FabricServiceCore) {
+		calls = append(calls, "handler")
+		core.SendResponse(request, "ok")
+	})
+
+	wg.Add(1)
+	router.HandleServiceRequest(newRouterTestRequest("test", "allowed"), core)
+	wg.Wait()
+	assert.Equal(t, []string{"logger:test", "auth", "handler"}, calls)
+	assert.Equal(t, "ok", (*responses)[0].Payload)
+
+	calls = nil
+	wg.Add(1)
+	router.HandleServiceRequest(newRouterTestRequest("test", "forbidden"), core)
+	wg.Wait()
+	assert.Equal(t, []string{"logger:test", "auth"}, calls)
+	assert.Equal(t, 401, (*responses)[1].ErrorCode)
+
+	// unknown commands go through the middleware too
+	calls = nil
+	wg.Add(1)
+	router.HandleServiceRequest(newRouterTestRequest("unknown", "forbidden"), core)
+	wg.Wait()
+	assert.Equal(t, []string{"logger:unknown", "auth"}, calls)
+	assert.Equal(t, 401, (*responses)[2].ErrorCode)
+
+	calls = nil
+	wg.Add(1)
+	router.HandleServiceRequest(newRouterTestRequest("unknown", "allowed"), core)
+	wg.Wait()
+	assert.Equal(t, []string{"logger:unknown", "auth"}, calls)
+	assert.Equal(t, 403, (*responses)[3].ErrorCode)
+}
+
+func TestRequestRouter_MiddlewareReplacesPayload(t *testing.T) {
+	core := newTestFabricCore("test-channel")
+	wg := sync.WaitGroup{}
+	responses := listenRouterResponses(t, core, &wg)
+
+	router := NewRequestRouter()
+	router.Use(func(next RequestHandlerFunc) RequestHandlerFunc {
+		return func(request *model.Request, payload interface{}, core FabricServiceCore) {
+			next(request, []byte(`{"name": "decrypted"}`), core)
+		}
+	})
+	router.Handle("test", reflect.TypeOf(testRouterPayload{}),
+		func(request *model.Request, payload interface{}, core FabricServiceCore) {
+			core.SendResponse(request, payload.(testRouterPayload).Name)
+		})
+
+	wg.Add(1)
+	router.HandleServiceRequest(newRouterTestRequest("test", "encrypted"), core)
+	wg.Wait()
+	assert.Equal(t, "decrypted", (*responses)[0].Payload)
+}
+
+func TestRequestRouter_SupportedCommands(t *testing.T) {
+	router := NewRequestRouter()
+	assert.Len(t, router.SupportedCommands(), 0)
+
+	handler := func(request *model.Request, payload interface{}, core FabricServiceCore) {}
+	router.Handle("b-command", nil, handler)
+	router.HandleWithDescription("a-command", "first command", reflect.TypeOf(&testRouterPayload{}), handler)
+
+	assert.Equal(t, []RequestCommand{
+		{Command: "a-command", Description: "first command", PayloadType: "*service.testRouterPayload"},
+		{Command: "b-command"},
+	}, router.SupportedCommands())
+}
@@ -0,0 +1,243 @@
+// Copyright 2019-2021 VMware, Inc.
+// SPDX-License-Identifier: BSD-2-Clause
+
+package service
+
+import (
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/vmware/transport-go/model"
+	"log"
+	"sync"
+)
+
+// CancelResponseStreamRequest is the request sent to a service channel to cancel the response stream of a
+// request, e.g. by the plank REST bridge once the HTTP client disconnects. The payload of the request is
+// the id of the streamed request, either as uuid.UUID or as string. A stream is only canceled by the
+// sender of the streamed request: the STOMP connection it was received on, or the REST bridge which set
+// ResponseStreamOwnerKey in its metadata.
+const CancelResponseStreamRequest = "transport-cancel-response-stream"
+
+// ResponseStreamOwnerKey is the RequestMetadata value identifying the sender of a request, set by in-process
+// senders such as the plank REST bridge. A CancelResponseStreamRequest has to carry the same value to cancel
+// the response stream of the request. The value should be random, as Metadata is never serialized it can't
+// be guessed by the clients of the bus.
+const ResponseStreamOwnerKey = "transport-response-stream-owner"
+
+// ResponseStreamEvent is the payload of the responses sent through a ResponseStream. The responses
+// of a stream can be delivered out of order, the receivers restore the order with the sequence numbers.
+// The last response of the stream has Done set to true and carries no data, streams closed with an
+// error end with an error response having a ResponseStreamEvent payload with Done set to true.
+type ResponseStreamEvent struct {
+	// Position of the event in the stream, starting from 1.
+	Sequence int `json:"sequence"`
+	// Optional type of the event, used as the event type of server-sent events by the plank REST bridge.
+	Event string `json:"event,omitempty"`
+	// Payload of the event.
+	Data interface{} `json:"data,omitempty"`
+	// Marks the end of the stream.
+	Done bool `json:"done,omitempty"`
+}
+
+// ResponseStream sends the response to a request as a series of responses, for example the progress
+// of a long-running job or the records of an export, until it is closed. Streams are opened with
+// ResponseStreamer.OpenResponseStream, the receiver of the stream can cancel it at any time with
+// a CancelResponseStreamRequest. Streams still open once the service returned from handling the request
+// are closed with an error.
+type ResponseStream struct {
+	request  *model.Request
+	core     *fabricCore
+	owner    string
+	lock     sync.Mutex
+	sequence int
+	closed   bool
+	canceled bool
+	done     chan struct{}
+}
+
+// Send sends the payload as the next event of the stream. Returns an error if the stream is closed
+// or was canceled by the receiver, in which case the service should stop producing events.
+func (s *ResponseStream) Send(payload interface{}) error {
+	return s.SendEvent("", payload)
+}
+
+// SendEvent is the same as Send, but sets the type of the event as well.
+func (s *ResponseStream) SendEvent(event string, payload interface{}) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err := s.checkOpen(); err != nil {
+		return err
+	}
+	s.sequence++
+	s.core.sendResponse(s.request, s.newResponse(&ResponseStreamEvent{
+		Sequence: s.sequence,
+		Event:    event,
+		Data:     payload,
+	}))
+	return nil
+}
+
+// Close sends the completion marker of the stream. Closing a closed or canceled stream has no effect.
+func (s *ResponseStream) Close() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.checkOpen() != nil {
+		return
+	}
+	s.sequence++
+	s.core.sendResponse(s.request, s.newResponse(&ResponseStreamEvent{Sequence: s.sequence, Done: true}))
+	s.close(false)
+}
+
+// CloseWithError ends the stream with an error response, e.g. when the job producing the events fails.
+// Closing a closed or canceled stream has no effect.
+func (s *ResponseStream) CloseWithError(errorCode int, errorMessage string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.checkOpen() != nil {
+		return
+	}
+	s.sequence++
+	response := s.newResponse(&ResponseStreamEvent{Sequence: s.sequence, Done: true})
+	response.Error = true
+	response.ErrorCode = errorCode
+	response.ErrorMessage = errorMessage
+	s.core.sendResponse(s.request, response)
+	s.close(false)
+}
+
+// Done returns a channel which is closed once the stream is closed or canceled by the receiver.
+func (s *ResponseStream) Done() <-chan struct{} {
+	return s.done
+}
+
+// Canceled reports whether the stream was canceled by the receiver.
+func (s *ResponseStream) Canceled() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.canceled
+}
+
+func (s *ResponseStream) checkOpen() error {
+	if s.canceled {
+		return fmt.Errorf("response stream of request %v was canceled", s.request.Id)
+	}
+	if s.closed {
+		return fmt.Errorf("response stream of request %v is closed", s.request.Id)
+	}
+	return nil
+}
+
+func (s *ResponseStream) newResponse(event *ResponseStreamEvent) *model.Response {
+	return &model.Response{
+		Id:                s.request.Id,
+		Destination:       s.core.channelName,
+		Payload:           event,
+		Headers:           s.core.mergeHeadersWithDefaults(nil),
+		BrokerDestination: s.request.BrokerDestination,
+	}
+}
+
+// close marks the stream as closed or canceled. must be called with the lock held.
+func (s *ResponseStream) close(canceled bool) {
+	s.closed = true
+	s.canceled = canceled
+	close(s.done)
+	if s.core.streams != nil && s.request.Id != nil {
+		s.core.streams.remove(*s.request.Id, s)
+	}
+}
+
+// responseStreams keeps track of the open response streams of a service so that they can be canceled.
+type responseStreams struct {
+	lock    sync.Mutex
+	streams map[uuid.UUID]*ResponseStream
+}
+
+// responseStreamOwner identifies the sender of a request from its metadata, to only accept the cancellation
+// of a response stream from the sender of the streamed request.
+func responseStreamOwner(metadata *model.RequestMetadata) string {
+	if metadata == nil {
+		return ""
+	}
+	if metadata.Transport == model.TransportSTOMP {
+		return metadata.Transport + ":" + metadata.ConnectionId
+	}
+	if owner, ok := metadata.Get(ResponseStreamOwnerKey).(string); ok {
+		return metadata.Transport + ":" + owner
+	}
+	return metadata.Transport
+}
+
+func newResponseStreams() *responseStreams {
+	return &responseStreams{streams: make(map[uuid.UUID]*ResponseStream)}
+}
+
+func (rs *responseStreams) add(id uuid.UUID, stream *ResponseStream) {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+	rs.streams[id] = stream
+}
+
+func (rs *responseStreams) remove(id uuid.UUID, stream *ResponseStream) {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+	if rs.streams[id] == stream {
+		delete(rs.streams, id)
+	}
+}
+
+// closeAbandoned closes the response stream of the request with an error if the service returned from
+// handling the request, or panicked, without closing it.
+func (rs *responseStreams) closeAbandoned(request *model.Request) {
+	if request.Id == nil {
+		return
+	}
+	rs.lock.Lock()
+	stream := rs.streams[*request.Id]
+	rs.lock.Unlock()
+	if stream == nil {
+		return
+	}
+	log.Printf("response stream of request \"%s\" on channel '%s' was not closed by the service",
+		request.Request, stream.core.channelName)
+	stream.CloseWithError(500, "response stream was not closed by the service")
+}
+
+// cancel cancels the open response stream of the request with the id in the payload of the
+// CancelResponseStreamRequest. returns false if there is no such stream, or if the cancel request
+// wasn't sent by the sender of the streamed request.
+func (rs *responseStreams) cancel(cancelRequest *model.Request) bool {
+	var id uuid.UUID
+	switch payload := cancelRequest.Payload.(type) {
+	case uuid.UUID:
+		id = payload
+	case *uuid.UUID:
+		if payload == nil {
+			return false
+		}
+		id = *payload
+	case string:
+		var err error
+		if id, err = uuid.Parse(payload); err != nil {
+			return false
+		}
+	default:
+		return false
+	}
+
+	rs.lock.Lock()
+	stream := rs.streams[id]
+	rs.lock.Unlock()
+	if stream == nil || stream.owner != responseStreamOwner(cancelRequest.Metadata) {
+		return false
+	}
+
+	stream.lock.Lock()
+	defer stream.lock.Unlock()
+	if stream.checkOpen() != nil {
+		return false
+	}
+	stream.close(true)
+	return true
+}
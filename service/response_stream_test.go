@@ -0,0 +1,266 @@
+// Copyright 2019-2021 VMware, Inc.
+// SPDX-License-Identifier: BSD-2-Clause
+
+package service
+
+import (
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/vmware/transport-go/model"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// streamingFabricService streams the numbers up to the payload of the request, or until the stream is canceled
+// if the payload is zero. the stream of "open" requests is left open.
+type streamingFabricService struct {
+	lock    sync.Mutex
+	streams []*ResponseStream
+}
+
+func (fs *streamingFabricService) HandleServiceRequest(request *model.Request, core FabricServiceCore) {
+	stream := core.(ResponseStreamer).OpenResponseStream(request)
+	fs.lock.Lock()
+	fs.streams = append(fs.streams, stream)
+	fs.lock.Unlock()
+
+	count := int(request.Payload.(float64))
+	if count == 0 {
+		for stream.Send("tick") == nil {
+			time.Sleep(time.Millisecond)
+		}
+		return
+	}
+	for i := 1; i <= count; i++ {
+		_ = stream.SendEvent("number", i)
+	}
+	switch request.Request {
+	case "fail":
+		stream.CloseWithError(500, "failed")
+	case "open":
+	default:
+		stream.Close()
+	}
+}
+
+func (fs *streamingFabricService) getStreams() []*ResponseStream {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	return append([]*ResponseStream{}, fs.streams...)
+}
+
+// collectStreamResponses sends the request and collects the responses of its stream, ordered by sequence
+func collectStreamResponses(t *testing.T, registry *serviceRegistry, requestType string, count float64) []*model.Response {
+	id := uuid.New()
+	var lock sync.Mutex
+	responses := make([]*model.Response, 0)
+	done := make(chan bool, 1)
+	mh, _ := registry.bus.ListenStreamForDestination("test-channel", &id)
+	mh.Handle(func(message *model.Message) {
+		lock.Lock()
+		defer lock.Unlock()
+		response := message.Payload.(*model.Response)
+		responses = append(responses, response)
+		if response.Payload.(*ResponseStreamEvent).Done {
+			done <- true
+		}
+	}, func(e error) {})
+	defer mh.Close()
+
+	registry.bus.SendRequestMessage("test-channel", &model.Request{Id: &id, Request: requestType, Payload: count}, &id)
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		assert.Fail(t, "stream not closed")
+	}
+
+	// the completion marker may overtake other responses
+	assert.Eventually(t, func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return len(responses) == int(count)+1
+	}, time.Second, time.Millisecond)
+	lock.Lock()
+	defer lock.Unlock()
+	sort.Slice(responses, func(i, j int) bool {
+		return responses[i].Payload.(*ResponseStreamEvent).Sequence < responses[j].Payload.(*ResponseStreamEvent).Sequence
+	})
+	return responses
+}
+
+func TestResponseStream_SendAndClose(t *testing.T) {
+	registry := newCacheTestServiceRegistry()
+	svc := &streamingFabricService{}
+	assert.Nil(t, registry.RegisterService(svc, "test-channel"))
+
+	responses := collectStreamResponses(t, registry, "count", 3)
+	for i, response := range responses[:3] {
+		event := response.Payload.(*ResponseStreamEvent)
+		assert.Equal(t, &ResponseStreamEvent{Sequence: i + 1, Event: "number", Data: i + 1}, event)
+		assert.False(t, response.Error)
+	}
+	assert.Equal(t, &ResponseStreamEvent{Sequence: 4, Done: true}, responses[3].Payload)
+
+	stream := svc.getStreams()[0]
+	assert.NotNil(t, stream.Send("late"))
+	assert.False(t, stream.Canceled())
+	select {
+	case <-stream.Done():
+	default:
+		assert.Fail(t, "stream not done")
+	}
+	assert.Empty(t, registry.services["test-channel"].streams.streams)
+}
+
+func TestResponseStream_CloseWithError(t *testing.T) {
+	registry := newCacheTestServiceRegistry()
+	assert.Nil(t, registry.RegisterService(&streamingFabricService{}, "test-channel"))
+
+	responses := collectStreamResponses(t, registry, "fail", 2)
+	last := responses[2]
+	assert.True(t, last.Error)
+	assert.Equal(t, 500, last.ErrorCode)
+	assert.Equal(t, "failed", last.ErrorMessage)
+	assert.Equal(t, &ResponseStreamEvent{Sequence: 3, Done: true}, last.Payload)
+}
+
+func TestResponseStream_Cancel(t *testing.T) {
+	registry := newCacheTestServiceRegistry()
+	svc := &streamingFabricService{}
+	// the cancel requests must not wait for the single worker busy streaming
+	assert.Nil(t, registry.RegisterServiceWithOptions(svc, "test-channel", &ServiceOptions{MaxConcurrentRequests: 1}))
+
+	ids := []uuid.UUID{uuid.New(), uuid.New()}
+	registry.bus.SendRequestMessage("test-channel", &model.Request{Id: &ids[0], Request: "ticks", Payload: float64(0)}, &ids[0])
+	assert.Eventually(t, func() bool {
+		return len(svc.getStreams()) == 1
+	}, time.Second, time.Millisecond)
+	registry.bus.SendRequestMessage("test-channel", &model.Request{Id: &ids[1], Request: "ticks", Payload: float64(0)}, &ids[1])
+
+	// unknown ids and malformed payloads are ignored
+	cancelId := uuid.New()
+	registry.bus.SendRequestMessage("test-channel",
+		&model.Request{Id: &cancelId, Request: CancelResponseStreamRequest, Payload: "not-an-id"}, &cancelId)
+	registry.bus.SendRequestMessage("test-channel",
+		&model.Request{Id: &cancelId, Request: CancelResponseStreamRequest, Payload: uuid.New()}, &cancelId)
+
+	// the first stream is canceled with its id, the second one with the string of its id
+	registry.bus.SendRequestMessage("test-channel",
+		&model.Request{Id: &cancelId, Request: CancelResponseStreamRequest, Payload: ids[0]}, &cancelId)
+	assert.Eventually(t, func() bool {
+		streams := svc.getStreams()
+		return len(streams) == 2 && streams[0].Canceled()
+	}, time.Second, time.Millisecond)
+
+	registry.bus.SendRequestMessage("test-channel",
+		&model.Request{Id: &cancelId, Request: CancelResponseStreamRequest, Payload: ids[1].String()}, &cancelId)
+	stream := svc.getStreams()[1]
+	select {
+	case <-stream.Done():
+		assert.True(t, stream.Canceled())
+	case <-time.After(time.Second):
+		assert.Fail(t, "stream not canceled")
+	}
+
+	// canceled streams can't be closed anymore
+	stream.Close()
+	assert.True(t, stream.Canceled())
+}
+
+func TestResponseStream_CancelOnlyBySender(t *testing.T) {
+	registry := newCacheTestServiceRegistry()
+	svc := &streamingFabricService{}
+	assert.Nil(t, registry.RegisterService(svc, "test-channel"))
+
+	id := uuid.New()
+	registry.bus.SendRequestMessage("test-channel", &model.Request{Id: &id, Request: "ticks", Payload: float64(0),
+		Metadata: &model.RequestMetadata{Transport: model.TransportSTOMP, ConnectionId: "conn-1"}}, &id)
+	assert.Eventually(t, func() bool {
+		return len(svc.getStreams()) == 1
+	}, time.Second, time.Millisecond)
+	stream := svc.getStreams()[0]
+
+	// other connections and senders without metadata can't cancel the stream
+	cancelId := uuid.New()
+	for _, metadata := range []*model.RequestMetadata{
+		nil,
+		{Transport: model.TransportSTOMP, ConnectionId: "conn-2"},
+		{Transport: model.TransportHTTP, Values: map[string]interface{}{ResponseStreamOwnerKey: "conn-1"}},
+	} {
+		assert.False(t, registry.services["test-channel"].streams.cancel(
+			&model.Request{Id: &cancelId, Request: CancelResponseStreamRequest, Payload: id, Metadata: metadata}))
+	}
+	assert.False(t, stream.Canceled())
+
+	registry.bus.SendRequestMessage("test-channel", &model.Request{Id: &cancelId, Request: CancelResponseStreamRequest,
+		Payload: id, Metadata: &model.RequestMetadata{Transport: model.TransportSTOMP, ConnectionId: "conn-1"}}, &cancelId)
+	select {
+	case <-stream.Done():
+		assert.True(t, stream.Canceled())
+	case <-time.After(time.Second):
+		assert.Fail(t, "stream not canceled")
+	}
+
+	// streams of the REST bridge are canceled with the value identifying the bridge
+	owner := &model.RequestMetadata{Transport: model.TransportHTTP}
+	owner.Set(ResponseStreamOwnerKey, uuid.NewString())
+	bridgedId := uuid.New()
+	registry.bus.SendRequestMessage("test-channel", &model.Request{Id: &bridgedId, Request: "ticks", Payload: float64(0),
+		Metadata: owner}, &bridgedId)
+	assert.Eventually(t, func() bool {
+		return len(svc.getStreams()) == 2
+	}, time.Second, time.Millisecond)
+	stream = svc.getStreams()[1]
+	assert.False(t, registry.services["test-channel"].streams.cancel(&model.Request{Id: &cancelId,
+		Request: CancelResponseStreamRequest, Payload: bridgedId, Metadata: &model.RequestMetadata{Transport: model.TransportHTTP}}))
+	assert.True(t, registry.services["test-channel"].streams.cancel(&model.Request{Id: &cancelId,
+		Request: CancelResponseStreamRequest, Payload: bridgedId, Metadata: &model.RequestMetadata{Transport: model.TransportHTTP,
+			Values: map[string]interface{}{ResponseStreamOwnerKey: owner.Get(ResponseStreamOwnerKey)}}}))
+	assert.True(t, stream.Canceled())
+}
+
+func TestResponseStream_ClosedWhenServiceReturns(t *testing.T) {
+	registry := newCacheTestServiceRegistry()
+	svc := &streamingFabricService{}
+	assert.Nil(t, registry.RegisterService(svc, "test-channel"))
+
+	// the "open" request leaves the stream open
+	responses := collectStreamResponses(t, registry, "open", 2)
+	last := responses[2]
+	assert.True(t, last.Error)
+	assert.Equal(t, 500, last.ErrorCode)
+	assert.Equal(t, "response stream was not closed by the service", last.ErrorMessage)
+	assert.Equal(t, &ResponseStreamEvent{Sequence: 3, Done: true}, last.Payload)
+	streams := registry.services["test-channel"].streams
+	assert.Eventually(t, func() bool {
+		streams.lock.Lock()
+		defer streams.lock.Unlock()
+		return len(streams.streams) == 0
+	}, time.Second, time.Millisecond)
+}
+
+func TestFabricCore_OpenResponseStream(t *testing.T) {
+	// streams of cores without a service can't be canceled but work otherwise
+	core := newTestFabricCore("test-channel")
+	id := uuid.New()
+	responses := make(chan *model.Response, 2)
+	mh, _ := core.Bus().ListenStreamForDestination("test-channel", &id)
+	mh.Handle(func(message *model.Message) {
+		responses <- message.Payload.(*model.Response)
+	}, func(e error) {})
+
+	stream := core.(ResponseStreamer).OpenResponseStream(&model.Request{Id: &id})
+	assert.Nil(t, stream.Send("hello"))
+	stream.Close()
+	stream.CloseWithError(500, "ignored")
+
+	received := []*model.Response{<-responses, <-responses}
+	sort.Slice(received, func(i, j int) bool {
+		return received[i].Payload.(*ResponseStreamEvent).Sequence < received[j].Payload.(*ResponseStreamEvent).Sequence
+	})
+	assert.Equal(t, "hello", received[0].Payload.(*ResponseStreamEvent).Data)
+	assert.True(t, received[1].Payload.(*ResponseStreamEvent).Done)
+	assert.False(t, received[1].Error)
+}
@@ -5,17 +5,31 @@ package service
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/json"
+	"fmt"
+	"github.com/vmware/transport-go/bus"
 	"github.com/vmware/transport-go/model"
+	"github.com/vmware/transport-go/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 	"io"
 	"net/http"
 	"net/url"
 	"reflect"
 	"strings"
+	"sync"
+	"time"
 )
 
 const (
 	restServiceChannel = "fabric-rest"
+
+	// maximum number of clients with TLS client certificates cached by the fabric-rest service
+	maxTLSClients = 64
 )
 
 type RestServiceRequest struct {
@@ -38,6 +52,30 @@ type RestServiceRequest struct {
 	// Shouldn't be populated directly, the field is used to deserialize
 	// com.vmware.bifrost.core.model.RestServiceRequest Java/Typescript requests
 	ApiClass string `json:"apiClass"`
+	// Optional timeout and retry policy of the request. If omitted, the policy of the host set by
+	// ServiceRegistry.SetRestServiceHostPolicy is used, or else the global one set by
+	// ServiceRegistry.SetGlobalRestServicePolicy. The policy can be set only by the services running in the same process, its values are
+	// capped and the circuit breaker of the host or global policy is always used.
+	Policy *RestRequestPolicy `json:"-"`
+	// Optional stream mode. If set the response body is not loaded into memory but sent
+	// as a series of responses with RestStreamEvent payloads. The timeout of the request
+	// policy applies only until the response headers are received.
+	StreamMode RestStreamMode `json:"streamMode,omitempty"`
+	// Size of the chunks sent in the RestStreamChunks mode, defaults to 32KB.
+	ChunkSize int `json:"chunkSize,omitempty"`
+	// Optional handler of the events of a streamed response. If set, the events are passed to the handler
+	// one by one in order instead of being sent as responses, and the body is read only as fast as the
+	// handler returns. Returning an error stops the stream. Only the completion marker or the error
+	// response is sent on the channel.
+	StreamHandler func(event *RestStreamEvent) error `json:"-"`
+	// Optional parts of a multipart/form-data request body. If set the Body is ignored.
+	Multipart []*MultipartPart `json:"multipart,omitempty"`
+	// Optional credential provider of the request. If omitted the provider set by
+	// FabricServiceCore.SetCredentialProvider is used.
+	Credentials CredentialProvider `json:"-"`
+	// Optional metadata of the service request the request is made on behalf of. The trace
+	// context it carries is continued by the request and propagated to the upstream.
+	Metadata *model.RequestMetadata `json:"-"`
 }
 
 func (request *RestServiceRequest) marshalBody() ([]byte, error) {
@@ -55,14 +93,50 @@ func (request *RestServiceRequest) marshalBody() ([]byte, error) {
 }
 
 type restService struct {
-	httpClient http.Client
-	baseHost   string
+	httpClient      http.Client
+	baseHost        string
+	policyLock      sync.RWMutex
+	policy          *RestRequestPolicy
+	hostPolicies    map[string]*RestRequestPolicy
+	circuitBreakers circuitBreakers
+	clientsLock     sync.Mutex
+	tlsClients      map[*tls.Config]*http.Client
+	tlsConfigs      []*tls.Config // TLS configurations of the cached clients, oldest first
 }
 
 func (rs *restService) setBaseHost(host string) {
 	rs.baseHost = host
 }
 
+func (rs *restService) setPolicy(policy *RestRequestPolicy) {
+	rs.policyLock.Lock()
+	defer rs.policyLock.Unlock()
+	rs.policy = policy
+}
+
+func (rs *restService) setHostPolicy(host string, policy *RestRequestPolicy) {
+	rs.policyLock.Lock()
+	defer rs.policyLock.Unlock()
+	if rs.hostPolicies == nil {
+		rs.hostPolicies = make(map[string]*RestRequestPolicy)
+	}
+	if policy == nil {
+		delete(rs.hostPolicies, host)
+	} else {
+		rs.hostPolicies[host] = policy
+	}
+}
+
+// getPolicy returns the policy of the requests to the given host, or the global policy if the host has none.
+func (rs *restService) getPolicy(host string) *RestRequestPolicy {
+	rs.policyLock.RLock()
+	defer rs.policyLock.RUnlock()
+	if policy, found := rs.hostPolicies[host]; found {
+		return policy
+	}
+	return rs.policy
+}
+
 func (rs *restService) HandleServiceRequest(request *model.Request, core FabricServiceCore) {
 
 	restReq, ok := rs.getRestServiceRequest(request)
@@ -71,14 +145,14 @@ func (rs *restService) HandleServiceRequest(request *model.Request, core FabricS
 		return
 	}
 
-	body, err := restReq.marshalBody()
+	body, err := restReq.newRequestBody()
 	if err != nil {
 		core.SendErrorResponse(request, 500, "cannot marshal request body: "+err.Error())
 		return
 	}
 
-	httpReq, err := http.NewRequest(restReq.Method,
-		rs.getRequestUrl(restReq.Uri, core), bytes.NewBuffer(body))
+	httpReq, err := http.NewRequestWithContext(
+		tracing.ContextFromRequest(request), restReq.Method, rs.getRequestUrl(restReq.Uri, core), nil)
 
 	if err != nil {
 		core.SendErrorResponse(request, 500, err.Error())
@@ -104,9 +178,18 @@ func (rs *restService) HandleServiceRequest(request *model.Request, core FabricS
 		restReq.ResponseType = reflect.TypeOf([]byte{})
 	}
 
-	httpResp, err := rs.httpClient.Do(httpReq)
+	if body.contentType != "" {
+		httpReq.Header.Set("Content-Type", body.contentType)
+	}
+
+	policy := rs.getPolicy(httpReq.URL.Host)
+	if restReq.Policy != nil {
+		policy = restReq.Policy.limit(policy)
+	}
+
+	httpResp, errCode, err := rs.doRequest(httpReq, restReq, body, policy, core)
 	if err != nil {
-		core.SendErrorResponse(request, 500, err.Error())
+		core.SendErrorResponse(request, errCode, err.Error())
 		return
 	}
 	defer httpResp.Body.Close()
@@ -121,6 +204,11 @@ func (rs *restService) HandleServiceRequest(request *model.Request, core FabricS
 		return
 	}
 
+	if restReq.StreamMode != "" {
+		rs.streamResponse(request, restReq, httpResp.Body, policy, core)
+		return
+	}
+
 	result, err := rs.deserializeResponse(httpResp.Body, restReq.ResponseType)
 	if err != nil {
 		core.SendErrorResponse(request, 500, "failed to deserialize response:"+err.Error())
@@ -129,6 +217,237 @@ func (rs *restService) HandleServiceRequest(request *model.Request, core FabricS
 	}
 }
 
+// doRequest sends the request according to the policy, retrying it and tracking the health of the upstream host
+// if configured so. returns the response, or the error and the status code to report it with. the timeout of
+// streamed requests applies only until the response headers are received.
+func (rs *restService) doRequest(httpReq *http.Request, restReq *RestServiceRequest, body *restRequestBody,
+	policy *RestRequestPolicy, core FabricServiceCore) (*http.Response, int, error) {
+
+	host := httpReq.URL.Host
+	var breaker *circuitBreaker
+	if policy != nil && policy.CircuitBreaker != nil {
+		breaker = rs.circuitBreakers.get(host)
+	}
+	maxRetries := policy.getMaxRetries()
+	client := rs.getHttpClient(restReq.Credentials, host)
+	reauthenticated := false
+
+	for attempt := 1; ; attempt++ {
+		timeout := policy.getTimeout()
+		ctx, cancelFn := context.WithCancel(context.Background())
+		timer := time.AfterFunc(timeout, cancelFn)
+		release := func() {
+			timer.Stop()
+			cancelFn()
+		}
+
+		// the circuit breaker is consulted first so that no credentials are obtained for a host which is down
+		trial := false
+		if breaker != nil {
+			allowed, halfOpened := breaker.allow(policy.CircuitBreaker)
+			trial = halfOpened
+			if halfOpened {
+				core.Bus().SendMonitorEvent(bus.RestCircuitBreakerHalfOpenedEvt, restServiceChannel,
+					&RestRequestMonitorData{Host: host})
+			}
+			if !allowed {
+				release()
+				return nil, http.StatusServiceUnavailable,
+					fmt.Errorf("rest-service error, circuit breaker is open for host %s", host)
+			}
+		}
+
+		attemptReq, err := rs.newAttemptRequest(ctx, httpReq, body.newReader(), restReq.Credentials)
+		if err != nil {
+			if trial {
+				// a trial request is never lost to an unavailable credential provider
+				breaker.cancelTrial()
+			}
+			release()
+			return nil, 500, err
+		}
+
+		span := startClientSpan(httpReq, attemptReq, attempt)
+		httpResp, err := client.Do(attemptReq)
+		endClientSpan(span, httpResp, err)
+		if restReq.StreamMode != "" {
+			timer.Stop()
+		}
+		// the context is canceled only by the timer before the request completes
+		timedOut := err != nil && ctx.Err() != nil
+		if timedOut {
+			err = fmt.Errorf("rest-service error, request to %s timed out after %s", host, timeout.String())
+		}
+
+		if breaker != nil {
+			if err != nil || httpResp.StatusCode >= 500 {
+				if breaker.onFailure(policy.CircuitBreaker) {
+					core.Bus().SendMonitorEvent(bus.RestCircuitBreakerOpenedEvt, restServiceChannel,
+						&RestRequestMonitorData{Host: host})
+				}
+			} else if breaker.onSuccess() {
+				core.Bus().SendMonitorEvent(bus.RestCircuitBreakerClosedEvt, restServiceChannel,
+					&RestRequestMonitorData{Host: host})
+			}
+		}
+
+		// drop cached credentials rejected by the upstream and try once more with fresh ones
+		if err == nil && httpResp.StatusCode == http.StatusUnauthorized && restReq.Credentials != nil {
+			if invalidator, ok := restReq.Credentials.(credentialInvalidator); ok {
+				invalidator.Invalidate()
+				if !reauthenticated && body.replayable {
+					reauthenticated = true
+					httpResp.Body.Close()
+					release()
+					attempt--
+					continue
+				}
+			}
+		}
+
+		retry := attempt <= maxRetries && body.replayable && policy.canRetry(httpReq) &&
+			(err != nil || policy.isRetryableStatus(httpResp.StatusCode))
+		if !retry {
+			if err != nil {
+				release()
+				if timedOut {
+					return nil, http.StatusGatewayTimeout, err
+				}
+				return nil, 500, err
+			}
+			httpResp.Body = &cancelOnCloseBody{ReadCloser: httpResp.Body, cancelFn: release}
+			return httpResp, 0, nil
+		}
+
+		monitorData := &RestRequestMonitorData{Host: host, Method: httpReq.Method, Uri: httpReq.URL.String(), Attempt: attempt}
+		if err != nil {
+			monitorData.Error = err.Error()
+		} else {
+			monitorData.StatusCode = httpResp.StatusCode
+			httpResp.Body.Close()
+		}
+		release()
+		core.Bus().SendMonitorEvent(bus.RestRequestRetryEvt, restServiceChannel, monitorData)
+		time.Sleep(policy.getRetryBackoff(attempt))
+	}
+}
+
+// startClientSpan starts the span of an attempt to send a request which is part of a trace and propagates
+// the trace context of the span to the upstream. returns nil for requests which are not part of a trace.
+func startClientSpan(httpReq *http.Request, attemptReq *http.Request, attempt int) trace.Span {
+	if !tracing.HasTraceContext(httpReq.Context()) {
+		return nil
+	}
+	ctx, span := tracing.Tracer().Start(httpReq.Context(), "HTTP "+httpReq.Method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.method", httpReq.Method),
+			attribute.String("http.url", httpReq.URL.Redacted()),
+			attribute.Int("http.resend_count", attempt-1)))
+	tracing.Inject(ctx, propagation.HeaderCarrier(attemptReq.Header))
+	return span
+}
+
+func endClientSpan(span trace.Span, httpResp *http.Response, err error) {
+	if span == nil {
+		return
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	} else {
+		span.SetAttributes(attribute.Int("http.status_code", httpResp.StatusCode))
+		if httpResp.StatusCode >= 500 {
+			span.SetStatus(codes.Error, httpResp.Status)
+		}
+	}
+	span.End()
+}
+
+// newAttemptRequest creates a copy of the request with a fresh body, as the body of a sent request
+// is consumed, and applies the credentials to it.
+func (rs *restService) newAttemptRequest(ctx context.Context, httpReq *http.Request, body io.Reader,
+	credentials CredentialProvider) (*http.Request, error) {
+
+	attemptReq, err := http.NewRequestWithContext(ctx, httpReq.Method, httpReq.URL.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	attemptReq.Header = httpReq.Header.Clone()
+	if credentials != nil {
+		if err = credentials.ApplyCredentials(ctx, attemptReq); err != nil {
+			// closing the body stops the writer of streamed bodies
+			attemptReq.Body.Close()
+			return nil, &credentialsError{err: err}
+		}
+	}
+	return attemptReq, nil
+}
+
+// getHttpClient returns the client presenting the TLS client certificate the credentials provide for the host,
+// or the default client if there's none. clients are cached per TLS configuration to reuse their connections,
+// up to maxTLSClients clients.
+func (rs *restService) getHttpClient(credentials CredentialProvider, host string) *http.Client {
+	tlsProvider, ok := credentials.(TLSCredentialProvider)
+	if !ok {
+		return &rs.httpClient
+	}
+	tlsConfig := tlsProvider.GetTLSConfig(host)
+	if tlsConfig == nil {
+		return &rs.httpClient
+	}
+
+	rs.clientsLock.Lock()
+	defer rs.clientsLock.Unlock()
+	if client, found := rs.tlsClients[tlsConfig]; found {
+		return client
+	}
+
+	var transport *http.Transport
+	switch t := rs.httpClient.Transport.(type) {
+	case nil:
+		transport = http.DefaultTransport.(*http.Transport).Clone()
+	case *http.Transport:
+		transport = t.Clone()
+	default:
+		// custom round trippers manage their own TLS configuration
+		return &rs.httpClient
+	}
+	transport.TLSClientConfig = tlsConfig.Clone()
+
+	client := &http.Client{
+		Transport:     transport,
+		CheckRedirect: rs.httpClient.CheckRedirect,
+		Jar:           rs.httpClient.Jar,
+		Timeout:       rs.httpClient.Timeout,
+	}
+	if rs.tlsClients == nil {
+		rs.tlsClients = make(map[*tls.Config]*http.Client)
+	}
+	if len(rs.tlsConfigs) >= maxTLSClients {
+		oldest := rs.tlsConfigs[0]
+		rs.tlsConfigs[0] = nil
+		rs.tlsConfigs = rs.tlsConfigs[1:]
+		// requests in progress complete, only the idle connections are closed
+		rs.tlsClients[oldest].CloseIdleConnections()
+		delete(rs.tlsClients, oldest)
+	}
+	rs.tlsClients[tlsConfig] = client
+	rs.tlsConfigs = append(rs.tlsConfigs, tlsConfig)
+	return client
+}
+
+// cancelOnCloseBody releases the context of a request once its response body is closed.
+type cancelOnCloseBody struct {
+	io.ReadCloser
+	cancelFn context.CancelFunc
+}
+
+func (b *cancelOnCloseBody) Close() error {
+	defer b.cancelFn()
+	return b.ReadCloser.Close()
+}
+
 func (rs *restService) getRestServiceRequest(request *model.Request) (*RestServiceRequest, bool) {
 	restReq, ok := request.Payload.(*RestServiceRequest)
 	if ok {
@@ -0,0 +1,361 @@
+// Copyright 2019-2021 VMware, Inc.
+// SPDX-License-Identifier: BSD-2-Clause
+
+package service
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// refresh OAuth2 tokens this long before they expire if not configured otherwise
+const defaultTokenRefreshBefore = 30 * time.Second
+
+// CredentialProvider adds credentials to the requests made by the fabric-rest service. A provider can be set
+// for all requests made by a fabric service with FabricServiceCore.SetCredentialProvider, or for a single
+// request with RestServiceRequest.Credentials. ApplyCredentials is called before every attempt to send a
+// request so providers can refresh expired credentials. Providers must add credentials only to the requests
+// to the hosts they are meant for, as a service can call any host.
+type CredentialProvider interface {
+	ApplyCredentials(ctx context.Context, req *http.Request) error
+}
+
+// TLSCredentialProvider is a CredentialProvider which also provides the TLS configuration, e.g. client
+// certificates, used to connect to a host. GetTLSConfig returns nil for hosts with no specific configuration.
+type TLSCredentialProvider interface {
+	CredentialProvider
+	GetTLSConfig(host string) *tls.Config
+}
+
+// credentialInvalidator is implemented by providers caching credentials which should be dropped once the
+// upstream rejects them with 401 Unauthorized.
+type credentialInvalidator interface {
+	Invalidate()
+}
+
+// credentialsError is returned when the credentials of a request can't be obtained.
+type credentialsError struct {
+	err error
+}
+
+func (e *credentialsError) Error() string {
+	return "rest-service error, unable to obtain credentials: " + e.err.Error()
+}
+
+// OAuth2ClientCredentialsConfig configures the OAuth2 client credentials flow.
+type OAuth2ClientCredentialsConfig struct {
+	TokenUrl     string            // token endpoint of the authorization server
+	ClientId     string            // client id sent with HTTP basic authentication
+	ClientSecret string            // client secret sent with HTTP basic authentication
+	Scopes       []string          // optional scopes requested
+	Params       map[string]string // optional additional parameters of the token request, e.g. audience
+	// tokens are refreshed this long before they expire, defaults to 30 seconds.
+	RefreshBefore time.Duration
+	// optional client used to call the token endpoint, defaults to a client with a 30 seconds timeout.
+	HttpClient *http.Client
+	// hosts (host or host:port) the token is sent to. the token is never sent to the other hosts.
+	Hosts []string
+}
+
+type oauth2Token struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+type oauth2ClientCredentialsProvider struct {
+	config    OAuth2ClientCredentialsConfig
+	lock      sync.Mutex
+	token     string
+	tokenType string
+	expiresAt time.Time
+	// closed once the token request in progress, if any, completes
+	fetching chan struct{}
+}
+
+// NewOAuth2ClientCredentialsProvider creates a provider adding the access token obtained with the OAuth2
+// client credentials flow to the Authorization header of the requests to the configured hosts. The token
+// is cached until it's about to expire, or until an upstream rejects it.
+func NewOAuth2ClientCredentialsProvider(config *OAuth2ClientCredentialsConfig) CredentialProvider {
+	p := &oauth2ClientCredentialsProvider{config: *config}
+	if p.config.RefreshBefore <= 0 {
+		p.config.RefreshBefore = defaultTokenRefreshBefore
+	}
+	if p.config.HttpClient == nil {
+		p.config.HttpClient = &http.Client{Timeout: 30 * time.Second}
+	}
+	return p
+}
+
+func (p *oauth2ClientCredentialsProvider) ApplyCredentials(ctx context.Context, req *http.Request) error {
+	if !matchesHost(p.config.Hosts, req.URL.Host) {
+		return nil
+	}
+	token, tokenType, err := p.getToken(ctx)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", tokenType+" "+token)
+	return nil
+}
+
+func (p *oauth2ClientCredentialsProvider) Invalidate() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.token = ""
+}
+
+// getToken returns the cached token, or requests a new one. the token is requested without holding the lock,
+// concurrent callers wait for the request in progress.
+func (p *oauth2ClientCredentialsProvider) getToken(ctx context.Context) (string, string, error) {
+	for {
+		p.lock.Lock()
+		if p.token != "" && (p.expiresAt.IsZero() || time.Now().Before(p.expiresAt.Add(-p.config.RefreshBefore))) {
+			token, tokenType := p.token, p.tokenType
+			p.lock.Unlock()
+			return token, tokenType, nil
+		}
+		if fetching := p.fetching; fetching != nil {
+			p.lock.Unlock()
+			select {
+			case <-fetching:
+				continue
+			case <-ctx.Done():
+				return "", "", ctx.Err()
+			}
+		}
+		fetching := make(chan struct{})
+		p.fetching = fetching
+		p.lock.Unlock()
+
+		token, err := p.requestToken(ctx)
+
+		p.lock.Lock()
+		p.fetching = nil
+		close(fetching)
+		if err != nil {
+			p.lock.Unlock()
+			return "", "", err
+		}
+		p.token = token.AccessToken
+		p.tokenType = "Bearer"
+		if token.TokenType != "" && !strings.EqualFold(token.TokenType, "bearer") {
+			p.tokenType = token.TokenType
+		}
+		p.expiresAt = time.Time{}
+		if token.ExpiresIn > 0 {
+			p.expiresAt = time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+		}
+		tokenValue, tokenType := p.token, p.tokenType
+		p.lock.Unlock()
+		return tokenValue, tokenType, nil
+	}
+}
+
+func (p *oauth2ClientCredentialsProvider) requestToken(ctx context.Context) (*oauth2Token, error) {
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	if len(p.config.Scopes) > 0 {
+		form.Set("scope", strings.Join(p.config.Scopes, " "))
+	}
+	for k, v := range p.config.Params {
+		form.Set(k, v)
+	}
+
+	tokenReq, err := http.NewRequestWithContext(
+		ctx, http.MethodPost, p.config.TokenUrl, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	tokenReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	tokenReq.Header.Set("Accept", "application/json")
+	tokenReq.SetBasicAuth(url.QueryEscape(p.config.ClientId), url.QueryEscape(p.config.ClientSecret))
+
+	resp, err := p.config.HttpClient.Do(tokenReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token request failed: %s", resp.Status)
+	}
+
+	var token oauth2Token
+	if err = json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return nil, fmt.Errorf("invalid token response: %s", err.Error())
+	}
+	if token.AccessToken == "" {
+		return nil, fmt.Errorf("invalid token response: no access token")
+	}
+	return &token, nil
+}
+
+type fileBearerTokenProvider struct {
+	path    string
+	hosts   []string
+	lock    sync.Mutex
+	token   string
+	modTime time.Time
+	size    int64
+}
+
+// NewFileBearerTokenProvider creates a provider adding the bearer token stored in the file at the given
+// path to the Authorization header of the requests to the given hosts (host or host:port). The file is
+// read again whenever it changes, e.g. when the token is rotated by a Kubernetes projected volume.
+func NewFileBearerTokenProvider(path string, hosts ...string) CredentialProvider {
+	return &fileBearerTokenProvider{path: path, hosts: hosts}
+}
+
+func (p *fileBearerTokenProvider) ApplyCredentials(ctx context.Context, req *http.Request) error {
+	if !matchesHost(p.hosts, req.URL.Host) {
+		return nil
+	}
+	token, err := p.getToken()
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+func (p *fileBearerTokenProvider) getToken() (string, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	info, err := os.Stat(p.path)
+	if err != nil {
+		return "", err
+	}
+	if p.token != "" && info.ModTime().Equal(p.modTime) && info.Size() == p.size {
+		return p.token, nil
+	}
+
+	data, err := ioutil.ReadFile(p.path)
+	if err != nil {
+		return "", err
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("token file %s is empty", p.path)
+	}
+	p.token, p.modTime, p.size = token, info.ModTime(), info.Size()
+	return p.token, nil
+}
+
+// MTLSCredentialProvider provides TLS client certificates per host.
+type MTLSCredentialProvider struct {
+	lock       sync.RWMutex
+	tlsConfigs map[string]*tls.Config
+}
+
+// NewMTLSCredentialProvider creates a provider without any client certificates,
+// use AddClientCertificate to configure the certificate of each host.
+func NewMTLSCredentialProvider() *MTLSCredentialProvider {
+	return &MTLSCredentialProvider{tlsConfigs: make(map[string]*tls.Config)}
+}
+
+// AddClientCertificate configures the client certificate presented to the given host (or host:port). If
+// caFile is not empty the server certificate of the host is verified with the CA certificates in the file.
+func (p *MTLSCredentialProvider) AddClientCertificate(host string, certFile string, keyFile string, caFile string) error {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return fmt.Errorf("unable to load client certificate for host %s: %s", host, err.Error())
+	}
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}}
+
+	if caFile != "" {
+		caData, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return fmt.Errorf("unable to load CA certificates for host %s: %s", host, err.Error())
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(caData) {
+			return fmt.Errorf("unable to load CA certificates for host %s: no certificates found", host)
+		}
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.tlsConfigs[host] = tlsConfig
+	return nil
+}
+
+func (p *MTLSCredentialProvider) ApplyCredentials(ctx context.Context, req *http.Request) error {
+	return nil
+}
+
+func (p *MTLSCredentialProvider) GetTLSConfig(host string) *tls.Config {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	if tlsConfig, found := p.tlsConfigs[host]; found {
+		return tlsConfig
+	}
+	// fall back to the configuration of the host without the port
+	if i := strings.LastIndex(host, ":"); i > 0 {
+		return p.tlsConfigs[host[:i]]
+	}
+	return nil
+}
+
+type chainedCredentialProvider struct {
+	providers []CredentialProvider
+}
+
+// ChainCredentialProviders combines multiple providers into one, e.g. an MTLSCredentialProvider
+// and an OAuth2 client credentials provider. The providers are applied in order.
+func ChainCredentialProviders(providers ...CredentialProvider) TLSCredentialProvider {
+	return &chainedCredentialProvider{providers: providers}
+}
+
+func (p *chainedCredentialProvider) ApplyCredentials(ctx context.Context, req *http.Request) error {
+	for _, provider := range p.providers {
+		if err := provider.ApplyCredentials(ctx, req); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *chainedCredentialProvider) GetTLSConfig(host string) *tls.Config {
+	for _, provider := range p.providers {
+		if tlsProvider, ok := provider.(TLSCredentialProvider); ok {
+			if tlsConfig := tlsProvider.GetTLSConfig(host); tlsConfig != nil {
+				return tlsConfig
+			}
+		}
+	}
+	return nil
+}
+
+func (p *chainedCredentialProvider) Invalidate() {
+	for _, provider := range p.providers {
+		if invalidator, ok := provider.(credentialInvalidator); ok {
+			invalidator.Invalidate()
+		}
+	}
+}
+
+// matchesHost reports whether the host (host:port) of a request is one of the given hosts. hosts configured
+// without a port match all ports.
+func matchesHost(hosts []string, host string) bool {
+	hostname := host
+	if i := strings.LastIndex(host, ":"); i > 0 && !strings.HasSuffix(host, "]") {
+		hostname = host[:i]
+	}
+	for _, h := range hosts {
+		if strings.EqualFold(h, host) || strings.EqualFold(h, hostname) {
+			return true
+		}
+	}
+	return false
+}
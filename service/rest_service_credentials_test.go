@@ -0,0 +1,444 @@
+// Copyright 2019-2021 VMware, Inc.
+// SPDX-License-Identifier: BSD-2-Clause
+
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newStubTokenServer starts a token endpoint issuing numbered tokens valid for expiresIn seconds.
+func newStubTokenServer(t *testing.T, expiresIn int) (*httptest.Server, func() int) {
+	var lock sync.Mutex
+	issued := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clientId, clientSecret, _ := r.BasicAuth()
+		if clientId != "client-1" || clientSecret != "secret-1" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		r.ParseForm()
+		assert.Equal(t, "client_credentials", r.PostForm.Get("grant_type"))
+		assert.Equal(t, "read write", r.PostForm.Get("scope"))
+
+		lock.Lock()
+		issued++
+		token := fmt.Sprintf("token-%d", issued)
+		lock.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": token, "token_type": "bearer", "expires_in": expiresIn})
+	}))
+	return server, func() int {
+		lock.Lock()
+		defer lock.Unlock()
+		return issued
+	}
+}
+
+// newAuthRecordingTransport records the Authorization header of every request and responds with the statuses in order.
+func newAuthRecordingTransport(statuses ...int) (RoundTripFunc, func() []string) {
+	var lock sync.Mutex
+	authHeaders := make([]string, 0)
+	return func(req *http.Request) (*http.Response, error) {
+			lock.Lock()
+			defer lock.Unlock()
+			status := 200
+			if len(authHeaders) < len(statuses) {
+				status = statuses[len(authHeaders)]
+			}
+			authHeaders = append(authHeaders, req.Header.Get("Authorization"))
+			return &http.Response{
+				StatusCode: status,
+				Status:     http.StatusText(status),
+				Body:       ioutil.NopCloser(bytes.NewBufferString("{}")),
+				Header:     make(http.Header),
+			}, nil
+		}, func() []string {
+			lock.Lock()
+			defer lock.Unlock()
+			return append([]string{}, authHeaders...)
+		}
+}
+
+func TestRestService_OAuth2ClientCredentials(t *testing.T) {
+	tokenServer, getIssued := newStubTokenServer(t, 3600)
+	defer tokenServer.Close()
+
+	core := newTestFabricCore(restServiceChannel)
+	rs := &restService{}
+	transport, getAuthHeaders := newAuthRecordingTransport()
+	rs.httpClient.Transport = transport
+
+	provider := NewOAuth2ClientCredentialsProvider(&OAuth2ClientCredentialsConfig{
+		TokenUrl: tokenServer.URL, ClientId: "client-1", ClientSecret: "secret-1", Scopes: []string{"read", "write"},
+		Hosts: []string{"localhost:4444"}})
+
+	for i := 0; i < 3; i++ {
+		response := handleRestRequest(t, rs, core, &RestServiceRequest{
+			Uri: "http://localhost:4444/test-url", Credentials: provider})
+		assert.False(t, response.Error)
+	}
+	// the token is cached until it expires
+	assert.Equal(t, 1, getIssued())
+	assert.Equal(t, []string{"Bearer token-1", "Bearer token-1", "Bearer token-1"}, getAuthHeaders())
+}
+
+func TestRestService_OAuth2ClientCredentialsRefresh(t *testing.T) {
+	// tokens expiring within the refresh window are refreshed before every request
+	tokenServer, getIssued := newStubTokenServer(t, 10)
+	defer tokenServer.Close()
+
+	core := newTestFabricCore(restServiceChannel)
+	rs := &restService{}
+	transport, getAuthHeaders := newAuthRecordingTransport()
+	rs.httpClient.Transport = transport
+
+	provider := NewOAuth2ClientCredentialsProvider(&OAuth2ClientCredentialsConfig{
+		TokenUrl: tokenServer.URL, ClientId: "client-1", ClientSecret: "secret-1", Scopes: []string{"read", "write"},
+		Hosts: []string{"localhost:4444"}})
+
+	handleRestRequest(t, rs, core, &RestServiceRequest{Uri: "http://localhost:4444/test-url", Credentials: provider})
+	handleRestRequest(t, rs, core, &RestServiceRequest{Uri: "http://localhost:4444/test-url", Credentials: provider})
+	assert.Equal(t, 2, getIssued())
+	assert.Equal(t, []string{"Bearer token-1", "Bearer token-2"}, getAuthHeaders())
+}
+
+func TestRestService_OAuth2ClientCredentialsRejectedToken(t *testing.T) {
+	tokenServer, getIssued := newStubTokenServer(t, 3600)
+	defer tokenServer.Close()
+
+	core := newTestFabricCore(restServiceChannel)
+	rs := &restService{}
+	transport, getAuthHeaders := newAuthRecordingTransport(401)
+	rs.httpClient.Transport = transport
+
+	provider := NewOAuth2ClientCredentialsProvider(&OAuth2ClientCredentialsConfig{
+		TokenUrl: tokenServer.URL, ClientId: "client-1", ClientSecret: "secret-1", Scopes: []string{"read", "write"},
+		Hosts: []string{"localhost:4444"}})
+
+	// the rejected token is dropped and the request is sent again with a new one
+	response := handleRestRequest(t, rs, core, &RestServiceRequest{
+		Uri: "http://localhost:4444/test-url", Credentials: provider})
+	assert.False(t, response.Error)
+	assert.Equal(t, 2, getIssued())
+	assert.Equal(t, []string{"Bearer token-1", "Bearer token-2"}, getAuthHeaders())
+
+	// the request is sent again only once
+	transport, getAuthHeaders = newAuthRecordingTransport(401, 401, 401)
+	rs.httpClient.Transport = transport
+	response = handleRestRequest(t, rs, core, &RestServiceRequest{
+		Uri: "http://localhost:4444/test-url", Credentials: provider})
+	assert.Equal(t, 401, response.ErrorCode)
+	assert.Len(t, getAuthHeaders(), 2)
+}
+
+func TestRestService_OAuth2ClientCredentialsFailure(t *testing.T) {
+	tokenServer, getIssued := newStubTokenServer(t, 3600)
+	defer tokenServer.Close()
+
+	core := newTestFabricCore(restServiceChannel)
+	rs := &restService{}
+	transport, getAuthHeaders := newAuthRecordingTransport()
+	rs.httpClient.Transport = transport
+
+	provider := NewOAuth2ClientCredentialsProvider(&OAuth2ClientCredentialsConfig{
+		TokenUrl: tokenServer.URL, ClientId: "client-1", ClientSecret: "wrong-secret", Hosts: []string{"localhost"}})
+
+	response := handleRestRequest(t, rs, core, &RestServiceRequest{
+		Uri: "http://localhost:4444/test-url", Credentials: provider})
+	assert.Equal(t, 500, response.ErrorCode)
+	assert.Equal(t,
+		"rest-service error, unable to obtain credentials: token request failed: 401 Unauthorized", response.ErrorMessage)
+	assert.Equal(t, 0, getIssued())
+	// the upstream is not called
+	assert.Len(t, getAuthHeaders(), 0)
+}
+
+func TestRestService_CredentialsFailureWithCircuitBreaker(t *testing.T) {
+	core := newTestFabricCore(restServiceChannel)
+	rs := &restService{}
+	transport, calls := newStatusTransport(200)
+	rs.httpClient.Transport = transport
+	rs.setPolicy(&RestRequestPolicy{
+		CircuitBreaker: &CircuitBreakerPolicy{FailureThreshold: 1, OpenTimeout: time.Minute},
+	})
+
+	failing := credentialProviderFunc(func(ctx context.Context, req *http.Request) error {
+		return errors.New("no credentials")
+	})
+	for i := 0; i < 3; i++ {
+		response := handleRestRequest(t, rs, core, &RestServiceRequest{
+			Uri: "http://localhost:4444/test-url", Credentials: failing})
+		assert.Equal(t, "rest-service error, unable to obtain credentials: no credentials", response.ErrorMessage)
+	}
+
+	// credential failures don't open the circuit
+	response := handleRestRequest(t, rs, core, &RestServiceRequest{Uri: "http://localhost:4444/test-url"})
+	assert.False(t, response.Error)
+	assert.Equal(t, 1, *calls)
+}
+
+type credentialProviderFunc func(ctx context.Context, req *http.Request) error
+
+func (f credentialProviderFunc) ApplyCredentials(ctx context.Context, req *http.Request) error {
+	return f(ctx, req)
+}
+
+func TestRestService_FileBearerToken(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	assert.Nil(t, ioutil.WriteFile(tokenFile, []byte("file-token-1\n"), 0600))
+
+	core := newTestFabricCore(restServiceChannel)
+	rs := &restService{}
+	transport, getAuthHeaders := newAuthRecordingTransport()
+	rs.httpClient.Transport = transport
+	provider := NewFileBearerTokenProvider(tokenFile, "localhost")
+
+	handleRestRequest(t, rs, core, &RestServiceRequest{Uri: "http://localhost:4444/test-url", Credentials: provider})
+
+	// the rotated token is reloaded
+	assert.Nil(t, ioutil.WriteFile(tokenFile, []byte("file-token-2"), 0600))
+	future := time.Now().Add(time.Minute)
+	assert.Nil(t, os.Chtimes(tokenFile, future, future))
+	handleRestRequest(t, rs, core, &RestServiceRequest{Uri: "http://localhost:4444/test-url", Credentials: provider})
+	assert.Equal(t, []string{"Bearer file-token-1", "Bearer file-token-2"}, getAuthHeaders())
+
+	os.Remove(tokenFile)
+	response := handleRestRequest(t, rs, core, &RestServiceRequest{
+		Uri: "http://localhost:4444/test-url", Credentials: provider})
+	assert.Equal(t, 500, response.ErrorCode)
+	assert.True(t, strings.HasPrefix(response.ErrorMessage, "rest-service error, unable to obtain credentials:"))
+}
+
+// writeTestCertificate writes a self-signed client certificate and its key to the directory.
+func writeTestCertificate(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test-client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	certDer, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.Nil(t, err)
+
+	certFile, keyFile := filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key")
+	assert.Nil(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDer}), 0600))
+	assert.Nil(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+	return certFile, keyFile
+}
+
+func TestRestService_MTLSCredentials(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"client": r.TLS.PeerCertificates[0].Subject.CommonName,
+			"auth":   r.Header.Get("Authorization"),
+		})
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	server.StartTLS()
+	defer server.Close()
+
+	dir := t.TempDir()
+	certFile, keyFile := writeTestCertificate(t, dir)
+	caFile := filepath.Join(dir, "ca.crt")
+	assert.Nil(t, ioutil.WriteFile(caFile,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600))
+
+	host := strings.TrimPrefix(server.URL, "https://")
+	mtls := NewMTLSCredentialProvider()
+	assert.Nil(t, mtls.AddClientCertificate(host, certFile, keyFile, caFile))
+	assert.NotNil(t, mtls.GetTLSConfig(host))
+	assert.Nil(t, mtls.GetTLSConfig("otherhost"))
+
+	core := newTestFabricCore(restServiceChannel)
+	rs := &restService{}
+	tokenFile := filepath.Join(dir, "token")
+	assert.Nil(t, ioutil.WriteFile(tokenFile, []byte("file-token"), 0600))
+
+	response := handleRestRequest(t, rs, core, &RestServiceRequest{
+		Uri:         server.URL + "/test-url",
+		Credentials: ChainCredentialProviders(mtls, NewFileBearerTokenProvider(tokenFile, host)),
+	})
+	assert.False(t, response.Error)
+	assert.Equal(t, map[string]interface{}{"client": "test-client", "auth": "Bearer file-token"}, response.Payload)
+
+	// without the client certificate the handshake fails
+	response = handleRestRequest(t, rs, core, &RestServiceRequest{Uri: server.URL + "/test-url"})
+	assert.Equal(t, 500, response.ErrorCode)
+
+	assert.NotNil(t, mtls.AddClientCertificate("otherhost", certFile, keyFile, filepath.Join(dir, "missing.crt")))
+}
+
+func TestRestService_CredentialsAreScopedToHosts(t *testing.T) {
+	tokenServer, getIssued := newStubTokenServer(t, 3600)
+	defer tokenServer.Close()
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	assert.Nil(t, ioutil.WriteFile(tokenFile, []byte("file-token"), 0600))
+
+	core := newTestFabricCore(restServiceChannel)
+	rs := &restService{}
+	transport, getAuthHeaders := newAuthRecordingTransport()
+	rs.httpClient.Transport = transport
+
+	oauth2 := NewOAuth2ClientCredentialsProvider(&OAuth2ClientCredentialsConfig{
+		TokenUrl: tokenServer.URL, ClientId: "client-1", ClientSecret: "secret-1", Scopes: []string{"read", "write"},
+		Hosts: []string{"api.example.com"}})
+	file := NewFileBearerTokenProvider(tokenFile, "files.example.com:8443")
+
+	for _, uri := range []string{"http://otherhost/test-url", "http://api.example.com.evil/test-url",
+		"http://files.example.com/test-url", "http://files.example.com:9443/test-url"} {
+		handleRestRequest(t, rs, core, &RestServiceRequest{Uri: uri, Credentials: ChainCredentialProviders(oauth2, file)})
+	}
+	assert.Equal(t, []string{"", "", "", ""}, getAuthHeaders())
+	assert.Equal(t, 0, getIssued())
+
+	handleRestRequest(t, rs, core, &RestServiceRequest{Uri: "http://api.example.com:8080/test-url", Credentials: oauth2})
+	handleRestRequest(t, rs, core, &RestServiceRequest{Uri: "http://files.example.com:8443/test-url", Credentials: file})
+	assert.Equal(t, []string{"Bearer token-1", "Bearer file-token"}, getAuthHeaders()[4:])
+}
+
+func TestOAuth2ClientCredentialsProvider_ConcurrentTokenRequests(t *testing.T) {
+	release := make(chan struct{})
+	requested := make(chan struct{}, 10)
+	var lock sync.Mutex
+	issued := 0
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested <- struct{}{}
+		<-release
+		lock.Lock()
+		issued++
+		lock.Unlock()
+		json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "token", "expires_in": 3600})
+	}))
+	defer tokenServer.Close()
+
+	provider := NewOAuth2ClientCredentialsProvider(&OAuth2ClientCredentialsConfig{
+		TokenUrl: tokenServer.URL, ClientId: "client-1", ClientSecret: "secret-1", Hosts: []string{"localhost"}})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req, _ := http.NewRequest(http.MethodGet, "http://localhost/test-url", nil)
+			assert.Nil(t, provider.ApplyCredentials(context.Background(), req))
+			assert.Equal(t, "Bearer token", req.Header.Get("Authorization"))
+		}()
+	}
+	<-requested
+
+	// the provider is not locked while the token is requested
+	invalidated := make(chan struct{})
+	go func() {
+		provider.(credentialInvalidator).Invalidate()
+		close(invalidated)
+	}()
+	select {
+	case <-invalidated:
+	case <-time.After(time.Second):
+		assert.Fail(t, "provider locked during the token request")
+	}
+
+	// and a caller giving up doesn't wait for the token request
+	ctx, cancelFn := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancelFn()
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost/test-url", nil)
+	assert.Equal(t, context.DeadlineExceeded, provider.ApplyCredentials(ctx, req))
+
+	close(release)
+	wg.Wait()
+	lock.Lock()
+	defer lock.Unlock()
+	assert.Equal(t, 1, issued)
+}
+
+func TestRestService_NoCredentialsWhileCircuitIsOpen(t *testing.T) {
+	core := newTestFabricCore(restServiceChannel)
+	rs := &restService{}
+	transport, _ := newStatusTransport(500, 200)
+	rs.httpClient.Transport = transport
+	rs.setPolicy(&RestRequestPolicy{
+		CircuitBreaker: &CircuitBreakerPolicy{FailureThreshold: 1, OpenTimeout: 50 * time.Millisecond},
+	})
+
+	applied := 0
+	fail := false
+	provider := credentialProviderFunc(func(ctx context.Context, req *http.Request) error {
+		applied++
+		if fail {
+			return errors.New("no credentials")
+		}
+		return nil
+	})
+	restReq := &RestServiceRequest{Uri: "http://localhost:4444/test-url", Credentials: provider}
+
+	assert.Equal(t, 500, handleRestRequest(t, rs, core, restReq).ErrorCode)
+	assert.Equal(t, http.StatusServiceUnavailable, handleRestRequest(t, rs, core, restReq).ErrorCode)
+	assert.Equal(t, 1, applied)
+
+	// the trial request is not lost to a credential failure
+	time.Sleep(60 * time.Millisecond)
+	fail = true
+	assert.Equal(t, "rest-service error, unable to obtain credentials: no credentials",
+		handleRestRequest(t, rs, core, restReq).ErrorMessage)
+	fail = false
+	assert.False(t, handleRestRequest(t, rs, core, restReq).Error)
+	assert.Equal(t, 3, applied)
+}
+
+type tlsConfigProvider struct {
+	config *tls.Config
+}
+
+func (p *tlsConfigProvider) ApplyCredentials(ctx context.Context, req *http.Request) error {
+	return nil
+}
+
+func (p *tlsConfigProvider) GetTLSConfig(host string) *tls.Config {
+	return p.config
+}
+
+func TestRestService_TLSClientsAreBounded(t *testing.T) {
+	rs := &restService{}
+	first := &tlsConfigProvider{config: &tls.Config{}}
+	firstClient := rs.getHttpClient(first, "localhost")
+	assert.Equal(t, firstClient, rs.getHttpClient(first, "localhost"))
+
+	for i := 0; i < maxTLSClients; i++ {
+		rs.getHttpClient(&tlsConfigProvider{config: &tls.Config{}}, "localhost")
+	}
+	assert.Len(t, rs.tlsClients, maxTLSClients)
+	assert.Len(t, rs.tlsConfigs, maxTLSClients)
+	// the oldest client was dropped
+	assert.NotEqual(t, firstClient, rs.getHttpClient(first, "localhost"))
+}
@@ -0,0 +1,281 @@
+// Copyright 2019-2021 VMware, Inc.
+// SPDX-License-Identifier: BSD-2-Clause
+
+package service
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// timeout of the requests made by the fabric-rest service if no policy sets a different one
+	defaultRestRequestTimeout = 60 * time.Second
+	defaultRestRetryBackoff   = 100 * time.Millisecond
+
+	// limits of the policies set for single requests with RestServiceRequest.Policy
+	maxRestRequestTimeout = 5 * time.Minute
+	maxRestRetries        = 10
+	maxRestRetryBackoff   = time.Minute
+	maxRestStreamDuration = 24 * time.Hour
+
+	defaultRestStreamIdleTimeout = 5 * time.Minute
+	defaultRestMaxStreamDuration = time.Hour
+
+	defaultCircuitBreakerFailureThreshold = 5
+	defaultCircuitBreakerOpenTimeout      = 30 * time.Second
+
+	// header marking a non-idempotent request as safe to retry
+	idempotencyKeyHeader = "Idempotency-Key"
+)
+
+// statuses retried if RestRequestPolicy.RetryOnStatus is not set
+var defaultRetryStatuses = []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout}
+
+// RestRequestPolicy controls how the fabric-rest service calls an upstream. The policy can be set for all
+// requests with ServiceRegistry.SetGlobalRestServicePolicy, for the requests to a host with
+// ServiceRegistry.SetRestServiceHostPolicy or for a single request with RestServiceRequest.Policy.
+type RestRequestPolicy struct {
+	// Timeout of a single attempt, defaults to 60 seconds.
+	Timeout time.Duration `json:"timeout,omitempty"`
+	// Maximum number of retries after the first attempt. Requests are retried after transport errors,
+	// timeouts and responses with one of the RetryOnStatus statuses.
+	MaxRetries int `json:"maxRetries,omitempty"`
+	// Delay before the first retry, doubled for every next retry. defaults to 100 milliseconds.
+	RetryBackoff time.Duration `json:"retryBackoff,omitempty"`
+	// Upper limit of the delay between retries, unlimited if not set.
+	MaxRetryBackoff time.Duration `json:"maxRetryBackoff,omitempty"`
+	// Response statuses to retry on, defaults to 502, 503 and 504.
+	RetryOnStatus []int `json:"retryOnStatus,omitempty"`
+	// By default only requests with idempotent methods (GET, HEAD, OPTIONS, PUT, DELETE, TRACE) or with
+	// the Idempotency-Key header are retried. RetryNonIdempotent allows retrying all requests.
+	RetryNonIdempotent bool `json:"retryNonIdempotent,omitempty"`
+	// Maximum time to wait for the next part of a streamed response body, defaults to 5 minutes.
+	StreamIdleTimeout time.Duration `json:"streamIdleTimeout,omitempty"`
+	// Maximum duration of a streamed response body, defaults to 1 hour.
+	MaxStreamDuration time.Duration `json:"maxStreamDuration,omitempty"`
+	// Optional circuit breaker shared by all requests to the same host. it's configured only by the
+	// global and host policies, the circuit breaker of a single request policy is ignored.
+	CircuitBreaker *CircuitBreakerPolicy `json:"circuitBreaker,omitempty"`
+}
+
+// CircuitBreakerPolicy configures the circuit breaker of an upstream host. After FailureThreshold
+// consecutive failed requests the circuit opens and all requests to the host are rejected with
+// 503 Service Unavailable. After OpenTimeout a single trial request is let through, the circuit
+// closes again if it succeeds.
+type CircuitBreakerPolicy struct {
+	FailureThreshold int           `json:"failureThreshold"` // defaults to 5 failures
+	OpenTimeout      time.Duration `json:"openTimeout"`      // defaults to 30 seconds
+}
+
+func (p *CircuitBreakerPolicy) getFailureThreshold() int {
+	if p.FailureThreshold <= 0 {
+		return defaultCircuitBreakerFailureThreshold
+	}
+	return p.FailureThreshold
+}
+
+func (p *CircuitBreakerPolicy) getOpenTimeout() time.Duration {
+	if p.OpenTimeout <= 0 {
+		return defaultCircuitBreakerOpenTimeout
+	}
+	return p.OpenTimeout
+}
+
+// RestRequestMonitorData is the data of the monitor events sent by the fabric-rest service.
+type RestRequestMonitorData struct {
+	Host       string `json:"host"`
+	Method     string `json:"method,omitempty"`
+	Uri        string `json:"uri,omitempty"`
+	Attempt    int    `json:"attempt,omitempty"`
+	StatusCode int    `json:"statusCode,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// limit returns a copy of the policy of a single request with its values capped by the limits of the
+// service, and the circuit breaker of the host policy.
+func (p *RestRequestPolicy) limit(hostPolicy *RestRequestPolicy) *RestRequestPolicy {
+	limited := *p
+	if limited.Timeout > maxRestRequestTimeout {
+		limited.Timeout = maxRestRequestTimeout
+	}
+	if limited.MaxRetries > maxRestRetries {
+		limited.MaxRetries = maxRestRetries
+	}
+	if limited.RetryBackoff > maxRestRetryBackoff {
+		limited.RetryBackoff = maxRestRetryBackoff
+	}
+	if limited.MaxRetryBackoff <= 0 || limited.MaxRetryBackoff > maxRestRetryBackoff {
+		limited.MaxRetryBackoff = maxRestRetryBackoff
+	}
+	if limited.StreamIdleTimeout > maxRestRequestTimeout {
+		limited.StreamIdleTimeout = maxRestRequestTimeout
+	}
+	if limited.MaxStreamDuration > maxRestStreamDuration {
+		limited.MaxStreamDuration = maxRestStreamDuration
+	}
+	limited.CircuitBreaker = nil
+	if hostPolicy != nil {
+		limited.CircuitBreaker = hostPolicy.CircuitBreaker
+	}
+	return &limited
+}
+
+func (p *RestRequestPolicy) getTimeout() time.Duration {
+	if p == nil || p.Timeout <= 0 {
+		return defaultRestRequestTimeout
+	}
+	return p.Timeout
+}
+
+func (p *RestRequestPolicy) getStreamIdleTimeout() time.Duration {
+	if p == nil || p.StreamIdleTimeout <= 0 {
+		return defaultRestStreamIdleTimeout
+	}
+	return p.StreamIdleTimeout
+}
+
+func (p *RestRequestPolicy) getMaxStreamDuration() time.Duration {
+	if p == nil || p.MaxStreamDuration <= 0 {
+		return defaultRestMaxStreamDuration
+	}
+	return p.MaxStreamDuration
+}
+
+func (p *RestRequestPolicy) getMaxRetries() int {
+	if p == nil || p.MaxRetries < 0 {
+		return 0
+	}
+	return p.MaxRetries
+}
+
+// getRetryBackoff returns the delay before the given retry (starting from 1).
+func (p *RestRequestPolicy) getRetryBackoff(retry int) time.Duration {
+	backoff := p.RetryBackoff
+	if backoff <= 0 {
+		backoff = defaultRestRetryBackoff
+	}
+	for i := 1; i < retry; i++ {
+		backoff *= 2
+		if p.MaxRetryBackoff > 0 && backoff >= p.MaxRetryBackoff {
+			break
+		}
+	}
+	if p.MaxRetryBackoff > 0 && backoff > p.MaxRetryBackoff {
+		return p.MaxRetryBackoff
+	}
+	return backoff
+}
+
+func (p *RestRequestPolicy) isRetryableStatus(statusCode int) bool {
+	statuses := p.RetryOnStatus
+	if len(statuses) == 0 {
+		statuses = defaultRetryStatuses
+	}
+	for _, status := range statuses {
+		if status == statusCode {
+			return true
+		}
+	}
+	return false
+}
+
+// canRetry implements the idempotency guard, requests which are not safe to send twice are not retried.
+func (p *RestRequestPolicy) canRetry(httpReq *http.Request) bool {
+	if p.RetryNonIdempotent || httpReq.Header.Get(idempotencyKeyHeader) != "" {
+		return true
+	}
+	switch httpReq.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+type circuitBreakerState int
+
+const (
+	circuitClosed circuitBreakerState = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+type circuitBreaker struct {
+	lock     sync.Mutex
+	state    circuitBreakerState
+	failures int
+	openedAt time.Time
+}
+
+// allow reports whether a request can be sent to the host and whether the circuit has just
+// transitioned to half-open.
+func (cb *circuitBreaker) allow(policy *CircuitBreakerPolicy) (allowed bool, halfOpened bool) {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+
+	switch cb.state {
+	case circuitOpen:
+		if time.Since(cb.openedAt) < policy.getOpenTimeout() {
+			return false, false
+		}
+		// let a single trial request through
+		cb.state = circuitHalfOpen
+		return true, true
+	case circuitHalfOpen:
+		// a trial request is already in progress
+		return false, false
+	}
+	return true, false
+}
+
+// cancelTrial lets the next request through as the trial request if the trial request was not sent.
+func (cb *circuitBreaker) cancelTrial() {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	if cb.state == circuitHalfOpen {
+		cb.state = circuitOpen
+	}
+}
+
+// onSuccess records a successful request and reports whether the circuit has been closed.
+func (cb *circuitBreaker) onSuccess() (closed bool) {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	closed = cb.state != circuitClosed
+	cb.state = circuitClosed
+	cb.failures = 0
+	return closed
+}
+
+// onFailure records a failed request and reports whether the circuit has been opened.
+func (cb *circuitBreaker) onFailure(policy *CircuitBreakerPolicy) (opened bool) {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	cb.failures++
+	if cb.state == circuitHalfOpen || (cb.state == circuitClosed && cb.failures >= policy.getFailureThreshold()) {
+		cb.state = circuitOpen
+		cb.openedAt = time.Now()
+		return true
+	}
+	return false
+}
+
+type circuitBreakers struct {
+	lock     sync.Mutex
+	breakers map[string]*circuitBreaker
+}
+
+func (cbs *circuitBreakers) get(host string) *circuitBreaker {
+	cbs.lock.Lock()
+	defer cbs.lock.Unlock()
+	if cbs.breakers == nil {
+		cbs.breakers = make(map[string]*circuitBreaker)
+	}
+	cb, found := cbs.breakers[host]
+	if !found {
+		cb = &circuitBreaker{}
+		cbs.breakers[host] = cb
+	}
+	return cb
+}
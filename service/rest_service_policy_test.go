@@ -0,0 +1,300 @@
+// Copyright 2019-2021 VMware, Inc.
+// SPDX-License-Identifier: BSD-2-Clause
+
+package service
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/vmware/transport-go/bus"
+	"github.com/vmware/transport-go/model"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func handleRestRequest(t *testing.T, rs *restService, core FabricServiceCore, restReq *RestServiceRequest) *model.Response {
+	mh, _ := core.Bus().ListenOnce(restServiceChannel)
+	responseChan := make(chan *model.Response, 1)
+	mh.Handle(func(message *model.Message) {
+		responseChan <- message.Payload.(*model.Response)
+	}, func(e error) {})
+
+	rs.HandleServiceRequest(&model.Request{Payload: restReq}, core)
+	select {
+	case response := <-responseChan:
+		return response
+	case <-time.After(2 * time.Second):
+		assert.Fail(t, "no response received")
+		return &model.Response{}
+	}
+}
+
+func newStatusTransport(statuses ...int) (RoundTripFunc, *int) {
+	var lock sync.Mutex
+	calls := 0
+	return func(req *http.Request) (*http.Response, error) {
+		lock.Lock()
+		defer lock.Unlock()
+		status := statuses[len(statuses)-1]
+		if calls < len(statuses) {
+			status = statuses[calls]
+		}
+		calls++
+		body, _ := ioutil.ReadAll(req.Body)
+		return &http.Response{
+			StatusCode: status,
+			Status:     http.StatusText(status),
+			Body:       ioutil.NopCloser(bytes.NewBuffer(body)),
+			Header:     make(http.Header),
+		}, nil
+	}, &calls
+}
+
+func collectMonitorEvents(eventBus bus.EventBus, eventTypes ...bus.MonitorEventType) func() []*bus.MonitorEvent {
+	var lock sync.Mutex
+	events := make([]*bus.MonitorEvent, 0)
+	eventBus.AddMonitorEventListener(func(event *bus.MonitorEvent) {
+		lock.Lock()
+		events = append(events, event)
+		lock.Unlock()
+	}, eventTypes...)
+	return func() []*bus.MonitorEvent {
+		lock.Lock()
+		defer lock.Unlock()
+		return append([]*bus.MonitorEvent{}, events...)
+	}
+}
+
+func TestRestService_Retry(t *testing.T) {
+	core := newTestFabricCore(restServiceChannel)
+	getEvents := collectMonitorEvents(core.Bus(), bus.RestRequestRetryEvt)
+
+	rs := &restService{}
+	transport, calls := newStatusTransport(503, 502, 200)
+	rs.httpClient.Transport = transport
+
+	policy := &RestRequestPolicy{MaxRetries: 3, RetryBackoff: time.Millisecond}
+	response := handleRestRequest(t, rs, core, &RestServiceRequest{
+		Uri: "http://localhost:4444/test-url", Method: http.MethodGet, Body: `{"a": 1}`, Policy: policy})
+	assert.False(t, response.Error)
+	assert.Equal(t, map[string]interface{}{"a": float64(1)}, response.Payload)
+	assert.Equal(t, 3, *calls)
+
+	assert.Len(t, getEvents(), 2)
+	assert.Equal(t, &RestRequestMonitorData{
+		Host: "localhost:4444", Method: http.MethodGet, Uri: "http://localhost:4444/test-url",
+		Attempt: 1, StatusCode: 503,
+	}, getEvents()[0].Data)
+
+	// the retries are exhausted
+	transport, calls = newStatusTransport(503)
+	rs.httpClient.Transport = transport
+	response = handleRestRequest(t, rs, core, &RestServiceRequest{
+		Uri: "http://localhost:4444/test-url", Method: http.MethodGet, Policy: policy})
+	assert.True(t, response.Error)
+	assert.Equal(t, 503, response.ErrorCode)
+	assert.Equal(t, 4, *calls)
+
+	// statuses not configured for retry are not retried
+	transport, calls = newStatusTransport(500)
+	rs.httpClient.Transport = transport
+	response = handleRestRequest(t, rs, core, &RestServiceRequest{
+		Uri: "http://localhost:4444/test-url", Method: http.MethodGet, Policy: policy})
+	assert.Equal(t, 500, response.ErrorCode)
+	assert.Equal(t, 1, *calls)
+}
+
+func TestRestService_RetryIdempotencyGuard(t *testing.T) {
+	core := newTestFabricCore(restServiceChannel)
+	rs := &restService{}
+	policy := &RestRequestPolicy{MaxRetries: 2, RetryBackoff: time.Millisecond}
+
+	transport, calls := newStatusTransport(503, 200)
+	rs.httpClient.Transport = transport
+	response := handleRestRequest(t, rs, core, &RestServiceRequest{
+		Uri: "http://localhost:4444/test-url", Method: http.MethodPost, Policy: policy})
+	assert.Equal(t, 503, response.ErrorCode)
+	assert.Equal(t, 1, *calls)
+
+	transport, calls = newStatusTransport(503, 200)
+	rs.httpClient.Transport = transport
+	response = handleRestRequest(t, rs, core, &RestServiceRequest{
+		Uri: "http://localhost:4444/test-url", Method: http.MethodPost, Body: "{}",
+		Headers: map[string]string{"Idempotency-Key": "key-1", "Content-Type": "application/json"}, Policy: policy})
+	assert.False(t, response.Error)
+	assert.Equal(t, 2, *calls)
+
+	transport, calls = newStatusTransport(503, 200)
+	rs.httpClient.Transport = transport
+	response = handleRestRequest(t, rs, core, &RestServiceRequest{
+		Uri: "http://localhost:4444/test-url", Method: http.MethodPost, Body: "{}",
+		Policy: &RestRequestPolicy{MaxRetries: 2, RetryBackoff: time.Millisecond, RetryNonIdempotent: true}})
+	assert.False(t, response.Error)
+	assert.Equal(t, 2, *calls)
+}
+
+func TestRestService_Timeout(t *testing.T) {
+	core := newTestFabricCore(restServiceChannel)
+	rs := &restService{}
+	rs.httpClient.Transport = RoundTripFunc(func(req *http.Request) (*http.Response, error) {
+		<-req.Context().Done()
+		return nil, req.Context().Err()
+	})
+
+	rs.setPolicy(&RestRequestPolicy{Timeout: 10 * time.Millisecond})
+	response := handleRestRequest(t, rs, core, &RestServiceRequest{Uri: "http://localhost:4444/test-url"})
+	assert.True(t, response.Error)
+	assert.Equal(t, http.StatusGatewayTimeout, response.ErrorCode)
+	assert.Equal(t, "rest-service error, request to localhost:4444 timed out after 10ms", response.ErrorMessage)
+
+	// the request policy overrides the global one
+	start := time.Now()
+	response = handleRestRequest(t, rs, core, &RestServiceRequest{
+		Uri: "http://localhost:4444/test-url", Policy: &RestRequestPolicy{Timeout: 50 * time.Millisecond}})
+	assert.Equal(t, http.StatusGatewayTimeout, response.ErrorCode)
+	assert.True(t, time.Since(start) >= 50*time.Millisecond)
+}
+
+func TestRestService_CircuitBreaker(t *testing.T) {
+	core := newTestFabricCore(restServiceChannel)
+	getEvents := collectMonitorEvents(core.Bus(), bus.RestCircuitBreakerOpenedEvt,
+		bus.RestCircuitBreakerHalfOpenedEvt, bus.RestCircuitBreakerClosedEvt)
+
+	rs := &restService{}
+	transport, calls := newStatusTransport(500, 500, 500, 200)
+	rs.httpClient.Transport = transport
+	rs.setPolicy(&RestRequestPolicy{
+		CircuitBreaker: &CircuitBreakerPolicy{FailureThreshold: 2, OpenTimeout: 50 * time.Millisecond},
+	})
+	restReq := &RestServiceRequest{Uri: "http://localhost:4444/test-url"}
+
+	assert.Equal(t, 500, handleRestRequest(t, rs, core, restReq).ErrorCode)
+	assert.Equal(t, 500, handleRestRequest(t, rs, core, restReq).ErrorCode)
+
+	// the circuit is open, the upstream is not called
+	response := handleRestRequest(t, rs, core, restReq)
+	assert.Equal(t, http.StatusServiceUnavailable, response.ErrorCode)
+	assert.Equal(t, "rest-service error, circuit breaker is open for host localhost:4444", response.ErrorMessage)
+	assert.Equal(t, 2, *calls)
+
+	// other hosts are not affected
+	assert.Equal(t, 500, handleRestRequest(t, rs, core, &RestServiceRequest{Uri: "http://otherhost/test-url"}).ErrorCode)
+
+	// the trial request fails and the circuit opens again
+	time.Sleep(60 * time.Millisecond)
+	transport, calls = newStatusTransport(500, 200)
+	rs.httpClient.Transport = transport
+	assert.Equal(t, 500, handleRestRequest(t, rs, core, restReq).ErrorCode)
+	assert.Equal(t, http.StatusServiceUnavailable, handleRestRequest(t, rs, core, restReq).ErrorCode)
+
+	// the trial request succeeds and the circuit closes
+	time.Sleep(60 * time.Millisecond)
+	assert.False(t, handleRestRequest(t, rs, core, restReq).Error)
+	assert.False(t, handleRestRequest(t, rs, core, restReq).Error)
+	assert.Equal(t, 3, *calls)
+
+	eventTypes := make([]bus.MonitorEventType, 0)
+	for _, event := range getEvents() {
+		eventTypes = append(eventTypes, event.EventType)
+	}
+	assert.Equal(t, []bus.MonitorEventType{
+		bus.RestCircuitBreakerOpenedEvt,
+		bus.RestCircuitBreakerHalfOpenedEvt,
+		bus.RestCircuitBreakerOpenedEvt,
+		bus.RestCircuitBreakerHalfOpenedEvt,
+		bus.RestCircuitBreakerClosedEvt,
+	}, eventTypes)
+	assert.Equal(t, &RestRequestMonitorData{Host: "localhost:4444"}, getEvents()[0].Data)
+}
+
+func TestRestRequestPolicy_getRetryBackoff(t *testing.T) {
+	policy := &RestRequestPolicy{RetryBackoff: 10 * time.Millisecond, MaxRetryBackoff: 50 * time.Millisecond}
+	assert.Equal(t, 10*time.Millisecond, policy.getRetryBackoff(1))
+	assert.Equal(t, 20*time.Millisecond, policy.getRetryBackoff(2))
+	assert.Equal(t, 40*time.Millisecond, policy.getRetryBackoff(3))
+	assert.Equal(t, 50*time.Millisecond, policy.getRetryBackoff(4))
+	assert.Equal(t, 50*time.Millisecond, policy.getRetryBackoff(100))
+	assert.Equal(t, defaultRestRetryBackoff, (&RestRequestPolicy{}).getRetryBackoff(1))
+}
+
+func TestRestService_HostPolicy(t *testing.T) {
+	core := newTestFabricCore(restServiceChannel)
+	rs := &restService{}
+	transport, calls := newStatusTransport(503, 200)
+	rs.httpClient.Transport = transport
+	rs.setPolicy(&RestRequestPolicy{MaxRetries: 0})
+	rs.setHostPolicy("localhost:4444", &RestRequestPolicy{MaxRetries: 1, RetryBackoff: time.Millisecond})
+
+	assert.False(t, handleRestRequest(t, rs, core, &RestServiceRequest{Uri: "http://localhost:4444/test-url"}).Error)
+	assert.Equal(t, 2, *calls)
+
+	// the global policy applies to the other hosts
+	transport, calls = newStatusTransport(503, 200)
+	rs.httpClient.Transport = transport
+	assert.Equal(t, 503, handleRestRequest(t, rs, core, &RestServiceRequest{Uri: "http://otherhost/test-url"}).ErrorCode)
+	assert.Equal(t, 1, *calls)
+
+	rs.setHostPolicy("localhost:4444", nil)
+	assert.Equal(t, rs.policy, rs.getPolicy("localhost:4444"))
+}
+
+func TestRestService_RequestPolicyFromClient(t *testing.T) {
+	rs := &restService{}
+	restReq, ok := rs.getRestServiceRequest(&model.Request{Payload: map[string]interface{}{
+		"uri":    "http://localhost:4444/test-url",
+		"method": "GET",
+		"policy": map[string]interface{}{"maxRetries": 1000, "timeout": 0},
+	}})
+	assert.True(t, ok)
+	assert.Nil(t, restReq.Policy)
+}
+
+func TestRestService_RequestPolicyDoesNotConfigureCircuitBreaker(t *testing.T) {
+	core := newTestFabricCore(restServiceChannel)
+	rs := &restService{}
+	transport, calls := newStatusTransport(500)
+	rs.httpClient.Transport = transport
+
+	restReq := &RestServiceRequest{Uri: "http://localhost:4444/test-url", Policy: &RestRequestPolicy{
+		CircuitBreaker: &CircuitBreakerPolicy{FailureThreshold: 1, OpenTimeout: time.Minute},
+	}}
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, 500, handleRestRequest(t, rs, core, restReq).ErrorCode)
+	}
+	assert.Equal(t, 3, *calls)
+}
+
+func TestRestRequestPolicy_limit(t *testing.T) {
+	hostPolicy := &RestRequestPolicy{CircuitBreaker: &CircuitBreakerPolicy{FailureThreshold: 3}}
+	policy := (&RestRequestPolicy{
+		Timeout:         time.Hour,
+		MaxRetries:      1000,
+		RetryBackoff:    time.Hour,
+		CircuitBreaker:  &CircuitBreakerPolicy{FailureThreshold: 1},
+		RetryOnStatus:   []int{500},
+		MaxRetryBackoff: 0,
+	}).limit(hostPolicy)
+	assert.Equal(t, &RestRequestPolicy{
+		Timeout:         maxRestRequestTimeout,
+		MaxRetries:      maxRestRetries,
+		RetryBackoff:    maxRestRetryBackoff,
+		MaxRetryBackoff: maxRestRetryBackoff,
+		RetryOnStatus:   []int{500},
+		CircuitBreaker:  hostPolicy.CircuitBreaker,
+	}, policy)
+	assert.Nil(t, (&RestRequestPolicy{CircuitBreaker: &CircuitBreakerPolicy{}}).limit(nil).CircuitBreaker)
+}
+
+func TestCircuitBreaker_DefaultPolicy(t *testing.T) {
+	cb := &circuitBreaker{}
+	policy := &CircuitBreakerPolicy{}
+	for i := 1; i < defaultCircuitBreakerFailureThreshold; i++ {
+		assert.False(t, cb.onFailure(policy))
+	}
+	assert.True(t, cb.onFailure(policy))
+	allowed, _ := cb.allow(policy)
+	assert.False(t, allowed)
+}
@@ -0,0 +1,369 @@
+// Copyright 2019-2021 VMware, Inc.
+// SPDX-License-Identifier: BSD-2-Clause
+
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/vmware/transport-go/model"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/textproto"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RestStreamMode defines how the fabric-rest service streams the response body of the upstream.
+type RestStreamMode string
+
+const (
+	// RestStreamChunks streams the response body as []byte chunks of RestServiceRequest.ChunkSize bytes.
+	RestStreamChunks RestStreamMode = "chunks"
+	// RestStreamNDJSON streams every line of a newline delimited JSON body as a separate value.
+	RestStreamNDJSON RestStreamMode = "ndjson"
+	// RestStreamSSE streams the server-sent events of a text/event-stream body.
+	RestStreamSSE RestStreamMode = "sse"
+
+	defaultRestStreamChunkSize = 32 * 1024
+	maxRestStreamLineSize      = 10 * 1024 * 1024
+)
+
+// RestStreamEvent is the payload of the responses sent by the fabric-rest service for streamed response
+// bodies. every chunk, NDJSON line or SSE event is sent as a separate response, the last response of the
+// stream has Done set to true and carries no data. the responses are sent in order, but are delivered
+// in order only to the handlers subscribed with bus.OrderedMessageHandler.HandleOrdered. services
+// streaming large bodies should set RestServiceRequest.StreamHandler instead.
+type RestStreamEvent struct {
+	// Position of the event in the stream, starting from 1.
+	Sequence int `json:"sequence"`
+	// []byte chunk, NDJSON value or SSE event data. NDJSON values and SSE event data are deserialized
+	// to RestServiceRequest.ResponseType if set, NDJSON values default to map[string]interface{} and
+	// SSE event data to string. SSE event data is never sent as []byte.
+	Data interface{} `json:"data,omitempty"`
+	// Type and id of the SSE event.
+	Event   string `json:"event,omitempty"`
+	EventId string `json:"eventId,omitempty"`
+	// Marks the end of the stream.
+	Done bool `json:"done,omitempty"`
+}
+
+// MultipartPart is a single part of a multipart/form-data request body.
+type MultipartPart struct {
+	// Name of the form field.
+	Name string `json:"name"`
+	// Optional file name, if set the part is sent as a file.
+	FileName string `json:"fileName,omitempty"`
+	// Optional content type of the part, files default to application/octet-stream.
+	ContentType string `json:"contentType,omitempty"`
+	// Content of the part. String and []byte contents are sent as is, all other contents
+	// are serialized as json.
+	Content interface{} `json:"content,omitempty"`
+	// Optional reader streaming the content of the part, used instead of Content to upload large
+	// contents without loading them into memory. Requests with readers are never retried.
+	Reader io.Reader `json:"-"`
+}
+
+// restRequestBody creates the body of every attempt to send a request.
+type restRequestBody struct {
+	newReader   func() io.Reader
+	contentType string // content type overriding the one of the request, if set
+	replayable  bool   // whether the body can be sent more than once
+}
+
+func (request *RestServiceRequest) newRequestBody() (*restRequestBody, error) {
+	if len(request.Multipart) == 0 {
+		body, err := request.marshalBody()
+		if err != nil {
+			return nil, err
+		}
+		return &restRequestBody{
+			newReader:  func() io.Reader { return bytes.NewBuffer(body) },
+			replayable: true,
+		}, nil
+	}
+
+	replayable := true
+	contents := make([][]byte, len(request.Multipart))
+	for i, part := range request.Multipart {
+		if part.Reader != nil {
+			replayable = false
+			continue
+		}
+		content, err := marshalContent(part.Content)
+		if err != nil {
+			return nil, err
+		}
+		contents[i] = content
+	}
+
+	boundary := multipart.NewWriter(ioutil.Discard).Boundary()
+	return &restRequestBody{
+		newReader: func() io.Reader {
+			pr, pw := io.Pipe()
+			go func() {
+				pw.CloseWithError(request.writeMultipart(pw, boundary, contents))
+			}()
+			return pr
+		},
+		contentType: "multipart/form-data; boundary=" + boundary,
+		replayable:  replayable,
+	}, nil
+}
+
+func (request *RestServiceRequest) writeMultipart(w io.Writer, boundary string, contents [][]byte) error {
+	mw := multipart.NewWriter(w)
+	if err := mw.SetBoundary(boundary); err != nil {
+		return err
+	}
+	for i, part := range request.Multipart {
+		header := make(textproto.MIMEHeader)
+		disposition := fmt.Sprintf(`form-data; name="%s"`, escapeQuotes(part.Name))
+		contentType := part.ContentType
+		if part.FileName != "" {
+			disposition += fmt.Sprintf(`; filename="%s"`, escapeQuotes(part.FileName))
+			if contentType == "" {
+				contentType = "application/octet-stream"
+			}
+		}
+		header.Set("Content-Disposition", disposition)
+		if contentType != "" {
+			header.Set("Content-Type", contentType)
+		}
+
+		pw, err := mw.CreatePart(header)
+		if err != nil {
+			return err
+		}
+		if part.Reader != nil {
+			_, err = io.Copy(pw, part.Reader)
+		} else {
+			_, err = pw.Write(contents[i])
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return mw.Close()
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+func escapeQuotes(s string) string {
+	return quoteEscaper.Replace(s)
+}
+
+func marshalContent(content interface{}) ([]byte, error) {
+	switch c := content.(type) {
+	case nil:
+		return []byte{}, nil
+	case string:
+		return []byte(c), nil
+	case []byte:
+		return c, nil
+	}
+	return json.Marshal(content)
+}
+
+// streamResponse sends the response body as a series of responses according to the stream mode of the request.
+// the stream fails if the upstream sends no data for the idle timeout of the policy, or if it doesn't end
+// within the maximum stream duration of the policy.
+func (rs *restService) streamResponse(request *model.Request, restReq *RestServiceRequest,
+	body io.ReadCloser, policy *RestRequestPolicy, core FabricServiceCore) {
+
+	guardedBody := newGuardedStreamBody(body, policy.getStreamIdleTimeout(), policy.getMaxStreamDuration())
+	defer guardedBody.stop()
+
+	sequence := 0
+	emit := func(event *RestStreamEvent) error {
+		sequence++
+		event.Sequence = sequence
+		if restReq.StreamHandler != nil {
+			return restReq.StreamHandler(event)
+		}
+		core.SendResponse(request, event)
+		return nil
+	}
+
+	var err error
+	switch restReq.StreamMode {
+	case RestStreamChunks:
+		err = rs.streamChunks(guardedBody, restReq.ChunkSize, emit)
+	case RestStreamNDJSON:
+		err = rs.streamNDJSON(guardedBody, restReq.ResponseType, emit)
+	case RestStreamSSE:
+		err = rs.streamSSE(guardedBody, restReq.ResponseType, emit)
+	default:
+		err = fmt.Errorf("unsupported stream mode \"%s\"", restReq.StreamMode)
+	}
+
+	if err != nil {
+		core.SendErrorResponse(request, 500, "rest-service error, failed to stream response: "+err.Error())
+		return
+	}
+	core.SendResponse(request, &RestStreamEvent{Sequence: sequence + 1, Done: true})
+}
+
+// guardedStreamBody closes a streamed response body once the upstream sends no data for the idle
+// timeout, or once the maximum duration of the stream is exceeded.
+type guardedStreamBody struct {
+	body        io.ReadCloser
+	idleTimeout time.Duration
+	idleTimer   *time.Timer
+	maxTimer    *time.Timer
+	lock        sync.Mutex
+	err         error
+}
+
+func newGuardedStreamBody(body io.ReadCloser, idleTimeout, maxDuration time.Duration) *guardedStreamBody {
+	b := &guardedStreamBody{body: body, idleTimeout: idleTimeout}
+	b.idleTimer = time.AfterFunc(idleTimeout, func() {
+		b.expire(fmt.Errorf("no data received for %s", idleTimeout.String()))
+	})
+	b.idleTimer.Stop()
+	b.maxTimer = time.AfterFunc(maxDuration, func() {
+		b.expire(fmt.Errorf("stream exceeded the maximum duration of %s", maxDuration.String()))
+	})
+	return b
+}
+
+// Read reads the next part of the body. only the time spent waiting for the upstream counts towards
+// the idle timeout, not the time spent handling the events.
+func (b *guardedStreamBody) Read(p []byte) (int, error) {
+	b.idleTimer.Reset(b.idleTimeout)
+	n, err := b.body.Read(p)
+	b.idleTimer.Stop()
+	if err != nil {
+		b.lock.Lock()
+		defer b.lock.Unlock()
+		if b.err != nil {
+			return n, b.err
+		}
+	}
+	return n, err
+}
+
+func (b *guardedStreamBody) expire(err error) {
+	b.lock.Lock()
+	if b.err == nil {
+		b.err = err
+	}
+	b.lock.Unlock()
+	b.body.Close()
+}
+
+func (b *guardedStreamBody) stop() {
+	b.idleTimer.Stop()
+	b.maxTimer.Stop()
+}
+
+func (rs *restService) streamChunks(body io.Reader, chunkSize int, emit func(event *RestStreamEvent) error) error {
+	if chunkSize <= 0 {
+		chunkSize = defaultRestStreamChunkSize
+	}
+	buf := make([]byte, chunkSize)
+	for {
+		n, err := io.ReadFull(body, buf)
+		if n > 0 {
+			chunk := make([]byte, n)
+			copy(chunk, buf[:n])
+			if emitErr := emit(&RestStreamEvent{Data: chunk}); emitErr != nil {
+				return emitErr
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (rs *restService) streamNDJSON(body io.Reader, responseType reflect.Type, emit func(event *RestStreamEvent) error) error {
+	scanner := newLineScanner(body)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		value, err := rs.deserializeResponse(ioutil.NopCloser(bytes.NewReader(line)), responseType)
+		if err != nil {
+			return err
+		}
+		if err = emit(&RestStreamEvent{Data: value}); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func (rs *restService) streamSSE(body io.Reader, responseType reflect.Type, emit func(event *RestStreamEvent) error) error {
+	scanner := newLineScanner(body)
+	event := &RestStreamEvent{}
+	var data []string
+
+	dispatch := func() error {
+		if len(data) == 0 {
+			event = &RestStreamEvent{}
+			return nil
+		}
+		event.Data = strings.Join(data, "\n")
+		// the data of the events is text, so it's sent as string unless it's deserialized to a value
+		if responseType != nil && responseType.Kind() != reflect.String && responseType != reflect.TypeOf([]byte{}) {
+			value, err := rs.deserializeResponse(
+				ioutil.NopCloser(strings.NewReader(event.Data.(string))), responseType)
+			if err != nil {
+				return err
+			}
+			event.Data = value
+		}
+		err := emit(event)
+		event = &RestStreamEvent{}
+		data = nil
+		return err
+	}
+
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if line == "" {
+			if err := dispatch(); err != nil {
+				return err
+			}
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			// comment
+			continue
+		}
+
+		field, value := line, ""
+		if i := strings.Index(line, ":"); i >= 0 {
+			field, value = line[:i], strings.TrimPrefix(line[i+1:], " ")
+		}
+		switch field {
+		case "event":
+			event.Event = value
+		case "data":
+			data = append(data, value)
+		case "id":
+			event.EventId = value
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	// dispatch the last event if the stream didn't end with a blank line
+	return dispatch()
+}
+
+func newLineScanner(body io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), maxRestStreamLineSize)
+	return scanner
+}
@@ -0,0 +1,325 @@
+// Copyright 2019-2021 VMware, Inc.
+// SPDX-License-Identifier: BSD-2-Clause
+
+package service
+
+import (
+	"bytes"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/vmware/transport-go/model"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// streamRestRequest returns the stream events sent for the request sorted by sequence, or the error response.
+func streamRestRequest(
+	t *testing.T, rs *restService, restReq *RestServiceRequest) ([]*RestStreamEvent, *model.Response) {
+
+	core := newTestFabricCore(restServiceChannel)
+	var lock sync.Mutex
+	events := make([]*RestStreamEvent, 0)
+	errChan := make(chan *model.Response, 1)
+	mh, _ := core.Bus().ListenStream(restServiceChannel)
+	defer mh.Close()
+	mh.Handle(func(message *model.Message) {
+		response := message.Payload.(*model.Response)
+		if response.Error {
+			errChan <- response
+			return
+		}
+		lock.Lock()
+		events = append(events, response.Payload.(*RestStreamEvent))
+		lock.Unlock()
+	}, func(e error) {})
+
+	rs.HandleServiceRequest(&model.Request{Payload: restReq}, core)
+
+	done := func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		for _, e := range events {
+			if e.Done {
+				return len(events) == e.Sequence
+			}
+		}
+		return false
+	}
+	for start := time.Now(); !done(); time.Sleep(time.Millisecond) {
+		select {
+		case errResponse := <-errChan:
+			return nil, errResponse
+		default:
+		}
+		if time.Since(start) > 2*time.Second {
+			assert.Fail(t, "stream did not complete")
+			return nil, nil
+		}
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].Sequence < events[j].Sequence
+	})
+	return events, nil
+}
+
+func newBodyTransport(body string) RoundTripFunc {
+	return func(req *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: 200,
+			Body:       ioutil.NopCloser(strings.NewReader(body)),
+			Header:     make(http.Header),
+		}, nil
+	}
+}
+
+func TestRestService_StreamChunks(t *testing.T) {
+	rs := &restService{}
+	rs.httpClient.Transport = newBodyTransport("0123456789")
+
+	events, _ := streamRestRequest(t, rs, &RestServiceRequest{
+		Uri: "http://localhost:4444/logs", StreamMode: RestStreamChunks, ChunkSize: 4})
+	assert.Len(t, events, 4)
+	assert.Equal(t, []byte("0123"), events[0].Data)
+	assert.Equal(t, []byte("4567"), events[1].Data)
+	assert.Equal(t, []byte("89"), events[2].Data)
+	assert.Equal(t, &RestStreamEvent{Sequence: 4, Done: true}, events[3])
+}
+
+func TestRestService_StreamNDJSON(t *testing.T) {
+	rs := &restService{}
+	rs.httpClient.Transport = newBodyTransport("{\"name\": \"a\", \"count\": 1}\n\n{\"name\": \"b\", \"count\": 2}\n")
+
+	events, _ := streamRestRequest(t, rs, &RestServiceRequest{
+		Uri: "http://localhost:4444/items", StreamMode: RestStreamNDJSON, ResponseType: reflect.TypeOf(testItem{})})
+	assert.Len(t, events, 3)
+	assert.Equal(t, testItem{Name: "a", Count: 1}, events[0].Data)
+	assert.Equal(t, testItem{Name: "b", Count: 2}, events[1].Data)
+	assert.True(t, events[2].Done)
+
+	rs.httpClient.Transport = newBodyTransport("{\"name\": \"a\"}\nnot-json\n")
+	_, errResponse := streamRestRequest(t, rs, &RestServiceRequest{
+		Uri: "http://localhost:4444/items", StreamMode: RestStreamNDJSON})
+	assert.Equal(t, 500, errResponse.ErrorCode)
+	assert.True(t, strings.HasPrefix(errResponse.ErrorMessage, "rest-service error, failed to stream response:"))
+}
+
+func TestRestService_StreamSSE(t *testing.T) {
+	rs := &restService{}
+	rs.httpClient.Transport = newBodyTransport(
+		": comment\n" +
+			"event: update\nid: 1\ndata: first line\ndata: second line\n\n" +
+			"data: {\"name\": \"a\", \"count\": 1}\r\n\r\n" +
+			"\n" +
+			"event: last\ndata: no trailing blank line")
+
+	events, _ := streamRestRequest(t, rs, &RestServiceRequest{
+		Uri: "http://localhost:4444/events", StreamMode: RestStreamSSE})
+	assert.Len(t, events, 4)
+	assert.Equal(t, &RestStreamEvent{
+		Sequence: 1, Event: "update", EventId: "1", Data: "first line\nsecond line"}, events[0])
+	assert.Equal(t, `{"name": "a", "count": 1}`, events[1].Data)
+	assert.Equal(t, &RestStreamEvent{Sequence: 3, Event: "last", Data: "no trailing blank line"}, events[2])
+	assert.True(t, events[3].Done)
+}
+
+func TestRestService_StreamTimeoutAppliesToHeaders(t *testing.T) {
+	rs := &restService{}
+	pr, pw := io.Pipe()
+	rs.httpClient.Transport = RoundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: 200, Body: pr, Header: make(http.Header)}, nil
+	})
+	go func() {
+		pw.Write([]byte("{}\n"))
+		time.Sleep(50 * time.Millisecond)
+		pw.Write([]byte("{}\n"))
+		pw.Close()
+	}()
+
+	events, errResponse := streamRestRequest(t, rs, &RestServiceRequest{
+		Uri: "http://localhost:4444/items", StreamMode: RestStreamNDJSON,
+		Policy: &RestRequestPolicy{Timeout: 10 * time.Millisecond}})
+	assert.Nil(t, errResponse)
+	assert.Len(t, events, 3)
+}
+
+func TestRestService_MultipartUpload(t *testing.T) {
+	core := newTestFabricCore(restServiceChannel)
+	rs := &restService{}
+
+	var lock sync.Mutex
+	parts := make(map[string]string)
+	partHeaders := make(map[string]string)
+	calls := 0
+	rs.httpClient.Transport = RoundTripFunc(func(req *http.Request) (*http.Response, error) {
+		lock.Lock()
+		defer lock.Unlock()
+		calls++
+		_, params, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+		assert.Nil(t, err)
+		reader := multipart.NewReader(req.Body, params["boundary"])
+		for {
+			part, err := reader.NextPart()
+			if err != nil {
+				break
+			}
+			content, _ := ioutil.ReadAll(part)
+			parts[part.FormName()] = string(content)
+			partHeaders[part.FormName()] = part.FileName() + "|" + part.Header.Get("Content-Type")
+		}
+		return &http.Response{
+			StatusCode: 503,
+			Body:       ioutil.NopCloser(bytes.NewBufferString("{}")),
+			Header:     make(http.Header),
+		}, nil
+	})
+
+	response := handleRestRequest(t, rs, core, &RestServiceRequest{
+		Uri:    "http://localhost:4444/upload",
+		Method: http.MethodPut,
+		Multipart: []*MultipartPart{
+			{Name: "description", Content: "log files"},
+			{Name: "meta", ContentType: "application/json", Content: map[string]int{"count": 1}},
+			{Name: "file", FileName: "app.log", Reader: strings.NewReader("line 1\nline 2\n")},
+		},
+		Policy: &RestRequestPolicy{MaxRetries: 2, RetryBackoff: time.Millisecond},
+	})
+	assert.Equal(t, 503, response.ErrorCode)
+
+	lock.Lock()
+	defer lock.Unlock()
+	// requests with streamed parts are not retried
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, "log files", parts["description"])
+	assert.Equal(t, `{"count":1}`, parts["meta"])
+	assert.Equal(t, "|application/json", partHeaders["meta"])
+	assert.Equal(t, "line 1\nline 2\n", parts["file"])
+	assert.Equal(t, "app.log|application/octet-stream", partHeaders["file"])
+}
+
+func TestRestService_MultipartUploadRetry(t *testing.T) {
+	core := newTestFabricCore(restServiceChannel)
+	rs := &restService{}
+	var lock sync.Mutex
+	bodies := make([]string, 0)
+	rs.httpClient.Transport = RoundTripFunc(func(req *http.Request) (*http.Response, error) {
+		lock.Lock()
+		defer lock.Unlock()
+		body, _ := ioutil.ReadAll(req.Body)
+		bodies = append(bodies, string(body))
+		status := 503
+		if len(bodies) > 1 {
+			status = 200
+		}
+		return &http.Response{
+			StatusCode: status,
+			Body:       ioutil.NopCloser(bytes.NewBufferString("{}")),
+			Header:     make(http.Header),
+		}, nil
+	})
+
+	response := handleRestRequest(t, rs, core, &RestServiceRequest{
+		Uri:       "http://localhost:4444/upload",
+		Method:    http.MethodPut,
+		Multipart: []*MultipartPart{{Name: "file", FileName: "a.txt", Content: []byte("content")}},
+		Policy:    &RestRequestPolicy{MaxRetries: 2, RetryBackoff: time.Millisecond},
+	})
+	assert.False(t, response.Error)
+
+	lock.Lock()
+	defer lock.Unlock()
+	assert.Len(t, bodies, 2)
+	assert.Equal(t, bodies[0], bodies[1])
+	assert.True(t, strings.Contains(bodies[1], "content"))
+}
+
+func TestRestService_StreamHandler(t *testing.T) {
+	rs := &restService{}
+	rs.httpClient.Transport = newBodyTransport("0123456789")
+
+	// the events are passed to the handler in order and only the completion marker is sent
+	events := make([]*RestStreamEvent, 0)
+	handler := func(event *RestStreamEvent) error {
+		events = append(events, event)
+		return nil
+	}
+	core := newTestFabricCore(restServiceChannel)
+	response := handleRestRequest(t, rs, core, &RestServiceRequest{
+		Uri: "http://localhost:4444/logs", StreamMode: RestStreamChunks, ChunkSize: 2, StreamHandler: handler})
+	assert.Equal(t, &RestStreamEvent{Sequence: 6, Done: true}, response.Payload)
+	assert.Len(t, events, 5)
+	for i, event := range events {
+		assert.Equal(t, i+1, event.Sequence)
+	}
+	assert.Equal(t, []byte("89"), events[4].Data)
+
+	// the stream stops once the handler fails
+	calls := 0
+	errResponse := handleRestRequest(t, rs, core, &RestServiceRequest{
+		Uri: "http://localhost:4444/logs", StreamMode: RestStreamChunks, ChunkSize: 2,
+		StreamHandler: func(event *RestStreamEvent) error {
+			calls++
+			if calls == 2 {
+				return errors.New("consumer failed")
+			}
+			return nil
+		}})
+	assert.Equal(t, "rest-service error, failed to stream response: consumer failed", errResponse.ErrorMessage)
+	assert.Equal(t, 2, calls)
+}
+
+func TestRestService_StreamSSEWithoutJSONContentType(t *testing.T) {
+	rs := &restService{}
+	rs.httpClient.Transport = newBodyTransport("data: plain text\n\n")
+
+	events, _ := streamRestRequest(t, rs, &RestServiceRequest{
+		Uri: "http://localhost:4444/events", StreamMode: RestStreamSSE,
+		Headers: map[string]string{"Content-Type": "text/plain"}})
+	assert.Len(t, events, 2)
+	assert.Equal(t, "plain text", events[0].Data)
+}
+
+func TestRestService_StreamLimits(t *testing.T) {
+	newPipeTransport := func(data string, interval time.Duration) RoundTripFunc {
+		return func(req *http.Request) (*http.Response, error) {
+			pr, pw := io.Pipe()
+			go func() {
+				for {
+					if _, err := pw.Write([]byte(data)); err != nil {
+						return
+					}
+					time.Sleep(interval)
+				}
+			}()
+			return &http.Response{StatusCode: 200, Body: pr, Header: make(http.Header)}, nil
+		}
+	}
+
+	// an upstream which stops sending data
+	rs := &restService{}
+	rs.httpClient.Transport = newPipeTransport("{}\n", time.Hour)
+	_, errResponse := streamRestRequest(t, rs, &RestServiceRequest{
+		Uri: "http://localhost:4444/items", StreamMode: RestStreamNDJSON,
+		Policy: &RestRequestPolicy{StreamIdleTimeout: 20 * time.Millisecond}})
+	assert.Equal(t, "rest-service error, failed to stream response: no data received for 20ms",
+		errResponse.ErrorMessage)
+
+	// an upstream which never ends
+	rs.httpClient.Transport = newPipeTransport("{}\n", time.Millisecond)
+	_, errResponse = streamRestRequest(t, rs, &RestServiceRequest{
+		Uri: "http://localhost:4444/items", StreamMode: RestStreamNDJSON,
+		Policy: &RestRequestPolicy{MaxStreamDuration: 50 * time.Millisecond}})
+	assert.Equal(t, "rest-service error, failed to stream response: stream exceeded the maximum duration of 50ms",
+		errResponse.ErrorMessage)
+}
@@ -0,0 +1,310 @@
+// Copyright 2019-2021 VMware, Inc.
+// SPDX-License-Identifier: BSD-2-Clause
+
+package service
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/vmware/transport-go/bus"
+	"github.com/vmware/transport-go/model"
+	"sync"
+	"time"
+)
+
+const (
+	// ServiceCacheInvalidationChannel is the channel on which ServiceCacheInvalidation requests
+	// are sent to drop the cached responses of fabric services. Only the invalidations sent by
+	// the server itself are accepted, requests of fabric (STOMP) clients, REST bridges and brokers
+	// are ignored.
+	ServiceCacheInvalidationChannel = "transport-service-cache-invalidation"
+
+	defaultServiceCacheTTL        = time.Minute
+	defaultServiceCacheMaxEntries = 1000
+)
+
+// ServiceCacheOptions enables caching of the responses of a fabric service registered with
+// ServiceRegistry.RegisterServiceWithOptions. Identical requests, with the same Request type,
+// payload and principal, are answered from the cache without reaching the service. Only the first successful
+// response of a request is cached, so services sending multiple responses per request should
+// not be cached. The cached payloads are shared by all the responses and must not be modified.
+type ServiceCacheOptions struct {
+	// How long the responses are cached, defaults to one minute.
+	TTL time.Duration
+
+	// Maximum number of cached responses, the least recently used responses are evicted
+	// once it's reached. Defaults to 1000.
+	MaxEntries int
+
+	// Optional request types to cache, e.g. "get-joke". All requests are cached if empty.
+	Requests []string
+
+	// Share the cached responses between all callers. By default the responses to authenticated
+	// requests are cached per principal, set only if the responses don't depend on the caller.
+	SharedAcrossPrincipals bool
+}
+
+// ServiceCacheInvalidation is the payload of the requests sent on the ServiceCacheInvalidationChannel.
+type ServiceCacheInvalidation struct {
+	// Channel of the service whose responses are dropped, all services if empty.
+	ServiceChannel string `json:"serviceChannel"`
+	// Request type whose responses are dropped, all request types if empty.
+	Request string `json:"request"`
+}
+
+type serviceCacheEntry struct {
+	key       string
+	request   string
+	response  *model.Response
+	etag      string
+	expiresAt time.Time
+}
+
+// responseCache is the LRU cache of the responses of a single service.
+type responseCache struct {
+	lock        sync.Mutex
+	options     ServiceCacheOptions
+	channelName string
+	bus         bus.EventBus
+	requests    map[string]bool
+	entries     map[string]*list.Element
+	lru         *list.List
+}
+
+// newResponseCache creates the cache for the options, returns nil if the options don't enable one.
+func newResponseCache(eventBus bus.EventBus, channelName string, options *ServiceCacheOptions) *responseCache {
+	if options == nil {
+		return nil
+	}
+	cache := &responseCache{
+		options:     *options,
+		channelName: channelName,
+		bus:         eventBus,
+		entries:     make(map[string]*list.Element),
+		lru:         list.New(),
+	}
+	if cache.options.TTL <= 0 {
+		cache.options.TTL = defaultServiceCacheTTL
+	}
+	if cache.options.MaxEntries <= 0 {
+		cache.options.MaxEntries = defaultServiceCacheMaxEntries
+	}
+	if len(options.Requests) > 0 {
+		cache.requests = make(map[string]bool)
+		for _, request := range options.Requests {
+			cache.requests[request] = true
+		}
+	}
+	return cache
+}
+
+// getKey returns the cache key of the request, or false if the request can't be cached.
+func (c *responseCache) getKey(request *model.Request) (string, bool) {
+	if c.requests != nil && !c.requests[request.Request] {
+		return "", false
+	}
+	var payload []byte
+	switch p := request.Payload.(type) {
+	case []byte:
+		payload = p
+	case string:
+		payload = []byte(p)
+	default:
+		var err error
+		if payload, err = json.Marshal(request.Payload); err != nil {
+			// e.g. *http.Request payloads relayed by REST bridges
+			return "", false
+		}
+	}
+	hash := sha256.Sum256(payload)
+	key := c.channelName + "\x00" + request.Request + "\x00" + hex.EncodeToString(hash[:])
+	if principal := request.Metadata.GetPrincipal(); principal != nil && !c.options.SharedAcrossPrincipals {
+		principalHash := sha256.Sum256([]byte(principal.AuthMethod + "\x00" + principal.Name))
+		key += "\x00" + hex.EncodeToString(principalHash[:])
+	}
+	return key, true
+}
+
+// serve sends the cached response of the request, returns false if there's none.
+func (c *responseCache) serve(request *model.Request, core *fabricCore) bool {
+	key, ok := c.getKey(request)
+	if !ok {
+		return false
+	}
+
+	c.lock.Lock()
+	element, found := c.entries[key]
+	var entry *serviceCacheEntry
+	if found {
+		entry = element.Value.(*serviceCacheEntry)
+		if time.Now().After(entry.expiresAt) {
+			c.removeElement(element)
+			found = false
+		} else {
+			c.lru.MoveToFront(element)
+		}
+	}
+	c.lock.Unlock()
+
+	if !found {
+		c.bus.SendMonitorEvent(bus.ServiceCacheMissEvt, c.channelName, nil)
+		return false
+	}
+	c.bus.SendMonitorEvent(bus.ServiceCacheHitEvt, c.channelName, nil)
+
+	response := *entry.response
+	response.Id = request.Id
+	response.BrokerDestination = request.BrokerDestination
+	response.Headers = make(map[string]string, len(entry.response.Headers)+2)
+	for k, v := range entry.response.Headers {
+		response.Headers[k] = v
+	}
+	setCacheHeaders(&response, entry.etag, time.Until(entry.expiresAt))
+	core.sendResponse(request, &response)
+	return true
+}
+
+// store caches the response of the request unless the request already has a cached response.
+// the ETag and Cache-Control headers are added to the response.
+func (c *responseCache) store(request *model.Request, response *model.Response) {
+	if response.Error {
+		return
+	}
+	key, ok := c.getKey(request)
+	if !ok {
+		return
+	}
+	payload, err := marshalCachedPayload(response.Payload)
+	if err != nil {
+		return
+	}
+	hash := sha256.Sum256(payload)
+	etag := fmt.Sprintf(`"%s"`, hex.EncodeToString(hash[:16]))
+
+	c.lock.Lock()
+	if element, found := c.entries[key]; found {
+		if time.Now().Before(element.Value.(*serviceCacheEntry).expiresAt) {
+			c.lock.Unlock()
+			return
+		}
+		c.removeElement(element)
+	}
+
+	headers := make(map[string]string, len(response.Headers))
+	for k, v := range response.Headers {
+		headers[k] = v
+	}
+	cached := *response
+	cached.Headers = headers
+	entry := &serviceCacheEntry{
+		key:       key,
+		request:   request.Request,
+		response:  &cached,
+		etag:      etag,
+		expiresAt: time.Now().Add(c.options.TTL),
+	}
+	c.entries[key] = c.lru.PushFront(entry)
+
+	evicted := 0
+	for c.lru.Len() > c.options.MaxEntries {
+		c.removeElement(c.lru.Back())
+		evicted++
+	}
+	c.lock.Unlock()
+
+	for i := 0; i < evicted; i++ {
+		c.bus.SendMonitorEvent(bus.ServiceCacheEvictedEvt, c.channelName, nil)
+	}
+
+	if response.Headers == nil {
+		response.Headers = make(map[string]string)
+	}
+	setCacheHeaders(response, etag, c.options.TTL)
+}
+
+// invalidate drops the cached responses of the given request type, or all cached responses if empty.
+func (c *responseCache) invalidate(requestType string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for element := c.lru.Front(); element != nil; {
+		next := element.Next()
+		if requestType == "" || element.Value.(*serviceCacheEntry).request == requestType {
+			c.removeElement(element)
+		}
+		element = next
+	}
+}
+
+func (c *responseCache) removeElement(element *list.Element) {
+	c.lru.Remove(element)
+	delete(c.entries, element.Value.(*serviceCacheEntry).key)
+}
+
+func (c *responseCache) size() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.lru.Len()
+}
+
+func setCacheHeaders(response *model.Response, etag string, maxAge time.Duration) {
+	response.Headers["ETag"] = etag
+	response.Headers["Cache-Control"] = fmt.Sprintf("max-age=%d", int(maxAge.Seconds()))
+}
+
+func marshalCachedPayload(payload interface{}) ([]byte, error) {
+	switch p := payload.(type) {
+	case []byte:
+		return p, nil
+	case string:
+		return []byte(p), nil
+	}
+	return json.Marshal(payload)
+}
+
+// listenForCacheInvalidations drops the cached responses of the services as requested
+// on the ServiceCacheInvalidationChannel by the server.
+func (r *serviceRegistry) listenForCacheInvalidations() {
+	r.bus.GetChannelManager().CreateChannel(ServiceCacheInvalidationChannel)
+	mh, err := r.bus.ListenRequestStream(ServiceCacheInvalidationChannel)
+	if err != nil {
+		return
+	}
+	mh.Handle(func(message *model.Message) {
+		invalidation, ok := getServiceCacheInvalidation(message.Payload)
+		if !ok {
+			return
+		}
+		r.lock.Lock()
+		defer r.lock.Unlock()
+		for channelName, sw := range r.services {
+			if sw.cache != nil && (invalidation.ServiceChannel == "" || invalidation.ServiceChannel == channelName) {
+				sw.cache.invalidate(invalidation.Request)
+			}
+		}
+	}, func(e error) {})
+}
+
+// getServiceCacheInvalidation returns the invalidation sent by the server. the requests received from the
+// clients carry metadata and the ones received from brokers are not deserialized, so both are ignored.
+func getServiceCacheInvalidation(payload interface{}) (*ServiceCacheInvalidation, bool) {
+	switch p := payload.(type) {
+	case *ServiceCacheInvalidation:
+		return p, true
+	case ServiceCacheInvalidation:
+		return &p, true
+	case *model.Request:
+		if p.Metadata != nil {
+			return nil, false
+		}
+		return getServiceCacheInvalidation(p.Payload)
+	case model.Request:
+		if p.Metadata != nil {
+			return nil, false
+		}
+		return getServiceCacheInvalidation(p.Payload)
+	}
+	return nil, false
+}
@@ -0,0 +1,251 @@
+// Copyright 2019-2021 VMware, Inc.
+// SPDX-License-Identifier: BSD-2-Clause
+
+package service
+
+import (
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/vmware/transport-go/bus"
+	"github.com/vmware/transport-go/model"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingFabricService responds with the payload of the request and the number of requests handled so far.
+type countingFabricService struct {
+	calls int32
+}
+
+func (fs *countingFabricService) HandleServiceRequest(request *model.Request, core FabricServiceCore) {
+	calls := atomic.AddInt32(&fs.calls, 1)
+	if request.Payload == "fail" {
+		core.SendErrorResponse(request, 500, "failed")
+		return
+	}
+	core.SendResponse(request, fmt.Sprintf("%s-%v-%d", request.Request, request.Payload, calls))
+}
+
+func newCacheTestServiceRegistry() *serviceRegistry {
+	registry := newTestServiceRegistry()
+	registry.lifecycleManager = newTestServiceLifecycleManager(registry).(*serviceLifecycleManager)
+	return registry
+}
+
+func sendCachedServiceRequest(
+	t *testing.T, registry *serviceRegistry, requestType string, payload interface{}) *model.Response {
+
+	id := uuid.New()
+	responseChan := make(chan *model.Response, 1)
+	mh, _ := registry.bus.ListenOnceForDestination("test-channel", &id)
+	mh.Handle(func(message *model.Message) {
+		responseChan <- message.Payload.(*model.Response)
+	}, func(e error) {})
+	registry.bus.SendRequestMessage("test-channel", &model.Request{Id: &id, Request: requestType, Payload: payload}, &id)
+
+	select {
+	case response := <-responseChan:
+		assert.Equal(t, &id, response.Id)
+		return response
+	case <-time.After(2 * time.Second):
+		assert.Fail(t, "no response received")
+		return &model.Response{}
+	}
+}
+
+func TestServiceCache_CachesResponses(t *testing.T) {
+	registry := newCacheTestServiceRegistry()
+	getEvents := collectMonitorEvents(registry.bus, bus.ServiceCacheHitEvt, bus.ServiceCacheMissEvt)
+	svc := &countingFabricService{}
+	assert.Nil(t, registry.RegisterServiceWithOptions(svc, "test-channel",
+		&ServiceOptions{Cache: &ServiceCacheOptions{TTL: time.Minute}}))
+
+	first := sendCachedServiceRequest(t, registry, "get-joke", "a")
+	assert.Equal(t, "get-joke-a-1", first.Payload)
+	assert.NotEmpty(t, first.Headers["ETag"])
+	assert.Equal(t, "max-age=60", first.Headers["Cache-Control"])
+
+	second := sendCachedServiceRequest(t, registry, "get-joke", "a")
+	assert.Equal(t, "get-joke-a-1", second.Payload)
+	assert.Equal(t, first.Headers["ETag"], second.Headers["ETag"])
+	assert.Equal(t, int32(1), atomic.LoadInt32(&svc.calls))
+
+	// different payloads and request types are cached separately
+	assert.Equal(t, "get-joke-b-2", sendCachedServiceRequest(t, registry, "get-joke", "b").Payload)
+	assert.Equal(t, "get-quote-a-3", sendCachedServiceRequest(t, registry, "get-quote", "a").Payload)
+	assert.Equal(t, "get-joke-map[symbol:A]-4",
+		sendCachedServiceRequest(t, registry, "get-joke", map[string]string{"symbol": "A"}).Payload)
+	assert.Equal(t, "get-joke-map[symbol:A]-4",
+		sendCachedServiceRequest(t, registry, "get-joke", map[string]string{"symbol": "A"}).Payload)
+
+	eventTypes := make([]bus.MonitorEventType, 0)
+	for _, event := range getEvents() {
+		assert.Equal(t, "test-channel", event.EntityName)
+		eventTypes = append(eventTypes, event.EventType)
+	}
+	assert.Equal(t, []bus.MonitorEventType{
+		bus.ServiceCacheMissEvt, bus.ServiceCacheHitEvt, bus.ServiceCacheMissEvt,
+		bus.ServiceCacheMissEvt, bus.ServiceCacheMissEvt, bus.ServiceCacheHitEvt,
+	}, eventTypes)
+}
+
+func TestServiceCache_ErrorsAreNotCached(t *testing.T) {
+	registry := newCacheTestServiceRegistry()
+	svc := &countingFabricService{}
+	assert.Nil(t, registry.RegisterServiceWithOptions(svc, "test-channel", &ServiceOptions{Cache: &ServiceCacheOptions{}}))
+
+	assert.True(t, sendCachedServiceRequest(t, registry, "get-joke", "fail").Error)
+	assert.True(t, sendCachedServiceRequest(t, registry, "get-joke", "fail").Error)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&svc.calls))
+}
+
+func TestServiceCache_TTL(t *testing.T) {
+	registry := newCacheTestServiceRegistry()
+	svc := &countingFabricService{}
+	assert.Nil(t, registry.RegisterServiceWithOptions(svc, "test-channel",
+		&ServiceOptions{Cache: &ServiceCacheOptions{TTL: 20 * time.Millisecond}}))
+
+	assert.Equal(t, "get-joke-a-1", sendCachedServiceRequest(t, registry, "get-joke", "a").Payload)
+	assert.Equal(t, "get-joke-a-1", sendCachedServiceRequest(t, registry, "get-joke", "a").Payload)
+	time.Sleep(30 * time.Millisecond)
+	assert.Equal(t, "get-joke-a-2", sendCachedServiceRequest(t, registry, "get-joke", "a").Payload)
+}
+
+func TestServiceCache_MaxEntries(t *testing.T) {
+	registry := newCacheTestServiceRegistry()
+	getEvents := collectMonitorEvents(registry.bus, bus.ServiceCacheEvictedEvt)
+	svc := &countingFabricService{}
+	assert.Nil(t, registry.RegisterServiceWithOptions(svc, "test-channel",
+		&ServiceOptions{Cache: &ServiceCacheOptions{MaxEntries: 2}}))
+
+	sendCachedServiceRequest(t, registry, "get-joke", "a")
+	sendCachedServiceRequest(t, registry, "get-joke", "b")
+	// "a" becomes the most recently used entry, "b" is evicted
+	sendCachedServiceRequest(t, registry, "get-joke", "a")
+	sendCachedServiceRequest(t, registry, "get-joke", "c")
+	assert.Equal(t, 2, registry.services["test-channel"].cache.size())
+	assert.Len(t, getEvents(), 1)
+
+	assert.Equal(t, "get-joke-a-1", sendCachedServiceRequest(t, registry, "get-joke", "a").Payload)
+	assert.Equal(t, "get-joke-b-4", sendCachedServiceRequest(t, registry, "get-joke", "b").Payload)
+}
+
+func TestServiceCache_Requests(t *testing.T) {
+	registry := newCacheTestServiceRegistry()
+	svc := &countingFabricService{}
+	assert.Nil(t, registry.RegisterServiceWithOptions(svc, "test-channel",
+		&ServiceOptions{Cache: &ServiceCacheOptions{Requests: []string{"get-joke"}}}))
+
+	sendCachedServiceRequest(t, registry, "get-joke", "a")
+	sendCachedServiceRequest(t, registry, "get-joke", "a")
+	response := sendCachedServiceRequest(t, registry, "post-joke", "a")
+	assert.Empty(t, response.Headers["ETag"])
+	sendCachedServiceRequest(t, registry, "post-joke", "a")
+	assert.Equal(t, int32(3), atomic.LoadInt32(&svc.calls))
+}
+
+func TestServiceCache_Principals(t *testing.T) {
+	newRequest := func(principal *model.Principal) *model.Request {
+		return &model.Request{Request: "get-balance", Payload: "account",
+			Metadata: &model.RequestMetadata{Principal: principal}}
+	}
+	alice := &model.Principal{Name: "alice", AuthMethod: "jwt"}
+	bob := &model.Principal{Name: "bob", AuthMethod: "jwt"}
+
+	// the responses to authenticated requests are cached per principal
+	cache := newResponseCache(nil, "test-channel", &ServiceCacheOptions{})
+	aliceKey, _ := cache.getKey(newRequest(alice))
+	bobKey, _ := cache.getKey(newRequest(bob))
+	anonymousKey, _ := cache.getKey(newRequest(nil))
+	assert.NotEqual(t, aliceKey, bobKey)
+	assert.NotEqual(t, aliceKey, anonymousKey)
+	sameAliceKey, _ := cache.getKey(newRequest(&model.Principal{Name: "alice", AuthMethod: "jwt", Roles: []string{"admin"}}))
+	assert.Equal(t, aliceKey, sameAliceKey)
+	apiKeyAliceKey, _ := cache.getKey(newRequest(&model.Principal{Name: "alice", AuthMethod: "api_key"}))
+	assert.NotEqual(t, aliceKey, apiKeyAliceKey)
+
+	// unless the service shares them between all callers
+	cache = newResponseCache(nil, "test-channel", &ServiceCacheOptions{SharedAcrossPrincipals: true})
+	aliceKey, _ = cache.getKey(newRequest(alice))
+	bobKey, _ = cache.getKey(newRequest(bob))
+	assert.Equal(t, aliceKey, bobKey)
+}
+
+func TestServiceCache_Invalidation(t *testing.T) {
+	registry := newCacheTestServiceRegistry()
+	svc := &countingFabricService{}
+	assert.Nil(t, registry.RegisterServiceWithOptions(svc, "test-channel", &ServiceOptions{Cache: &ServiceCacheOptions{}}))
+	cache := registry.services["test-channel"].cache
+
+	sendCachedServiceRequest(t, registry, "get-joke", "a")
+	sendCachedServiceRequest(t, registry, "get-quote", "a")
+	assert.Equal(t, 2, cache.size())
+
+	// other services are not affected
+	registry.bus.SendRequestMessage(ServiceCacheInvalidationChannel,
+		&ServiceCacheInvalidation{ServiceChannel: "other-channel"}, nil)
+	registry.bus.SendRequestMessage(ServiceCacheInvalidationChannel,
+		&ServiceCacheInvalidation{ServiceChannel: "test-channel", Request: "get-joke"}, nil)
+	assert.Eventually(t, func() bool {
+		return cache.size() == 1
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, "get-joke-a-3", sendCachedServiceRequest(t, registry, "get-joke", "a").Payload)
+	assert.Equal(t, "get-quote-a-2", sendCachedServiceRequest(t, registry, "get-quote", "a").Payload)
+
+	// invalidation requests of clients are ignored
+	registry.bus.SendRequestMessage(ServiceCacheInvalidationChannel,
+		map[string]interface{}{"serviceChannel": "test-channel"}, nil)
+	registry.bus.SendRequestMessage(ServiceCacheInvalidationChannel, &model.Request{
+		Payload:  map[string]interface{}{"serviceChannel": "test-channel"},
+		Metadata: &model.RequestMetadata{Transport: model.TransportSTOMP, ConnectionId: "conn-1"},
+	}, nil)
+	registry.bus.SendRequestMessage(ServiceCacheInvalidationChannel, &model.Request{
+		Payload:  &ServiceCacheInvalidation{ServiceChannel: "test-channel"},
+		Metadata: &model.RequestMetadata{Transport: model.TransportHTTP},
+	}, nil)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 2, cache.size())
+
+	registry.bus.SendRequestMessage(ServiceCacheInvalidationChannel,
+		&model.Request{Payload: &ServiceCacheInvalidation{ServiceChannel: "test-channel"}}, nil)
+	assert.Eventually(t, func() bool {
+		return cache.size() == 0
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestServiceCache_InterceptorsAreApplied(t *testing.T) {
+	registry := newCacheTestServiceRegistry()
+	svc := &countingFabricService{}
+	assert.Nil(t, registry.RegisterServiceWithOptions(svc, "test-channel", &ServiceOptions{Cache: &ServiceCacheOptions{}}))
+
+	var intercepted int32
+	assert.Nil(t, registry.AddServiceInterceptor("test-channel", &ServiceInterceptor{
+		InterceptRequest: func(request *model.Request, core FabricServiceCore, next func(request *model.Request)) {
+			atomic.AddInt32(&intercepted, 1)
+			if request.Payload == "denied" {
+				core.SendErrorResponse(request, 403, "denied")
+				return
+			}
+			next(request)
+		},
+	}))
+
+	sendCachedServiceRequest(t, registry, "get-joke", "a")
+	sendCachedServiceRequest(t, registry, "get-joke", "a")
+	assert.Equal(t, int32(2), atomic.LoadInt32(&intercepted))
+	assert.Equal(t, 403, sendCachedServiceRequest(t, registry, "get-joke", "denied").ErrorCode)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&svc.calls))
+}
+
+func TestServiceCache_ReplaceServiceInvalidatesCache(t *testing.T) {
+	registry := newCacheTestServiceRegistry()
+	assert.Nil(t, registry.RegisterServiceWithOptions(&countingFabricService{}, "test-channel",
+		&ServiceOptions{Cache: &ServiceCacheOptions{}}))
+
+	assert.Equal(t, "get-joke-a-1", sendCachedServiceRequest(t, registry, "get-joke", "a").Payload)
+	assert.Nil(t, registry.ReplaceService("test-channel", &countingFabricService{calls: 10}))
+	assert.Equal(t, "get-joke-a-11", sendCachedServiceRequest(t, registry, "get-joke", "a").Payload)
+	assert.Equal(t, "get-joke-a-11", sendCachedServiceRequest(t, registry, "get-joke", "a").Payload)
+}
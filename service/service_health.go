@@ -0,0 +1,114 @@
+// Copyright 2019-2021 VMware, Inc.
+// SPDX-License-Identifier: BSD-2-Clause
+
+package service
+
+import (
+	"context"
+	"log"
+	"sync"
+)
+
+type HealthStatus string
+
+const (
+	HealthStatusUp   HealthStatus = "UP"
+	HealthStatusDown HealthStatus = "DOWN"
+)
+
+// HealthCheckResult is the detailed health status of a single service or component.
+type HealthCheckResult struct {
+	Status  HealthStatus           `json:"status"`
+	Message string                 `json:"message,omitempty"`
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
+// HealthReport is the aggregated health of multiple services or components. The report is
+// DOWN if any of its entries is DOWN.
+type HealthReport struct {
+	Status   HealthStatus                  `json:"status"`
+	Services map[string]*HealthCheckResult `json:"services,omitempty"`
+}
+
+// HealthCheckable Optional interface, if implemented by a fabric service, its CheckHealth method will
+// be invoked by ServiceRegistry.CheckHealth to get the detailed health status of the service, e.g.
+// to report that a database the service depends on is down. Implementations should return once the
+// context is done.
+type HealthCheckable interface {
+	CheckHealth(ctx context.Context) *HealthCheckResult
+}
+
+// CheckHealth checks the health of all registered services. A service is DOWN if it has lifecycle
+// hooks and is not marked as ready in the ServiceReadyStore yet, or if its HealthCheckable.CheckHealth
+// method reports so, panics or doesn't return before the context is done.
+func (r *serviceRegistry) CheckHealth(ctx context.Context) *HealthReport {
+	r.lock.RLock()
+	services := make(map[string]FabricService)
+	for chanName, sw := range r.services {
+		if isInternal, _ := internalServices[chanName]; !isInternal {
+			services[chanName] = sw.getService()
+		}
+	}
+	r.lock.RUnlock()
+
+	report := &HealthReport{
+		Status:   HealthStatusUp,
+		Services: make(map[string]*HealthCheckResult),
+	}
+	var lock sync.Mutex
+	wg := sync.WaitGroup{}
+	for chanName, svc := range services {
+		wg.Add(1)
+		go func(chanName string, svc FabricService) {
+			defer wg.Done()
+			result := r.checkServiceHealth(ctx, chanName, svc)
+			lock.Lock()
+			report.Services[chanName] = result
+			if result.Status != HealthStatusUp {
+				report.Status = HealthStatusDown
+			}
+			lock.Unlock()
+		}(chanName, svc)
+	}
+	wg.Wait()
+	return report
+}
+
+func (r *serviceRegistry) checkServiceHealth(
+	ctx context.Context, serviceChannelName string, svc FabricService) *HealthCheckResult {
+
+	if _, hasHooks := svc.(ServiceLifecycleHookEnabled); hasHooks {
+		if readyStore := r.bus.GetStoreManager().GetStore(ServiceReadyStore); readyStore != nil {
+			if ready, found := readyStore.Get(serviceChannelName); !found || ready != true {
+				return &HealthCheckResult{Status: HealthStatusDown, Message: "service is not ready"}
+			}
+		}
+	}
+
+	healthCheckable, ok := svc.(HealthCheckable)
+	if !ok {
+		return &HealthCheckResult{Status: HealthStatusUp}
+	}
+
+	resultChan := make(chan *HealthCheckResult, 1)
+	go func() {
+		defer func() {
+			if rec := recover(); rec != nil {
+				// the panic is logged rather than reported, the health endpoints are not protected
+				log.Printf("health check of service '%s' panicked: %v", serviceChannelName, rec)
+				resultChan <- &HealthCheckResult{Status: HealthStatusDown, Message: "health check failed"}
+			}
+		}()
+		resultChan <- healthCheckable.CheckHealth(ctx)
+	}()
+
+	select {
+	case result := <-resultChan:
+		if result == nil {
+			return &HealthCheckResult{Status: HealthStatusDown, Message: "health check returned no result"}
+		}
+		return result
+	case <-ctx.Done():
+		return &HealthCheckResult{Status: HealthStatusDown, Message: "health check timed out"}
+	}
+}
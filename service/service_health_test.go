@@ -0,0 +1,93 @@
+// Copyright 2019-2021 VMware, Inc.
+// SPDX-License-Identifier: BSD-2-Clause
+
+package service
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/vmware/transport-go/model"
+	"testing"
+	"time"
+)
+
+type mockHealthCheckableService struct {
+	result *HealthCheckResult
+	panic  bool
+	block  bool
+}
+
+func (s *mockHealthCheckableService) HandleServiceRequest(request *model.Request, core FabricServiceCore) {
+}
+
+func (s *mockHealthCheckableService) CheckHealth(ctx context.Context) *HealthCheckResult {
+	if s.panic {
+		panic("check failed")
+	}
+	if s.block {
+		<-ctx.Done()
+	}
+	return s.result
+}
+
+func newHealthTestServiceRegistry() *serviceRegistry {
+	registry := newTestServiceRegistry()
+	registry.lifecycleManager = newTestServiceLifecycleManager(registry).(*serviceLifecycleManager)
+	return registry
+}
+
+func TestServiceRegistry_CheckHealth(t *testing.T) {
+	registry := newHealthTestServiceRegistry()
+
+	report := registry.CheckHealth(context.Background())
+	assert.Equal(t, HealthStatusUp, report.Status)
+	assert.Len(t, report.Services, 0)
+
+	registry.RegisterService(&mockFabricService{}, "plain-service")
+	registry.RegisterService(&mockHealthCheckableService{
+		result: &HealthCheckResult{Status: HealthStatusUp, Details: map[string]interface{}{"db": "connected"}},
+	}, "healthy-service")
+
+	report = registry.CheckHealth(context.Background())
+	assert.Equal(t, HealthStatusUp, report.Status)
+	assert.Equal(t, &HealthCheckResult{Status: HealthStatusUp}, report.Services["plain-service"])
+	assert.Equal(t, "connected", report.Services["healthy-service"].Details["db"])
+
+	registry.RegisterService(&mockHealthCheckableService{
+		result: &HealthCheckResult{Status: HealthStatusDown, Message: "db is down"},
+	}, "unhealthy-service")
+	registry.RegisterService(&mockHealthCheckableService{panic: true}, "panicking-service")
+	registry.RegisterService(&mockHealthCheckableService{}, "nil-result-service")
+
+	report = registry.CheckHealth(context.Background())
+	assert.Equal(t, HealthStatusDown, report.Status)
+	assert.Equal(t, HealthStatusUp, report.Services["plain-service"].Status)
+	assert.Equal(t, "db is down", report.Services["unhealthy-service"].Message)
+	assert.Equal(t, "health check failed", report.Services["panicking-service"].Message)
+	assert.Equal(t, "health check returned no result", report.Services["nil-result-service"].Message)
+}
+
+func TestServiceRegistry_CheckHealthTimeout(t *testing.T) {
+	registry := newHealthTestServiceRegistry()
+	registry.RegisterService(&mockHealthCheckableService{block: true}, "blocking-service")
+
+	ctx, cancelFn := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancelFn()
+
+	report := registry.CheckHealth(ctx)
+	assert.Equal(t, HealthStatusDown, report.Status)
+	assert.Equal(t, "health check timed out", report.Services["blocking-service"].Message)
+}
+
+func TestServiceRegistry_CheckHealthServiceNotReady(t *testing.T) {
+	registry := newHealthTestServiceRegistry()
+	registry.RegisterService(&mockLifecycleHookEnabledService{}, "hooks-service")
+
+	report := registry.CheckHealth(context.Background())
+	assert.Equal(t, HealthStatusDown, report.Status)
+	assert.Equal(t, "service is not ready", report.Services["hooks-service"].Message)
+
+	registry.bus.GetStoreManager().GetStore(ServiceReadyStore).Put("hooks-service", true, ServiceInitStateChange)
+	report = registry.CheckHealth(context.Background())
+	assert.Equal(t, HealthStatusUp, report.Status)
+}
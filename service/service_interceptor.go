@@ -0,0 +1,82 @@
+// Copyright 2019-2021 VMware, Inc.
+// SPDX-License-Identifier: BSD-2-Clause
+
+package service
+
+import (
+	"github.com/vmware/transport-go/model"
+	"sync"
+)
+
+// ServiceInterceptor wraps the request handling of fabric services registered with the ServiceRegistry.
+// Interceptors can be used for cross-cutting concerns like authentication checks, tracing, request
+// logging, panic recovery or timing metrics. Both hooks are optional.
+type ServiceInterceptor struct {
+	// Name of the interceptor, used only to identify the interceptor in logs.
+	Name string
+
+	// InterceptRequest is invoked for every request sent to the service. The interceptor must call
+	// next to pass the (possibly modified) request on to the next interceptor and finally the service.
+	// Not calling next stops the request, the interceptor should then send an error response with core.
+	InterceptRequest func(request *model.Request, core FabricServiceCore, next func(request *model.Request))
+
+	// InterceptResponse is invoked for every response sent by the service via FabricServiceCore.
+	// It returns the response to be sent, which can be the same (modified) instance or a new one.
+	// Returning nil drops the response.
+	InterceptResponse func(request *model.Request, response *model.Response) *model.Response
+}
+
+// interceptorChain is a thread-safe list of interceptors which can be extended
+// while the services are handling requests.
+type interceptorChain struct {
+	lock         sync.RWMutex
+	interceptors []*ServiceInterceptor
+}
+
+func (c *interceptorChain) add(interceptor *ServiceInterceptor) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.interceptors = append(c.interceptors, interceptor)
+}
+
+func (c *interceptorChain) getAll() []*ServiceInterceptor {
+	if c == nil {
+		return nil
+	}
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.interceptors
+}
+
+// handleInterceptedRequest passes the request through the request hooks of the interceptors,
+// in the order in which they are listed, and finally to the handler.
+func handleInterceptedRequest(
+	interceptors []*ServiceInterceptor, request *model.Request,
+	core FabricServiceCore, handler func(request *model.Request)) {
+
+	next := handler
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		if interceptors[i].InterceptRequest == nil {
+			continue
+		}
+		intercept := interceptors[i].InterceptRequest
+		nextHandler := next
+		next = func(request *model.Request) {
+			intercept(request, core, nextHandler)
+		}
+	}
+	next(request)
+}
+
+// interceptResponse passes the response through the response hooks of the interceptors in reverse
+// order, so the interceptor which saw the request first sees the response last.
+func interceptResponse(
+	interceptors []*ServiceInterceptor, request *model.Request, response *model.Response) *model.Response {
+
+	for i := len(interceptors) - 1; i >= 0 && response != nil; i-- {
+		if interceptors[i].InterceptResponse != nil {
+			response = interceptors[i].InterceptResponse(request, response)
+		}
+	}
+	return response
+}
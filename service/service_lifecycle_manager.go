@@ -3,6 +3,7 @@ package service
 import (
 	"github.com/vmware/transport-go/model"
 	"net/http"
+	"reflect"
 )
 
 var svcLifecycleManagerInstance ServiceLifecycleManager
@@ -33,6 +34,16 @@ type RESTBridgeConfig struct {
 	AllowHead            bool           // whether HEAD calls are allowed for this bridge point
 	AllowOptions         bool           // whether OPTIONS calls are allowed for this bridge point
 	FabricRequestBuilder RequestBuilder // function to transform HTTP request into a transport request
+
+	// declarative alternative to FabricRequestBuilder binding the HTTP request to a struct, takes precedence if set
+	RequestBinding *RequestBinding
+
+	// optional API description of the bridge point, used to generate the OpenAPI document of the server
+	Summary      string       // short summary of what the endpoint does
+	Description  string       // longer description of the endpoint
+	QueryParams  []string     // names of the query parameters read by FabricRequestBuilder
+	RequestType  reflect.Type // type of the request body, if any
+	ResponseType reflect.Type // type of the response payload
 }
 
 type serviceLifecycleManager struct {
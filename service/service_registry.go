@@ -4,12 +4,17 @@
 package service
 
 import (
+	"context"
 	"fmt"
 	"github.com/vmware/transport-go/bus"
 	"github.com/vmware/transport-go/model"
+	"github.com/vmware/transport-go/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"log"
 	"reflect"
 	"sync"
+	"time"
 )
 
 var internalServices = map[string]bool{
@@ -35,21 +40,73 @@ type ServiceRegistry interface {
 	// its Init method will be called during the registration process.
 	RegisterService(service FabricService, serviceChannelName string) error
 
+	// RegisterServiceWithOptions is the same as RegisterService, but the requests are delivered to the
+	// service according to the options, e.g. by a bounded number of workers.
+	RegisterServiceWithOptions(service FabricService, serviceChannelName string, options *ServiceOptions) error
+
 	// UnregisterService unregisters the fabric service associated with the given channel.
 	UnregisterService(serviceChannelName string) error
 
+	// ReplaceService atomically replaces the fabric service associated with the given channel without
+	// dropping requests. the old service is shut down once its in-flight requests are completed and its
+	// REST bridges are replaced with the ones of the new service.
+	ReplaceService(serviceChannelName string, newService FabricService) error
+
+	// RegisterServiceVersion registers a second version of the fabric service associated with the given
+	// channel. the requests are split between the two versions as defined by the TrafficSplit.
+	RegisterServiceVersion(serviceChannelName string, service FabricService, split *TrafficSplit) error
+
+	// PromoteServiceVersion replaces the fabric service associated with the given channel with
+	// its second version registered by RegisterServiceVersion.
+	PromoteServiceVersion(serviceChannelName string) error
+
+	// UnregisterServiceVersion unregisters the second version of the fabric service associated with the given channel.
+	UnregisterServiceVersion(serviceChannelName string) error
+
 	// SetGlobalRestServiceBaseHost sets the global base host or host:port to be used by the restService
 	SetGlobalRestServiceBaseHost(host string)
 
+	// SetGlobalRestServicePolicy sets the timeout, retry and circuit breaker policy of the requests made by the
+	// restService. the policy of a single request can be overridden with RestServiceRequest.Policy.
+	SetGlobalRestServicePolicy(policy *RestRequestPolicy)
+
+	// SetRestServiceHostPolicy sets the policy of the requests made by the restService to the given host
+	// (host or host:port), overriding the global policy. a nil policy removes the policy of the host.
+	SetRestServiceHostPolicy(host string, policy *RestRequestPolicy)
+
 	// GetService returns the FabricService for the channel name given as the parameter
 	GetService(serviceChannelName string) (FabricService, error)
+
+	// AddGlobalInterceptor adds an interceptor applied to the requests and responses of all
+	// registered fabric services, including services registered later. Internal services like
+	// fabric-rest are not intercepted. Global interceptors are invoked before the per-service ones.
+	AddGlobalInterceptor(interceptor *ServiceInterceptor) error
+
+	// AddServiceInterceptor adds an interceptor applied only to the requests and responses
+	// of the fabric service associated with the given channel.
+	AddServiceInterceptor(serviceChannelName string, interceptor *ServiceInterceptor) error
+
+	// CheckHealth returns the aggregated health of all registered fabric services.
+	CheckHealth(ctx context.Context) *HealthReport
+
+	// EnableClusterDiscovery starts advertising the registered fabric services to the other nodes
+	// connected to the same broker and returns the cluster-wide view of the services. requests for
+	// services provided only by remote nodes are forwarded to them.
+	EnableClusterDiscovery(config *ClusterConfig) (ClusterRegistry, error)
+
+	// GetClusterRegistry returns the cluster-wide view of the services, or nil if cluster discovery
+	// is not enabled.
+	GetClusterRegistry() ClusterRegistry
 }
 
 type serviceRegistry struct {
-	lock             sync.Mutex
-	services         map[string]*fabricServiceWrapper
-	bus              bus.EventBus
-	lifecycleManager *serviceLifecycleManager
+	lock               sync.RWMutex
+	services           map[string]*fabricServiceWrapper
+	bus                bus.EventBus
+	lifecycleManager   *serviceLifecycleManager
+	globalInterceptors *interceptorChain
+	clusterRegistry    *clusterRegistry
+	clusterLock        sync.Mutex // serializes enabling the cluster discovery
 }
 
 var once sync.Once
@@ -74,8 +131,9 @@ func GetServiceRegistry() ServiceRegistry {
 
 func newServiceRegistry(bus bus.EventBus) ServiceRegistry {
 	registry := &serviceRegistry{
-		bus:      bus,
-		services: make(map[string]*fabricServiceWrapper),
+		bus:                bus,
+		services:           make(map[string]*fabricServiceWrapper),
+		globalInterceptors: &interceptorChain{},
 	}
 	// create a channel for service lifecycle manager
 	_ = bus.GetChannelManager().CreateChannel(LifecycleManagerChannelName)
@@ -83,6 +141,9 @@ func newServiceRegistry(bus bus.EventBus) ServiceRegistry {
 	// create a bus store for delivering service ready notifications
 	bus.GetStoreManager().CreateStoreWithType(ServiceReadyStore, reflect.TypeOf(true)).Initialize()
 
+	// drop cached service responses as requested on the invalidation channel
+	registry.listenForCacheInvalidations()
+
 	// auto-register the restService
 	registry.RegisterService(&restService{}, restServiceChannel)
 	return registry
@@ -91,18 +152,36 @@ func newServiceRegistry(bus bus.EventBus) ServiceRegistry {
 // GetService returns the FabricService instance registered at the provided service channel name.
 // if no service is found at the service channel it returns an error.
 func (r *serviceRegistry) GetService(serviceChannelName string) (FabricService, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
 	if serviceWrapper, ok := r.services[serviceChannelName]; ok {
-		return serviceWrapper.service, nil
+		return serviceWrapper.getService(), nil
 	}
 	return nil, fmt.Errorf("fabric service not found at channel %s", serviceChannelName)
 }
 
 func (r *serviceRegistry) SetGlobalRestServiceBaseHost(host string) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
 	r.services[restServiceChannel].service.(*restService).setBaseHost(host)
 }
 
+func (r *serviceRegistry) SetGlobalRestServicePolicy(policy *RestRequestPolicy) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	r.services[restServiceChannel].service.(*restService).setPolicy(policy)
+}
+
+func (r *serviceRegistry) SetRestServiceHostPolicy(host string, policy *RestRequestPolicy) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	r.services[restServiceChannel].service.(*restService).setHostPolicy(host, policy)
+}
+
 // GetAllServiceChannels returns the list of service channels that are registered with the registry
 func (r *serviceRegistry) GetAllServiceChannels() []string {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
 	services := make([]string, 0)
 	for chanName, _ := range r.services {
 		// do not return internal services like fabric-rest
@@ -114,24 +193,34 @@ func (r *serviceRegistry) GetAllServiceChannels() []string {
 }
 
 func (r *serviceRegistry) RegisterService(service FabricService, serviceChannelName string) error {
-	r.lock.Lock()
-	defer r.lock.Unlock()
+	return r.RegisterServiceWithOptions(service, serviceChannelName, nil)
+}
+
+func (r *serviceRegistry) RegisterServiceWithOptions(
+	service FabricService, serviceChannelName string, options *ServiceOptions) error {
 
 	if service == nil {
 		return fmt.Errorf("unable to register service: nil service")
 	}
 
+	r.lock.Lock()
 	if _, ok := r.services[serviceChannelName]; ok {
+		r.lock.Unlock()
 		return fmt.Errorf("unable to register service: service channel name is already used: %s", serviceChannelName)
 	}
 
 	sw := newServiceWrapper(r.bus, service, serviceChannelName)
-	err := sw.init()
+	if isInternal, _ := internalServices[serviceChannelName]; !isInternal {
+		sw.globalInterceptors = r.globalInterceptors
+	}
+	err := sw.init(options)
 	if err != nil {
+		r.lock.Unlock()
 		return err
 	}
 
 	r.services[serviceChannelName] = sw
+	r.lock.Unlock()
 
 	// if the service is an internal service like fabric-rest don't bother setting up lifecycle hooks
 	if isInternal, _ := internalServices[serviceChannelName]; isInternal {
@@ -176,27 +265,129 @@ func (r *serviceRegistry) UnregisterService(serviceChannelName string) error {
 	return nil
 }
 
+func (r *serviceRegistry) AddGlobalInterceptor(interceptor *ServiceInterceptor) error {
+	if interceptor == nil {
+		return fmt.Errorf("unable to add interceptor: nil interceptor")
+	}
+	r.globalInterceptors.add(interceptor)
+	return nil
+}
+
+func (r *serviceRegistry) AddServiceInterceptor(serviceChannelName string, interceptor *ServiceInterceptor) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if interceptor == nil {
+		return fmt.Errorf("unable to add interceptor: nil interceptor")
+	}
+	sw, ok := r.services[serviceChannelName]
+	if !ok {
+		return fmt.Errorf("unable to add interceptor: no service is registered for channel \"%s\"", serviceChannelName)
+	}
+	sw.interceptors.add(interceptor)
+	return nil
+}
+
+func (r *serviceRegistry) EnableClusterDiscovery(config *ClusterConfig) (ClusterRegistry, error) {
+	// the cluster registry reads the registered services when it starts, so the registry must not be
+	// locked while it's created.
+	r.clusterLock.Lock()
+	defer r.clusterLock.Unlock()
+
+	if r.GetClusterRegistry() != nil {
+		return nil, fmt.Errorf("unable to enable cluster discovery: cluster discovery is already enabled")
+	}
+	cr, err := newClusterRegistry(r, r.bus, config)
+	if err != nil {
+		return nil, err
+	}
+	r.lock.Lock()
+	r.clusterRegistry = cr
+	r.lock.Unlock()
+	return cr, nil
+}
+
+func (r *serviceRegistry) GetClusterRegistry() ClusterRegistry {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	if r.clusterRegistry == nil || r.clusterRegistry.isStopped() {
+		return nil
+	}
+	return r.clusterRegistry
+}
+
 type fabricServiceWrapper struct {
-	service           FabricService
-	fabricCore        *fabricCore
-	requestMsgHandler bus.MessageHandler
+	lock               sync.RWMutex
+	service            FabricService
+	fabricCore         *fabricCore
+	inFlight           *sync.WaitGroup // requests being handled by the service
+	version            *serviceVersion // second version of the service running side by side, if any
+	requestMsgHandler  bus.MessageHandler
+	workerPool         *serviceWorkerPool
+	cache              *responseCache
+	streams            *responseStreams
+	globalInterceptors *interceptorChain
+	interceptors       *interceptorChain
 }
 
 func newServiceWrapper(
 	bus bus.EventBus, service FabricService, serviceChannelName string) *fabricServiceWrapper {
 
-	return &fabricServiceWrapper{
-		service: service,
-		fabricCore: &fabricCore{
-			bus:         bus,
-			channelName: serviceChannelName,
-		},
+	sw := &fabricServiceWrapper{
+		service:      service,
+		inFlight:     &sync.WaitGroup{},
+		interceptors: &interceptorChain{},
+		streams:      newResponseStreams(),
+	}
+	sw.fabricCore = &fabricCore{
+		bus:         bus,
+		channelName: serviceChannelName,
+		service:     service,
+		streams:     sw.streams,
+	}
+	sw.fabricCore.interceptors = sw.getInterceptors
+	return sw
+}
+
+// newFabricCore creates a new core for another instance of the service.
+func (sw *fabricServiceWrapper) newFabricCore(service FabricService) *fabricCore {
+	return &fabricCore{
+		bus:          sw.fabricCore.bus,
+		channelName:  sw.fabricCore.channelName,
+		service:      service,
+		interceptors: sw.getInterceptors,
+		cache:        sw.cache,
+		streams:      sw.streams,
 	}
 }
 
-func (sw *fabricServiceWrapper) init() error {
+func (sw *fabricServiceWrapper) getService() FabricService {
+	sw.lock.RLock()
+	defer sw.lock.RUnlock()
+	return sw.service
+}
+
+// getInterceptors returns the global interceptors followed by the service interceptors.
+func (sw *fabricServiceWrapper) getInterceptors() []*ServiceInterceptor {
+	globalInterceptors := sw.globalInterceptors.getAll()
+	serviceInterceptors := sw.interceptors.getAll()
+	if len(globalInterceptors) == 0 {
+		return serviceInterceptors
+	}
+	interceptors := make([]*ServiceInterceptor, 0, len(globalInterceptors)+len(serviceInterceptors))
+	interceptors = append(interceptors, globalInterceptors...)
+	return append(interceptors, serviceInterceptors...)
+}
+
+func (sw *fabricServiceWrapper) init(options *ServiceOptions) error {
 	sw.fabricCore.bus.GetChannelManager().CreateChannel(sw.fabricCore.channelName)
 
+	if options != nil {
+		sw.cache = newResponseCache(sw.fabricCore.bus, sw.fabricCore.channelName, options.Cache)
+		sw.fabricCore.cache = sw.cache
+	}
+
 	initializationService, ok := sw.service.(FabricInitializableService)
 	if ok {
 		initializationErr := initializationService.Init(sw.fabricCore)
@@ -211,7 +402,13 @@ func (sw *fabricServiceWrapper) init() error {
 	}
 
 	sw.requestMsgHandler = mh
-	mh.Handle(
+	sw.workerPool = newServiceWorkerPool(options)
+	handle := mh.Handle
+	if orderedHandler, ok := mh.(bus.OrderedMessageHandler); ok && sw.workerPool != nil && options.SerializationKey != nil {
+		// keyed requests are queued in the order they were sent, queueing them never blocks
+		handle = orderedHandler.HandleOrdered
+	}
+	handle(
 		func(message *model.Message) {
 			requestPtr, ok := message.Payload.(*model.Request)
 			if !ok {
@@ -226,16 +423,112 @@ func (sw *fabricServiceWrapper) init() error {
 			if message.DestinationId != nil {
 				requestPtr.Id = message.DestinationId
 			}
+			continueTrace(requestPtr, message)
 
-			sw.service.HandleServiceRequest(requestPtr, sw.fabricCore)
+			// cancel requests bypass the worker pool as the requests whose streams they cancel may
+			// be occupying all the workers
+			if requestPtr.Request == CancelResponseStreamRequest {
+				sw.streams.cancel(requestPtr)
+				return
+			}
+
+			if sw.workerPool == nil {
+				sw.handleRequest(requestPtr)
+			} else if err := sw.workerPool.submit(requestPtr, sw.handleRequest); err != nil {
+				sw.fabricCore.SendErrorResponse(requestPtr, 503, err.Error())
+			}
 		},
 		func(e error) {})
 
 	return nil
 }
 
+func (sw *fabricServiceWrapper) handleRequest(request *model.Request) {
+	service, core, inFlight := sw.selectService(request)
+	defer inFlight.Done()
+	defer sw.streams.closeAbandoned(request)
+
+	start := time.Now()
+	defer func() {
+		core.bus.SendMonitorEvent(bus.ServiceRequestHandledEvt, core.channelName, &ServiceRequestMonitorData{
+			Request: request.Request, Supported: isSupportedRequest(service, request.Request), Latency: time.Since(start)})
+	}()
+
+	if span := startServiceSpan(request, core.channelName); span != nil {
+		defer span.End()
+	}
+
+	handleInterceptedRequest(sw.getInterceptors(), request, core,
+		func(request *model.Request) {
+			// the cache is consulted after the interceptors so that e.g. authentication checks
+			// are applied to the cached responses as well
+			if sw.cache != nil && sw.cache.serve(request, core) {
+				return
+			}
+			service.HandleServiceRequest(request, core)
+		})
+}
+
+// selectService returns the version of the service which should handle the request and marks
+// the request as in-flight for that version.
+func (sw *fabricServiceWrapper) selectService(request *model.Request) (FabricService, *fabricCore, *sync.WaitGroup) {
+	sw.lock.RLock()
+	defer sw.lock.RUnlock()
+
+	if sw.version != nil && sw.version.split.matches(request) {
+		sw.version.inFlight.Add(1)
+		return sw.version.service, sw.version.core, sw.version.inFlight
+	}
+	sw.inFlight.Add(1)
+	return sw.service, sw.fabricCore, sw.inFlight
+}
+
+// continueTrace updates the trace context of the request to the one of the message it was received in, which
+// is the context of the span dispatching the request on the bus. the metadata is copied as it may be shared
+// with the sender of the request.
+func continueTrace(request *model.Request, message *model.Message) {
+	ctx := tracing.ContextFromHeaders(context.Background(), message.Headers)
+	if !tracing.HasTraceContext(ctx) {
+		return
+	}
+	metadata := model.RequestMetadata{}
+	if request.Metadata != nil {
+		metadata = *request.Metadata
+	}
+	tracing.InjectMetadata(ctx, &metadata)
+	request.Metadata = &metadata
+}
+
+// startServiceSpan starts the span of handling a request which is part of a trace and updates the trace
+// context of the request to the one of the span, so that the requests and the responses the service sends
+// while handling it continue the trace. returns nil for requests which are not part of a trace.
+func startServiceSpan(request *model.Request, channelName string) trace.Span {
+	ctx := tracing.ContextFromMetadata(context.Background(), request.Metadata)
+	if !tracing.HasTraceContext(ctx) {
+		return nil
+	}
+	spanName := channelName
+	if request.Request != "" {
+		spanName += " " + request.Request
+	}
+	ctx, span := tracing.Tracer().Start(ctx, spanName,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String(tracing.ChannelAttribute, channelName),
+			attribute.String(tracing.RequestAttribute, request.Request)))
+	metadata := *request.Metadata
+	tracing.InjectMetadata(ctx, &metadata)
+	request.Metadata = &metadata
+	return span
+}
+
 func (sw *fabricServiceWrapper) unregister() {
 	if sw.requestMsgHandler != nil {
 		sw.requestMsgHandler.Close()
 	}
+	if sw.workerPool != nil {
+		sw.workerPool.stop(func(request *model.Request) {
+			sw.fabricCore.SendErrorResponse(request, 503, "service is not accepting requests")
+		})
+	}
 }
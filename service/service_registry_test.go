@@ -9,9 +9,15 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/vmware/transport-go/bus"
 	"github.com/vmware/transport-go/model"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
 	"net/http"
+	"net/http/httptest"
 	"sync"
 	"testing"
+	"time"
 )
 
 func newTestServiceRegistry() *serviceRegistry {
@@ -149,6 +155,110 @@ func TestServiceRegistry_RegisterService(t *testing.T) {
 	assert.False(t, registry.bus.GetChannelManager().CheckChannelExists("test-channel2"))
 }
 
+type echoFabricService struct{}
+
+func (fs *echoFabricService) HandleServiceRequest(request *model.Request, core FabricServiceCore) {
+	core.SendResponse(request, request.Payload)
+}
+
+func TestServiceRegistry_Interceptors(t *testing.T) {
+	registry := newTestServiceRegistry()
+
+	var calls []string
+	var lock sync.Mutex
+	addCall := func(call string) {
+		lock.Lock()
+		calls = append(calls, call)
+		lock.Unlock()
+	}
+
+	assert.Nil(t, registry.AddGlobalInterceptor(&ServiceInterceptor{
+		Name: "global",
+		InterceptRequest: func(request *model.Request, core FabricServiceCore, next func(request *model.Request)) {
+			addCall("global-request")
+			if request.Payload == "forbidden" {
+				core.SendErrorResponse(request, 401, "unauthorized")
+				return
+			}
+			next(request)
+		},
+		InterceptResponse: func(request *model.Request, response *model.Response) *model.Response {
+			addCall("global-response")
+			if response.Payload == "drop" {
+				return nil
+			}
+			return response
+		},
+	}))
+	assert.EqualError(t, registry.AddGlobalInterceptor(nil), "unable to add interceptor: nil interceptor")
+
+	assert.Nil(t, registry.RegisterService(&echoFabricService{}, "test-channel"))
+	assert.Nil(t, registry.AddServiceInterceptor("test-channel", &ServiceInterceptor{
+		Name: "service",
+		InterceptRequest: func(request *model.Request, core FabricServiceCore, next func(request *model.Request)) {
+			addCall("service-request")
+			request.Payload = request.Payload.(string) + "-modified"
+			next(request)
+		},
+		InterceptResponse: func(request *model.Request, response *model.Response) *model.Response {
+			addCall("service-response")
+			response.Headers["X-Intercepted"] = "true"
+			return response
+		},
+	}))
+	assert.EqualError(t, registry.AddServiceInterceptor("invalid-channel", &ServiceInterceptor{}),
+		"unable to add interceptor: no service is registered for channel \"invalid-channel\"")
+	assert.EqualError(t, registry.AddServiceInterceptor("test-channel", nil),
+		"unable to add interceptor: nil interceptor")
+
+	wg := sync.WaitGroup{}
+	var responses []*model.Response
+	mh, _ := registry.bus.ListenStream("test-channel")
+	mh.Handle(func(message *model.Message) {
+		responses = append(responses, message.Payload.(*model.Response))
+		wg.Done()
+	}, func(e error) {
+		assert.Fail(t, "unexpected error")
+	})
+	defer mh.Close()
+
+	id := uuid.New()
+	wg.Add(1)
+	registry.bus.SendRequestMessage("test-channel", &model.Request{Id: &id, Payload: "hello"}, nil)
+	wg.Wait()
+
+	assert.Equal(t, "hello-modified", responses[0].Payload)
+	assert.Equal(t, "true", responses[0].Headers["X-Intercepted"])
+	assert.Equal(t, []string{"global-request", "service-request", "service-response", "global-response"}, calls)
+
+	// the request is stopped by the global interceptor
+	calls = nil
+	wg.Add(1)
+	registry.bus.SendRequestMessage("test-channel", &model.Request{Id: &id, Payload: "forbidden"}, nil)
+	wg.Wait()
+
+	assert.True(t, responses[1].Error)
+	assert.Equal(t, 401, responses[1].ErrorCode)
+	assert.Equal(t, []string{"global-request", "service-response", "global-response"}, calls)
+
+	// the new service interceptor changes the payload so the global interceptor drops the response
+	assert.Nil(t, registry.AddServiceInterceptor("test-channel", &ServiceInterceptor{
+		InterceptRequest: func(request *model.Request, core FabricServiceCore, next func(request *model.Request)) {
+			request.Payload = "drop"
+			next(request)
+			addCall("dropped")
+		},
+	}))
+	calls = nil
+	registry.bus.SendRequestMessage("test-channel", &model.Request{Id: &id, Payload: "test"}, nil)
+	assert.Eventually(t, func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return len(calls) > 0 && calls[len(calls)-1] == "dropped"
+	}, time.Second, 5*time.Millisecond)
+	assert.Len(t, responses, 2)
+}
+
 func TestServiceRegistry_RegisterInitializableService(t *testing.T) {
 	registry := newTestServiceRegistry()
 	mockService := &mockInitializableService{}
@@ -215,3 +325,149 @@ func TestServiceRegistry_RegisterService_LifecycleHookEnabled(t *testing.T) {
 	restBridgeConfig := svc.GetRESTBridgeConfig()
 	assert.NotNil(t, restBridgeConfig)
 }
+
+type tracedFabricService struct {
+	upstreamUrl string
+}
+
+func (fs *tracedFabricService) HandleServiceRequest(request *model.Request, core FabricServiceCore) {
+	core.RestServiceRequest(&RestServiceRequest{
+		Uri:      fs.upstreamUrl,
+		Method:   http.MethodGet,
+		Metadata: request.Metadata,
+	}, func(response *model.Response) {
+		core.SendResponse(request, response.Payload)
+	}, func(response *model.Response) {
+		core.SendErrorResponse(request, response.ErrorCode, response.ErrorMessage)
+	})
+}
+
+func TestServiceRegistry_Tracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(trace.NewNoopTracerProvider())
+
+	upstreamTraceParent := make(chan string, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamTraceParent <- r.Header.Get("traceparent")
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"name": "upstream"}`))
+	}))
+	defer upstream.Close()
+
+	registry := newTestServiceRegistry()
+	registry.lifecycleManager = newTestServiceLifecycleManager(registry).(*serviceLifecycleManager)
+	assert.Nil(t, registry.RegisterService(&tracedFabricService{upstreamUrl: upstream.URL}, "traced-channel"))
+
+	id := uuid.New()
+	metadata := &model.RequestMetadata{TraceParent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}
+	mh, _ := registry.bus.ListenOnceForDestination("traced-channel", &id)
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	mh.Handle(func(message *model.Message) {
+		assert.False(t, message.Payload.(*model.Response).Error)
+		wg.Done()
+	}, func(e error) {})
+	registry.bus.SendRequestMessage("traced-channel",
+		&model.Request{Id: &id, Request: "get-item", Metadata: metadata}, &id)
+	wg.Wait()
+
+	// the metadata of the sender is left intact
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", metadata.TraceParent)
+
+	// the spans are ended after the response is sent
+	assert.Eventually(t, func() bool { return len(recorder.Ended()) == 5 }, time.Second, 10*time.Millisecond)
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.SpanContext().TraceID().String())
+		spans[span.Name()] = span
+	}
+	assert.Equal(t, "00f067aa0ba902b7", spans["traced-channel send"].Parent().SpanID().String())
+	assert.Equal(t, trace.SpanKindProducer, spans["traced-channel send"].SpanKind())
+	assert.Equal(t, spans["traced-channel send"].SpanContext().SpanID(), spans["traced-channel get-item"].Parent().SpanID())
+	assert.Equal(t, trace.SpanKindServer, spans["traced-channel get-item"].SpanKind())
+	assert.Equal(t, spans["traced-channel get-item"].SpanContext().SpanID(), spans["fabric-rest send"].Parent().SpanID())
+	assert.Equal(t, spans["fabric-rest send"].SpanContext().SpanID(), spans["fabric-rest"].Parent().SpanID())
+	assert.Equal(t, spans["fabric-rest"].SpanContext().SpanID(), spans["HTTP GET"].Parent().SpanID())
+	assert.Equal(t, trace.SpanKindClient, spans["HTTP GET"].SpanKind())
+
+	// the upstream continues the trace of the client span
+	traceParent := <-upstreamTraceParent
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-"+spans["HTTP GET"].SpanContext().SpanID().String()+"-01", traceParent)
+}
+
+func TestServiceRegistry_TracingNotStarted(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(trace.NewNoopTracerProvider())
+
+	registry := newTestServiceRegistry()
+	registry.lifecycleManager = newTestServiceLifecycleManager(registry).(*serviceLifecycleManager)
+	assert.Nil(t, registry.RegisterService(&echoFabricService{}, "test-channel"))
+
+	id := uuid.New()
+	mh, _ := registry.bus.ListenOnceForDestination("test-channel", &id)
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	mh.Handle(func(message *model.Message) {
+		wg.Done()
+	}, func(e error) {})
+	registry.bus.SendRequestMessage("test-channel", &model.Request{Id: &id, Payload: "test"}, &id)
+	wg.Wait()
+
+	// requests which are not part of a trace are not traced
+	assert.Empty(t, recorder.Ended())
+	assert.Empty(t, recorder.Started())
+}
+
+type failingFabricService struct{}
+
+func (fs *failingFabricService) HandleServiceRequest(request *model.Request, core FabricServiceCore) {
+	time.Sleep(5 * time.Millisecond)
+	core.HandleUnknownRequest(request)
+}
+
+func TestServiceRegistry_MonitorEvents(t *testing.T) {
+	registry := newTestServiceRegistry()
+	registry.lifecycleManager = newTestServiceLifecycleManager(registry).(*serviceLifecycleManager)
+	assert.Nil(t, registry.RegisterService(&failingFabricService{}, "failing-channel"))
+
+	var lock sync.Mutex
+	var monitorEvents []*bus.MonitorEvent
+	registry.bus.AddMonitorEventListener(func(event *bus.MonitorEvent) {
+		lock.Lock()
+		monitorEvents = append(monitorEvents, event)
+		lock.Unlock()
+	}, bus.ServiceRequestHandledEvt, bus.ServiceResponseSentEvt)
+
+	id := uuid.New()
+	mh, _ := registry.bus.ListenOnceForDestination("failing-channel", &id)
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	mh.Handle(func(message *model.Message) {
+		wg.Done()
+	}, func(e error) {})
+	registry.bus.SendRequestMessage("failing-channel", &model.Request{Id: &id, Request: "get-joke"}, &id)
+	wg.Wait()
+
+	assert.Eventually(t, func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return len(monitorEvents) == 2
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, bus.NewMonitorEvent(bus.ServiceResponseSentEvt, "failing-channel",
+		&ServiceResponseMonitorData{Request: "get-joke", Error: true, ErrorCode: 403}), monitorEvents[0])
+	assert.Equal(t, bus.ServiceRequestHandledEvt, monitorEvents[1].EventType)
+	assert.Equal(t, "failing-channel", monitorEvents[1].EntityName)
+	assert.Equal(t, "get-joke", monitorEvents[1].Data.(*ServiceRequestMonitorData).Request)
+	assert.GreaterOrEqual(t, monitorEvents[1].Data.(*ServiceRequestMonitorData).Latency, 5*time.Millisecond)
+}
+
+func TestIsSupportedRequest(t *testing.T) {
+	router := NewRequestRouter()
+	router.Handle("get-joke", nil, func(request *model.Request, payload interface{}, core FabricServiceCore) {})
+	assert.True(t, isSupportedRequest(router, "get-joke"))
+	assert.False(t, isSupportedRequest(router, "get-secret"))
+	assert.False(t, isSupportedRequest(&failingFabricService{}, "get-joke"))
+	assert.False(t, isSupportedRequest(nil, "get-joke"))
+}
@@ -0,0 +1,287 @@
+// Copyright 2019-2021 VMware, Inc.
+// SPDX-License-Identifier: BSD-2-Clause
+
+package service
+
+import (
+	"fmt"
+	"github.com/vmware/transport-go/model"
+	"log"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maximum time to wait for the in-flight requests of a replaced service to complete
+const serviceDrainTimeout = 30 * time.Second
+
+// TrafficSplit defines which requests are delivered to the second version of a service running
+// side by side with the registered one. requests with the header Header set to HeaderValue are always
+// delivered to the second version, the rest of the requests are split randomly by Percentage.
+type TrafficSplit struct {
+	Percentage  int    // percentage (0-100) of requests delivered to the second version
+	Header      string // optional request header selecting the second version
+	HeaderValue string // value of Header selecting the second version
+}
+
+func (ts *TrafficSplit) matches(request *model.Request) bool {
+	if ts.Header != "" && request.Headers != nil {
+		if value, found := getRequestHeader(request.Headers, ts.Header); found && value == ts.HeaderValue {
+			return true
+		}
+	}
+	if ts.Percentage <= 0 {
+		return false
+	}
+	return ts.Percentage >= 100 || rand.Intn(100) < ts.Percentage
+}
+
+// getRequestHeader looks up the request header case-insensitively, like the headers of HTTP requests.
+func getRequestHeader(headers map[string]string, name string) (string, bool) {
+	if value, found := headers[http.CanonicalHeaderKey(name)]; found {
+		return value, true
+	}
+	for k, v := range headers {
+		if strings.EqualFold(k, name) {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+type serviceVersion struct {
+	service  FabricService
+	core     *fabricCore
+	inFlight *sync.WaitGroup
+	split    *TrafficSplit
+}
+
+// ReplaceService atomically replaces the fabric service registered at the given channel. requests
+// arriving during the replacement are delivered to the new service, while the requests already being
+// handled by the old service are drained before its OnServerShutdown hook is called. the REST bridges
+// of the old service are replaced in place by the ones of the new service. the registry is not locked
+// while the new service initializes, and the old one is drained in the background.
+func (r *serviceRegistry) ReplaceService(serviceChannelName string, newService FabricService) error {
+	if newService == nil {
+		return fmt.Errorf("unable to replace service: nil service")
+	}
+	r.lock.Lock()
+	sw, ok := r.services[serviceChannelName]
+	r.lock.Unlock()
+	if !ok {
+		return fmt.Errorf("unable to replace service: no service is registered for channel \"%s\"", serviceChannelName)
+	}
+
+	core := sw.newFabricCore(newService)
+	if err := r.initServiceInstance(newService, core); err != nil {
+		return fmt.Errorf("unable to replace service: %s", err.Error())
+	}
+
+	r.lock.Lock()
+	if r.services[serviceChannelName] != sw {
+		r.lock.Unlock()
+		if hooks, ok := newService.(ServiceLifecycleHookEnabled); ok {
+			hooks.OnServerShutdown()
+		}
+		return fmt.Errorf(
+			"unable to replace service: service at channel \"%s\" was unregistered during the replacement",
+			serviceChannelName)
+	}
+	sw.lock.Lock()
+	oldService, oldInFlight := sw.service, sw.inFlight
+	sw.service, sw.fabricCore, sw.inFlight = newService, core, &sync.WaitGroup{}
+	sw.lock.Unlock()
+	r.lock.Unlock()
+	// the responses of the old service are no longer valid
+	if sw.cache != nil {
+		sw.cache.invalidate("")
+	}
+
+	go r.retireServiceInstance(serviceChannelName, oldService, oldInFlight)
+	return r.replaceRESTBridges(serviceChannelName, oldService, newService)
+}
+
+// RegisterServiceVersion registers a second version of the fabric service registered at the given channel.
+// the two versions run side by side and the requests are split between them as defined by split. only
+// one additional version can be registered at a time. the registry is not locked while the version initializes.
+func (r *serviceRegistry) RegisterServiceVersion(
+	serviceChannelName string, service FabricService, split *TrafficSplit) error {
+
+	if service == nil {
+		return fmt.Errorf("unable to register service version: nil service")
+	}
+	if split == nil {
+		return fmt.Errorf("unable to register service version: nil traffic split")
+	}
+	r.lock.Lock()
+	sw, ok := r.services[serviceChannelName]
+	r.lock.Unlock()
+	if !ok {
+		return fmt.Errorf(
+			"unable to register service version: no service is registered for channel \"%s\"", serviceChannelName)
+	}
+
+	sw.lock.RLock()
+	hasVersion := sw.version != nil
+	sw.lock.RUnlock()
+	if hasVersion {
+		return fmt.Errorf(
+			"unable to register service version: a second version is already registered for channel \"%s\"",
+			serviceChannelName)
+	}
+
+	core := sw.newFabricCore(service)
+	if err := r.initServiceInstance(service, core); err != nil {
+		return fmt.Errorf("unable to register service version: %s", err.Error())
+	}
+
+	// the registry was not locked while the new version initialized, check again that it can be registered.
+	var err error
+	r.lock.Lock()
+	sw.lock.Lock()
+	if r.services[serviceChannelName] != sw {
+		err = fmt.Errorf(
+			"unable to register service version: service at channel \"%s\" was unregistered during the registration",
+			serviceChannelName)
+	} else if sw.version != nil {
+		err = fmt.Errorf(
+			"unable to register service version: a second version is already registered for channel \"%s\"",
+			serviceChannelName)
+	} else {
+		sw.version = &serviceVersion{service: service, core: core, inFlight: &sync.WaitGroup{}, split: split}
+	}
+	sw.lock.Unlock()
+	r.lock.Unlock()
+
+	if err != nil {
+		if hooks, ok := service.(ServiceLifecycleHookEnabled); ok {
+			hooks.OnServerShutdown()
+		}
+	}
+	return err
+}
+
+// PromoteServiceVersion makes the second version of the fabric service registered at the given channel
+// the only version handling requests. the previous version is drained and shut down in the background
+// like with ReplaceService.
+func (r *serviceRegistry) PromoteServiceVersion(serviceChannelName string) error {
+	r.lock.Lock()
+	sw, ok := r.services[serviceChannelName]
+	if !ok {
+		r.lock.Unlock()
+		return fmt.Errorf(
+			"unable to promote service version: no service is registered for channel \"%s\"", serviceChannelName)
+	}
+
+	sw.lock.Lock()
+	if sw.version == nil {
+		sw.lock.Unlock()
+		r.lock.Unlock()
+		return fmt.Errorf(
+			"unable to promote service version: no second version is registered for channel \"%s\"",
+			serviceChannelName)
+	}
+	oldService, oldInFlight := sw.service, sw.inFlight
+	newService := sw.version.service
+	sw.service, sw.fabricCore, sw.inFlight = sw.version.service, sw.version.core, sw.version.inFlight
+	sw.version = nil
+	sw.lock.Unlock()
+	r.lock.Unlock()
+	if sw.cache != nil {
+		sw.cache.invalidate("")
+	}
+
+	go r.retireServiceInstance(serviceChannelName, oldService, oldInFlight)
+	return r.replaceRESTBridges(serviceChannelName, oldService, newService)
+}
+
+// UnregisterServiceVersion removes the second version of the fabric service registered at the given channel.
+// all requests are delivered to the registered version again and the removed version is drained and shut down
+// in the background.
+func (r *serviceRegistry) UnregisterServiceVersion(serviceChannelName string) error {
+	r.lock.Lock()
+	sw, ok := r.services[serviceChannelName]
+	if !ok {
+		r.lock.Unlock()
+		return fmt.Errorf(
+			"unable to unregister service version: no service is registered for channel \"%s\"", serviceChannelName)
+	}
+
+	sw.lock.Lock()
+	version := sw.version
+	sw.version = nil
+	sw.lock.Unlock()
+	r.lock.Unlock()
+
+	if version == nil {
+		return fmt.Errorf(
+			"unable to unregister service version: no second version is registered for channel \"%s\"",
+			serviceChannelName)
+	}
+	go r.retireServiceInstance(serviceChannelName, version.service, version.inFlight)
+	return nil
+}
+
+// initServiceInstance initializes a new instance of a service and waits until it's ready to handle requests.
+func (r *serviceRegistry) initServiceInstance(service FabricService, core *fabricCore) error {
+	if initializationService, ok := service.(FabricInitializableService); ok {
+		if err := initializationService.Init(core); err != nil {
+			return err
+		}
+	}
+	if hooks, ok := service.(ServiceLifecycleHookEnabled); ok {
+		readyChan := hooks.OnServiceReady()
+		ready := <-readyChan
+		close(readyChan)
+		if !ready {
+			return fmt.Errorf("service failed to become ready")
+		}
+	}
+	return nil
+}
+
+// retireServiceInstance waits for the in-flight requests of a service instance no longer receiving
+// requests to complete and then calls its OnServerShutdown hook.
+func (r *serviceRegistry) retireServiceInstance(
+	serviceChannelName string, service FabricService, inFlight *sync.WaitGroup) {
+
+	drained := make(chan struct{})
+	go func() {
+		inFlight.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+	case <-time.After(serviceDrainTimeout):
+		log.Printf("in-flight requests of the service at channel '%s' did not complete in %s",
+			serviceChannelName, serviceDrainTimeout.String())
+	}
+
+	if hooks, ok := service.(ServiceLifecycleHookEnabled); ok {
+		hooks.OnServerShutdown()
+	}
+}
+
+// replaceRESTBridges replaces the REST bridges of the old service with the ones of the new service.
+func (r *serviceRegistry) replaceRESTBridges(serviceChannelName string, oldService, newService FabricService) error {
+	_, oldHasHooks := oldService.(ServiceLifecycleHookEnabled)
+	newHooks, newHasHooks := newService.(ServiceLifecycleHookEnabled)
+	if !oldHasHooks && !newHasHooks {
+		return nil
+	}
+
+	var config []*RESTBridgeConfig
+	if newHasHooks {
+		// the new service is ready already, make sure it's not waited for again.
+		r.bus.GetStoreManager().GetStore(ServiceReadyStore).Put(serviceChannelName, true, ServiceInitStateChange)
+		config = newHooks.GetRESTBridgeConfig()
+	}
+
+	return r.bus.SendResponseMessage(
+		LifecycleManagerChannelName,
+		&SetupRESTBridgeRequest{ServiceChannel: serviceChannelName, Override: true, Config: config},
+		r.bus.GetId())
+}
@@ -0,0 +1,241 @@
+// Copyright 2019-2021 VMware, Inc.
+// SPDX-License-Identifier: BSD-2-Clause
+
+package service
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/vmware/transport-go/model"
+	"sync"
+	"testing"
+	"time"
+)
+
+type versionedFabricService struct {
+	version  string
+	release  chan bool
+	started  chan bool
+	shutdown bool
+	lock     sync.Mutex
+}
+
+func (fs *versionedFabricService) HandleServiceRequest(request *model.Request, core FabricServiceCore) {
+	if fs.started != nil {
+		fs.started <- true
+	}
+	if fs.release != nil {
+		<-fs.release
+	}
+	core.SendResponse(request, fs.version)
+}
+
+func (fs *versionedFabricService) OnServiceReady() chan bool {
+	readyChan := make(chan bool, 1)
+	readyChan <- true
+	return readyChan
+}
+
+func (fs *versionedFabricService) OnServerShutdown() {
+	fs.lock.Lock()
+	fs.shutdown = true
+	fs.lock.Unlock()
+}
+
+func (fs *versionedFabricService) isShutdown() bool {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	return fs.shutdown
+}
+
+func (fs *versionedFabricService) GetRESTBridgeConfig() []*RESTBridgeConfig {
+	return []*RESTBridgeConfig{{ServiceChannel: "versioned-service", Uri: "/rest/" + fs.version}}
+}
+
+type slowReadyFabricService struct {
+	versionedFabricService
+	ready chan bool
+}
+
+func (fs *slowReadyFabricService) OnServiceReady() chan bool {
+	readyChan := make(chan bool, 1)
+	go func() {
+		readyChan <- <-fs.ready
+	}()
+	return readyChan
+}
+
+func newReplacementTestServiceRegistry() *serviceRegistry {
+	registry := newTestServiceRegistry()
+	registry.lifecycleManager = newTestServiceLifecycleManager(registry).(*serviceLifecycleManager)
+	return registry
+}
+
+func sendVersionedRequest(t *testing.T, registry *serviceRegistry, headers map[string]string) string {
+	id := uuid.New()
+	mh, _ := registry.bus.ListenOnce("versioned-service")
+	responseChan := make(chan string, 1)
+	mh.Handle(func(message *model.Message) {
+		responseChan <- message.Payload.(*model.Response).Payload.(string)
+	}, func(e error) {})
+	registry.bus.SendRequestMessage("versioned-service", &model.Request{Id: &id, Headers: headers}, &id)
+
+	select {
+	case version := <-responseChan:
+		return version
+	case <-time.After(2 * time.Second):
+		assert.Fail(t, "no response received")
+		return ""
+	}
+}
+
+func TestServiceRegistry_ReplaceService(t *testing.T) {
+	registry := newReplacementTestServiceRegistry()
+	v1 := &versionedFabricService{version: "v1", started: make(chan bool, 1), release: make(chan bool)}
+	assert.Nil(t, registry.RegisterService(v1, "versioned-service"))
+
+	bridgeRequests := make(chan *SetupRESTBridgeRequest, 1)
+	mh, _ := registry.bus.ListenStreamForDestination(LifecycleManagerChannelName, registry.bus.GetId())
+	mh.Handle(func(message *model.Message) {
+		bridgeRequests <- message.Payload.(*SetupRESTBridgeRequest)
+	}, func(e error) {})
+
+	// keep a request in-flight in the old version
+	id := uuid.New()
+	registry.bus.SendRequestMessage("versioned-service", &model.Request{Id: &id}, &id)
+	<-v1.started
+
+	v2 := &versionedFabricService{version: "v2"}
+	replaced := make(chan error, 1)
+	go func() {
+		replaced <- registry.ReplaceService("versioned-service", v2)
+	}()
+
+	// new requests are handled by the new version while the old one is draining
+	assert.Eventually(t, func() bool {
+		svc, _ := registry.GetService("versioned-service")
+		return svc == v2
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, "v2", sendVersionedRequest(t, registry, nil))
+	assert.False(t, v1.isShutdown())
+
+	// the registry is not locked while the old version is draining
+	assert.EqualError(t, registry.UnregisterServiceVersion("versioned-service"),
+		"unable to unregister service version: no second version is registered for channel \"versioned-service\"")
+
+	// the replacement doesn't wait for the old version to drain
+	assert.Nil(t, <-replaced)
+	assert.False(t, v1.isShutdown())
+
+	v1.release <- true
+	assert.Eventually(t, v1.isShutdown, time.Second, time.Millisecond)
+	assert.False(t, v2.isShutdown())
+
+	bridgeRequest := <-bridgeRequests
+	assert.True(t, bridgeRequest.Override)
+	assert.Equal(t, "versioned-service", bridgeRequest.ServiceChannel)
+	assert.Equal(t, "/rest/v2", bridgeRequest.Config[0].Uri)
+	ready, _ := registry.bus.GetStoreManager().GetStore(ServiceReadyStore).Get("versioned-service")
+	assert.Equal(t, true, ready)
+
+	assert.EqualError(t, registry.ReplaceService("versioned-service", nil),
+		"unable to replace service: nil service")
+	assert.EqualError(t, registry.ReplaceService("missing-service", v2),
+		"unable to replace service: no service is registered for channel \"missing-service\"")
+	assert.EqualError(t, registry.ReplaceService("versioned-service", &mockInitializableService{initError: errors.New("init failed")}),
+		"unable to replace service: init failed")
+
+	svc, _ := registry.GetService("versioned-service")
+	assert.Equal(t, v2, svc)
+}
+
+func TestServiceRegistry_ServiceVersions(t *testing.T) {
+	registry := newReplacementTestServiceRegistry()
+	v1 := &versionedFabricService{version: "v1"}
+	v2 := &versionedFabricService{version: "v2"}
+	assert.Nil(t, registry.RegisterService(v1, "versioned-service"))
+
+	assert.EqualError(t, registry.RegisterServiceVersion("versioned-service", v2, nil),
+		"unable to register service version: nil traffic split")
+	assert.EqualError(t, registry.PromoteServiceVersion("versioned-service"),
+		"unable to promote service version: no second version is registered for channel \"versioned-service\"")
+
+	// header based split
+	assert.Nil(t, registry.RegisterServiceVersion("versioned-service", v2,
+		&TrafficSplit{Header: "X-Version", HeaderValue: "v2"}))
+	assert.EqualError(t, registry.RegisterServiceVersion("versioned-service", v2, &TrafficSplit{}),
+		"unable to register service version: a second version is already registered for channel \"versioned-service\"")
+	assert.Equal(t, "v1", sendVersionedRequest(t, registry, nil))
+	assert.Equal(t, "v1", sendVersionedRequest(t, registry, map[string]string{"X-Version": "v1"}))
+	assert.Equal(t, "v2", sendVersionedRequest(t, registry, map[string]string{"X-Version": "v2"}))
+
+	assert.Nil(t, registry.UnregisterServiceVersion("versioned-service"))
+	assert.Eventually(t, v2.isShutdown, time.Second, time.Millisecond)
+	assert.False(t, v1.isShutdown())
+	assert.Equal(t, "v1", sendVersionedRequest(t, registry, map[string]string{"X-Version": "v2"}))
+	assert.EqualError(t, registry.UnregisterServiceVersion("versioned-service"),
+		"unable to unregister service version: no second version is registered for channel \"versioned-service\"")
+
+	// percentage based split
+	v3 := &versionedFabricService{version: "v3"}
+	assert.Nil(t, registry.RegisterServiceVersion("versioned-service", v3, &TrafficSplit{Percentage: 100}))
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, "v3", sendVersionedRequest(t, registry, nil))
+	}
+
+	assert.Nil(t, registry.PromoteServiceVersion("versioned-service"))
+	assert.Eventually(t, v1.isShutdown, time.Second, time.Millisecond)
+	assert.False(t, v3.isShutdown())
+	svc, _ := registry.GetService("versioned-service")
+	assert.Equal(t, v3, svc)
+	assert.Equal(t, "v3", sendVersionedRequest(t, registry, nil))
+}
+
+func TestTrafficSplit_Matches(t *testing.T) {
+	noHeaders := &model.Request{}
+	assert.False(t, (&TrafficSplit{Percentage: 0}).matches(noHeaders))
+	assert.True(t, (&TrafficSplit{Percentage: 100}).matches(noHeaders))
+	assert.False(t, (&TrafficSplit{Header: "X-Canary", HeaderValue: "true"}).matches(noHeaders))
+	assert.True(t, (&TrafficSplit{Header: "X-Canary", HeaderValue: "true"}).matches(
+		&model.Request{Headers: map[string]string{"X-Canary": "true"}}))
+
+	// header names are matched case-insensitively
+	assert.True(t, (&TrafficSplit{Header: "x-canary", HeaderValue: "true"}).matches(
+		&model.Request{Headers: map[string]string{"X-Canary": "true"}}))
+	assert.True(t, (&TrafficSplit{Header: "X-Canary", HeaderValue: "true"}).matches(
+		&model.Request{Headers: map[string]string{"x-canary": "true"}}))
+	assert.False(t, (&TrafficSplit{Header: "X-Canary", HeaderValue: "true"}).matches(
+		&model.Request{Headers: map[string]string{"X-Canary": "TRUE"}}))
+
+	matched := 0
+	split := &TrafficSplit{Percentage: 50}
+	for i := 0; i < 1000; i++ {
+		if split.matches(noHeaders) {
+			matched++
+		}
+	}
+	assert.InDelta(t, 500, matched, 150)
+}
+
+func TestServiceRegistry_RegisterServiceVersionDoesNotLockRegistry(t *testing.T) {
+	registry := newReplacementTestServiceRegistry()
+	assert.Nil(t, registry.RegisterService(&versionedFabricService{version: "v1"}, "versioned-service"))
+
+	v2 := &slowReadyFabricService{
+		versionedFabricService: versionedFabricService{version: "v2"}, ready: make(chan bool)}
+	registered := make(chan error, 1)
+	go func() {
+		registered <- registry.RegisterServiceVersion("versioned-service", v2, &TrafficSplit{Percentage: 100})
+	}()
+
+	// the registry can be used while the version initializes
+	assert.Eventually(t, func() bool {
+		return len(registry.GetAllServiceChannels()) == 1
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, "v1", sendVersionedRequest(t, registry, nil))
+
+	v2.ready <- true
+	assert.Nil(t, <-registered)
+	assert.Equal(t, "v2", sendVersionedRequest(t, registry, nil))
+}
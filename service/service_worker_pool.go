@@ -0,0 +1,157 @@
+// Copyright 2019-2021 VMware, Inc.
+// SPDX-License-Identifier: BSD-2-Clause
+
+package service
+
+import (
+	"fmt"
+	"github.com/vmware/transport-go/model"
+	"hash/fnv"
+	"runtime"
+	"sync"
+)
+
+// DefaultServiceQueueDepth is the number of requests waiting for a free worker used
+// when ServiceOptions.QueueDepth is not set.
+const DefaultServiceQueueDepth = 1000
+
+// ServiceOptions controls how requests are delivered to a fabric service registered
+// with ServiceRegistry.RegisterServiceWithOptions.
+type ServiceOptions struct {
+	// MaxConcurrentRequests is the number of workers handling the requests of the service. Zero
+	// (the default) keeps the unbounded behavior, every request is handled in its own goroutine.
+	MaxConcurrentRequests int
+
+	// QueueDepth is the maximum number of requests waiting for a free worker, requests arriving
+	// when the queue is full, or still queued when the service is unregistered, are rejected with
+	// a 503 error response. Requests with a serialization key are queued per worker. Defaults to
+	// DefaultServiceQueueDepth.
+	QueueDepth int
+
+	// SerializationKey optionally returns a key for the request. Requests with the same non-empty key
+	// are handled one at a time, in the order in which they were sent to the service channel, e.g. all
+	// requests for the same entity ID. If MaxConcurrentRequests is not set runtime.NumCPU() workers are used.
+	SerializationKey func(request *model.Request) string
+
+	// Cache optionally enables caching of the responses of the service.
+	Cache *ServiceCacheOptions
+}
+
+type queuedRequest struct {
+	request *model.Request
+	handler func(request *model.Request)
+}
+
+// serviceWorkerPool is a fixed set of workers handling the requests of a single service.
+type serviceWorkerPool struct {
+	options      ServiceOptions
+	sharedQueue  chan *queuedRequest
+	workerQueues []chan *queuedRequest
+	done         chan struct{}
+	// guards queueing requests against stopping the pool, so that no request is left in the queues
+	lock    sync.RWMutex
+	stopped bool
+}
+
+// newServiceWorkerPool creates and starts a worker pool for the given options, returns nil if
+// the options don't require one.
+func newServiceWorkerPool(options *ServiceOptions) *serviceWorkerPool {
+	if options == nil || (options.MaxConcurrentRequests <= 0 && options.SerializationKey == nil) {
+		return nil
+	}
+
+	pool := &serviceWorkerPool{
+		options: *options,
+		done:    make(chan struct{}),
+	}
+	if pool.options.MaxConcurrentRequests <= 0 {
+		pool.options.MaxConcurrentRequests = runtime.NumCPU()
+	}
+	if pool.options.QueueDepth <= 0 {
+		pool.options.QueueDepth = DefaultServiceQueueDepth
+	}
+
+	pool.sharedQueue = make(chan *queuedRequest, pool.options.QueueDepth)
+	pool.workerQueues = make([]chan *queuedRequest, pool.options.MaxConcurrentRequests)
+	for i := range pool.workerQueues {
+		pool.workerQueues[i] = make(chan *queuedRequest, pool.options.QueueDepth)
+		go pool.runWorker(pool.workerQueues[i])
+	}
+	return pool
+}
+
+func (pool *serviceWorkerPool) runWorker(workerQueue chan *queuedRequest) {
+	for {
+		// requests from the worker queue are always picked up first to keep
+		// the keyed requests from waiting behind the shared ones.
+		select {
+		case qr := <-workerQueue:
+			qr.handler(qr.request)
+			continue
+		default:
+		}
+
+		select {
+		case <-pool.done:
+			return
+		case qr := <-workerQueue:
+			qr.handler(qr.request)
+		case qr := <-pool.sharedQueue:
+			qr.handler(qr.request)
+		}
+	}
+}
+
+// submit queues the request for the workers. Returns an error if the queue is full or
+// the pool is stopped.
+func (pool *serviceWorkerPool) submit(request *model.Request, handler func(request *model.Request)) error {
+	queue := pool.sharedQueue
+	if pool.options.SerializationKey != nil {
+		if key := pool.options.SerializationKey(request); key != "" {
+			queue = pool.workerQueues[pool.getWorkerIndex(key)]
+		}
+	}
+
+	pool.lock.RLock()
+	defer pool.lock.RUnlock()
+	if pool.stopped {
+		return fmt.Errorf("service is not accepting requests")
+	}
+
+	select {
+	case queue <- &queuedRequest{request: request, handler: handler}:
+		return nil
+	default:
+		return fmt.Errorf("service is busy, request queue is full")
+	}
+}
+
+func (pool *serviceWorkerPool) getWorkerIndex(key string) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(len(pool.workerQueues)))
+}
+
+// stop stops the workers once they complete the requests they are handling and passes
+// the requests still waiting in the queues to reject.
+func (pool *serviceWorkerPool) stop(reject func(request *model.Request)) {
+	pool.lock.Lock()
+	if pool.stopped {
+		pool.lock.Unlock()
+		return
+	}
+	pool.stopped = true
+	close(pool.done)
+	pool.lock.Unlock()
+
+	for _, queue := range append([]chan *queuedRequest{pool.sharedQueue}, pool.workerQueues...) {
+		for drained := false; !drained; {
+			select {
+			case qr := <-queue:
+				reject(qr.request)
+			default:
+				drained = true
+			}
+		}
+	}
+}
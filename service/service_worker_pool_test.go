@@ -0,0 +1,270 @@
+// Copyright 2019-2021 VMware, Inc.
+// SPDX-License-Identifier: BSD-2-Clause
+
+package service
+
+import (
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/vmware/transport-go/model"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type blockingFabricService struct {
+	release   chan bool
+	running   int32
+	maxActive int32
+	lock      sync.Mutex
+	handled   []string
+}
+
+func (fs *blockingFabricService) HandleServiceRequest(request *model.Request, core FabricServiceCore) {
+	active := atomic.AddInt32(&fs.running, 1)
+	for {
+		max := atomic.LoadInt32(&fs.maxActive)
+		if active <= max || atomic.CompareAndSwapInt32(&fs.maxActive, max, active) {
+			break
+		}
+	}
+	<-fs.release
+	atomic.AddInt32(&fs.running, -1)
+
+	fs.lock.Lock()
+	fs.handled = append(fs.handled, request.Payload.(string))
+	fs.lock.Unlock()
+	core.SendResponse(request, request.Payload)
+}
+
+func (fs *blockingFabricService) getHandled() []string {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	return append([]string(nil), fs.handled...)
+}
+
+func sendWorkerPoolRequest(registry *serviceRegistry, payload string) {
+	id := uuid.New()
+	registry.bus.SendRequestMessage("test-channel", &model.Request{Id: &id, Request: "test", Payload: payload}, nil)
+}
+
+func TestServiceWorkerPool_MaxConcurrentRequests(t *testing.T) {
+	registry := newTestServiceRegistry()
+	svc := &blockingFabricService{release: make(chan bool)}
+	assert.Nil(t, registry.RegisterServiceWithOptions(svc, "test-channel",
+		&ServiceOptions{MaxConcurrentRequests: 2, QueueDepth: 1}))
+
+	var errorCodes []int
+	var lock sync.Mutex
+	mh, _ := registry.bus.ListenStream("test-channel")
+	mh.Handle(func(message *model.Message) {
+		resp := message.Payload.(*model.Response)
+		if resp.Error {
+			lock.Lock()
+			errorCodes = append(errorCodes, resp.ErrorCode)
+			lock.Unlock()
+		}
+	}, func(e error) {})
+	defer mh.Close()
+
+	// two requests are handled, one is waiting in the queue
+	for i := 0; i < 3; i++ {
+		sendWorkerPoolRequest(registry, "request")
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&svc.running) == 2
+	}, time.Second, 5*time.Millisecond)
+
+	// the queue is full, the request is rejected
+	sendWorkerPoolRequest(registry, "rejected")
+	assert.Eventually(t, func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return len(errorCodes) == 1 && errorCodes[0] == 503
+	}, time.Second, 5*time.Millisecond)
+
+	for i := 0; i < 3; i++ {
+		svc.release <- true
+	}
+	assert.Eventually(t, func() bool {
+		return len(svc.getHandled()) == 3
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&svc.maxActive))
+
+	assert.Nil(t, registry.UnregisterService("test-channel"))
+}
+
+func TestServiceWorkerPool_SerializationKey(t *testing.T) {
+	registry := newTestServiceRegistry()
+	svc := &blockingFabricService{release: make(chan bool, 100)}
+	assert.Nil(t, registry.RegisterServiceWithOptions(svc, "test-channel", &ServiceOptions{
+		MaxConcurrentRequests: 4,
+		SerializationKey: func(request *model.Request) string {
+			return "entity-1"
+		},
+	}))
+
+	for i := 0; i < 3; i++ {
+		sendWorkerPoolRequest(registry, "request")
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// all requests have the same key, only one of them is handled at a time
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&svc.running) == 1
+	}, time.Second, 5*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&svc.running))
+
+	for i := 0; i < 3; i++ {
+		svc.release <- true
+	}
+	assert.Eventually(t, func() bool {
+		return len(svc.getHandled()) == 3
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&svc.maxActive))
+
+	assert.Nil(t, registry.UnregisterService("test-channel"))
+}
+
+type keyedFabricService struct {
+	lock        sync.Mutex
+	active      map[string]int
+	order       map[string][]string
+	overlapping bool
+	concurrent  bool
+	handled     int32
+}
+
+func (fs *keyedFabricService) HandleServiceRequest(request *model.Request, core FabricServiceCore) {
+	key := request.Payload.(string)
+	fs.lock.Lock()
+	fs.order[key] = append(fs.order[key], request.Request)
+	fs.active[key]++
+	if fs.active[key] > 1 {
+		fs.overlapping = true
+	}
+	if len(fs.active) > 1 {
+		fs.concurrent = true
+	}
+	fs.lock.Unlock()
+
+	time.Sleep(2 * time.Millisecond)
+
+	fs.lock.Lock()
+	if fs.active[key]--; fs.active[key] == 0 {
+		delete(fs.active, key)
+	}
+	fs.lock.Unlock()
+	atomic.AddInt32(&fs.handled, 1)
+}
+
+func TestServiceWorkerPool_KeyedRequestsAreOrdered(t *testing.T) {
+	pool := newServiceWorkerPool(&ServiceOptions{
+		MaxConcurrentRequests: 3,
+		SerializationKey: func(request *model.Request) string {
+			return request.Request
+		},
+	})
+	defer pool.stop(func(request *model.Request) {})
+
+	var lock sync.Mutex
+	handled := make(map[string][]int)
+	wg := sync.WaitGroup{}
+	handler := func(request *model.Request) {
+		lock.Lock()
+		handled[request.Request] = append(handled[request.Request], request.Payload.(int))
+		lock.Unlock()
+		wg.Done()
+	}
+
+	for i := 0; i < 20; i++ {
+		for _, key := range []string{"a", "b", "c"} {
+			wg.Add(1)
+			assert.Nil(t, pool.submit(&model.Request{Request: key, Payload: i}, handler))
+		}
+	}
+	wg.Wait()
+
+	for _, key := range []string{"a", "b", "c"} {
+		assert.Len(t, handled[key], 20)
+		for i, value := range handled[key] {
+			assert.Equal(t, i, value)
+		}
+	}
+}
+
+func TestServiceWorkerPool_KeyedRequestsAreMutuallyExclusive(t *testing.T) {
+	registry := newTestServiceRegistry()
+	svc := &keyedFabricService{active: make(map[string]int), order: make(map[string][]string)}
+	assert.Nil(t, registry.RegisterServiceWithOptions(svc, "test-channel", &ServiceOptions{
+		MaxConcurrentRequests: 3,
+		SerializationKey: func(request *model.Request) string {
+			return request.Payload.(string)
+		},
+	}))
+
+	for i := 0; i < 20; i++ {
+		for _, key := range []string{"a", "b", "c"} {
+			id := uuid.New()
+			registry.bus.SendRequestMessage("test-channel",
+				&model.Request{Id: &id, Request: strconv.Itoa(i), Payload: key}, nil)
+		}
+	}
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&svc.handled) == 60
+	}, 5*time.Second, 5*time.Millisecond)
+
+	svc.lock.Lock()
+	defer svc.lock.Unlock()
+	assert.False(t, svc.overlapping)
+	assert.True(t, svc.concurrent)
+
+	// the requests with the same key are handled in the order they were sent
+	for _, key := range []string{"a", "b", "c"} {
+		assert.Len(t, svc.order[key], 20)
+		for i, value := range svc.order[key] {
+			assert.Equal(t, strconv.Itoa(i), value)
+		}
+	}
+
+	assert.Nil(t, registry.UnregisterService("test-channel"))
+}
+
+func TestServiceWorkerPool_DefaultQueueDepth(t *testing.T) {
+	pool := newServiceWorkerPool(&ServiceOptions{MaxConcurrentRequests: 1})
+	assert.Equal(t, DefaultServiceQueueDepth, pool.options.QueueDepth)
+
+	release := make(chan bool)
+	blocking := func(request *model.Request) { <-release }
+	defer close(release)
+
+	// the queued requests are rejected when the pool stops
+	rejected := 0
+	defer func() {
+		pool.stop(func(request *model.Request) { rejected++ })
+		assert.Equal(t, DefaultServiceQueueDepth, rejected)
+	}()
+
+	// one request is handled by the worker, the rest fill the queue
+	assert.Nil(t, pool.submit(&model.Request{}, blocking))
+	assert.Eventually(t, func() bool { return len(pool.sharedQueue) == 0 }, time.Second, time.Millisecond)
+	for i := 0; i < DefaultServiceQueueDepth; i++ {
+		assert.Nil(t, pool.submit(&model.Request{}, blocking))
+	}
+	assert.EqualError(t, pool.submit(&model.Request{}, blocking), "service is busy, request queue is full")
+}
+
+func TestServiceWorkerPool_Stop(t *testing.T) {
+	assert.Nil(t, newServiceWorkerPool(nil))
+	assert.Nil(t, newServiceWorkerPool(&ServiceOptions{QueueDepth: 10}))
+
+	pool := newServiceWorkerPool(&ServiceOptions{MaxConcurrentRequests: 1})
+	pool.stop(func(request *model.Request) {})
+	pool.stop(func(request *model.Request) {})
+	assert.EqualError(t, pool.submit(&model.Request{}, func(request *model.Request) {}),
+		"service is not accepting requests")
+}
@@ -5,18 +5,40 @@ package stompserver
 
 import "strings"
 
+// DefaultHeartBeatGraceMultiplier is used when no (or an invalid) grace multiplier is configured.
+// With the default multiplier a connection times out as soon as the negotiated client heart-beat
+// interval passes without any data received.
+const DefaultHeartBeatGraceMultiplier = 1.0
+
 type StompConfig interface {
 	HeartBeat() int64
+	// HeartBeatGraceMultiplier returns the multiplier applied to the negotiated client heart-beat
+	// interval to get the read timeout after which the connection is considered dead.
+	HeartBeatGraceMultiplier() float64
 	AppDestinationPrefix() []string
 	IsAppRequestDestination(destination string) bool
 }
 
 type stompConfig struct {
-	heartbeat     int64
-	appDestPrefix []string
+	heartbeat      int64
+	heartbeatGrace float64
+	appDestPrefix  []string
 }
 
 func NewStompConfig(heartBeatMs int64, appDestinationPrefix []string) StompConfig {
+	return NewStompConfigWithHeartBeatGrace(heartBeatMs, DefaultHeartBeatGraceMultiplier, appDestinationPrefix)
+}
+
+// NewStompConfigWithHeartBeatGrace creates a new StompConfig which tolerates client heart-beats
+// arriving up to graceMultiplier times the negotiated interval. Values lower than 1 are replaced
+// with DefaultHeartBeatGraceMultiplier.
+func NewStompConfigWithHeartBeatGrace(
+	heartBeatMs int64, graceMultiplier float64, appDestinationPrefix []string) StompConfig {
+
+	if graceMultiplier < 1 {
+		graceMultiplier = DefaultHeartBeatGraceMultiplier
+	}
+
 	prefixes := make([]string, len(appDestinationPrefix))
 	for i := 0; i < len(appDestinationPrefix); i++ {
 		if appDestinationPrefix[i] != "" && !strings.HasSuffix(appDestinationPrefix[i], "/") {
@@ -27,8 +49,9 @@ func NewStompConfig(heartBeatMs int64, appDestinationPrefix []string) StompConfi
 	}
 
 	return &stompConfig{
-		heartbeat:     heartBeatMs,
-		appDestPrefix: prefixes,
+		heartbeat:      heartBeatMs,
+		heartbeatGrace: graceMultiplier,
+		appDestPrefix:  prefixes,
 	}
 }
 
@@ -36,6 +59,10 @@ func (c *stompConfig) HeartBeat() int64 {
 	return c.heartbeat
 }
 
+func (c *stompConfig) HeartBeatGraceMultiplier() float64 {
+	return c.heartbeatGrace
+}
+
 func (c *stompConfig) AppDestinationPrefix() []string {
 	return c.appDestPrefix
 }
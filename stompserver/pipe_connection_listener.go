@@ -0,0 +1,61 @@
+// Copyright 2019-2021 VMware, Inc.
+// SPDX-License-Identifier: BSD-2-Clause
+
+package stompserver
+
+import (
+	"fmt"
+	"net"
+	"sync"
+)
+
+// PipeConnectionListener is an in-memory RawConnectionListener. Clients connect to it
+// by calling Dial, which returns the client side of a synchronous net.Pipe. No ports or
+// files are opened, which makes it a good fit for tests and embedded tools.
+type PipeConnectionListener interface {
+	RawConnectionListener
+	// Dial creates a new in-memory connection to the listener and returns the client end of it.
+	Dial() (net.Conn, error)
+}
+
+type pipeConnectionListener struct {
+	connections chan net.Conn
+	closed      chan struct{}
+	closeOnce   sync.Once
+}
+
+// NewPipeConnectionListener creates a new in-memory connection listener.
+func NewPipeConnectionListener() PipeConnectionListener {
+	return &pipeConnectionListener{
+		connections: make(chan net.Conn),
+		closed:      make(chan struct{}),
+	}
+}
+
+func (l *pipeConnectionListener) Dial() (net.Conn, error) {
+	serverConn, clientConn := net.Pipe()
+	select {
+	case l.connections <- serverConn:
+		return clientConn, nil
+	case <-l.closed:
+		serverConn.Close()
+		clientConn.Close()
+		return nil, fmt.Errorf("pipe listener is closed")
+	}
+}
+
+func (l *pipeConnectionListener) Accept() (RawConnection, error) {
+	select {
+	case conn := <-l.connections:
+		return newTcpStompConnection(conn), nil
+	case <-l.closed:
+		return nil, fmt.Errorf("pipe listener is closed")
+	}
+}
+
+func (l *pipeConnectionListener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.closed)
+	})
+	return nil
+}
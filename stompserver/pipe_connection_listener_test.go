@@ -0,0 +1,67 @@
+// Copyright 2019-2021 VMware, Inc.
+// SPDX-License-Identifier: BSD-2-Clause
+
+package stompserver
+
+import (
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestPipeConnectionListener_DialAndAccept(t *testing.T) {
+	listener := NewPipeConnectionListener()
+
+	rawConChan := make(chan RawConnection)
+	go func() {
+		rawCon, err := listener.Accept()
+		assert.Nil(t, err)
+		rawConChan <- rawCon
+	}()
+
+	clientConn, err := listener.Dial()
+	assert.Nil(t, err)
+	assert.NotNil(t, clientConn)
+
+	rawCon := <-rawConChan
+
+	go func() {
+		wr := frame.NewWriter(clientConn)
+		wr.Write(frame.New(frame.CONNECT, frame.AcceptVersion, "1.2"))
+		wr.Write(frame.New(frame.SUBSCRIBE, frame.Destination, "/topic/test", frame.Id, "sub-1"))
+	}()
+
+	f, readErr := rawCon.ReadFrame()
+	assert.Nil(t, readErr)
+	verifyFrame(t, f, frame.New(frame.CONNECT, frame.AcceptVersion, "1.2"), true)
+
+	f, readErr = rawCon.ReadFrame()
+	assert.Nil(t, readErr)
+	verifyFrame(t, f, frame.New(frame.SUBSCRIBE, frame.Destination, "/topic/test", frame.Id, "sub-1"), true)
+
+	go rawCon.WriteFrame(frame.New(frame.CONNECTED, frame.Version, "1.2"))
+
+	serverFrame, err := frame.NewReader(clientConn).Read()
+	assert.Nil(t, err)
+	verifyFrame(t, serverFrame, frame.New(frame.CONNECTED, frame.Version, "1.2"), true)
+
+	assert.Nil(t, rawCon.Close())
+	n, _ := clientConn.Read(make([]byte, 1))
+	assert.Equal(t, n, 0)
+}
+
+func TestPipeConnectionListener_Close(t *testing.T) {
+	listener := NewPipeConnectionListener()
+
+	assert.Nil(t, listener.Close())
+	// closing the listener twice is safe
+	assert.Nil(t, listener.Close())
+
+	rawCon, err := listener.Accept()
+	assert.Nil(t, rawCon)
+	assert.EqualError(t, err, "pipe listener is closed")
+
+	clientConn, err := listener.Dial()
+	assert.Nil(t, clientConn)
+	assert.EqualError(t, err, "pipe listener is closed")
+}
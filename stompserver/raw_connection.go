@@ -4,7 +4,9 @@
 package stompserver
 
 import (
+	"context"
 	"github.com/go-stomp/stomp/v3/frame"
+	"net"
 	"time"
 )
 
@@ -19,6 +21,19 @@ type RawConnection interface {
 	Close() error
 }
 
+// RemoteAddrProvider is implemented by raw connections which know the network address of the client
+type RemoteAddrProvider interface {
+	// Returns the network address of the client
+	RemoteAddr() net.Addr
+}
+
+// RequestContextProvider is implemented by raw connections established by an HTTP request, like WebSocket
+// connections, which keep the context of the request
+type RequestContextProvider interface {
+	// Returns the context of the HTTP request the connection was established with
+	RequestContext() context.Context
+}
+
 type RawConnectionListener interface {
 	// Blocks until a new RawConnection is established.
 	Accept() (RawConnection, error)
@@ -4,10 +4,22 @@
 package stompserver
 
 import (
+	"context"
 	"github.com/go-stomp/stomp/v3/frame"
 	"log"
 	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// ServerShutdownDestination is the system destination on which clients are notified
+	// that the server is draining and is about to close all connections.
+	ServerShutdownDestination = "/system/server-shutdown"
+
+	// interval in which Drain() checks whether all in-flight frames are processed
+	drainPollInterval = 10 * time.Millisecond
 )
 
 type SubscribeHandlerFunction func(conId string, subId string, destination string, frame *frame.Frame)
@@ -16,11 +28,31 @@ type UnsubscribeHandlerFunction func(conId string, subId string, destination str
 
 type ApplicationRequestHandlerFunction func(destination string, message []byte, connectionId string)
 
+type ApplicationRequestFrameHandlerFunction func(destination string, frame *frame.Frame, connectionId string)
+
+// FrameDirection tells whether a frame was received from or sent to a client
+type FrameDirection int
+
+const (
+	FrameReceived FrameDirection = iota
+	FrameSent
+)
+
+type FrameHandlerFunction func(connectionId string, frame *frame.Frame, direction FrameDirection)
+
+// IdleCheckFunction reports whether the application has finished processing the requests it received
+// from the clients, e.g. whether all SEND frames bridged to the application have been answered.
+type IdleCheckFunction func() bool
+
 type StompServer interface {
 	// starts the server
 	Start()
 	// stops the server
 	Stop()
+	// stops accepting new connections, notifies the connected clients on the ServerShutdownDestination
+	// and waits for all in-flight frames to be processed before stopping the server.
+	// Returns the context error if the context is done before all connections become idle.
+	Drain(ctx context.Context) error
 	// sends a message to a given stomp topic destination
 	SendMessage(destination string, messageBody []byte)
 	// sends a message to a single connection client
@@ -31,9 +63,44 @@ type StompServer interface {
 	OnUnsubscribeEvent(callback UnsubscribeHandlerFunction)
 	// registers a callback for application requests
 	OnApplicationRequest(callback ApplicationRequestHandlerFunction)
+	// registers a callback for every frame received from or sent to the clients, heart-beats excluded.
+	// callbacks are invoked from the goroutines of the connections and must not block.
+	OnFrame(callback FrameHandlerFunction)
+	// registers a callback consulted by Drain, which waits until all the callbacks report idle
+	// before closing the connections.
+	OnIdleCheck(callback IdleCheckFunction)
 	// SetConnectionEventCallback is used to set up a callback when certain STOMP session events happen
-	// such as ConnectionStarting, ConnectionClosed, SubscribeToTopic, UnsubscribeFromTopic and IncomingMessage.
+	// such as ConnectionStarting, ConnectionEstablished, ConnectionClosed, ConnectionTimedOut, SubscribeToTopic,
+	// UnsubscribeFromTopic and IncomingMessage.
 	SetConnectionEventCallback(connEventType StompSessionEventType, cb func(connEvent *ConnEvent))
+	// returns the heart-beat monitoring counters of the server
+	GetHeartBeatStats() HeartBeatStats
+}
+
+// ApplicationRequestFrameHandler is implemented by servers which can pass the whole SEND frame of
+// application requests to the application, e.g. to read the trace context from its headers.
+type ApplicationRequestFrameHandler interface {
+	// registers a callback for application requests receiving the whole SEND frame, including its headers
+	OnApplicationRequestFrame(callback ApplicationRequestFrameHandlerFunction)
+}
+
+// MessageFrameSender is implemented by servers which can apply options, e.g. additional headers,
+// to the MESSAGE frames they send to the clients.
+type MessageFrameSender interface {
+	// sends a message to a given stomp topic destination, applying the options to the MESSAGE frame
+	SendMessageWithOptions(destination string, messageBody []byte, opts ...func(*frame.Frame) error)
+	// sends a message to a single connection client, applying the options to the MESSAGE frame
+	SendMessageToClientWithOptions(connectionId string, destination string, messageBody []byte,
+		opts ...func(*frame.Frame) error)
+}
+
+// HeartBeatStats contains the counters collected while monitoring client heart-beats.
+type HeartBeatStats struct {
+	// number of connections dropped because the client stopped sending heart-beats
+	TimedOutConnections uint64
+	// number of client heart-beat intervals which passed without receiving any data,
+	// summed up for all connections, including the open ones
+	MissedHeartBeats uint64
 }
 
 type StompSessionEventType int
@@ -45,15 +112,23 @@ const (
 	SubscribeToTopic
 	UnsubscribeFromTopic
 	IncomingMessage
+	// ConnectionTimedOut is fired when the client stops sending heart-beats and the connection
+	// is dropped because of a read timeout. It is always followed by a ConnectionClosed event.
+	ConnectionTimedOut
 )
 
 type ConnEvent struct {
-	ConnId      string
-	eventType   StompSessionEventType
-	conn        StompConn
-	destination string
-	sub         *subscription
-	frame       *frame.Frame
+	ConnId     string
+	Principal  string // login of the client, populated for ConnectionEstablished events
+	RemoteAddr string // network address of the client, populated for ConnectionEstablished events if known
+	// context of the HTTP request the connection was established with, populated for ConnectionEstablished
+	// events of WebSocket connections
+	RequestContext context.Context
+	eventType      StompSessionEventType
+	conn           StompConn
+	destination    string
+	sub            *subscription
+	frame          *frame.Frame
 }
 
 type apiEventType int
@@ -62,6 +137,7 @@ const (
 	closeServer apiEventType = iota
 	sendMessage
 	sendPrivateMessage
+	checkIdle
 )
 
 type apiEvent struct {
@@ -69,6 +145,7 @@ type apiEvent struct {
 	connId      string
 	frame       *frame.Frame
 	destination string
+	idleReply   chan bool
 }
 
 type connSubscriptions struct {
@@ -88,7 +165,11 @@ type stompServer struct {
 	connectionEvents            chan *ConnEvent
 	connectionEventCallbacks    map[StompSessionEventType]func(event *ConnEvent)
 	apiEvents                   chan *apiEvent
+	runningLock                 sync.RWMutex
 	running                     bool
+	draining                    int32
+	timedOutConnections         uint64
+	missedHeartBeats            uint64
 	connectionsMap              map[string]StompConn
 	subscriptionsMap            map[string]map[string]*connSubscriptions
 	config                      StompConfig
@@ -96,6 +177,9 @@ type stompServer struct {
 	subscribeCallbacks          []SubscribeHandlerFunction
 	unsubscribeCallbacks        []UnsubscribeHandlerFunction
 	applicationRequestCallbacks []ApplicationRequestHandlerFunction
+	applicationFrameCallbacks   []ApplicationRequestFrameHandlerFunction
+	frameCallbacks              []FrameHandlerFunction
+	idleCheckCallbacks          []IdleCheckFunction
 }
 
 func NewStompServer(listener RawConnectionListener, config StompConfig) StompServer {
@@ -110,6 +194,9 @@ func NewStompServer(listener RawConnectionListener, config StompConfig) StompSer
 		subscribeCallbacks:          make([]SubscribeHandlerFunction, 0),
 		unsubscribeCallbacks:        make([]UnsubscribeHandlerFunction, 0),
 		applicationRequestCallbacks: make([]ApplicationRequestHandlerFunction, 0),
+		applicationFrameCallbacks:   make([]ApplicationRequestFrameHandlerFunction, 0),
+		frameCallbacks:              make([]FrameHandlerFunction, 0),
+		idleCheckCallbacks:          make([]IdleCheckFunction, 0),
 	}
 
 	return server
@@ -136,15 +223,46 @@ func (s *stompServer) OnApplicationRequest(callback ApplicationRequestHandlerFun
 	s.applicationRequestCallbacks = append(s.applicationRequestCallbacks, callback)
 }
 
-func (s *stompServer) SendMessage(destination string, messageBody []byte) {
+func (s *stompServer) OnApplicationRequestFrame(callback ApplicationRequestFrameHandlerFunction) {
+	s.callbackLock.Lock()
+	defer s.callbackLock.Unlock()
 
-	// create send frame.
-	f := frame.New(frame.MESSAGE,
-		frame.Destination, destination,
-		frame.ContentLength, strconv.Itoa(len(messageBody)),
-		frame.ContentType, "application/json;charset=UTF-8")
+	s.applicationFrameCallbacks = append(s.applicationFrameCallbacks, callback)
+}
 
-	f.Body = messageBody
+func (s *stompServer) OnFrame(callback FrameHandlerFunction) {
+	s.callbackLock.Lock()
+	defer s.callbackLock.Unlock()
+
+	s.frameCallbacks = append(s.frameCallbacks, callback)
+}
+
+func (s *stompServer) OnIdleCheck(callback IdleCheckFunction) {
+	s.callbackLock.Lock()
+	defer s.callbackLock.Unlock()
+
+	s.idleCheckCallbacks = append(s.idleCheckCallbacks, callback)
+}
+
+func (s *stompServer) notifyFrame(connectionId string, f *frame.Frame, direction FrameDirection) {
+	s.callbackLock.RLock()
+	defer s.callbackLock.RUnlock()
+
+	for _, callback := range s.frameCallbacks {
+		callback(connectionId, f, direction)
+	}
+}
+
+func (s *stompServer) SendMessage(destination string, messageBody []byte) {
+	s.SendMessageWithOptions(destination, messageBody)
+}
+
+func (s *stompServer) SendMessageWithOptions(destination string, messageBody []byte, opts ...func(*frame.Frame) error) {
+	f, err := newMessageFrame(destination, messageBody, opts)
+	if err != nil {
+		log.Printf("unable to send message to %s: %s", destination, err.Error())
+		return
+	}
 
 	s.apiEvents <- &apiEvent{
 		eventType:   sendMessage,
@@ -154,7 +272,27 @@ func (s *stompServer) SendMessage(destination string, messageBody []byte) {
 }
 
 func (s *stompServer) SendMessageToClient(connectionId string, destination string, messageBody []byte) {
+	s.SendMessageToClientWithOptions(connectionId, destination, messageBody)
+}
 
+func (s *stompServer) SendMessageToClientWithOptions(connectionId string, destination string, messageBody []byte,
+	opts ...func(*frame.Frame) error) {
+
+	f, err := newMessageFrame(destination, messageBody, opts)
+	if err != nil {
+		log.Printf("unable to send message to %s: %s", destination, err.Error())
+		return
+	}
+
+	s.apiEvents <- &apiEvent{
+		eventType:   sendPrivateMessage,
+		destination: destination,
+		frame:       f,
+		connId:      connectionId,
+	}
+}
+
+func newMessageFrame(destination string, messageBody []byte, opts []func(*frame.Frame) error) (*frame.Frame, error) {
 	// create send frame.
 	f := frame.New(frame.MESSAGE,
 		frame.Destination, destination,
@@ -163,12 +301,12 @@ func (s *stompServer) SendMessageToClient(connectionId string, destination strin
 
 	f.Body = messageBody
 
-	s.apiEvents <- &apiEvent{
-		eventType:   sendPrivateMessage,
-		destination: destination,
-		frame:       f,
-		connId:      connectionId,
+	for _, opt := range opts {
+		if err := opt(f); err != nil {
+			return nil, err
+		}
 	}
+	return f, nil
 }
 
 func (s *stompServer) SetConnectionEventCallback(connEventType StompSessionEventType, cb func(connEvent *ConnEvent)) {
@@ -178,39 +316,98 @@ func (s *stompServer) SetConnectionEventCallback(connEventType StompSessionEvent
 }
 
 func (s *stompServer) Start() {
+	s.runningLock.Lock()
 	if s.running {
+		s.runningLock.Unlock()
 		return
 	}
-
 	s.running = true
+	s.runningLock.Unlock()
+
 	go s.waitForConnections()
 	s.run()
 }
 
 func (s *stompServer) Stop() {
-	if s.running {
-		s.running = false
+	s.runningLock.Lock()
+	wasRunning := s.running
+	s.running = false
+	s.runningLock.Unlock()
+
+	if wasRunning {
 		s.apiEvents <- &apiEvent{
 			eventType: closeServer,
 		}
 	}
 }
 
+func (s *stompServer) isRunning() bool {
+	s.runningLock.RLock()
+	defer s.runningLock.RUnlock()
+	return s.running
+}
+
+func (s *stompServer) Drain(ctx context.Context) error {
+	if !s.isRunning() {
+		return nil
+	}
+
+	// stop accepting new connections
+	atomic.StoreInt32(&s.draining, 1)
+	s.connectionListener.Close()
+
+	// let the clients know the server is going away
+	s.SendMessage(ServerShutdownDestination, []byte(`{"event":"server-shutdown"}`))
+
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			// deadline reached, close the remaining connections immediately
+			s.Stop()
+			return ctx.Err()
+		case <-ticker.C:
+			reply := make(chan bool, 1)
+			s.apiEvents <- &apiEvent{
+				eventType: checkIdle,
+				idleReply: reply,
+			}
+			if <-reply {
+				s.Stop()
+				return nil
+			}
+		}
+	}
+}
+
+func (s *stompServer) GetHeartBeatStats() HeartBeatStats {
+	return HeartBeatStats{
+		TimedOutConnections: atomic.LoadUint64(&s.timedOutConnections),
+		MissedHeartBeats:    atomic.LoadUint64(&s.missedHeartBeats),
+	}
+}
+
+func (s *stompServer) isDraining() bool {
+	return atomic.LoadInt32(&s.draining) == 1
+}
+
 func (s *stompServer) waitForConnections() {
 	for {
-		if !s.running {
+		if !s.isRunning() || s.isDraining() {
 			return
 		}
 
 		rawConn, err := s.connectionListener.Accept()
 		if err != nil {
-			if s.running {
+			if s.isRunning() && !s.isDraining() {
 				log.Println("Failed to establish client connection:", err)
 			}
 			continue
 		}
 
-		c := NewStompConn(rawConn, s.config, s.connectionEvents)
+		c := newStompConn(rawConn, s.config, s.connectionEvents, s.notifyFrame, &s.missedHeartBeats)
 
 		s.connectionEvents <- &ConnEvent{
 			ConnId:    c.GetId(),
@@ -226,7 +423,10 @@ func (s *stompServer) run() {
 
 		case apiEvent, _ := <-s.apiEvents:
 			if apiEvent.eventType == closeServer {
-				s.connectionListener.Close()
+				// a draining server has already closed its listener
+				if !s.isDraining() {
+					s.connectionListener.Close()
+				}
 				// close all open connections
 				for _, c := range s.connectionsMap {
 					c.Close()
@@ -237,6 +437,8 @@ func (s *stompServer) run() {
 				s.sendFrame(apiEvent.destination, apiEvent.frame)
 			} else if apiEvent.eventType == sendPrivateMessage {
 				s.sendFrameToClient(apiEvent.connId, apiEvent.destination, apiEvent.frame)
+			} else if apiEvent.eventType == checkIdle {
+				apiEvent.idleReply <- s.isIdle()
 			}
 
 		case e, _ := <-s.connectionEvents:
@@ -245,6 +447,29 @@ func (s *stompServer) run() {
 	}
 }
 
+// isIdle returns true if there are no pending server events, none of the open
+// connections has frames waiting to be processed and the application reports
+// that it has answered the requests it received.
+func (s *stompServer) isIdle() bool {
+	if len(s.apiEvents) > 0 || len(s.connectionEvents) > 0 {
+		return false
+	}
+	for _, c := range s.connectionsMap {
+		if c.HasPendingFrames() {
+			return false
+		}
+	}
+
+	s.callbackLock.RLock()
+	defer s.callbackLock.RUnlock()
+	for _, callback := range s.idleCheckCallbacks {
+		if !callback() {
+			return false
+		}
+	}
+	return true
+}
+
 func (s *stompServer) handleConnectionEvent(e *ConnEvent) {
 
 	s.callbackLock.RLock()
@@ -257,6 +482,17 @@ func (s *stompServer) handleConnectionEvent(e *ConnEvent) {
 			fn(e)
 		}
 
+	case ConnectionEstablished:
+		if fn, exists := s.connectionEventCallbacks[ConnectionEstablished]; exists {
+			fn(e)
+		}
+
+	case ConnectionTimedOut:
+		atomic.AddUint64(&s.timedOutConnections, 1)
+		if fn, exists := s.connectionEventCallbacks[ConnectionTimedOut]; exists {
+			fn(e)
+		}
+
 	case ConnectionClosed:
 		delete(s.connectionsMap, e.conn.GetId())
 		for _, connSubscriptions := range s.subscriptionsMap {
@@ -322,6 +558,9 @@ func (s *stompServer) handleConnectionEvent(e *ConnEvent) {
 			for _, callback := range s.applicationRequestCallbacks {
 				callback(e.destination, e.frame.Body, e.conn.GetId())
 			}
+			for _, callback := range s.applicationFrameCallbacks {
+				callback(e.destination, e.frame, e.conn.GetId())
+			}
 		}
 		if fn, exists := s.connectionEventCallbacks[IncomingMessage]; exists {
 			fn(e)
@@ -4,13 +4,17 @@
 package stompserver
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/go-stomp/stomp/v3/frame"
 	"github.com/stretchr/testify/assert"
+	"os"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 type MockRawConnectionListener struct {
@@ -135,6 +139,70 @@ func TestStompServer_OnApplicationRequest(t *testing.T) {
 	wg.Wait()
 }
 
+func TestStompServer_OnApplicationRequestFrame(t *testing.T) {
+	server, _ := newTestStompServer(NewStompConfig(0, []string{"/pub"}))
+	go server.Start()
+
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	server.OnApplicationRequestFrame(func(destination string, f *frame.Frame, connectionId string) {
+		assert.Equal(t, "/pub/testRequest1", destination)
+		assert.Equal(t, "request1-payload", string(f.Body))
+		assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", f.Header.Get("traceparent"))
+		assert.Equal(t, "con1", connectionId)
+		wg.Done()
+	})
+
+	f := frame.New(frame.MESSAGE, frame.Destination, "/pub/testRequest1",
+		"traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	f.Body = []byte("request1-payload")
+
+	server.connectionEvents <- &ConnEvent{
+		ConnId:    "con1",
+		eventType: IncomingMessage,
+		conn: &stompConn{
+			id: "con1",
+		},
+		destination: "/pub/testRequest1",
+		frame:       f,
+	}
+
+	wg.Wait()
+}
+
+func TestStompServer_OnFrame(t *testing.T) {
+	server, listener := newTestStompServer(NewStompConfig(0, []string{"/pub"}))
+
+	var lock sync.Mutex
+	var commands []string
+	wg := sync.WaitGroup{}
+	wg.Add(2)
+	server.OnFrame(func(connectionId string, f *frame.Frame, direction FrameDirection) {
+		lock.Lock()
+		defer lock.Unlock()
+		if direction == FrameReceived {
+			commands = append(commands, "received "+f.Command)
+		} else {
+			commands = append(commands, "sent "+f.Command)
+		}
+		assert.NotEmpty(t, connectionId)
+		wg.Done()
+	})
+	go server.Start()
+
+	mockRwConn := NewMockRawConnection()
+	listener.incomingConnections <- mockRwConn
+	mockRwConn.SendConnectFrame()
+	wg.Wait()
+
+	// invalid frames are answered with an ERROR frame
+	wg.Add(2)
+	mockRwConn.incomingFrames <- frame.New(frame.SEND)
+	wg.Wait()
+
+	assert.Equal(t, []string{"received CONNECT", "sent CONNECTED", "received SEND", "sent ERROR"}, commands)
+}
+
 func TestStompServer_SendMessage(t *testing.T) {
 	server, listener := newTestStompServer(NewStompConfig(0, []string{"/pub/"}))
 	go server.Start()
@@ -281,6 +349,29 @@ func TestStompServer_SetConnectionEventCallback_ConnectionStarting(t *testing.T)
 	wg.Wait()
 }
 
+func TestStompServer_SetConnectionEventCallback_ConnectionEstablished(t *testing.T) {
+	wg := sync.WaitGroup{}
+	server, listener := newTestStompServer(NewStompConfig(0, []string{"/pub/"}))
+	server.SetConnectionEventCallback(ConnectionEstablished, func(connEvent *ConnEvent) {
+		assert.Equal(t, ConnectionEstablished, connEvent.eventType)
+		assert.Equal(t, "user1", connEvent.Principal)
+		wg.Done()
+	})
+
+	go server.Start()
+
+	mockRwConn1 := NewMockRawConnection()
+	listener.incomingConnections <- mockRwConn1
+
+	// should trigger ConnectionEstablished callback
+	wg.Add(1)
+	mockRwConn1.incomingFrames <- frame.New(
+		frame.CONNECT,
+		frame.AcceptVersion, "1.2",
+		frame.Login, "user1")
+	wg.Wait()
+}
+
 func TestStompServer_SetConnectionEventCallback_SubscribeToTopic(t *testing.T) {
 	wg := sync.WaitGroup{}
 	server, listener := newTestStompServer(NewStompConfig(0, []string{"/pub/"}))
@@ -395,6 +486,66 @@ func TestStompServer_SetConnectionEventCallback_Disconnect(t *testing.T) {
 	wg.Wait()
 }
 
+func TestStompServer_SetConnectionEventCallback_TimedOut(t *testing.T) {
+	wg := sync.WaitGroup{}
+	server, listener := newTestStompServer(NewStompConfig(50, []string{"/pub/"}))
+	server.SetConnectionEventCallback(ConnectionTimedOut, func(connEvent *ConnEvent) {
+		assert.Equal(t, ConnectionTimedOut, connEvent.eventType)
+		wg.Done()
+	})
+	server.SetConnectionEventCallback(ConnectionClosed, func(connEvent *ConnEvent) {
+		assert.Equal(t, ConnectionClosed, connEvent.eventType)
+		wg.Done()
+	})
+
+	go server.Start()
+
+	mockRwConn1 := NewMockRawConnection()
+	listener.incomingConnections <- mockRwConn1
+
+	// connect with client heart-beats enabled and wait for the CONNECTED frame
+	mockRwConn1.writeWg = &wg
+	wg.Add(1)
+	mockRwConn1.incomingFrames <- frame.New(
+		frame.CONNECT,
+		frame.AcceptVersion, "1.2",
+		frame.HeartBeat, "50,0")
+	wg.Wait()
+
+	// should trigger both ConnectionTimedOut and ConnectionClosed callbacks
+	wg.Add(2)
+	mockRwConn1.incomingFrames <- os.ErrDeadlineExceeded
+	wg.Wait()
+
+	assert.Equal(t, uint64(1), server.GetHeartBeatStats().TimedOutConnections)
+}
+
+func TestStompServer_GetHeartBeatStats_OpenConnections(t *testing.T) {
+	wg := sync.WaitGroup{}
+	server, listener := newTestStompServer(NewStompConfig(50, []string{"/pub/"}))
+	go server.Start()
+
+	mockRwConn1 := NewMockRawConnection()
+	listener.incomingConnections <- mockRwConn1
+
+	mockRwConn1.writeWg = &wg
+	wg.Add(1)
+	mockRwConn1.incomingFrames <- frame.New(
+		frame.CONNECT,
+		frame.AcceptVersion, "1.2",
+		frame.HeartBeat, "50,0")
+	wg.Wait()
+
+	// heart-beats missed by a connection are counted while it is still open
+	time.Sleep(120 * time.Millisecond)
+	mockRwConn1.incomingFrames <- nil
+	assert.Eventually(t, func() bool {
+		return server.GetHeartBeatStats().MissedHeartBeats >= 2
+	}, time.Second, time.Millisecond)
+
+	server.Stop()
+}
+
 func TestStompServer_SendMessageToClient(t *testing.T) {
 	server, listener := newTestStompServer(NewStompConfig(0, []string{"/pub/"}))
 	go server.Start()
@@ -457,6 +608,56 @@ func TestStompServer_SendMessageToClient(t *testing.T) {
 
 }
 
+func TestStompServer_SendMessageWithOptions(t *testing.T) {
+	server, listener := newTestStompServer(NewStompConfig(0, []string{"/pub/"}))
+	go server.Start()
+
+	mockRwConn := NewMockRawConnection()
+	listener.incomingConnections <- mockRwConn
+	mockRwConn.SendConnectFrame()
+
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	server.OnSubscribeEvent(func(conId string, subId string, destination string, f *frame.Frame) {
+		wg.Done()
+	})
+	subscribeMockConToTopic(mockRwConn, "/topic/test-topic1")
+	wg.Wait()
+
+	var conId string
+	for id := range server.connectionsMap {
+		conId = id
+	}
+
+	addTraceParent := func(f *frame.Frame) error {
+		f.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+		return nil
+	}
+	failingOption := func(f *frame.Frame) error {
+		return fmt.Errorf("invalid header")
+	}
+
+	mockRwConn.writeWg = &wg
+
+	wg.Add(1)
+	server.SendMessageWithOptions("/topic/test-topic1", []byte("test-message"), addTraceParent)
+	wg.Wait()
+	f := mockRwConn.LastSentFrame()
+	assert.Equal(t, "test-message", string(f.Body))
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", f.Header.Get("traceparent"))
+
+	// messages are not sent if an option fails
+	server.SendMessageToClientWithOptions(conId, "/topic/test-topic1", []byte("invalid-message"), failingOption)
+
+	wg.Add(1)
+	server.SendMessageToClientWithOptions(conId, "/topic/test-topic1", []byte("test-message2"), addTraceParent)
+	wg.Wait()
+	f = mockRwConn.LastSentFrame()
+	assert.Equal(t, 3, len(mockRwConn.sentFrames))
+	assert.Equal(t, "test-message2", string(f.Body))
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", f.Header.Get("traceparent"))
+}
+
 func TestStompServer_Stop(t *testing.T) {
 	server, listener := newTestStompServer(NewStompConfig(0, []string{"/pub"}))
 
@@ -496,6 +697,122 @@ func TestStompServer_Stop(t *testing.T) {
 	assert.Equal(t, listener.connected, false)
 }
 
+func TestStompServer_Drain(t *testing.T) {
+	server, listener := newTestStompServer(NewStompConfig(0, []string{"/pub"}))
+
+	wg := sync.WaitGroup{}
+
+	go func() {
+		server.Start()
+		wg.Done()
+	}()
+
+	mockRwConn1 := NewMockRawConnection()
+	mockRwConn2 := NewMockRawConnection()
+	listener.incomingConnections <- mockRwConn1
+	listener.incomingConnections <- mockRwConn2
+
+	wg.Add(1)
+	server.OnSubscribeEvent(func(conId string, subId string, destination string, frame *frame.Frame) {
+		wg.Done()
+	})
+
+	mockRwConn1.SendConnectFrame()
+	mockRwConn2.SendConnectFrame()
+	subscribeMockConToTopic(mockRwConn1, ServerShutdownDestination)
+
+	wg.Wait()
+
+	mockRwConn1.writeWg = &wg
+	// one write for the server-shutdown notice and one for the start goroutine
+	wg.Add(2)
+	assert.Nil(t, server.Drain(context.Background()))
+	wg.Wait()
+
+	assert.Equal(t, mockRwConn1.LastSentFrame().Command, frame.MESSAGE)
+	assert.Equal(t, mockRwConn1.LastSentFrame().Header.Get(frame.Destination), ServerShutdownDestination)
+	assert.Equal(t, string(mockRwConn1.LastSentFrame().Body), `{"event":"server-shutdown"}`)
+	assert.Equal(t, mockRwConn1.connected, false)
+	assert.Equal(t, mockRwConn2.connected, false)
+	assert.Equal(t, listener.connected, false)
+
+	// draining a stopped server is a no-op
+	assert.Nil(t, server.Drain(context.Background()))
+}
+
+func TestStompServer_DrainTimeout(t *testing.T) {
+	server, listener := newTestStompServer(NewStompConfig(0, []string{"/pub"}))
+
+	wg := sync.WaitGroup{}
+
+	go func() {
+		server.Start()
+		wg.Done()
+	}()
+
+	mockRwConn1 := NewMockRawConnection()
+	listener.incomingConnections <- mockRwConn1
+	mockRwConn1.SendConnectFrame()
+
+	wg.Add(1)
+	server.OnSubscribeEvent(func(conId string, subId string, destination string, frame *frame.Frame) {
+		wg.Done()
+	})
+	subscribeMockConToTopic(mockRwConn1, "/topic1")
+	wg.Wait()
+
+	// simulate a frame which is never delivered
+	for _, conn := range server.connectionsMap {
+		atomic.AddInt64(&conn.(*stompConn).pendingFrames, 1)
+	}
+
+	ctx, cancelFn := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancelFn()
+
+	wg.Add(1)
+	assert.Equal(t, context.DeadlineExceeded, server.Drain(ctx))
+	wg.Wait()
+
+	assert.Equal(t, mockRwConn1.connected, false)
+	assert.Equal(t, listener.connected, false)
+}
+
+func TestStompServer_DrainWaitsForIdleChecks(t *testing.T) {
+	server, listener := newTestStompServer(NewStompConfig(0, []string{"/pub"}))
+	stopped := make(chan bool)
+	go func() {
+		server.Start()
+		stopped <- true
+	}()
+
+	mockRwConn1 := NewMockRawConnection()
+	listener.incomingConnections <- mockRwConn1
+	mockRwConn1.SendConnectFrame()
+
+	// the application is still processing a request
+	var answered int32
+	server.OnIdleCheck(func() bool {
+		return atomic.LoadInt32(&answered) == 1
+	})
+
+	drained := make(chan error)
+	go func() {
+		drained <- server.Drain(context.Background())
+	}()
+
+	select {
+	case <-drained:
+		assert.Fail(t, "drain finished before the application became idle")
+	case <-time.After(50 * time.Millisecond):
+	}
+	assert.True(t, mockRwConn1.connected)
+
+	atomic.StoreInt32(&answered, 1)
+	assert.Nil(t, <-drained)
+	<-stopped
+	assert.Equal(t, mockRwConn1.connected, false)
+}
+
 func TestStompServer_ConnectionErrors(t *testing.T) {
 	server, listener := newTestStompServer(NewStompConfig(0, []string{"/pub"}))
 
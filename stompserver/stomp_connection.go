@@ -4,11 +4,13 @@
 package stompserver
 
 import (
+	"context"
 	"fmt"
 	"github.com/go-stomp/stomp/v3"
 	"github.com/go-stomp/stomp/v3/frame"
 	"github.com/google/uuid"
 	"log"
+	"net"
 	"strconv"
 	"strings"
 	"sync"
@@ -25,6 +27,12 @@ type StompConn interface {
 	// Return unique connection Id string
 	GetId() string
 	SendFrameToSubscription(f *frame.Frame, sub *subscription)
+	// Returns true if the connection has incoming or outgoing frames
+	// which are not processed yet
+	HasPendingFrames() bool
+	// Returns the number of client heart-beat intervals which passed
+	// without receiving any data from the client
+	MissedHeartBeats() uint64
 	Close()
 }
 
@@ -51,10 +59,25 @@ type stompConn struct {
 	config           StompConfig
 	subscriptions    map[string]*subscription
 	currentMessageId uint64
-	closeOnce        sync.Once
+	pendingFrames    int64
+	missedHeartBeats uint64
+	// running total of the missed heart-beats of all the server connections, if any
+	missedHeartBeatsTotal *uint64
+	deadlineLock          sync.Mutex
+	closeOnce             sync.Once
+	frameHandler          FrameHandlerFunction
 }
 
 func NewStompConn(rawConnection RawConnection, config StompConfig, events chan *ConnEvent) StompConn {
+	return newStompConn(rawConnection, config, events, nil, nil)
+}
+
+// newStompConn creates a connection which notifies the frame handler, if any, of all the frames
+// it receives from and sends to the client. the heart-beats the client misses are also added to
+// missedHeartBeatsTotal, if not nil, as soon as they are detected.
+func newStompConn(rawConnection RawConnection, config StompConfig, events chan *ConnEvent,
+	frameHandler FrameHandlerFunction, missedHeartBeatsTotal *uint64) StompConn {
+
 	conn := &stompConn{
 		rawConnection: rawConnection,
 		state:         connecting,
@@ -64,6 +87,9 @@ func NewStompConn(rawConnection RawConnection, config StompConfig, events chan *
 		id:            uuid.New().String(),
 		events:        events,
 		subscriptions: make(map[string]*subscription),
+		frameHandler:  frameHandler,
+
+		missedHeartBeatsTotal: missedHeartBeatsTotal,
 	}
 
 	go conn.run()
@@ -74,9 +100,18 @@ func NewStompConn(rawConnection RawConnection, config StompConfig, events chan *
 
 func (conn *stompConn) SendFrameToSubscription(f *frame.Frame, sub *subscription) {
 	f.Header.Add(frame.Subscription, sub.id)
+	atomic.AddInt64(&conn.pendingFrames, 1)
 	conn.outFrames <- f
 }
 
+func (conn *stompConn) HasPendingFrames() bool {
+	return atomic.LoadInt64(&conn.pendingFrames) > 0
+}
+
+func (conn *stompConn) MissedHeartBeats() uint64 {
+	return atomic.LoadUint64(&conn.missedHeartBeats)
+}
+
 func (conn *stompConn) Close() {
 	conn.closeOnce.Do(func() {
 		atomic.StoreInt32(&conn.state, closed)
@@ -127,7 +162,8 @@ func (conn *stompConn) run() {
 			conn.populateMessageIdHeader(f)
 
 			// write the frame to the client
-			err := conn.rawConnection.WriteFrame(f)
+			err := conn.writeFrame(f)
+			atomic.AddInt64(&conn.pendingFrames, -1)
 			if err != nil || f.Command == frame.ERROR {
 				return
 			}
@@ -137,7 +173,9 @@ func (conn *stompConn) run() {
 				return
 			}
 
-			if err := conn.handleIncomingFrame(f); err != nil {
+			err := conn.handleIncomingFrame(f)
+			atomic.AddInt64(&conn.pendingFrames, -1)
+			if err != nil {
 				conn.sendError(err)
 				return
 			}
@@ -235,14 +273,18 @@ func (conn *stompConn) handleConnect(f *frame.Frame) error {
 	conn.writeTimeout = cyDuration
 
 	cx, cy := int64(cxDuration/time.Millisecond), int64(cyDuration/time.Millisecond)
+	conn.deadlineLock.Lock()
 	atomic.StoreInt64(&conn.readTimeoutMs, cx)
+	// readInFrames might be already waiting for the next frame with no deadline
+	conn.updateReadDeadline()
+	conn.deadlineLock.Unlock()
 
 	response := frame.New(frame.CONNECTED,
 		frame.Version, string(conn.version),
 		frame.Server, "stompServer/0.0.1",
 		frame.HeartBeat, fmt.Sprintf("%d,%d", cy, cx))
 
-	err = conn.rawConnection.WriteFrame(response)
+	err = conn.writeFrame(response)
 	if err != nil {
 		return err
 	}
@@ -250,14 +292,33 @@ func (conn *stompConn) handleConnect(f *frame.Frame) error {
 	atomic.StoreInt32(&conn.state, connected)
 
 	conn.events <- &ConnEvent{
-		ConnId:    conn.GetId(),
-		eventType: ConnectionEstablished,
-		conn:      conn,
+		ConnId:         conn.GetId(),
+		Principal:      f.Header.Get(frame.Login),
+		RemoteAddr:     conn.getRemoteAddr(),
+		RequestContext: conn.getRequestContext(),
+		eventType:      ConnectionEstablished,
+		conn:           conn,
 	}
 
 	return nil
 }
 
+// getRequestContext returns the context of the HTTP request the raw connection was established with, if any
+func (conn *stompConn) getRequestContext() context.Context {
+	if provider, ok := conn.rawConnection.(RequestContextProvider); ok {
+		return provider.RequestContext()
+	}
+	return nil
+}
+
+// getRemoteAddr returns the network address of the client, empty if the raw connection does not know it
+func (conn *stompConn) getRemoteAddr() string {
+	if provider, ok := conn.rawConnection.(RemoteAddrProvider); ok && provider.RemoteAddr() != nil {
+		return provider.RemoteAddr().String()
+	}
+	return ""
+}
+
 func (conn *stompConn) handleDisconnect(f *frame.Frame) error {
 	if atomic.LoadInt32(&conn.state) == connecting {
 		return notConnectedStompError
@@ -388,7 +449,7 @@ func (conn *stompConn) handleSend(f *frame.Frame) error {
 func (conn *stompConn) sendReceiptResponse(f *frame.Frame) error {
 	if receipt, ok := f.Header.Contains(frame.Receipt); ok {
 		f.Header.Del(frame.Receipt)
-		return conn.rawConnection.WriteFrame(frame.New(frame.RECEIPT, frame.ReceiptId, receipt))
+		return conn.writeFrame(frame.New(frame.RECEIPT, frame.ReceiptId, receipt))
 	}
 	return nil
 }
@@ -398,31 +459,75 @@ func (conn *stompConn) readInFrames() {
 		close(conn.inFrames)
 	}()
 
-	infiniteTimeout := time.Time{}
-	var readTimeoutMs int64 = 0
+	lastRead := time.Now()
 	for {
-		readTimeoutMs = atomic.LoadInt64(&conn.readTimeoutMs)
-		if readTimeoutMs > 0 {
-			conn.rawConnection.SetReadDeadline(time.Now().Add(
-				time.Duration(readTimeoutMs) * time.Millisecond))
-		} else {
-			conn.rawConnection.SetReadDeadline(infiniteTimeout)
-		}
+		conn.deadlineLock.Lock()
+		conn.updateReadDeadline()
+		conn.deadlineLock.Unlock()
 
 		f, err := conn.rawConnection.ReadFrame()
+		interval := time.Duration(atomic.LoadInt64(&conn.readTimeoutMs)) * time.Millisecond
 		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() && interval > 0 {
+				conn.addMissedHeartBeats(time.Since(lastRead), interval)
+				conn.events <- &ConnEvent{
+					ConnId:    conn.GetId(),
+					eventType: ConnectionTimedOut,
+					conn:      conn,
+				}
+			}
 			return
 		}
 
+		if interval > 0 {
+			conn.addMissedHeartBeats(time.Since(lastRead), interval)
+		}
+		lastRead = time.Now()
+
 		if f == nil {
 			// heartbeat frame
 			continue
 		}
 
+		if conn.frameHandler != nil {
+			conn.frameHandler(conn.id, f, FrameReceived)
+		}
+
+		atomic.AddInt64(&conn.pendingFrames, 1)
 		conn.inFrames <- f
 	}
 }
 
+// updateReadDeadline sets the read deadline of the raw connection to the negotiated
+// client heart-beat interval multiplied by the configured grace multiplier.
+// Must be called with the deadlineLock held.
+func (conn *stompConn) updateReadDeadline() {
+	readTimeoutMs := atomic.LoadInt64(&conn.readTimeoutMs)
+	if readTimeoutMs > 0 {
+		interval := time.Duration(readTimeoutMs) * time.Millisecond
+		conn.rawConnection.SetReadDeadline(time.Now().Add(
+			time.Duration(float64(interval) * conn.config.HeartBeatGraceMultiplier())))
+	} else {
+		conn.rawConnection.SetReadDeadline(time.Time{})
+	}
+}
+
+// addMissedHeartBeats counts every full heart-beat interval, extended by the configured grace
+// multiplier, which passed without receiving data from the client. heart-beats arriving late
+// but within the grace period are not counted as missed.
+func (conn *stompConn) addMissedHeartBeats(elapsed time.Duration, interval time.Duration) {
+	gracedInterval := time.Duration(float64(interval) * conn.config.HeartBeatGraceMultiplier())
+	if gracedInterval <= 0 {
+		return
+	}
+	if missed := uint64(elapsed / gracedInterval); missed > 0 {
+		atomic.AddUint64(&conn.missedHeartBeats, missed)
+		if conn.missedHeartBeatsTotal != nil {
+			atomic.AddUint64(conn.missedHeartBeatsTotal, missed)
+		}
+	}
+}
+
 func determineVersion(f *frame.Frame) (stomp.Version, error) {
 	if acceptVersion, ok := f.Header.Contains(frame.AcceptVersion); ok {
 		versions := strings.Split(acceptVersion, ",")
@@ -453,7 +558,16 @@ func (conn *stompConn) sendError(err error) {
 	errorFrame := frame.New(frame.ERROR,
 		frame.Message, err.Error())
 
-	conn.rawConnection.WriteFrame(errorFrame)
+	conn.writeFrame(errorFrame)
+}
+
+// writeFrame writes the frame to the client and notifies the frame handler
+func (conn *stompConn) writeFrame(f *frame.Frame) error {
+	err := conn.rawConnection.WriteFrame(f)
+	if err == nil && conn.frameHandler != nil {
+		conn.frameHandler(conn.id, f, FrameSent)
+	}
+	return err
 }
 
 func (conn *stompConn) populateMessageIdHeader(f *frame.Frame) {
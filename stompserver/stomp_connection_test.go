@@ -8,7 +8,9 @@ import (
 	"fmt"
 	"github.com/go-stomp/stomp/v3/frame"
 	"github.com/stretchr/testify/assert"
+	"os"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -774,6 +776,99 @@ func TestStompConn_SetReadDeadline(t *testing.T) {
 	assert.Greater(t, float64(21), diff.Seconds())
 }
 
+func TestStompConn_SetReadDeadlineWithGraceMultiplier(t *testing.T) {
+	_, rawConn, events := getTestStompConn(NewStompConfigWithHeartBeatGrace(20000, 1.5, []string{}), nil)
+
+	rawConn.incomingFrames <- frame.New(
+		frame.CONNECT,
+		frame.AcceptVersion, "1.2",
+		frame.HeartBeat, "200,200")
+
+	<-events
+
+	rawConn.incomingFrames <- nil
+	rawConn.incomingFrames <- nil
+
+	diff := rawConn.getCurrentReadDeadline().Sub(time.Now())
+
+	// verify the read deadline for the connection is
+	// between 25 and 31 seconds
+	assert.Greater(t, diff.Seconds(), float64(25))
+	assert.Greater(t, float64(31), diff.Seconds())
+}
+
+func TestStompConn_HeartbeatTimeout(t *testing.T) {
+	stompConn, rawConn, events := getTestStompConn(NewStompConfig(50, []string{}), nil)
+
+	rawConn.incomingFrames <- frame.New(
+		frame.CONNECT,
+		frame.AcceptVersion, "1.2",
+		frame.HeartBeat, "50,0")
+
+	e := <-events
+	assert.Equal(t, e.eventType, ConnectionEstablished)
+
+	// a late heart-beat is counted as missed but doesn't close the connection
+	time.Sleep(120 * time.Millisecond)
+	rawConn.incomingFrames <- nil
+	rawConn.incomingFrames <- nil
+	assert.GreaterOrEqual(t, stompConn.MissedHeartBeats(), uint64(2))
+	assert.Equal(t, atomic.LoadInt32(&stompConn.state), connected)
+
+	// simulate expired read deadline
+	rawConn.incomingFrames <- os.ErrDeadlineExceeded
+
+	e = <-events
+	assert.Equal(t, e.eventType, ConnectionTimedOut)
+	assert.Equal(t, e.ConnId, stompConn.GetId())
+
+	e = <-events
+	assert.Equal(t, e.eventType, ConnectionClosed)
+	assert.Equal(t, stompConn.state, closed)
+}
+
+func TestStompConn_LateHeartbeatWithinGrace(t *testing.T) {
+	stompConn, rawConn, events := getTestStompConn(NewStompConfigWithHeartBeatGrace(50, 3, []string{}), nil)
+
+	rawConn.incomingFrames <- frame.New(
+		frame.CONNECT,
+		frame.AcceptVersion, "1.2",
+		frame.HeartBeat, "50,0")
+
+	e := <-events
+	assert.Equal(t, e.eventType, ConnectionEstablished)
+
+	// a heart-beat arriving late but within the grace period is not counted as missed
+	time.Sleep(80 * time.Millisecond)
+	rawConn.incomingFrames <- nil
+	rawConn.incomingFrames <- nil
+	assert.Equal(t, uint64(0), stompConn.MissedHeartBeats())
+
+	// a heart-beat arriving after the grace period is counted as missed
+	time.Sleep(170 * time.Millisecond)
+	rawConn.incomingFrames <- nil
+	rawConn.incomingFrames <- nil
+	assert.Equal(t, uint64(1), stompConn.MissedHeartBeats())
+	assert.Equal(t, atomic.LoadInt32(&stompConn.state), connected)
+}
+
+func TestStompConn_ReadErrorIsNotTimeout(t *testing.T) {
+	stompConn, rawConn, events := getTestStompConn(NewStompConfig(50, []string{}), nil)
+
+	rawConn.incomingFrames <- frame.New(
+		frame.CONNECT,
+		frame.AcceptVersion, "1.2",
+		frame.HeartBeat, "50,0")
+
+	<-events
+
+	rawConn.incomingFrames <- errors.New("connection reset")
+
+	e := <-events
+	assert.Equal(t, e.eventType, ConnectionClosed)
+	assert.Equal(t, stompConn.state, closed)
+}
+
 func TestStompConn_WriteHeartbeat(t *testing.T) {
 	stompConn, rawConn, events := getTestStompConn(NewStompConfig(100, []string{}), nil)
 
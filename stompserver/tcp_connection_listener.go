@@ -10,19 +10,28 @@ import (
 )
 
 type tcpStompConnection struct {
-	tcpCon net.Conn
+	tcpCon      net.Conn
+	frameReader *frame.Reader
+	frameWriter *frame.Writer
+}
+
+// newTcpStompConnection wraps a stream based net.Conn (TCP, UNIX socket or pipe).
+// The frame reader is kept for the lifetime of the connection so that data buffered
+// after the end of one frame is not lost when the next frame is read.
+func newTcpStompConnection(conn net.Conn) *tcpStompConnection {
+	return &tcpStompConnection{
+		tcpCon:      conn,
+		frameReader: frame.NewReader(conn),
+		frameWriter: frame.NewWriter(conn),
+	}
 }
 
 func (c *tcpStompConnection) ReadFrame() (*frame.Frame, error) {
-	frameR := frame.NewReader(c.tcpCon)
-	f, e := frameR.Read()
-	return f, e
+	return c.frameReader.Read()
 }
 
 func (c *tcpStompConnection) WriteFrame(f *frame.Frame) error {
-	frameWr := frame.NewWriter(c.tcpCon)
-	err := frameWr.Write(f)
-	return err
+	return c.frameWriter.Write(f)
 }
 
 func (c *tcpStompConnection) SetReadDeadline(t time.Time) {
@@ -33,6 +42,10 @@ func (c *tcpStompConnection) Close() error {
 	return c.tcpCon.Close()
 }
 
+func (c *tcpStompConnection) RemoteAddr() net.Addr {
+	return c.tcpCon.RemoteAddr()
+}
+
 type tcpConnectionListener struct {
 	listener net.Listener
 }
@@ -52,7 +65,7 @@ func (l *tcpConnectionListener) Accept() (RawConnection, error) {
 		return nil, err
 	}
 
-	return &tcpStompConnection{tcpCon: conn}, nil
+	return newTcpStompConnection(conn), nil
 }
 
 func (l *tcpConnectionListener) Close() error {
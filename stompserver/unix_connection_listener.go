@@ -0,0 +1,37 @@
+// Copyright 2019-2021 VMware, Inc.
+// SPDX-License-Identifier: BSD-2-Clause
+
+package stompserver
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+// time to wait for a process listening on an existing socket file to accept a connection
+const unixSocketDialTimeout = time.Second
+
+// NewUnixConnectionListener creates a RawConnectionListener accepting STOMP connections over
+// a UNIX domain socket bound to socketPath. A stale socket file left behind by a previous
+// process is removed before binding, while a socket another process is still listening on is
+// left alone and an error is returned. The socket file is removed when the listener is closed.
+func NewUnixConnectionListener(socketPath string) (RawConnectionListener, error) {
+	if fi, err := os.Stat(socketPath); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if conn, dialErr := net.DialTimeout("unix", socketPath, unixSocketDialTimeout); dialErr == nil {
+			conn.Close()
+			return nil, fmt.Errorf("unable to listen on %s: the socket is in use by another process", socketPath)
+		}
+		if err = os.Remove(socketPath); err != nil {
+			return nil, err
+		}
+	}
+
+	unixListener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, err
+	}
+	// UNIX sockets are stream oriented just like TCP, so the tcp listener can be reused as is
+	return &tcpConnectionListener{listener: unixListener}, nil
+}
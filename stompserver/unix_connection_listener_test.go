@@ -0,0 +1,78 @@
+// Copyright 2019-2021 VMware, Inc.
+// SPDX-License-Identifier: BSD-2-Clause
+
+package stompserver
+
+import (
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUnixConnectionListener_Accept(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "fabric.sock")
+
+	listener, err := NewUnixConnectionListener(socketPath)
+	assert.Nil(t, err)
+	assert.NotNil(t, listener)
+
+	go func() {
+		clientConn, err := net.Dial("unix", socketPath)
+		assert.Nil(t, err)
+		wr := frame.NewWriter(clientConn)
+		wr.Write(frame.New(frame.CONNECT, frame.AcceptVersion, "1.2"))
+	}()
+
+	rawCon, err := listener.Accept()
+	assert.Nil(t, err)
+
+	f, readErr := rawCon.ReadFrame()
+	assert.Nil(t, readErr)
+	verifyFrame(t, f, frame.New(frame.CONNECT, frame.AcceptVersion, "1.2"), true)
+
+	assert.Nil(t, rawCon.Close())
+	assert.Nil(t, listener.Close())
+
+	// the socket file is removed together with the listener
+	_, err = os.Stat(socketPath)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestUnixConnectionListener_StaleSocket(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "fabric.sock")
+
+	// simulate a socket file left behind by a crashed process
+	staleListener, err := net.Listen("unix", socketPath)
+	assert.Nil(t, err)
+	staleListener.(*net.UnixListener).SetUnlinkOnClose(false)
+	staleListener.Close()
+
+	listener, err := NewUnixConnectionListener(socketPath)
+	assert.Nil(t, err)
+	assert.NotNil(t, listener)
+	listener.Close()
+}
+
+func TestUnixConnectionListener_SocketInUse(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "fabric.sock")
+
+	listener, err := NewUnixConnectionListener(socketPath)
+	assert.Nil(t, err)
+	defer listener.Close()
+
+	// a socket another listener is serving on is not unlinked
+	second, err := NewUnixConnectionListener(socketPath)
+	assert.Nil(t, second)
+	assert.NotNil(t, err)
+	_, err = os.Stat(socketPath)
+	assert.Nil(t, err)
+}
+
+func TestUnixConnectionListener_InvalidPath(t *testing.T) {
+	listener, err := NewUnixConnectionListener(filepath.Join(t.TempDir(), "missing-dir", "fabric.sock"))
+	assert.Nil(t, listener)
+	assert.NotNil(t, err)
+}
@@ -4,6 +4,7 @@
 package stompserver
 
 import (
+	"context"
 	"github.com/go-stomp/stomp/v3/frame"
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
@@ -16,6 +17,7 @@ import (
 
 type webSocketStompConnection struct {
 	wsCon *websocket.Conn
+	ctx   context.Context // context of the upgrade request
 }
 
 func (c *webSocketStompConnection) ReadFrame() (*frame.Frame, error) {
@@ -46,6 +48,14 @@ func (c *webSocketStompConnection) SetReadDeadline(t time.Time) {
 	c.wsCon.SetReadDeadline(t)
 }
 
+func (c *webSocketStompConnection) RemoteAddr() net.Addr {
+	return c.wsCon.RemoteAddr()
+}
+
+func (c *webSocketStompConnection) RequestContext() context.Context {
+	return c.ctx
+}
+
 func (c *webSocketStompConnection) Close() error {
 	return c.wsCon.Close()
 }
@@ -87,6 +97,7 @@ func NewWebSocketConnectionFromExistingHttpServer(httpServer *http.Server, handl
 			l.connectionsChannel <- rawConnResult{
 				conn: &webSocketStompConnection{
 					wsCon: conn,
+					ctx:   request.Context(),
 				},
 			}
 		}
@@ -123,6 +134,7 @@ func NewWebSocketConnectionListener(addr string, endpoint string, allowedOrigins
 			l.connectionsChannel <- rawConnResult{
 				conn: &webSocketStompConnection{
 					wsCon: conn,
+					ctx:   request.Context(),
 				},
 			}
 		}
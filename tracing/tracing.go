@@ -0,0 +1,191 @@
+// Copyright 2019-2021 VMware, Inc.
+// SPDX-License-Identifier: BSD-2-Clause
+
+// Package tracing propagates W3C trace context (https://www.w3.org/TR/trace-context) across the hops
+// requests take through transport: HTTP requests, STOMP frames, bus messages and service requests.
+// Spans are recorded with the global OpenTelemetry tracer provider, so nothing is exported unless the
+// application installs one, e.g. through the tracing configuration of plank.
+package tracing
+
+import (
+	"context"
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/vmware/transport-go/model"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"strings"
+)
+
+// InstrumentationName is the name of the tracer recording the spans of transport
+const InstrumentationName = "github.com/vmware/transport-go"
+
+// W3C trace context headers, used as HTTP, STOMP frame and message header names
+const (
+	TraceParentHeader = "traceparent"
+	TraceStateHeader  = "tracestate"
+)
+
+// span attributes recorded by transport
+const (
+	ChannelAttribute     = "transport.channel"
+	RequestAttribute     = "transport.request"
+	DestinationAttribute = "transport.destination"
+	ConnectionAttribute  = "transport.connection_id"
+)
+
+var propagator = propagation.TraceContext{}
+
+// Tracer returns the tracer of the global tracer provider used to record the spans of transport
+func Tracer() trace.Tracer {
+	return otel.GetTracerProvider().Tracer(InstrumentationName)
+}
+
+// Extract returns a copy of the context carrying the trace context read from the carrier
+func Extract(ctx context.Context, carrier propagation.TextMapCarrier) context.Context {
+	return propagator.Extract(ctx, carrier)
+}
+
+// Inject writes the trace context of the context to the carrier
+func Inject(ctx context.Context, carrier propagation.TextMapCarrier) {
+	propagator.Inject(ctx, carrier)
+}
+
+// HasTraceContext returns whether the context carries a valid trace context
+func HasTraceContext(ctx context.Context) bool {
+	return trace.SpanContextFromContext(ctx).IsValid()
+}
+
+// ContextFromMetadata returns a copy of the context carrying the trace context of the request metadata
+func ContextFromMetadata(ctx context.Context, metadata *model.RequestMetadata) context.Context {
+	if metadata == nil || metadata.TraceParent == "" {
+		return ctx
+	}
+	return Extract(ctx, &metadataCarrier{metadata: metadata})
+}
+
+// ContextFromRequest returns a context carrying the trace context of the request. services use it as the
+// parent of the spans they record while handling the request.
+func ContextFromRequest(request *model.Request) context.Context {
+	if request == nil {
+		return context.Background()
+	}
+	return ContextFromMetadata(context.Background(), request.Metadata)
+}
+
+// InjectMetadata writes the trace context of the context to the request metadata
+func InjectMetadata(ctx context.Context, metadata *model.RequestMetadata) {
+	metadata.TraceParent = ""
+	metadata.TraceState = ""
+	Inject(ctx, &metadataCarrier{metadata: metadata})
+}
+
+// ContextFromHeaders returns a copy of the context carrying the trace context of the message headers
+func ContextFromHeaders(ctx context.Context, headers []model.MessageHeader) context.Context {
+	carrier := MessageHeaderCarrier(headers)
+	return Extract(ctx, &carrier)
+}
+
+// InjectFrame returns a frame option adding the trace context of the context to the headers of a
+// STOMP frame, e.g. to pass to bridge.Connection.SendMessage.
+func InjectFrame(ctx context.Context) func(f *frame.Frame) error {
+	return func(f *frame.Frame) error {
+		Inject(ctx, FrameHeaderCarrier{Frame: f})
+		return nil
+	}
+}
+
+// MessageHeaderCarrier adapts the headers of a model.Message to a propagation.TextMapCarrier
+type MessageHeaderCarrier []model.MessageHeader
+
+// Get returns the value of the header, ignoring case
+func (c *MessageHeaderCarrier) Get(key string) string {
+	for _, header := range *c {
+		if strings.EqualFold(header.Label, key) {
+			return header.Value
+		}
+	}
+	return ""
+}
+
+// Set sets the value of the header, replacing the existing one
+func (c *MessageHeaderCarrier) Set(key string, value string) {
+	for i, header := range *c {
+		if strings.EqualFold(header.Label, key) {
+			(*c)[i].Value = value
+			return
+		}
+	}
+	*c = append(*c, model.MessageHeader{Label: key, Value: value})
+}
+
+// Keys returns the names of the headers
+func (c *MessageHeaderCarrier) Keys() []string {
+	keys := make([]string, 0, len(*c))
+	for _, header := range *c {
+		keys = append(keys, header.Label)
+	}
+	return keys
+}
+
+// FrameHeaderCarrier adapts the headers of a STOMP frame to a propagation.TextMapCarrier
+type FrameHeaderCarrier struct {
+	Frame *frame.Frame
+}
+
+// Get returns the value of the header
+func (c FrameHeaderCarrier) Get(key string) string {
+	if c.Frame == nil || c.Frame.Header == nil {
+		return ""
+	}
+	return c.Frame.Header.Get(key)
+}
+
+// Set sets the value of the header, replacing the existing one
+func (c FrameHeaderCarrier) Set(key string, value string) {
+	if c.Frame.Header == nil {
+		c.Frame.Header = frame.NewHeader()
+	}
+	c.Frame.Header.Set(key, value)
+}
+
+// Keys returns the names of the headers
+func (c FrameHeaderCarrier) Keys() []string {
+	if c.Frame == nil || c.Frame.Header == nil {
+		return nil
+	}
+	keys := make([]string, 0, c.Frame.Header.Len())
+	for i := 0; i < c.Frame.Header.Len(); i++ {
+		key, _ := c.Frame.Header.GetAt(i)
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// metadataCarrier adapts the trace context fields of model.RequestMetadata to a propagation.TextMapCarrier
+type metadataCarrier struct {
+	metadata *model.RequestMetadata
+}
+
+func (c *metadataCarrier) Get(key string) string {
+	switch strings.ToLower(key) {
+	case TraceParentHeader:
+		return c.metadata.TraceParent
+	case TraceStateHeader:
+		return c.metadata.TraceState
+	}
+	return ""
+}
+
+func (c *metadataCarrier) Set(key string, value string) {
+	switch strings.ToLower(key) {
+	case TraceParentHeader:
+		c.metadata.TraceParent = value
+	case TraceStateHeader:
+		c.metadata.TraceState = value
+	}
+}
+
+func (c *metadataCarrier) Keys() []string {
+	return []string{TraceParentHeader, TraceStateHeader}
+}
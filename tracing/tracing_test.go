@@ -0,0 +1,89 @@
+// Copyright 2019-2021 VMware, Inc.
+// SPDX-License-Identifier: BSD-2-Clause
+
+package tracing
+
+import (
+	"context"
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/stretchr/testify/assert"
+	"github.com/vmware/transport-go/model"
+	"go.opentelemetry.io/otel/trace"
+	"testing"
+)
+
+const (
+	testTraceParent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	testTraceState  = "vendor=value"
+)
+
+func TestContextFromMetadata(t *testing.T) {
+	ctx := ContextFromMetadata(context.Background(), nil)
+	assert.False(t, HasTraceContext(ctx))
+
+	ctx = ContextFromMetadata(context.Background(), &model.RequestMetadata{TraceParent: "invalid"})
+	assert.False(t, HasTraceContext(ctx))
+
+	ctx = ContextFromMetadata(context.Background(),
+		&model.RequestMetadata{TraceParent: testTraceParent, TraceState: testTraceState})
+	assert.True(t, HasTraceContext(ctx))
+	spanCtx := trace.SpanContextFromContext(ctx)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", spanCtx.TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", spanCtx.SpanID().String())
+	assert.True(t, spanCtx.IsRemote())
+
+	metadata := &model.RequestMetadata{TraceParent: "stale", TraceState: "stale"}
+	InjectMetadata(ctx, metadata)
+	assert.Equal(t, testTraceParent, metadata.TraceParent)
+	assert.Equal(t, testTraceState, metadata.TraceState)
+
+	// metadata is cleared if the context carries no trace context
+	InjectMetadata(context.Background(), metadata)
+	assert.Empty(t, metadata.TraceParent)
+	assert.Empty(t, metadata.TraceState)
+}
+
+func TestContextFromRequest(t *testing.T) {
+	assert.False(t, HasTraceContext(ContextFromRequest(nil)))
+	assert.False(t, HasTraceContext(ContextFromRequest(&model.Request{})))
+	assert.True(t, HasTraceContext(ContextFromRequest(
+		&model.Request{Metadata: &model.RequestMetadata{TraceParent: testTraceParent}})))
+}
+
+func TestMessageHeaderCarrier(t *testing.T) {
+	carrier := MessageHeaderCarrier([]model.MessageHeader{
+		{Label: "reply-to", Value: "/temp-queue/1"},
+		{Label: "TraceParent", Value: "stale"},
+	})
+	ctx := ContextFromMetadata(context.Background(),
+		&model.RequestMetadata{TraceParent: testTraceParent, TraceState: testTraceState})
+	Inject(ctx, &carrier)
+
+	assert.Equal(t, []model.MessageHeader{
+		{Label: "reply-to", Value: "/temp-queue/1"},
+		{Label: "TraceParent", Value: testTraceParent},
+		{Label: "tracestate", Value: testTraceState},
+	}, []model.MessageHeader(carrier))
+	assert.Equal(t, []string{"reply-to", "TraceParent", "tracestate"}, carrier.Keys())
+
+	extracted := trace.SpanContextFromContext(ContextFromHeaders(context.Background(), carrier))
+	assert.Equal(t, trace.SpanContextFromContext(ctx).TraceID(), extracted.TraceID())
+	assert.Equal(t, testTraceState, extracted.TraceState().String())
+	assert.False(t, HasTraceContext(ContextFromHeaders(context.Background(), nil)))
+}
+
+func TestInjectFrame(t *testing.T) {
+	ctx := ContextFromMetadata(context.Background(), &model.RequestMetadata{TraceParent: testTraceParent})
+	f := frame.New(frame.SEND, frame.Destination, "/topic/test")
+	assert.Nil(t, InjectFrame(ctx)(f))
+	assert.Equal(t, testTraceParent, f.Header.Get(TraceParentHeader))
+	assert.Equal(t, []string{frame.Destination, TraceParentHeader}, FrameHeaderCarrier{Frame: f}.Keys())
+
+	extracted := Extract(context.Background(), FrameHeaderCarrier{Frame: f})
+	assert.Equal(t, trace.SpanContextFromContext(ctx), trace.SpanContextFromContext(extracted))
+
+	f = &frame.Frame{Command: frame.SEND}
+	assert.Nil(t, InjectFrame(ctx)(f))
+	assert.Equal(t, testTraceParent, f.Header.Get(TraceParentHeader))
+	assert.Empty(t, FrameHeaderCarrier{}.Get(TraceParentHeader))
+}